@@ -0,0 +1,285 @@
+package slim
+
+import (
+	goAst "go/ast"
+	"sort"
+	"strings"
+
+	"github.com/golib/slim/parser"
+)
+
+const (
+	// A data field or method, i.e. User.Name or $item.Title
+	RefField = "field"
+	// A function call to a runtime helper or builtin, i.e. upper or len
+	RefHelper = "helper"
+)
+
+// Reference is a data field, method or helper used by a template expression.
+type Reference struct {
+	parser.SourcePosition
+	// RefField or RefHelper
+	Kind string
+	// Dotted path of the reference as written in the template, variables keep their $ prefix.
+	Name string
+}
+
+// Lists every data field, method and helper referenced by the parsed template tree,
+// including imported and extended templates, ordered by file and position.
+func (c *Compiler) References() (refs []Reference, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverError(r)
+		}
+	}()
+
+	if c.node == nil {
+		return nil, nil
+	}
+
+	walkExpressions(c.node, func(node parser.Noder, value string) {
+		pos := node.Pos()
+
+		for _, ref := range expressionReferences(parseExpression(value)) {
+			ref.SourcePosition = pos
+			refs = append(refs, ref)
+		}
+	})
+
+	sort.SliceStable(refs, func(i, j int) bool {
+		if refs[i].Filename != refs[j].Filename {
+			return refs[i].Filename < refs[j].Filename
+		}
+
+		if refs[i].Line != refs[j].Line {
+			return refs[i].Line < refs[j].Line
+		}
+
+		return refs[i].Column < refs[j].Column
+	})
+
+	return refs, nil
+}
+
+// Groups references by the file they have been found in.
+// References of templates parsed from strings are grouped under the empty filename.
+func GroupReferences(refs []Reference) map[string][]Reference {
+	groups := make(map[string][]Reference)
+
+	for _, ref := range refs {
+		groups[ref.Filename] = append(groups[ref.Filename], ref)
+	}
+
+	return groups
+}
+
+// walkNodes calls fn for node and, as long as fn returns true, for each of its children in turn.
+func walkNodes(node parser.Noder, fn func(parser.Noder) bool) {
+	if node == nil || !fn(node) {
+		return
+	}
+
+	switch node := node.(type) {
+	case *parser.Block:
+		for _, child := range node.Children {
+			walkNodes(child, fn)
+		}
+	case *parser.Condition:
+		if node.Positive != nil {
+			walkNodes(node.Positive, fn)
+		}
+
+		if node.Negative != nil {
+			walkNodes(node.Negative, fn)
+		}
+	case *parser.Comment:
+		if node.Block != nil {
+			walkNodes(node.Block, fn)
+		}
+	case *parser.Tag:
+		if node.Block != nil {
+			walkNodes(node.Block, fn)
+		}
+	case *parser.Range:
+		if node.Block != nil {
+			walkNodes(node.Block, fn)
+		}
+	case *parser.Let:
+		if node.Block != nil {
+			walkNodes(node.Block, fn)
+		}
+	case *parser.While:
+		if node.Block != nil {
+			walkNodes(node.Block, fn)
+		}
+	case *parser.Cache:
+		if node.Block != nil {
+			walkNodes(node.Block, fn)
+		}
+	case *parser.ContentFor:
+		if node.Block != nil {
+			walkNodes(node.Block, fn)
+		}
+	case *parser.Define:
+		if node.Block != nil {
+			walkNodes(node.Block, fn)
+		}
+	case *parser.Mixin:
+		if node.Block != nil {
+			walkNodes(node.Block, fn)
+		}
+	case *parser.MixinCall:
+		if node.Block != nil {
+			walkNodes(node.Block, fn)
+		}
+	case *parser.Slot:
+		if node.Block != nil {
+			walkNodes(node.Block, fn)
+		}
+	}
+}
+
+// walkExpressions calls fn for every raw slim expression found within node and its children.
+func walkExpressions(node parser.Noder, fn func(parser.Noder, string)) {
+	walkNodes(node, func(node parser.Noder) bool {
+		switch node := node.(type) {
+		case *parser.Tag:
+			for _, attr := range node.Attributes {
+				if !attr.IsRaw && len(attr.Value) > 0 {
+					fn(node, attr.Value)
+				}
+
+				if len(attr.Condition) > 0 {
+					fn(node, attr.Condition)
+				}
+			}
+		case *parser.Text:
+			for _, match := range rinterpolate.FindAllStringSubmatch(node.Value, -1) {
+				fn(node, match[1])
+			}
+		case *parser.Condition:
+			fn(node, node.Expression)
+		case *parser.Assignment:
+			fn(node, node.Expression)
+		case *parser.Range:
+			fn(node, node.Expression)
+		case *parser.Let:
+			fn(node, node.Expression)
+		case *parser.While:
+			fn(node, node.Expression)
+		case *parser.Cache:
+			fn(node, node.Key)
+		case *parser.Embed:
+			if len(node.Expression) > 0 {
+				fn(node, node.Expression)
+			}
+		case *parser.Mixin:
+			for _, value := range node.Defaults {
+				if len(value) > 0 {
+					fn(node, value)
+				}
+			}
+		case *parser.MixinCall:
+			for _, arg := range splitArguments(node.Arguments) {
+				fn(node, arg)
+			}
+
+			for _, attr := range node.Attributes {
+				if !attr.IsRaw && len(attr.Value) > 0 {
+					fn(node, attr.Value)
+				}
+
+				if len(attr.Condition) > 0 {
+					fn(node, attr.Condition)
+				}
+			}
+		}
+
+		return true
+	})
+}
+
+func expressionReferences(expr goAst.Expr) []Reference {
+	refs := make([]Reference, 0)
+
+	var exec func(goAst.Expr)
+
+	exec = func(expr goAst.Expr) {
+		switch expr := expr.(type) {
+		case *goAst.BinaryExpr:
+			exec(expr.X)
+			exec(expr.Y)
+		case *goAst.UnaryExpr:
+			exec(expr.X)
+		case *goAst.ParenExpr:
+			exec(expr.X)
+		case *goAst.Ident, *goAst.SelectorExpr:
+			name, ok := selectorPath(expr)
+			if !ok {
+				if se, isSelector := expr.(*goAst.SelectorExpr); isSelector {
+					exec(se.X)
+				}
+
+				return
+			}
+
+			// plain variables are not data references, their fields are
+			if !strings.HasPrefix(name, "$") || strings.Contains(name, ".") {
+				refs = append(refs, Reference{Kind: RefField, Name: name})
+			}
+		case *goAst.CallExpr:
+			if ident, ok := expr.Fun.(*goAst.Ident); ok && isBuiltinFunction(ident.Name) {
+				name := ident.Name
+				if name == "__DEFAULT__" {
+					name = "default"
+				}
+
+				// runtime functions standing in for syntax are no helpers of the template
+				if !strings.HasPrefix(name, "__slim_") {
+					refs = append(refs, Reference{Kind: RefHelper, Name: name})
+				}
+			} else {
+				exec(expr.Fun)
+			}
+
+			for _, arg := range expr.Args {
+				exec(arg)
+			}
+		}
+	}
+
+	exec(expr)
+	return refs
+}
+
+// selectorPath turns an identifier or selector chain like __DOLLAR__item.Owner.Name into $item.Owner.Name
+func selectorPath(expr goAst.Expr) (string, bool) {
+	switch expr := expr.(type) {
+	case *goAst.Ident:
+		return strings.Replace(expr.Name, "__DOLLAR__", "$", 1), true
+	case *goAst.SelectorExpr:
+		if x, ok := selectorPath(expr.X); ok {
+			if x == "$" {
+				return expr.Sel.Name, true
+			}
+
+			return x + "." + expr.Sel.Name, true
+		}
+	}
+
+	return "", false
+}
+
+func isBuiltinFunction(name string) bool {
+	if name == "__DEFAULT__" {
+		name = "default"
+	}
+
+	for _, fname := range builtinFunctions {
+		if fname == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,208 @@
+package slim
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/golib/slim/parser"
+)
+
+var rassignment = regexp.MustCompile(`^\$\w*\s*:?=[^=]`)
+
+// states of the markup surrounding the actions of a generated template
+const (
+	auditText = iota
+	auditTag
+	auditAttrName
+	auditBeforeValue
+	auditQuoted
+	auditUnquoted
+)
+
+// elements whose content is no markup, actions within them are escaped for their own language
+var rawTextElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"textarea": true,
+	"title":    true,
+}
+
+// auditEscaping fails on actions of source producing output where html/template cannot escape it reliably:
+// within tag names and attribute names, within unquoted attribute values and within attribute values
+// whose quotes are written by different slim nodes, i.e. by two lines of inline HTML.
+func (c *Compiler) auditEscaping(source string) {
+	fail := func(offset int, message string) {
+		pos := parser.SourcePosition{Line: 1, Column: 1}
+		if mapping, ok := c.sourceMap.Lookup(offset); ok {
+			pos = parser.SourcePosition{Filename: mapping.Filename, Line: mapping.Line, Column: mapping.Column}
+		}
+
+		panic(parser.NewError(message, pos))
+	}
+
+	var (
+		state   = auditText
+		quote   byte
+		opened  int
+		tagName string
+	)
+
+	for i := 0; i < len(source); {
+		if strings.HasPrefix(source[i:], "{{") {
+			end := actionEnd(source, i)
+
+			if outputAction(source[i+2 : end-2]) {
+				switch state {
+				case auditTag, auditAttrName:
+					fail(i, "Output of an expression within tag "+tagName+" outside of an attribute value.")
+				case auditUnquoted, auditBeforeValue:
+					fail(i, "Output of an expression within an unquoted attribute value of tag "+tagName+".")
+				case auditQuoted:
+					if !c.sameNode(opened, i) {
+						fail(i, "Output of an expression within an attribute value of tag "+tagName+" quoted by several nodes.")
+					}
+				}
+			}
+
+			i = end
+			continue
+		}
+
+		ch := source[i]
+
+		switch state {
+		case auditText:
+			if strings.HasPrefix(source[i:], "<!--") {
+				if end := strings.Index(source[i:], "-->"); end >= 0 {
+					i += end + 3
+					continue
+				}
+			}
+
+			if ch == '<' && i+1 < len(source) && isTagStart(source[i+1]) {
+				name := i + 1
+				if source[name] == '/' {
+					name++
+				}
+
+				end := name
+				for end < len(source) && isNameChar(source[end]) {
+					end++
+				}
+
+				if strings.HasPrefix(source[end:], "{{") && outputAction(source[end+2:actionEnd(source, end)-2]) {
+					fail(end, "Output of an expression within a tag name.")
+				}
+
+				tagName = strings.ToLower(source[name:end])
+				state = auditTag
+				i = end
+				continue
+			}
+		case auditTag:
+			switch {
+			case ch == '>':
+				state = auditText
+
+				// content of raw text elements is skipped up to their end tag
+				if rawTextElements[tagName] && source[i-1] != '/' {
+					if end := strings.Index(strings.ToLower(source[i:]), "</"+tagName); end >= 0 {
+						i += end
+						continue
+					}
+				}
+			case !isSpace(ch) && ch != '/':
+				state = auditAttrName
+			}
+		case auditAttrName:
+			switch {
+			case ch == '=':
+				state = auditBeforeValue
+			case ch == '>':
+				state = auditTag
+				continue
+			case isSpace(ch):
+				state = auditTag
+			}
+		case auditBeforeValue:
+			switch {
+			case ch == '"' || ch == '\'':
+				state, quote, opened = auditQuoted, ch, i
+			case !isSpace(ch):
+				state = auditUnquoted
+				continue
+			}
+		case auditQuoted:
+			if ch == quote {
+				state = auditTag
+			}
+		case auditUnquoted:
+			if isSpace(ch) || ch == '>' {
+				state = auditTag
+				continue
+			}
+		}
+
+		i++
+	}
+}
+
+// sameNode reports whether the generated offsets a and b have been written by the same slim node.
+func (c *Compiler) sameNode(a, b int) bool {
+	first, ok := c.sourceMap.Lookup(a)
+	second, _ := c.sourceMap.Lookup(b)
+
+	if !ok {
+		return true
+	}
+
+	// actions are mapped to nodes of their own, the quote has to be written by the node enclosing them
+	return second.Start >= first.Start && second.End <= first.End
+}
+
+// actionEnd returns the offset following the action of source starting at start, skipping strings within it.
+func actionEnd(source string, start int) int {
+	for i := start + 2; i < len(source); i++ {
+		switch source[i] {
+		case '"', '`', '\'':
+			quote := source[i]
+			for i++; i < len(source) && source[i] != quote; i++ {
+				if source[i] == '\\' && quote != '`' {
+					i++
+				}
+			}
+		case '}':
+			if strings.HasPrefix(source[i:], "}}") {
+				return i + 2
+			}
+		}
+	}
+
+	return len(source)
+}
+
+// outputAction reports whether the action of given body writes output, rather than controlling the flow
+// or assigning variables.
+func outputAction(body string) bool {
+	body = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(body, "-"), "-"))
+
+	for _, keyword := range []string{"if", "else", "end", "range", "with", "define", "block", "break", "continue"} {
+		if body == keyword || strings.HasPrefix(body, keyword+" ") {
+			return false
+		}
+	}
+
+	return !strings.HasPrefix(body, "/*") && !rassignment.MatchString(body)
+}
+
+func isTagStart(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch == '/'
+}
+
+func isNameChar(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-' || ch == ':'
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f'
+}
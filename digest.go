@@ -0,0 +1,40 @@
+package slim
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+)
+
+// Returns the hex encoded SHA-256 digest of the Go template source tpl has been compiled from, empty if it has not
+// been compiled by slim. The source holds the templates it imports or extends and the files it includes, so the
+// digest changes whenever any of them or the options compiling them change, but not with the data rendered.
+// It serves to build ETags and cache keys of rendered pages, along with a digest or version of their data.
+func Digest(tpl *template.Template) string {
+	if tpl == nil {
+		return ""
+	}
+
+	digest, _ := attachment(tpl, "digest", tpl.Name())
+	return digest
+}
+
+// Returns the digest of the output of the last Compile call, see Digest, empty if nothing has been compiled yet.
+func (c *Compiler) Digest() string {
+	return c.digest
+}
+
+// Returns the digest of the template of given name, compiling it on first use, see Digest.
+func (r *Renderer) Digest(name string) (string, error) {
+	tpl, _, err := r.lookup(name)
+	if err != nil {
+		return "", err
+	}
+
+	return Digest(tpl.master), nil
+}
+
+func digest(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
@@ -26,13 +26,35 @@ Any arbitrary attribute name / value pair can be added this way:
 
     a[href="http://www.google.com"]
 
+Values in double or single quotes and bare words starting with a lowercase letter are literals,
+anything else is an expression (see Data):
+
+    input[type=text][placeholder='Your "nickname"']
+
+Names of tags and attributes may carry an XML namespace prefix:
+
+    svg[xmlns:xlink="http://www.w3.org/1999/xlink"]
+        use[xlink:href="#logo"]
+
+Attributes of front end frameworks, starting with `@`, `:`, `v-` or `x-`, hold JavaScript rather than
+slim expressions, so their values are output as written:
+
+    button[@click=open = !open][x-on:keyup.escape=open = false]
+
 You can mix multiple attributes together
 
     a#someid[href="/"][title="Main Page"].main.link Click Link
 
 gets converted to
 
-    <a id="someid" class="main link" href="/" title="Main Page">Click Link</a>
+    <a id="someid" href="/" title="Main Page" class="main link">Click Link</a>
+
+Attributes are output in the order of their declaration, class names in place of the first one.
+
+Class shorthands and class attributes are merged into a single attribute. Class expressions may
+give a string or a slice of names, empty values and repeated names are left out:
+
+    a.btn[class=Classes]
 
 It is also possible to define these attributes within the block of a tag
 
@@ -44,6 +66,58 @@ It is also possible to define these attributes within the block of a tag
         .link
         | Click Link
 
+A tag line may end with a silent comment. Everything after a ` / ` following the tag, its id, classes
+and attributes is left out of the output:
+
+    div.card[data-id=ID]  / renders the product card
+
+Long lines may be continued on the next line. A trailing \ is dropped and joins the next line with its
+indentation trimmed, a trailing comma inside an open call or attribute continues the line as well:
+
+    a[href="/articles/2024/\
+        long-article-name"]\
+        [title=printf("%s by %s",
+            Title, Author)]
+
+Piped text and comments are taken as written.
+
+Text may span several lines, the lines nested below a piped text line continue it. They are joined with
+newlines, or with Options.TextSeparator, i.e. " " to flow a paragraph into a single line:
+
+    p
+        | Lorem ipsum dolor sit amet,
+          consectetur adipiscing elit,
+          sed do eiusmod tempor.
+
+Consecutive lines of piped text are joined as the layout puts them, directly in compact output and on lines
+of their own when pretty printed. Options.TextJoin joins them by a newline, a single space or nothing instead,
+so prose does not pick up whitespace it was not written with:
+
+    p
+        | Read the
+        | manual
+    // <p>Read the manual</p> with TextJoinSpace
+
+The lines nested below a silent comment are left out with it.
+
+Comments
+
+Lines starting with `/` are comments for template authors, they are left out along with the lines nested below
+them. Lines starting with `/!` are HTML comments, markup nested below them is compiled as usual and output within
+the comment:
+
+    / not output
+    /! sidebar disabled until launch
+        aside
+            p Coming soon
+    // <!-- sidebar disabled until launch <aside><p>Coming soon</p></aside> -->
+
+With Options.StripComments or Options.Minify set, HTML comments are left out of the output, apart from
+conditional comments and comments marked by `/!keep`, i.e. license notices:
+
+    /!keep Copyright 2024 Example Inc.
+    // <!-- Copyright 2024 Example Inc. -->
+
 Doctypes
 
 To add a doctype, use `!!!` or `doctype` keywords:
@@ -58,6 +132,19 @@ or use `doctype`
 
 Available options: `5`, `default`, `xml`, `transitional`, `strict`, `frameset`, `1.1`, `basic`, `mobile`
 
+Templates without a doctype get the one named by Options.DefaultDoctype, if set.
+
+With Options.Format set to xhtml, the content of script and style tags is enclosed in a CDATA section
+commented out for browsers, or in Options.ScriptWrapper and Options.StyleWrapper. Scripts of other types than
+JavaScript, like JSON, are left alone.
+
+With Options.Format set to text, the same template gives the plain text of its output, i.e. for the text/plain
+part of a multipart email. Markup is left out, blocks like paragraphs and headings are put on lines of their own,
+list items are marked with a dash and links are followed by their URL in parentheses. Values are output as they
+are, not escaped for HTML:
+
+    tpl, err := slim.Compile(source, slim.Options{Format: parser.FORMAT_TEXT})
+
 Tag Content
 
 For single line tag text, you can just append the text after tag name:
@@ -81,6 +168,14 @@ For multi line tag text, or nested tags, use indentation:
 
                     a[href="/"] Go To Main Page
 
+Every level of nesting may be indented with tabs or spaces, as long as its lines agree. Options.IndentStyle
+restricts templates to tabs only, spaces only or either of them throughout a file. Options.MixedIndentation
+decides whether a file mixing tabs and spaces fails, raises a warning listed by Compiler.Warnings, or gets its
+tabs expanded to spaces at Options.TabWidth.
+
+The content of script and style tags and of multi-line text is taken as written. Its lines only need to be
+indented deeper than the tag, the indentation of the first line is left out and anything beyond it is kept.
+
 Data
 
 Input template data can be reached by key names directly. For example, assuming the template has been
@@ -113,6 +208,34 @@ would print
 
     <a title="Ekin" href="/ekin.koc"></a>
 
+Other attributes declared more than once on a tag are resolved by Options.DuplicateAttributes: the last
+declaration wins by default, the first one may win instead, values may be merged or compilation may fail.
+
+With Options.BooleanAttributes enabled, the expression of a boolean attribute like checked, disabled or
+required decides whether it is present, its value is left out:
+
+    input[type="checkbox"][checked=Done][disabled=Locked]
+
+would print `<input type="checkbox" checked>` if Done is true and Locked is false. Values `if` takes as false,
+like false, nil, 0 or an empty string, leave the attribute out, others make it present without being output.
+Without it the value is output as is, i.e. `checked="true"`.
+
+Attributes without value are expanded as xhtml requires, i.e. `checked="checked"`, with Options.Format set to
+xhtml, and with the default format if the template has an xml or xhtml doctype like `doctype transitional`.
+Minified output keeps them short.
+
+With Options.OmitEmptyAttributes enabled, attributes whose expression gives nil, false or an empty string
+are left out instead of being output with an empty value.
+
+With Options.AttributeWrapColumn set, pretty printed tags whose attributes would exceed that column put each
+attribute on a line of its own, so long tags of generated HTML remain reviewable in diffs:
+
+    <input
+        type="email"
+        name="user[email]"
+        placeholder="you@example.com"
+        required />
+
 Expressions
 
 Slim can expand basic expressions. For example, it is possible to concatenate strings with + operator:
@@ -123,6 +246,8 @@ Arithmetic expressions are also supported:
 
     p You need #{50 - Friends} more friends to reach 50!
 
+Integer arithmetic which overflows fails the execution with an error, as integer division by zero does.
+
 Expressions can be used within attributes
 
     img[alt=Name + " " + LastName][src=Avatar]
@@ -136,6 +261,19 @@ all variables must start with a $ character and can be assigned as in the follow
         $fullname = Name + " " + LastName
         p Welcome #{$fullname}
 
+Assigning a variable declared before, even outside of the enclosing if or each block, updates it.
+Use `:=` to declare a new variable of the same name instead. Several variables take the elements of a slice:
+
+    $total = 0
+    each $item in Items
+        $total = $total + $item.Price
+    $first, $last = split(Name, " ")
+
+Variables declared by `let` only exist within its block:
+
+    let $user = Post.Author
+        a[href=$user.URL] #{$user.Name}
+
 If you need to access the supplied data itself (i.e. the object containing Name, LastName etc fields.) you can use `$` variable
 
     p $.Name
@@ -158,7 +296,10 @@ Again, it is possible to use arithmetic and boolean operators
         if Name == "Ekin" && LastName == "Koc"
             p Hey! I know you..
 
-There is a special syntax for conditional attributes. Only block attributes can have conditions;
+There is a special syntax for conditional attributes. The condition applies to the attribute preceding it
+and takes the rest of the line, so it comes last on a tag line or gets a line of its own within the block:
+
+    div.hasfriends ? Friends > 0
 
     div
         .hasfriends ? Friends > 0
@@ -170,6 +311,18 @@ perfectly fine to use the same method for other types of attributes:
         #foo ? Name == "Ekin"
         [bar=baz] ? len(Repositories) > 0
 
+Conditional comments of legacy browsers and email clients enclose markup shown to those matching their condition
+only. A trailing `>` gives the downlevel-revealed form instead, shown to all clients not knowing conditional
+comments as well:
+
+    /[if mso]
+        table
+    // <!--[if mso]><table></table><![endif]-->
+
+    /[if !mso]>
+        div
+    // <!--[if !mso]><!--><div></div><!--<![endif]-->
+
 Iterations
 
 It is possible to iterate over arrays and maps using `each`:
@@ -182,13 +335,203 @@ would print
     p slim
     p dateformat
 
-It is also possible to iterate over values and indexes at the same time
+With two variables, like Go's range clause, the first one gets the index of slices, arrays and integer ranges
+or the key of maps, the second one the value. Channels only support a single variable.
 
     each $i, $repo in Repositories
         p
             .even ? $i % 2 == 0
             .odd ? $i % 2 == 1
 
+Within an each block, `$loop` describes the current iteration with the fields Index (from 0), Number (from 1),
+Length, First, Last, Even and Odd:
+
+    each $tag in Tags
+        | #{$tag}
+        if !$loop.Last
+            | ,
+
+Integer ranges, bounds included, save preparing a slice of numbers:
+
+    each $page in 1..Pages
+        a[href="?page=" + print($page)] #{$page}
+
+Maps are iterated in ascending key order, so the output does not change between executions.
+The `keys` helper gives the sorted keys of a map:
+
+    each $name in keys(Scores)
+        p #{$name}
+
+A `while` block repeats as long as its condition holds, an `until` block until it holds. Loops taking more
+than Options.MaxLoopIterations iterations fail the execution:
+
+    while Cursor.Next
+        p #{Cursor.Item.Name}
+
+Helpers
+
+Besides the Go template builtins, a set of helper functions is available to every template:
+
+    p #{upper(Name)}
+    p #{truncate(Bio, 140)}
+    p #{default(Nickname, Name)}
+    p #{join(Repositories, ", ")}
+
+Available string helpers: `upper`, `lower`, `title`, `trim`, `truncate`, `default`, `join`, `keys`, `split`, `replace`
+
+Time helpers format a time.Time as relative time or RFC 3339, and durations by their two largest units:
+
+    time[datetime=datetime(Post.Published)] #{timeago(Post.Published)}
+    // <time datetime="2024-03-01T09:30:00Z">3 hours ago</time>
+    span #{duration(Video.Length)}
+    // <span>1h 5m</span>
+
+Output is escaped according to html/template rules. Trusted content can be marked safe with
+`raw` (HTML), `safeURL` (URLs with any scheme) and `safeJS` (JavaScript expressions):
+
+    div #{raw(Article.Body)}
+    a[href=safeURL(Link)] Open
+
+Character references written in text, like `&nbsp;` or `&#8594;`, are output as written rather than escaped
+once more. Those of static attribute values are resolved and the value is escaped once, in the context of the
+attribute, so `a[href="/a?x=1&amp;y=2"]` links to /a?x=1&y=2. Static values of event handler attributes, like
+onclick, are output as JavaScript rather than as a string. `nbsp` and `entity` output references from expressions:
+
+    p 10#{nbsp()}km #{entity("rarr")}
+    a[title="Next &rarr;"] More
+
+`image_tag` outputs a lazily loaded img element offering variants of an image by width in its srcset, named by
+appending the width to the name of the image, sizes defaults to 100vw:
+
+    | #{image_tag("/img/hero.jpg", "Hero", "(min-width: 800px) 50vw", 320, 640, 1280)}
+    // <img src="/img/hero.jpg" srcset="/img/hero-320w.jpg 320w, /img/hero-640w.jpg 640w, /img/hero-1280w.jpg 1280w" ...>
+
+With Options.ImageManifest set, i.e. to the manifest of an image pipeline, the URLs of variants are looked up in
+it instead, all of its variants are offered when no widths are given and unknown images fail rendering.
+The element is closed like void tags of the format. Its URLs are checked like html/template checks those of
+attributes, or by URLSchemes with Options.CheckURLs set, unsafe ones are replaced by #ZgotmplZ.
+
+`jsonify` encodes view data as JSON for islands of progressive enhancement front ends. Unlike `json`, its output
+is not quoted within scripts, and <, > and & are escaped so the data cannot break out of the script element:
+
+    script#props[type="application/json"]
+        #{jsonify(Props)}
+
+While developing, `dump` pretty prints a value as JSON within a <pre> element and `typeOf`
+reports its Go type:
+
+    | #{dump($)}
+    p #{typeOf(Friends)}
+
+Fragment Caching
+
+The rendered output of a `cache` block is stored under the value of its key expression and reused
+by later executions. An optional lifetime is given as a Go duration or in seconds:
+
+    cache "navigation" 10m
+        ul#nav
+            each $item in Menu
+                li #{$item.Title}
+
+Fragments are kept in an in-memory LRU cache unless another runtime.Cache is assigned to the Compiler
+or Renderer. A fragment sees the data and the variables of its enclosing scope. Keys are scoped to the template,
+so blocks of different templates using the same key are stored apart.
+
+Content For
+
+A `content_for` (or `provide`) block renders content to be output at the `yield` point of the same name,
+even if that point comes earlier, like page specific tags within the head of a layout:
+
+    layout.slim
+        html
+            head
+                yield head
+            body
+                block content
+
+    page.slim
+        extend layout
+
+        block content
+            content_for head
+                meta[name="description"][content=Summary]
+            p #{Body}
+
+Content of several blocks of the same name is output in order. content_for blocks can not be nested.
+
+Defines
+
+A `define` block compiles its content into a named template of its own rather than outputting it, so a single
+file can hold several small partials. They are executed with the data passed to them:
+
+    define badge
+        span.badge #{Label}
+
+    define icons/star
+        svg.icon
+            use[href="#star"]
+
+An `embed` executes a template of the set by its name, passing it the result of an optional expression or the
+current data otherwise. Templates defined elsewhere, i.e. plain html/template ones added through Parse while
+migrating, are embedded alike:
+
+    ul
+        each $item in Items
+            li
+                embed "badge" $item
+        embed "legacy/footer"
+
+Mixins
+
+A `mixin` is a component compiled into a template of its own, output by calls of its name prefixed by `+`. The
+arguments of a call are bound to the parameters of the mixin in order, missing ones are nil. A `slot` of a mixin
+outputs the content a call fills it with, or its own content if the call does not fill it. Content nested below a
+call fills the slots of the same name, the rest of it fills the unnamed default slot:
+
+    mixin card($title)
+        div.card
+            header
+                slot header
+                    h3 #{$title}
+            div.body
+                slot
+            footer
+                slot footer
+
+    +card("News")
+        p #{Article.Summary}
+        slot footer
+            a[href=Article.URL] More
+
+Parameters may have default values, applied when a call omits the argument or passes nil, and the last one may
+collect the remaining arguments into a list, as in Pug. Defaults may refer to the parameters before them:
+
+    mixin button($label, $kind="primary", ...$icons)
+        button[class=$kind]
+            | #{$label}
+            each $icon in $icons
+                i[class=$icon]
+
+    +button("Delete", "danger", "icon-trash")
+
+Content filling slots is rendered with the data and the variables of the caller, like a fragment of a cache
+block. Elsewhere a `slot` line is the slot element of web components.
+
+The id, classes and attributes following a call are passed on to the tags of the mixin marked by `&attributes`,
+either right after the tag or on a line of its own below it. Classes are added to those of the tag, other
+attributes replace the attributes of the same name of the tag:
+
+    mixin button($label)
+        button.btn[type="button"]&attributes
+            | #{$label}
+
+    +button("Save").primary[type="submit"][disabled=Busy]
+
+Attributes of false or nil are left out as usual. Passed values are escaped in the context of their attribute like
+those of the tag itself, URLs with unsafe schemes are filtered. Event handlers and other attributes holding
+scripts or URLs by a name html/template does not know, like onclick or data-href, are only passed on with values
+typed as safe, i.e. template.JS.
+
 Includes
 
 A template can include other templates using `include`:
@@ -210,6 +553,91 @@ gets compiled to
         p this is template a
         p this is template b
 
+Targets of include and extend without an extension are looked up with the extensions of Options.Extensions,
+tried in order, `.html.slim` and `.slim` by default. They are relative to the directory of the template file,
+templates parsed from strings need Compiler.SetTemplateDir to import or extend others.
+
+Targets not found there are searched in the directories of Options.IncludePaths, in order, so shared component
+libraries and vendored themes are used without copying their files into every project:
+
+    slim.NewWithOptions(slim.WithIncludePaths("../components", "vendor/themes/base"))
+
+Component libraries are published as Go packages embedding their templates, mounted by downstream projects under
+a prefix of their choice with WithLibrary, see Library. Targets starting with the prefix are read from the library,
+whose templates import each other by their path within it:
+
+    // package ui of the design system
+    //go:embed components
+    var components embed.FS
+
+    func Register(prefix string) slim.Option {
+        return slim.WithLibrary(prefix, slim.Library{FS: components, Dir: "components"})
+    }
+
+    // a service using it
+    views := slim.NewSet(slim.WithLoader(viewsFS), ui.Register("ui"))
+
+    import ui/button
+    +button("Save").primary
+
+Imports of .html or .htm files no template resolves from are output as is, so existing HTML partials can be
+used while a site is migrated to slim:
+
+    body
+        import partials/header.html
+
+Files other than templates, like SVG icons or critical CSS, are output as is by `include_raw`. With
+`include_raw unescaped`, the content is output by the unescaped helper instead of being part of the template,
+so html/template does not take its markup into account:
+
+    body
+        include_raw icons/logo.svg
+        include_raw unescaped snippets/widget.html
+
+Filters
+
+Content nested below a `:name` filter is converted to HTML at compile time, `markdown` converts a file
+relative to the template the same way as :markdown:
+
+    article
+        markdown docs/intro.md
+        :markdown
+            # Getting started
+
+            Install the *slim* package first.
+
+Filters are registered with RegisterFilter or passed in Options.Filters. Building with the goldmark tag
+registers a Markdown renderer based on github.com/yuin/goldmark.
+
+CommandFilter pipes the content through an external command and caches its output by the hash of the content:
+
+    slim.RegisterFilter("pandoc", slim.CommandFilter("pandoc", "--from=rst", "--to=html"))
+
+The command is killed when it takes longer than 30 seconds and the 256 most recently used results are kept,
+FilterCommand configures both:
+
+    slim.RegisterFilter("scss", slim.FilterCommand{Name: "sass", Args: []string{"--stdin"}, Timeout: 5 * time.Second}.Filter())
+
+Go Templates
+
+Go template code nested below `go:`, or following `=` on a line starting with `={{`, is written into the generated
+template as is, rather than output as text. It is an escape hatch for actions slim has no syntax for, values are
+still escaped by html/template:
+
+    ul
+        go:
+            {{range $i, $name := .Names}}
+            <li>{{$i}}: {{$name}}</li>
+            {{end}}
+        ={{with .Title}}<h2>{{.}}</h2>{{end}}
+
+Actions written within text are output as text, unless Options.PassthroughActions is enabled, globally or by the
+pragma of a file, for teams writing go template actions inline:
+
+    /! slim: passthrough_actions=true
+    p
+        | Signed in as {{.User.Name}}
+
 Inheritance
 
 A template can inherit other templates. In order to inherit another template, an `extends` keyword should be used.
@@ -243,6 +671,163 @@ Parent template can define several named blocks and child template can modify th
             div#main
                 p Some content here
 
+Parents may extend templates in turn, i.e. a section layout extending the site layout. A template then overrides,
+appends to or prepends to the blocks of all templates above it, as filled by the nearer ones, including blocks
+nested within the content a nearer parent fills a block with.
+
+Blocks may be placed anywhere in a layout, i.e. within tags, conditions or loops, their content then keeps the
+variables and the formatting of their place. Attributes heading the content of a block placed immediately within a
+tag are added to that tag:
+
+    layout.slim
+        nav
+            block nav
+
+    page.slim
+        extend layout
+
+        block nav
+            .active
+            a[title="Home"]
+
+With Options.Strict enabled, compilation fails on typos which would otherwise only show up at runtime, if at
+all: calls of helpers which are neither built in nor registered, blocks overriding no block of the parent
+templates and parent templates defining no blocks at all.
+
+With Options.ValidateHTML enabled, compilation fails on markup browsers would repair in surprising ways: block
+elements nested inside a p or a phrasing element like span, a second title, unknown elements other than custom
+elements, and void elements given content.
+
+With Options.CheckURLs enabled, URLs of href, src, action and formaction attributes given by expressions are
+checked at render time. Those of schemes other than Options.URLSchemes, i.e. javascript: or data:, are replaced by
+#ZgotmplZ, even when marked safe by the safeURL helper:
+
+    a[href=safeURL(Profile.Website)]
+
+With Options.AuditEscaping enabled, compilation fails on expressions whose output html/template cannot escape
+reliably, as inline HTML may place them: within tag or attribute names, within unquoted attribute values or within
+attribute values whose quotes are written by different lines.
+
+Warnings of a Compiler include variables which are assigned but never used, counting the uses within passthrough
+blocks and, when actions are passed through, within the actions of text, and blocks of an extending template
+which match no block of its parents, so their content is dropped in favour of the defaults of the layout. Those
+point out a parent block of a similar name, i.e. content for contnet, along with its position. LintDir parses a
+whole template tree and warns on blocks of layouts which none of the templates extending them fill as well:
+
+    warnings, err := slim.LintDir("./views", slim.DefaultOptions)
+
+Pragmas
+
+A comment on the first line of a file starting with `slim:` overrides compiler options for the content of that
+file only, so pages and emails of a single template tree may be compiled differently:
+
+    /! slim: pretty=false, format=html
+
+Available options: `pretty`, `minify`, `strip_comments`, `line_numbers`, `trim_markers`, `omit_empty_attributes`, `boolean_attributes`,
+`passthrough_actions` (true or false), `format` (html or xhtml), `attribute_quote` (" or '), `text_join` (layout, newline, space or none),
+`attribute_wrap_column` and
+`max_loop_iterations`.
+
+Limits
+
+Parsing fails with an error once a template exceeds Options.Limits on nesting, file size, import depth or line
+length. The defaults of parser.DefaultLimits keep hostile templates and import cycles from exhausting memory or stack.
+
+ParseBytes is meant for templates of semi-trusted sources, i.e. edited by the admins of a CMS. Whatever its input
+holds, it fails with a *parser.Error rather than panicking, and rejects input beyond the limits before scanning it.
+The fuzz target FuzzParseBytes keeps it that way, run it with `go test -fuzz FuzzParseBytes`.
+
+CompileDir compiles every template below a directory into a single template, each defined by its path relative
+to the directory without extension and executed with ExecuteTemplate. CompileDirContext stops once its context
+is done:
+
+    views, err := slim.CompileDir("./views", slim.DefaultOptions)
+    views.ExecuteTemplate(w, "users/show", user)
+
+TemplateName returns the name a file is defined by, slimc names the templates of several inputs compiled into one
+set the same way.
+
+Digest returns a SHA-256 digest of the source a template has been compiled from, including the templates it imports
+or extends and the files it includes. It only changes when templates or options do, so combined with a version of
+the data it makes an ETag or a cache key of the rendered page:
+
+    etag := slim.Digest(views.Lookup("users/show")) + "-" + user.Version
+
+A Set is the container to hold compiled templates at runtime instead of maps of templates. It compiles templates
+added by AddString or AddFile with its options, loader and functions, and looks them up safely while others are
+added or replaced:
+
+    views := slim.NewSet(slim.WithLoader(viewsFS))
+    views.AddFuncs(template.FuncMap{"price": formatPrice})
+    err := views.AddFile("users/show", "users/show.slim")
+    views.ExecuteTemplate(w, "users/show", user)
+
+Rendering
+A Renderer compiles templates below a root directory on first use and renders them into http responses.
+
+    renderer := slim.NewRenderer("./views")
+    renderer.HTML(w, http.StatusOK, "users/show", user)
+
+Templates missing in the root directory are looked up in the directories of Roots, in order, as are the targets
+of their imports and extends. A site thereby overrides single partials of a theme, which in turn overrides those
+of the core, without touching the originals:
+
+    renderer.Roots = []string{"./themes/dark", "./core/views"}
+
+NewLayeredFS stacks file systems the same way for a Compiler given a loader:
+
+    compiler := slim.NewWithOptions(slim.WithLoader(slim.NewLayeredFS(os.DirFS("site"), themeFS, coreFS)))
+
+Values shared by every template, like the site name, are registered with SetGlobal. Request scoped values
+are attached to the request context with WithValue and rendered with Render. Templates read both through
+the `global` helper, request scoped values taking precedence:
+
+    renderer.SetGlobal("SiteName", "Example")
+    req = req.WithContext(slim.WithValue(req.Context(), "CurrentUser", user))
+    renderer.Render(w, req, http.StatusOK, "users/show", user)
+
+    title #{global("SiteName")}
+
+RenderContext renders with a context.Context rather than a request and stops once the context is done. Helpers
+registered with AddContextFuncs take the context of the render as their first argument, templates call them
+without it:
+
+    renderer.AddContextFuncs(template.FuncMap{
+        "t": func(ctx context.Context, key string) string { return translate(localeOf(ctx), key) },
+    })
+
+    h1 #{t("welcome")}
+
+Pages are rendered into pooled buffers before they are written, so a failing render responds with an error
+rather than half a page. Buffers grown beyond MaxBufferSize are not kept. With Streaming enabled, very large pages
+are written as they are executed instead, only a failing execution cuts them off. Stats returns the counters of template lookups, buffers and renders.
+
+Hooks registered with BeforeRender and AfterRender are called around every render, i.e. for timing or tracing:
+
+    renderer.AfterRender(func(name string, d time.Duration, err error) {
+        log.Printf("rendered %s in %s", name, d)
+    })
+
+With Options.TracerProvider set, ParseFile, Compile and every render start a span carrying the template name,
+renders the bytes written and whether the compiled template was cached. Building with the otel tag provides
+OtelTracerProvider adapting the providers of OpenTelemetry:
+
+    renderer.TracerProvider = slim.OtelTracerProvider(otel.GetTracerProvider())
+
+Options.Metrics receives the durations of compiles and renders, their failures and the cache hits of template
+lookups. NewExpvarMetrics publishes them through expvar, building with the prometheus tag provides
+NewPrometheusMetrics:
+
+    renderer.Metrics = slim.NewPrometheusMetrics(prometheus.DefaultRegisterer)
+
+With Development enabled, a failing render responds with an error page showing the offending line of slim source.
+Watch drops compiled templates whenever files below the root change. Together with LiveReload and the Middleware,
+open pages reload themselves on every change.
+
+    renderer.Development, renderer.LiveReload = true, true
+    renderer.Watch(time.Second)
+    http.ListenAndServe(":8080", renderer.Middleware(mux))
+
 License
 (The MIT License)
 
@@ -0,0 +1,26 @@
+package slim
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// character references of HTML, named or numeric, i.e. &nbsp; or &#8594;
+var rentity = regexp.MustCompile(`&(?:[a-zA-Z][a-zA-Z0-9]*|#[0-9]+|#[xX][0-9a-fA-F]+);`)
+
+// staticValue returns the expression giving the static value of attribute name. Its character references are
+// resolved, so html/template escapes the value once and in the context of the attribute, i.e. a URL of href.
+// Values of event handlers are JavaScript written by the author rather than strings.
+func (c *Compiler) staticValue(name, value string) string {
+	if name != "class" && rentity.MatchString(value) {
+		value = html.UnescapeString(value)
+	}
+
+	// html/template treats attributes named on* as event handlers, with or without a data- prefix
+	if strings.HasPrefix(strings.TrimPrefix(strings.ToLower(name), "data-"), "on") {
+		return `__slim_js "` + c.escape(value) + `"`
+	}
+
+	return `"` + c.escape(value) + `"`
+}
@@ -0,0 +1,117 @@
+package slim
+
+import (
+	"errors"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// number of source lines shown around the offending one
+const errorPageContext = 3
+
+var rslimerror = regexp.MustCompile(`^Slim Error(?: in <(.*?)>)?: (.*) - Line: (\d+), Column: (\d+)(?:, Length: \d+)?$`)
+
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Template error: {{.Name}}</title>
+<style>
+body { margin: 0; font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; background: #fafafa; color: #222; }
+header { padding: 24px 32px; background: #b3261e; color: #fff; }
+header h1 { margin: 0 0 8px; font-size: 20px; font-weight: 600; }
+header p { margin: 0; font-family: Menlo, Consolas, monospace; font-size: 14px; white-space: pre-wrap; }
+main { padding: 24px 32px; }
+.location { margin: 0 0 12px; font-family: Menlo, Consolas, monospace; font-size: 13px; color: #555; }
+pre { margin: 0; padding: 12px 0; background: #fff; border: 1px solid #ddd; border-radius: 4px; overflow-x: auto; font-size: 13px; line-height: 1.5; }
+.line { display: block; padding: 0 16px; white-space: pre; }
+.line .number { display: inline-block; width: 48px; color: #999; user-select: none; }
+.line.current { background: #fde7e6; }
+.line.current .number { color: #b3261e; font-weight: 600; }
+</style>
+</head>
+<body>
+<header>
+<h1>Failed to render {{.Name}}</h1>
+<p>{{.Message}}</p>
+</header>
+<main>
+{{if .Filename}}<p class="location">{{.Filename}}{{if .Line}}:{{.Line}}:{{.Column}}{{end}}</p>{{end}}
+{{if .Lines}}<pre>{{range .Lines}}<span class="line{{if .Current}} current{{end}}"><span class="number">{{.Number}}</span>{{.Text}}</span>
+{{end}}</pre>{{end}}
+</main>
+</body>
+</html>
+`))
+
+type errorPageLine struct {
+	Number  int
+	Text    string
+	Current bool
+}
+
+type errorPageData struct {
+	Name     string
+	Message  string
+	Filename string
+	Line     int
+	Column   int
+	Lines    []errorPageLine
+}
+
+// writeErrorPage renders a development error page for a failed render of template name,
+// showing the slim source around the position err refers to, if known.
+func writeErrorPage(w io.Writer, name string, err error) error {
+	data := errorPageData{Name: name, Message: err.Error()}
+
+	var execErr *ExecError
+	if errors.As(err, &execErr) {
+		data.Message = execErr.message
+		data.Filename = execErr.Filename
+		data.Line = execErr.Line
+		data.Column = execErr.Column
+	} else if matches := rslimerror.FindStringSubmatch(err.Error()); matches != nil {
+		data.Filename = matches[1]
+		data.Message = matches[2]
+		data.Line, _ = strconv.Atoi(matches[3])
+		data.Column, _ = strconv.Atoi(matches[4])
+	}
+
+	if len(data.Filename) > 0 && data.Line > 0 {
+		if source, err := ioutil.ReadFile(data.Filename); err == nil {
+			data.Lines = excerpt(string(source), data.Line)
+		}
+	}
+
+	return errorPage.Execute(w, data)
+}
+
+// excerpt returns the lines of source surrounding line, numbered from 1,
+// none if line is not within source, i.e. the file has changed since the error.
+func excerpt(source string, line int) []errorPageLine {
+	lines := strings.Split(source, "\n")
+	if line > len(lines) {
+		return nil
+	}
+
+	from := line - errorPageContext
+	if from < 1 {
+		from = 1
+	}
+
+	to := line + errorPageContext
+	if to > len(lines) {
+		to = len(lines)
+	}
+
+	result := make([]errorPageLine, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		result = append(result, errorPageLine{i, strings.TrimRight(lines[i-1], "\r"), i == line})
+	}
+
+	return result
+}
@@ -0,0 +1,110 @@
+package slim
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+	textTemplate "text/template"
+)
+
+var rexecerror = regexp.MustCompile(`^template: (.*?):(\d+)(?::(\d+))?: (?:executing "(?:.*?)" at <(.*?)>: )?(.*)$`)
+
+// recoverError turns a value recovered from a panic of parsing or compiling into an error.
+// Failures are raised as *parser.Error, anything else is a bug which must not escape the API as a panic either.
+func recoverError(r interface{}) error {
+	switch r := r.(type) {
+	case error:
+		return r
+	case string:
+		return errors.New(r)
+	}
+
+	return fmt.Errorf("%v", r)
+}
+
+// ExecError is an error raised while executing a compiled template, located in slim source.
+type ExecError struct {
+	Filename string
+	Line     int
+	Column   int
+	// Error reported by html/template
+	Err error
+
+	message string
+}
+
+func (e *ExecError) Error() string {
+	if len(e.Filename) > 0 {
+		return fmt.Sprintf("Slim Error in <%s>: %s - Line: %d, Column: %d", e.Filename, e.message, e.Line, e.Column)
+	}
+
+	return fmt.Sprintf("Slim Error: %s - Line: %d, Column: %d", e.message, e.Line, e.Column)
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// Translates an error returned by executing tmpl into an *ExecError pointing at the slim source
+// the failing action has been compiled from. Expressions referring to temporary variables of the
+// generated template are left out of the message.
+// Errors of templates not created by this package, or not raised by execution, are returned as is.
+func ExplainExecError(err error, tmpl *template.Template) error {
+	var execErr textTemplate.ExecError
+	if err == nil || tmpl == nil || !errors.As(err, &execErr) {
+		return err
+	}
+
+	message := execErr.Error()
+
+	// fragments, like cache and content_for blocks, are rendered by runtime functions,
+	// so errors raised within them are wrapped by the error of the calling action
+	if at := strings.LastIndex(message, "template: "); at > 0 && rexecerror.MatchString(message[at:]) {
+		message = message[at:]
+	}
+
+	matches := rexecerror.FindStringSubmatch(message)
+	if matches == nil {
+		return err
+	}
+
+	m := lookupSourceMap(tmpl, matches[1])
+	if m == nil {
+		return err
+	}
+
+	line, _ := strconv.Atoi(matches[2])
+	column, _ := strconv.Atoi(matches[3])
+	if column == 0 {
+		column = 1
+	}
+
+	mapping, ok := m.LookupLine(line, column)
+	if !ok {
+		return err
+	}
+
+	message = matches[5]
+	if at := matches[4]; len(at) > 0 && !strings.Contains(at, "$__slim_") {
+		message = "at <" + at + ">: " + message
+	}
+
+	return &ExecError{
+		Filename: mapping.Filename,
+		Line:     mapping.Line,
+		Column:   mapping.Column,
+		Err:      err,
+		message:  message,
+	}
+}
+
+func lookupSourceMap(tmpl *template.Template, name string) *SourceMap {
+	if named := tmpl.Lookup(name); named != nil && named != tmpl {
+		return attachedSourceMapOf(tmpl, name)
+	}
+
+	return attachedSourceMapOf(tmpl, tmpl.Name())
+}
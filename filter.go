@@ -0,0 +1,160 @@
+package slim
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golib/slim/parser"
+	"github.com/golib/slim/runtime"
+)
+
+// Filter converts the content of a filter, like :markdown, to HTML at compile time.
+type Filter func(content string) (string, error)
+
+// filters available to all templates
+var filters = map[string]Filter{}
+
+// Registers a filter available to all templates, like the Markdown renderer of :markdown and the markdown directive.
+// Filters of Options.Filters take precedence. It is not safe for concurrent use and should be called on initialization,
+// before templates are compiled.
+func RegisterFilter(name string, filter Filter) {
+	filters[name] = filter
+}
+
+const (
+	// Time the external command of a CommandFilter may take before it is killed
+	DefaultFilterTimeout = 30 * time.Second
+	// Number of results of its external command a CommandFilter keeps
+	DefaultFilterCacheSize = 256
+)
+
+// FilterCommand configures a filter piping content through an external command, see CommandFilter.
+type FilterCommand struct {
+	Name string
+	Args []string
+	// Time the command may take, it is killed beyond it and the filter fails.
+	// Default: DefaultFilterTimeout
+	Timeout time.Duration
+	// Number of results cached by the hash of the content, the least recently used ones are dropped beyond it.
+	// Default: DefaultFilterCacheSize
+	CacheSize int
+}
+
+// Returns a filter piping content through an external command, like sass, esbuild or pandoc, and taking what it
+// writes to standard output. Results are cached by the hash of the content, so snippets are only processed once.
+// The command is killed after DefaultFilterTimeout, see FilterCommand to configure it.
+//
+//	slim.RegisterFilter("scss", slim.CommandFilter("sass", "--stdin", "--style=compressed"))
+func CommandFilter(name string, args ...string) Filter {
+	return FilterCommand{Name: name, Args: args}.Filter()
+}
+
+// Returns the filter running the command, see CommandFilter.
+func (command FilterCommand) Filter() Filter {
+	timeout := command.Timeout
+	if timeout <= 0 {
+		timeout = DefaultFilterTimeout
+	}
+
+	size := command.CacheSize
+	if size <= 0 {
+		size = DefaultFilterCacheSize
+	}
+
+	cache := runtime.NewLRUCache(size)
+
+	return func(content string) (string, error) {
+		key := digest(content)
+
+		if result, ok := cache.Get(key); ok {
+			return string(result), nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		var stdout, stderr bytes.Buffer
+
+		cmd := exec.CommandContext(ctx, command.Name, command.Args...)
+		cmd.Stdin = strings.NewReader(content)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		// children of the killed command may keep its output open
+		cmd.WaitDelay = time.Second
+
+		if err := cmd.Run(); err != nil {
+			if ctx.Err() != nil {
+				return "", fmt.Errorf("%s: timed out after %s", command.Name, timeout)
+			}
+
+			if message := strings.TrimSpace(stderr.String()); len(message) > 0 {
+				return "", fmt.Errorf("%s: %w: %s", command.Name, err, message)
+			}
+
+			return "", fmt.Errorf("%s: %w", command.Name, err)
+		}
+
+		result := stdout.String()
+		cache.Set(key, template.HTML(result), 0)
+
+		return result, nil
+	}
+}
+
+// the output of filters and raw files is markup, actions of Go templates in it are not executed
+var filterDelimiters = strings.NewReplacer("{{", `{{"{{"}}`, "}}", `{{"}}"}}`)
+
+func (c *Compiler) visitFilter(node *parser.Filter) {
+	filter := c.Filters[node.Name]
+	if filter == nil {
+		filter = filters[node.Name]
+	}
+
+	if filter == nil {
+		if node.Name == "markdown" {
+			panic("Unable to convert Markdown without a renderer, build with the goldmark tag or register a markdown filter.")
+		}
+
+		panic(fmt.Sprintf("Unknown filter %s.", node.Name))
+	}
+
+	html, err := filter(node.Content)
+	if err != nil {
+		panic(fmt.Errorf("Filter %s failed: %w", node.Name, err))
+	}
+
+	c.writeMarkup(html)
+}
+
+func (c *Compiler) visitRawFile(node *parser.RawFile) {
+	if node.Unescaped {
+		c.indent(0, true)
+		c.write(`{{unescaped ` + strconv.Quote(strings.TrimRight(node.Content, "\n")) + `}}`)
+		return
+	}
+
+	c.writeMarkup(node.Content)
+}
+
+// writeMarkup writes static markup on lines of its own.
+func (c *Compiler) writeMarkup(html string) {
+	html = filterDelimiters.Replace(strings.TrimRight(html, "\n"))
+
+	c.indent(0, true)
+
+	lines := strings.Split(html, "\n")
+	for i := 0; i < len(lines); i++ {
+		c.write(lines[i])
+
+		if i < len(lines)-1 {
+			c.write("\n")
+			c.indent(0, false)
+		}
+	}
+}
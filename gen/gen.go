@@ -0,0 +1,364 @@
+// Package gen compiles slim templates into a Go source file, declaring a typed
+// RenderXxx function per template. It is meant to be driven by go:generate,
+// either through slimc:
+//
+//	//go:generate slimc -type PageData -o views_gen.go views/*.slim
+//
+// or from a small generator program calling Generate.
+package gen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/build"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/golib/slim"
+)
+
+type Config struct {
+	// Glob patterns of the slim templates to compile, i.e. "views/*.slim"
+	Inputs []string
+	// Path of the generated Go file
+	Output string
+	// Package name of the generated file.
+	// Default: name of the output directory
+	Package string
+	// Data type of the Render functions. It can be a local type name (PageData), or
+	// qualified with the full import path of its package (example.com/app/models.PageData) in which case
+	// the package is imported. Packages outside the standard library need their full path, mypkg.PageData is
+	// rejected rather than importing "mypkg".
+	// Default: interface{}
+	Type string
+	// Data types of individual templates, overriding Type. Keys are the slash separated paths of the templates
+	// relative to Dir with or without extension, i.e. "admin/index" or "admin/index.slim".
+	Types map[string]string
+	// Directory the names of the Render functions are derived relative to, so views/admin/users.slim
+	// relative to views is rendered by RenderAdminUsers and does not clash with views/users.slim.
+	// Default: the directory common to all inputs
+	Dir string
+	// Compiler options
+	Options slim.Options
+}
+
+// Compiles all templates matching config.Inputs and writes the generated Go source to config.Output.
+func Generate(config Config) error {
+	if len(config.Output) == 0 {
+		return errors.New("gen: no output file given")
+	}
+
+	if len(config.Package) == 0 {
+		abs, err := filepath.Abs(config.Output)
+		if err != nil {
+			return err
+		}
+
+		config.Package = filepath.Base(filepath.Dir(abs))
+	}
+
+	var buf bytes.Buffer
+
+	if err := Write(&buf, config); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(config.Output, buf.Bytes(), 0644)
+}
+
+// Same as Generate but writes the generated source into given io.Writer instance.
+// config.Output is ignored, config.Package must be set.
+func Write(out io.Writer, config Config) error {
+	if len(config.Package) == 0 {
+		return errors.New("gen: no package name given")
+	}
+
+	filenames, err := glob(config.Inputs)
+	if err != nil {
+		return err
+	}
+
+	// names of the imported packages by import path
+	imports := map[string]string{
+		"html/template":                 "template",
+		"io":                            "io",
+		"github.com/golib/slim/runtime": "runtime",
+	}
+
+	dir := config.Dir
+	if len(dir) == 0 {
+		if dir, err = commonDir(filenames); err != nil {
+			return err
+		}
+	}
+
+	// template files by the names of their Render functions
+	names := make(map[string]string)
+
+	var body bytes.Buffer
+
+	for _, filename := range filenames {
+		cmp := slim.New()
+		cmp.Options = config.Options
+
+		if err := cmp.ParseFile(filename); err != nil {
+			return err
+		}
+
+		src, err := cmp.String()
+		if err != nil {
+			return err
+		}
+
+		rel, err := relativePath(dir, filename)
+		if err != nil {
+			return err
+		}
+
+		name := templateName(rel)
+
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("gen: templates %s and %s would both be rendered by Render%s", other, filename, name)
+		}
+		names[name] = filename
+
+		varname := "tpl" + name
+
+		typeName, importPath, err := qualifyType(config.typeOf(rel))
+		if err != nil {
+			return err
+		}
+
+		if len(importPath) > 0 {
+			imports[importPath] = packageName(importPath)
+		}
+
+		fmt.Fprintf(&body, "\nvar %s = template.Must(runtime.New(%s, nil).Parse(%s))\n",
+			varname, strconv.Quote(filepath.Base(filename)), strconv.Quote(src))
+
+		fmt.Fprintf(&body, "\n// Render%s executes %s with data and writes the output to w.\n", name, filepath.Base(filename))
+		fmt.Fprintf(&body, "func Render%s(w io.Writer, data %s) error {\n", name, typeName)
+		fmt.Fprintf(&body, "\treturn %s.Execute(w, data)\n", varname)
+		fmt.Fprintln(&body, "}")
+	}
+
+	paths := make([]string, 0, len(imports))
+	for path := range imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+
+	fmt.Fprintln(&buf, "// Code generated by github.com/golib/slim/gen. DO NOT EDIT.")
+	fmt.Fprintln(&buf)
+	fmt.Fprintf(&buf, "package %s\n\n", config.Package)
+
+	fmt.Fprintln(&buf, "import (")
+	for _, importPath := range paths {
+		// packages named other than the last element of their path are imported by name
+		if name := imports[importPath]; name != path.Base(importPath) {
+			fmt.Fprintf(&buf, "\t%s %s\n", name, strconv.Quote(importPath))
+		} else {
+			fmt.Fprintf(&buf, "\t%s\n", strconv.Quote(importPath))
+		}
+	}
+	fmt.Fprintln(&buf, ")")
+
+	body.WriteTo(&buf)
+
+	source, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	_, err = out.Write(source)
+	return err
+}
+
+// templateName turns the path of a template relative to the directory of Config.Dir into the suffix of
+// its Render function, i.e. AdminUserProfile of admin/user_profile.html.slim.
+func templateName(rel string) string {
+	return camelCase(path.Join(path.Dir(rel), baseName(rel)))
+}
+
+// relativePath returns the slash separated path of filename relative to dir.
+func relativePath(dir, filename string) (string, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+
+	if dir, err = filepath.Abs(dir); err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil {
+		return "", err
+	}
+
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("gen: template %s is not within %s", filename, dir)
+	}
+
+	return filepath.ToSlash(rel), nil
+}
+
+// commonDir returns the deepest directory holding all filenames.
+func commonDir(filenames []string) (string, error) {
+	var dir string
+
+	for i, filename := range filenames {
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			return "", err
+		}
+
+		if i == 0 {
+			dir = filepath.Dir(abs)
+		}
+
+		for !within(dir, filepath.Dir(abs)) {
+			dir = filepath.Dir(dir)
+		}
+	}
+
+	return dir, nil
+}
+
+func within(dir, sub string) bool {
+	rel, err := filepath.Rel(dir, sub)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// camelCase joins the words of name, separated by anything but letters and digits, capitalizing each of them.
+func camelCase(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i, part := range parts {
+		runes := []rune(part)
+		runes[0] = unicode.ToUpper(runes[0])
+		parts[i] = string(runes)
+	}
+
+	return strings.Join(parts, "")
+}
+
+// typeOf returns the data type of the template of given relative path.
+func (config Config) typeOf(rel string) string {
+	if typ, ok := config.Types[rel]; ok {
+		return typ
+	}
+
+	if typ, ok := config.Types[path.Join(path.Dir(rel), baseName(rel))]; ok {
+		return typ
+	}
+
+	return config.Type
+}
+
+func baseName(filename string) string {
+	name := path.Base(filename)
+	name = strings.TrimSuffix(name, ".slim")
+	name = strings.TrimSuffix(name, ".html")
+	return name
+}
+
+// qualifyType splits example.com/app/models.PageData into models.PageData and its import path.
+// Packages outside the standard library must be given by their full import path.
+func qualifyType(typ string) (name, importPath string, err error) {
+	if len(typ) == 0 {
+		return "interface{}", "", nil
+	}
+
+	dot := strings.LastIndex(typ, ".")
+	if dot <= 0 {
+		return typ, "", nil
+	}
+
+	importPath = strings.TrimLeft(typ[:dot], "*[]")
+	prefix := typ[:len(typ[:dot])-len(importPath)]
+
+	if !strings.Contains(strings.SplitN(importPath, "/", 2)[0], ".") && !standardPackage(importPath) {
+		return "", "", fmt.Errorf("gen: type %s needs the full import path of its package, i.e. example.com/app/%s%s", typ, importPath, typ[dot:])
+	}
+
+	return prefix + packageName(importPath) + typ[dot:], importPath, nil
+}
+
+// standardPackage reports if importPath is a package of the standard library, i.e. time or net/http.
+func standardPackage(importPath string) bool {
+	pkg, err := build.Import(importPath, "", build.FindOnly)
+	return err == nil && pkg.Goroot
+}
+
+// major version suffixes of import paths, i.e. /v2 of modules or .v3 of gopkg.in
+var rversion = regexp.MustCompile(`^v[0-9]+$`)
+
+// packageName derives the name of a package from its import path, by convention the last element of
+// the path without its major version, i.e. models of example.com/app/models, app of example.com/app/v2
+// or yaml of gopkg.in/yaml.v3. Characters not allowed in identifiers are dropped.
+func packageName(importPath string) string {
+	elements := strings.Split(importPath, "/")
+
+	name := elements[len(elements)-1]
+	if rversion.MatchString(name) && len(elements) > 1 {
+		name = elements[len(elements)-2]
+	}
+
+	if dot := strings.LastIndex(name, "."); dot > 0 && rversion.MatchString(name[dot+1:]) {
+		name = name[:dot]
+	}
+
+	name = strings.TrimPrefix(name, "go-")
+
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return r
+		}
+
+		return -1
+	}, name)
+}
+
+func glob(patterns []string) ([]string, error) {
+	seen := make(map[string]bool)
+	filenames := make([]string, 0)
+
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("gen: no templates match %q", pattern)
+		}
+
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				filenames = append(filenames, match)
+			}
+		}
+	}
+
+	if len(filenames) == 0 {
+		return nil, errors.New("gen: no input templates given")
+	}
+
+	sort.Strings(filenames)
+	return filenames, nil
+}
@@ -0,0 +1,129 @@
+package gen
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of generated sources")
+
+// golden compares the source generated for config to testdata/<name>.golden, rewriting it with -update.
+func golden(t *testing.T, name string, config Config) {
+	var buf bytes.Buffer
+	if err := Write(&buf, config); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	filename := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	want, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if buf.String() != string(want) {
+		t.Fatalf("Generated source differs from %s, got:\n%s", filename, buf.String())
+	}
+}
+
+func Test_Write(t *testing.T) {
+	golden(t, "untyped", Config{
+		Inputs:  []string{"testdata/views/*.slim"},
+		Package: "views",
+	})
+
+	golden(t, "typed", Config{
+		Inputs:  []string{"testdata/views/*.slim"},
+		Package: "views",
+		Type:    "*example.com/app/models.PageData",
+		Types: map[string]string{
+			"user_list": "gopkg.in/app/users.v2.List",
+			"year.slim": "time.Time",
+		},
+	})
+}
+
+func Test_WriteNamesByRelativePath(t *testing.T) {
+	golden(t, "nested", Config{
+		Inputs:  []string{"testdata/views/*.slim", "testdata/views/admin/*.slim"},
+		Package: "views",
+	})
+
+	var buf bytes.Buffer
+
+	err := Write(&buf, Config{Inputs: []string{"testdata/clash/*.slim"}, Package: "views"})
+	if err == nil || !strings.Contains(err.Error(), "RenderUserList") {
+		t.Fatalf("Expected an error on templates clashing by name, got %v", err)
+	}
+
+	err = Write(&buf, Config{Inputs: []string{"testdata/views/index.slim"}, Package: "views", Dir: "testdata/views/admin"})
+	if err == nil || !strings.Contains(err.Error(), "is not within") {
+		t.Fatalf("Expected an error on a template outside of Dir, got %v", err)
+	}
+}
+
+func Test_WriteRejectsIncompleteImportPaths(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := Write(&buf, Config{Inputs: []string{"testdata/views/index.slim"}, Package: "views", Type: "mypkg.PageData"})
+	if err == nil || !strings.Contains(err.Error(), "example.com/app/mypkg.PageData") {
+		t.Fatalf("Expected an error asking for the full import path, got %v", err)
+	}
+}
+
+func Test_PackageName(t *testing.T) {
+	for path, name := range map[string]string{
+		"time":                        "time",
+		"net/http":                    "http",
+		"example.com/app/views":       "views",
+		"example.com/app/v2":          "app",
+		"gopkg.in/yaml.v3":            "yaml",
+		"github.com/mattn/go-sqlite3": "sqlite3",
+		"example.com/app/user-views":  "userviews",
+	} {
+		if got := packageName(path); got != name {
+			t.Errorf("Expected package name {%s} of %s, got {%s}.", name, path, got)
+		}
+	}
+}
+
+func Test_TemplateName(t *testing.T) {
+	for rel, name := range map[string]string{
+		"index.slim":                  "Index",
+		"user_profile.html.slim":      "UserProfile",
+		"admin/2fa-settings.slim":     "Admin2faSettings",
+		"admin/users/index.html.slim": "AdminUsersIndex",
+	} {
+		if got := templateName(rel); got != name {
+			t.Errorf("Expected template name {%s} of %s, got {%s}.", name, rel, got)
+		}
+	}
+}
+
+func Test_WriteTypesByRelativePath(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := Write(&buf, Config{
+		Inputs:  []string{"testdata/views/*.slim", "testdata/views/admin/*.slim"},
+		Package: "views",
+		Types:   map[string]string{"admin/index": "time.Time", "year.slim": "int"},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for _, fn := range []string{"RenderAdminIndex(w io.Writer, data time.Time)", "RenderIndex(w io.Writer, data interface{})", "RenderYear(w io.Writer, data int)"} {
+		if !strings.Contains(buf.String(), "func "+fn) {
+			t.Fatalf("Expected func %s in\n%s", fn, buf.String())
+		}
+	}
+}
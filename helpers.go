@@ -0,0 +1,81 @@
+package slim
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/golib/slim/runtime"
+)
+
+// templateFunctions are the functions predefined by text/template that expressions call.
+var templateFunctions = []string{"len", "print", "printf", "println", "urlquery", "js", "html", "index"}
+
+// builtinFunctions are the functions available to every template, those of text/template and the helpers of
+// runtime.FuncMap, along with __slim_seq that integer ranges compile to.
+var builtinFunctions = builtins()
+
+func builtins() []string {
+	names := append([]string{"__slim_seq"}, templateFunctions...)
+
+	for name := range runtime.FuncMap() {
+		if !strings.HasPrefix(name, "__slim_") {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Returns the documentation of the functions available to every template by name, their signature on the first line,
+// i.e. to be shown on hover by editors.
+func Helpers() map[string]string {
+	helpers := make(map[string]string, len(helperDocs))
+	for name, doc := range helperDocs {
+		helpers[name] = doc
+	}
+
+	return helpers
+}
+
+// helperDocs documents builtinFunctions other than __slim_seq, Test_Helpers keeps them in line.
+var helperDocs = map[string]string{
+	"len":      "len(x) int\n\nReturns the length of a string, slice, array, map or channel.",
+	"print":    "print(args...) string\n\nFormats its arguments like fmt.Sprint.",
+	"printf":   "printf(format, args...) string\n\nFormats its arguments like fmt.Sprintf.",
+	"println":  "println(args...) string\n\nFormats its arguments like fmt.Sprintln.",
+	"urlquery": "urlquery(args...) string\n\nEscapes its arguments for embedding in a URL query.",
+	"js":       "js(args...) string\n\nEscapes its arguments for embedding in JavaScript.",
+	"html":     "html(args...) string\n\nEscapes its arguments for embedding in HTML.",
+	"index":    "index(x, keys...) any\n\nIndexes into maps, slices and arrays, i.e. index(Users, 0).",
+
+	"json":      "json(x) string\n\nEncodes x as JSON.",
+	"jsonify":   "jsonify(x) JS\n\nEncodes x as JSON for embedding into a script element, escaping <, > and &.",
+	"unescaped": "unescaped(s) HTML\n\nMarks s as trusted HTML. Kept for compatibility, see raw.",
+	"raw":       "raw(x) HTML\n\nMarks x as trusted HTML, emitted without escaping. Never use it with user supplied content.",
+	"safeURL":   "safeURL(x) URL\n\nMarks x as a trusted URL, its scheme is not filtered.",
+	"safeJS":    "safeJS(x) JS\n\nMarks x as a trusted JavaScript expression.",
+	"dump":      "dump(x) HTML\n\nPretty prints x as indented JSON within a <pre> element.",
+	"typeOf":    "typeOf(x) string\n\nReturns the Go type name of x.",
+	"nbsp":      "nbsp() HTML\n\nReturns a non-breaking space.",
+	"entity":    "entity(name) HTML\n\nReturns the character reference of name, i.e. entity(\"rarr\") or entity(\"#8594\").",
+
+	"upper":    "upper(s) string\n\nMaps all letters of s to upper case.",
+	"lower":    "lower(s) string\n\nMaps all letters of s to lower case.",
+	"title":    "title(s) string\n\nUpper cases the first letter of each word in s.",
+	"trim":     "trim(s) string\n\nRemoves leading and trailing white space.",
+	"truncate": "truncate(s, length) string\n\nCuts s down to at most length runes, appending \"...\" when anything has been dropped.",
+	"default":  "default(x, fallback) any\n\nReturns fallback if x is nil, empty or the zero value of its type.",
+	"join":     "join(list, sep) string\n\nJoins the elements of list with sep.",
+	"keys":     "keys(m) []any\n\nReturns the keys of map m in ascending order.",
+	"split":    "split(s, sep) []string\n\nSplits s into all substrings separated by sep.",
+	"replace":  "replace(s, old, new) string\n\nReplaces all occurrences of old in s with new.",
+
+	"timeago":  "timeago(t) string\n\nReturns how long ago t has been, i.e. \"3 hours ago\", or how far ahead it is, i.e. \"in 2 days\".",
+	"duration": "duration(d) string\n\nFormats a time.Duration or a number of seconds by its two largest units, i.e. \"1h 5m\".",
+	"datetime": "datetime(t) string\n\nFormats t as RFC 3339, for the datetime attribute of time elements.",
+
+	"image_tag": "image_tag(src, alt, sizes, widths...) HTML\n\nReturns a lazily loaded img element of src offering its variants of given widths in srcset.",
+
+	"global": "global(name) any\n\nReturns a request scoped value or global of the Renderer, nil outside of one.",
+}
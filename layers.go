@@ -0,0 +1,67 @@
+package slim
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+)
+
+// Returns a file system resolving every name against layers in order, i.e. the templates of a site, of its theme
+// and of the core, so a layer overrides single templates of those following it without touching them. Given to
+// WithLoader, imports and extends of every layer resolve against the whole stack, a partial of the core importing
+// the header gets the one of the site if it overrides it. Directories list the files of all layers.
+func NewLayeredFS(layers ...fs.FS) fs.FS {
+	return layeredFS(layers)
+}
+
+type layeredFS []fs.FS
+
+func (l layeredFS) Open(name string) (fs.File, error) {
+	for _, layer := range l {
+		file, err := layer.Open(name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return file, err
+		}
+	}
+
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (l layeredFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var (
+		entries []fs.DirEntry
+		found   bool
+	)
+
+	seen := make(map[string]bool)
+
+	for _, layer := range l {
+		layerEntries, err := fs.ReadDir(layer, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		found = true
+
+		for _, entry := range layerEntries {
+			if !seen[entry.Name()] {
+				seen[entry.Name()] = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
@@ -0,0 +1,61 @@
+package slim
+
+import (
+	"html/template"
+	"io/fs"
+	"strings"
+)
+
+// Library is a directory of mixins and partials published as a Go package, i.e. the components of a design system
+// shared by several services. The package embeds the templates and offers a function registering them, which
+// downstream projects give to NewWithOptions or NewSet along with the prefix they import the library under:
+//
+//	//go:embed components
+//	var components embed.FS
+//
+//	func Register(prefix string) slim.Option {
+//		return slim.WithLibrary(prefix, slim.Library{FS: components, Dir: "components", Funcs: funcs})
+//	}
+//
+// Templates of the project then import the library by the prefix, i.e. `import ui/button`, while templates of
+// the library import each other by their path within it.
+type Library struct {
+	// Templates of the library
+	FS fs.FS
+	// Directory of FS holding the templates, i.e. the embedded directory, the root of FS if empty
+	Dir string
+	// Helpers called by the templates of the library, added to those of the compiler
+	Funcs template.FuncMap
+}
+
+// Mounts the templates of library under prefix and adds its helpers, see Library.
+func WithLibrary(prefix string, library Library) Option {
+	return func(c *Compiler) {
+		c.Mount(prefix, library)
+	}
+}
+
+// Mounts the templates of library under prefix, i.e. "ui", and adds its helpers, see Library.
+// It panics if Dir is no valid path within FS.
+func (c *Compiler) Mount(prefix string, library Library) {
+	fsys := library.FS
+	if len(library.Dir) > 0 {
+		sub, err := fs.Sub(fsys, library.Dir)
+		if err != nil {
+			panic("Unable to mount library " + prefix + " with error " + err.Error())
+		}
+
+		fsys = sub
+	}
+
+	// the map may be shared by copies of the options, i.e. DefaultOptions
+	libraries := make(map[string]fs.FS, len(c.Libraries)+1)
+	for name, lib := range c.Libraries {
+		libraries[name] = lib
+	}
+
+	libraries[strings.Trim(prefix, "/")] = fsys
+	c.Libraries = libraries
+
+	c.AddFuncs(library.Funcs)
+}
@@ -0,0 +1,166 @@
+package slim
+
+import (
+	"context"
+	"fmt"
+	goAst "go/ast"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golib/slim/parser"
+)
+
+// Parses every slim template below dir, as CompileDir does, and returns the warnings of parsing them along with
+// those on named blocks which layouts define but no template extending them fills, and on variables which are
+// assigned but never used.
+func LintDir(dir string, options Options) ([]parser.Warning, error) {
+	extensions := options.Extensions
+	if extensions == nil {
+		extensions = parser.DefaultExtensions
+	}
+
+	var warnings []parser.Warning
+
+	// named blocks of layouts, keyed by file and name, and whether an extending template fills them
+	defined := make(map[string]*parser.NamedBlock)
+	filled := make(map[string]bool)
+
+	err := walkTemplates(context.Background(), dir, extensions, func(name, filename string) error {
+		compiler := New()
+		compiler.Options = options
+		compiler.SetTemplateDir(dir)
+
+		if err := compiler.ParseFile(filename); err != nil {
+			return err
+		}
+
+		warnings = append(warnings, compiler.Warnings()...)
+
+		for _, block := range compiler.inherited {
+			key := block.Filename + "#" + block.Name
+			defined[key] = block
+
+			for _, ours := range compiler.blocks {
+				if ours.Name == block.Name {
+					filled[key] = true
+				}
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(defined))
+	for key := range defined {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !filled[key] {
+			block := defined[key]
+			warnings = append(warnings, parser.Warning{
+				SourcePosition: block.SourcePosition,
+				Message:        fmt.Sprintf("Block %s is never filled by a template extending it.", block.Name),
+			})
+		}
+	}
+
+	return dedupWarnings(warnings), nil
+}
+
+// unusedVariables returns warnings on the variables assignments within node give values
+// which no expression of node refers to, nor any go template code passed through as is.
+func unusedVariables(node parser.Noder, actions bool) []parser.Warning {
+	var assignments []*parser.Assignment
+	used := make(map[string]bool)
+
+	passthroughVariables(node, actions, used)
+
+	walkExpressions(node, func(node parser.Noder, value string) {
+		if assignment, ok := node.(*parser.Assignment); ok {
+			assignments = append(assignments, assignment)
+		}
+
+		goAst.Inspect(parseExpression(value), func(n goAst.Node) bool {
+			if ident, ok := n.(*goAst.Ident); ok && strings.HasPrefix(ident.Name, "__DOLLAR__") {
+				used["$"+ident.Name[len("__DOLLAR__"):]] = true
+			}
+
+			return true
+		})
+	})
+
+	warnings := make([]parser.Warning, 0)
+
+	for _, assignment := range assignments {
+		for _, name := range assignment.Variables {
+			if !used[name] {
+				warnings = append(warnings, parser.Warning{
+					SourcePosition: assignment.SourcePosition,
+					Message:        fmt.Sprintf("Variable %s is assigned but never used.", name),
+				})
+			}
+		}
+	}
+
+	return warnings
+}
+
+// passthroughVariables marks as used the variables go template code within node refers to, that is the code
+// of passthrough blocks and, with actions passed through, the actions of texts as well.
+func passthroughVariables(node parser.Noder, actions bool, used map[string]bool) {
+	walkNodes(node, func(node parser.Noder) bool {
+		switch node := node.(type) {
+		case *parser.Block:
+			// a file pragma may turn passing actions through on or off for the nodes of its file
+			if value, ok := node.Options["passthrough_actions"]; ok {
+				enabled, err := strconv.ParseBool(value)
+				if err != nil || enabled == actions {
+					return true
+				}
+
+				for _, child := range node.Children {
+					passthroughVariables(child, enabled, used)
+				}
+
+				return false
+			}
+		case *parser.Passthrough:
+			for _, name := range rvariable.FindAllString(node.Value, -1) {
+				used[name] = true
+			}
+		case *parser.Text:
+			if actions {
+				for _, action := range rdelimiter.FindAllString(node.Value, -1) {
+					for _, name := range rvariable.FindAllString(action, -1) {
+						used[name] = true
+					}
+				}
+			}
+		}
+
+		return true
+	})
+}
+
+// dedupWarnings drops repeated warnings, i.e. those of a layout parsed along with every template extending it.
+func dedupWarnings(warnings []parser.Warning) []parser.Warning {
+	seen := make(map[string]bool)
+	result := make([]parser.Warning, 0, len(warnings))
+
+	for _, warning := range warnings {
+		if key := warning.String(); !seen[key] {
+			seen[key] = true
+			result = append(result, warning)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,526 @@
+// Package lsp implements the language features an editor extension for Slim templates
+// needs: diagnostics on change, document symbols, go to definition for extend, import
+// and named block targets, hover documentation and completion of helper functions and
+// completion of mixin calls.
+//
+// It does not speak the Language Server Protocol wire format itself. Positions and ranges
+// follow its conventions (zero based lines and characters) so a JSON-RPC front end can
+// pass them through as is.
+package lsp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golib/slim"
+	"github.com/golib/slim/parser"
+)
+
+const (
+	SeverityError   = 1
+	SeverityWarning = 2
+)
+
+const (
+	SymbolTag    = "tag"
+	SymbolBlock  = "block"
+	SymbolMixin  = "mixin"
+	SymbolDefine = "define"
+)
+
+const (
+	CompletionHelper = "helper"
+	CompletionMixin  = "mixin"
+)
+
+var (
+	rword = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+	// mixin call being typed, up to the position completed
+	rcall = regexp.MustCompile(`^\+([\w\-\/]*)$`)
+)
+
+// helperDocs documents the functions available to every template, shown on hover.
+var helperDocs = slim.Helpers()
+
+// Position in a document, both line and character are zero based. Characters are byte offsets within the line.
+type Position struct {
+	Line      int
+	Character int
+}
+
+type Range struct {
+	Start Position
+	End   Position
+}
+
+type Diagnostic struct {
+	Range    Range
+	Severity int
+	Message  string
+}
+
+type Symbol struct {
+	Name     string
+	Kind     string
+	Range    Range
+	Children []Symbol
+}
+
+type Location struct {
+	Filename string
+	Range    Range
+}
+
+type Hover struct {
+	Contents string
+	Range    Range
+}
+
+type CompletionItem struct {
+	Label string
+	Kind  string
+	// Documentation of helpers, the parameters of mixins
+	Detail string
+	// Range of the document replaced by Label
+	Range Range
+}
+
+// Server keeps the text of open documents and answers editor requests about them.
+// It is safe for concurrent use.
+type Server struct {
+	// Options templates are parsed with as the compiler would, i.e. the Extensions and IncludePaths targets of
+	// import and extend are resolved by.
+	// Default: slim.DefaultOptions, reporting mixed indentation as warnings
+	Options slim.Options
+
+	mu   sync.Mutex
+	docs map[string]string
+}
+
+// Create and initialize a new Server
+func NewServer() *Server {
+	options := slim.DefaultOptions
+	options.MixedIndentation = parser.MixedIndentWarn
+
+	return &Server{
+		Options: options,
+		docs:    make(map[string]string),
+	}
+}
+
+// Registers an opened document and returns its diagnostics.
+func (s *Server) Open(filename, text string) []Diagnostic {
+	return s.Change(filename, text)
+}
+
+// Updates the text of a document and returns its diagnostics.
+func (s *Server) Change(filename, text string) []Diagnostic {
+	s.mu.Lock()
+	s.docs[filename] = text
+	s.mu.Unlock()
+
+	return s.Diagnostics(filename)
+}
+
+// Forgets a closed document, further requests read it from disk.
+func (s *Server) Close(filename string) {
+	s.mu.Lock()
+	delete(s.docs, filename)
+	s.mu.Unlock()
+}
+
+// Parses the document, including its imported and extended templates, and reports errors.
+func (s *Server) Diagnostics(filename string) (diagnostics []Diagnostic) {
+	text, ok := s.text(filename)
+	if !ok {
+		return nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			diagnostics = []Diagnostic{newDiagnostic(filename, r)}
+		}
+	}()
+
+	p := s.parser(filename, text)
+	p.Parse()
+
+	diagnostics = []Diagnostic{}
+
+	// warnings of imported and extended templates are theirs to report
+	for _, warning := range p.Warnings() {
+		if len(warning.Filename) == 0 {
+			start := Position{warning.Line - 1, warning.ByteColumn - 1}
+			end := Position{warning.EndLine - 1, warning.ByteEndColumn - 1}
+
+			diagnostics = append(diagnostics, Diagnostic{Range: Range{start, end}, Severity: SeverityWarning, Message: warning.Message})
+		}
+	}
+
+	return diagnostics
+}
+
+// Lists the tags, named blocks, mixins and defines of the document, nested as in the source.
+// Tags within conditions, loops and the like are listed in place of them.
+func (s *Server) Symbols(filename string) []Symbol {
+	text, ok := s.text(filename)
+	if !ok {
+		return nil
+	}
+
+	p, root, ok := s.outline(filename, text)
+	if !ok {
+		return nil
+	}
+
+	named := make(map[*parser.Block]string)
+	for _, block := range p.NamedBlocks() {
+		named[&block.Block] = block.Name
+	}
+
+	return symbols(root.Children, named)
+}
+
+// Resolves the target of an extend or import line, or the parent definition of a named block at pos.
+func (s *Server) Definition(filename string, pos Position) (Location, bool) {
+	text, ok := s.text(filename)
+	if !ok {
+		return Location{}, false
+	}
+
+	p, _, ok := s.outline(filename, text)
+	if !ok {
+		return Location{}, false
+	}
+
+	for _, target := range p.Targets() {
+		if target.Line-1 != pos.Line {
+			continue
+		}
+
+		if _, ok := s.text(target.Filename); len(target.Filename) == 0 || !ok {
+			return Location{}, false
+		}
+
+		return Location{Filename: target.Filename}, true
+	}
+
+	for _, block := range p.NamedBlocks() {
+		if block.Line-1 == pos.Line {
+			return s.findBlock(filename, block.Name, make(map[string]bool))
+		}
+	}
+
+	return Location{}, false
+}
+
+// Describes the helper function at pos.
+func (s *Server) Hover(filename string, pos Position) (Hover, bool) {
+	line, ok := s.line(filename, pos.Line)
+	if !ok {
+		return Hover{}, false
+	}
+
+	for _, loc := range rword.FindAllStringIndex(line, -1) {
+		if pos.Character < loc[0] || pos.Character >= loc[1] {
+			continue
+		}
+
+		name := line[loc[0]:loc[1]]
+		if !strings.HasPrefix(strings.TrimSpace(line[loc[1]:]), "(") {
+			return Hover{}, false
+		}
+
+		doc, ok := helperDocs[name]
+		if !ok {
+			return Hover{}, false
+		}
+
+		return Hover{
+			Contents: doc,
+			Range:    Range{Position{pos.Line, loc[0]}, Position{pos.Line, loc[1]}},
+		}, true
+	}
+
+	return Hover{}, false
+}
+
+// Completes the helper function or the mixin call being typed at pos, i.e. after "#{upp" or "+ca".
+// Mixins are those defined by the document itself.
+func (s *Server) Completion(filename string, pos Position) []CompletionItem {
+	line, ok := s.line(filename, pos.Line)
+	if !ok || pos.Character < 0 || pos.Character > len(line) {
+		return nil
+	}
+
+	items := []CompletionItem{}
+	before := line[:pos.Character]
+
+	if matches := rcall.FindStringSubmatch(strings.TrimSpace(before)); matches != nil {
+		text, _ := s.text(filename)
+		start := Position{pos.Line, pos.Character - len(matches[1])}
+
+		// the call being typed is left out, it need not parse yet
+		lines := strings.Split(text, "\n")
+		lines[pos.Line] = ""
+
+		_, root, _ := s.outline(filename, strings.Join(lines, "\n"))
+		for _, mixin := range mixins(root) {
+			if strings.HasPrefix(mixin.Name, matches[1]) {
+				items = append(items, CompletionItem{Label: mixin.Name, Kind: CompletionMixin, Detail: parameters(mixin), Range: Range{start, pos}})
+			}
+		}
+
+		return items
+	}
+
+	// the word being typed ends at pos
+	var prefix string
+	if loc := rword.FindAllStringIndex(before, -1); len(loc) > 0 && loc[len(loc)-1][1] == len(before) {
+		prefix = before[loc[len(loc)-1][0]:]
+	}
+
+	if len(prefix) == 0 {
+		return items
+	}
+
+	start := Position{pos.Line, pos.Character - len(prefix)}
+	for name, doc := range helperDocs {
+		if strings.HasPrefix(name, prefix) {
+			items = append(items, CompletionItem{Label: name, Kind: CompletionHelper, Detail: doc, Range: Range{start, pos}})
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Label < items[j].Label
+	})
+
+	return items
+}
+
+// findBlock looks up the definition of the named block in the templates filename extends.
+func (s *Server) findBlock(filename, name string, visited map[string]bool) (Location, bool) {
+	if visited[filename] {
+		return Location{}, false
+	}
+	visited[filename] = true
+
+	text, ok := s.text(filename)
+	if !ok {
+		return Location{}, false
+	}
+
+	p, _, ok := s.outline(filename, text)
+	if !ok {
+		return Location{}, false
+	}
+
+	for _, target := range p.Targets() {
+		if target.Directive != "extend" || len(target.Filename) == 0 {
+			continue
+		}
+
+		if location, ok := s.findBlock(target.Filename, name, visited); ok {
+			return location, true
+		}
+
+		ptext, ok := s.text(target.Filename)
+		if !ok {
+			return Location{}, false
+		}
+
+		parent, _, ok := s.outline(target.Filename, ptext)
+		if !ok {
+			return Location{}, false
+		}
+
+		for _, block := range parent.NamedBlocks() {
+			if block.Name == name {
+				return Location{Filename: target.Filename, Range: rangeOf(block.SourcePosition)}, true
+			}
+		}
+	}
+
+	return Location{}, false
+}
+
+// parser returns the parser of the text of filename, configured by the options of the server.
+func (s *Server) parser(filename, text string) *parser.Parser {
+	p, _ := parser.NewStringParser(text)
+
+	cmp := slim.New()
+	cmp.Options = s.Options
+	cmp.SetTemplateDir(filepath.Dir(filename))
+	cmp.Configure(p)
+
+	return p
+}
+
+// outline parses the text of filename on its own, see parser.Parser.SetShallow. It reports false if it fails to parse.
+func (s *Server) outline(filename, text string) (p *parser.Parser, root *parser.Block, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			p, root, ok = nil, nil, false
+		}
+	}()
+
+	p = s.parser(filename, text)
+	p.SetShallow(true)
+
+	return p, p.Parse(), true
+}
+
+func (s *Server) text(filename string) (string, bool) {
+	s.mu.Lock()
+	text, ok := s.docs[filename]
+	s.mu.Unlock()
+
+	if ok {
+		return text, true
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", false
+	}
+
+	return string(data), true
+}
+
+func (s *Server) line(filename string, line int) (string, bool) {
+	text, ok := s.text(filename)
+	if !ok {
+		return "", false
+	}
+
+	lines := strings.Split(text, "\n")
+	if line < 0 || line >= len(lines) {
+		return "", false
+	}
+
+	return lines[line], true
+}
+
+func newDiagnostic(filename string, r interface{}) Diagnostic {
+	err, ok := r.(*parser.Error)
+	if !ok {
+		return Diagnostic{Severity: SeverityError, Message: fmt.Sprint(r)}
+	}
+
+	// errors raised within imported templates are reported at the top of the document
+	if len(err.Filename) > 0 && err.Filename != filename {
+		return Diagnostic{Severity: SeverityError, Message: err.Filename + ": " + err.Message + " (line " + strconv.Itoa(err.Line) + ")"}
+	}
+
+	start := Position{err.Line - 1, err.ByteColumn - 1}
+	end := Position{err.EndLine - 1, err.ByteEndColumn - 1}
+
+	return Diagnostic{Range: Range{start, end}, Severity: SeverityError, Message: err.Message}
+}
+
+// rangeOf returns the range of the source of a node.
+func rangeOf(pos parser.SourcePosition) Range {
+	return Range{Position{pos.Line - 1, pos.ByteColumn - 1}, Position{pos.EndLine - 1, pos.ByteEndColumn - 1}}
+}
+
+// symbols lists the symbols of nodes, those within conditions, loops, calls and the like in place of them.
+func symbols(nodes []parser.Noder, named map[*parser.Block]string) []Symbol {
+	var list []Symbol
+
+	symbol := func(name, kind string, pos parser.SourcePosition, block *parser.Block) {
+		s := Symbol{Name: name, Kind: kind, Range: rangeOf(pos)}
+		if block != nil {
+			s.Children = symbols(block.Children, named)
+		}
+
+		list = append(list, s)
+	}
+
+	within := func(blocks ...*parser.Block) {
+		for _, block := range blocks {
+			if block != nil {
+				list = append(list, symbols(block.Children, named)...)
+			}
+		}
+	}
+
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *parser.Block:
+			if name, ok := named[node]; ok {
+				symbol(name, SymbolBlock, node.SourcePosition, node)
+			} else {
+				within(node)
+			}
+		case *parser.Tag:
+			symbol(node.Name, SymbolTag, node.SourcePosition, node.Block)
+		case *parser.Mixin:
+			symbol(node.Name, SymbolMixin, node.SourcePosition, node.Block)
+		case *parser.Define:
+			symbol(node.Name, SymbolDefine, node.SourcePosition, node.Block)
+		case *parser.Condition:
+			within(node.Positive, node.Negative)
+		case *parser.Range:
+			within(node.Block)
+		case *parser.Let:
+			within(node.Block)
+		case *parser.While:
+			within(node.Block)
+		case *parser.Cache:
+			within(node.Block)
+		case *parser.ContentFor:
+			within(node.Block)
+		case *parser.MixinCall:
+			within(node.Block)
+		case *parser.Slot:
+			within(node.Block)
+		}
+	}
+
+	return list
+}
+
+// mixins returns the mixins defined within root, nil if it failed to parse.
+func mixins(root *parser.Block) []*parser.Mixin {
+	if root == nil {
+		return nil
+	}
+
+	var list []*parser.Mixin
+
+	for _, node := range root.Children {
+		switch node := node.(type) {
+		case *parser.Mixin:
+			list = append(list, node)
+		case *parser.Block:
+			list = append(list, mixins(node)...)
+		}
+	}
+
+	return list
+}
+
+// parameters describes the parameters of a mixin as written, i.e. `$title, $size = "md", ...$rest`.
+func parameters(mixin *parser.Mixin) string {
+	list := make([]string, 0, len(mixin.Parameters)+1)
+
+	for i, param := range mixin.Parameters {
+		if i < len(mixin.Defaults) && len(mixin.Defaults[i]) > 0 {
+			param += " = " + mixin.Defaults[i]
+		}
+
+		list = append(list, param)
+	}
+
+	if len(mixin.Rest) > 0 {
+		list = append(list, "..."+mixin.Rest)
+	}
+
+	return strings.Join(list, ", ")
+}
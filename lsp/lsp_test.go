@@ -0,0 +1,162 @@
+package lsp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func expect(cur, expected string, t *testing.T) {
+	if cur != expected {
+		t.Fatalf("Expected {%s} got {%s}.", expected, cur)
+	}
+}
+
+// outline renders symbols as name:kind@line, children in parentheses.
+func outline(symbols []Symbol) string {
+	parts := make([]string, 0, len(symbols))
+
+	for _, symbol := range symbols {
+		part := fmt.Sprintf("%s:%s@%d", symbol.Name, symbol.Kind, symbol.Range.Start.Line)
+		if len(symbol.Children) > 0 {
+			part += "(" + outline(symbol.Children) + ")"
+		}
+
+		parts = append(parts, part)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func Test_Diagnostics(t *testing.T) {
+	s := NewServer()
+
+	diagnostics := s.Open("page.html.slim", "div\n\tp\n\t| #{upper(Name)}")
+	expect(fmt.Sprint(len(diagnostics)), "0", t)
+
+	diagnostics = s.Change("page.html.slim", "div\n\tp[title=\"open]")
+	if len(diagnostics) != 1 || diagnostics[0].Severity != SeverityError || diagnostics[0].Range.Start.Line != 1 {
+		t.Fatalf("Expected an error on the second line, got %+v", diagnostics)
+	}
+
+	diagnostics = s.Change("page.html.slim", "div\n\tp\n    \tspan")
+	if len(diagnostics) != 1 || diagnostics[0].Severity != SeverityWarning || diagnostics[0].Range.Start.Line != 2 {
+		t.Fatalf("Expected a warning on mixed indentation, got %+v", diagnostics)
+	}
+
+	s.Close("page.html.slim")
+
+	if diagnostics := s.Diagnostics("page.html.slim"); diagnostics != nil {
+		t.Fatalf("Expected no diagnostics of a closed document missing on disk, got %+v", diagnostics)
+	}
+}
+
+func Test_Symbols(t *testing.T) {
+	s := NewServer()
+	s.Open("page.html.slim", strings.Join([]string{
+		"mixin card($title)",
+		"\tarticle",
+		"\t\th2",
+		"\t\tslot body",
+		"define badge",
+		"\tspan.badge",
+		"extend layout",
+		"block content",
+		"\tmain",
+		"\t\t+card(\"News\")",
+		"\t\tif .Admin",
+		"\t\t\tp",
+		"\tscript",
+		"\t\tvar x = 1",
+	}, "\n"))
+
+	expect(outline(s.Symbols("page.html.slim")), "card:mixin@0(article:tag@1(h2:tag@2)) badge:define@4(span:tag@5) content:block@7(main:tag@8(p:tag@11) script:tag@12)", t)
+}
+
+func Test_Hover(t *testing.T) {
+	s := NewServer()
+	s.Open("page.html.slim", "p\n\t| #{upper(Name)} #{Name}")
+
+	hover, ok := s.Hover("page.html.slim", Position{1, 7})
+	if !ok || !strings.HasPrefix(hover.Contents, "upper(s) string") {
+		t.Fatalf("Expected the documentation of upper, got %+v", hover)
+	}
+
+	expect(fmt.Sprint(hover.Range), "{{1 5} {1 10}}", t)
+
+	if hover, ok := s.Hover("page.html.slim", Position{1, 20}); ok {
+		t.Fatalf("Expected no hover on a field, got %+v", hover)
+	}
+}
+
+func Test_Completion(t *testing.T) {
+	s := NewServer()
+	s.Open("page.html.slim", "mixin card($title, $size = \"md\")\n\tarticle\nmixin cart\n\tp\np\n\t| #{tr\n+ca(")
+
+	var labels []string
+	for _, item := range s.Completion("page.html.slim", Position{5, 7}) {
+		labels = append(labels, item.Kind+":"+item.Label)
+	}
+
+	expect(strings.Join(labels, " "), "helper:trim helper:truncate", t)
+
+	items := s.Completion("page.html.slim", Position{6, 3})
+
+	labels = nil
+	for _, item := range items {
+		labels = append(labels, item.Kind+":"+item.Label+"("+item.Detail+")")
+	}
+
+	expect(strings.Join(labels, " "), `mixin:card($title, $size = "md") mixin:cart()`, t)
+	expect(fmt.Sprint(items[0].Range), "{{6 1} {6 3}}", t)
+
+	expect(fmt.Sprint(len(s.Completion("page.html.slim", Position{4, 0}))), "0", t)
+}
+
+func Test_Definition(t *testing.T) {
+	dir := t.TempDir()
+	layout := filepath.Join(dir, "layout.html.slim")
+	ioutil.WriteFile(layout, []byte("html\n\tbody\n\t\tblock content"), 0644)
+
+	s := NewServer()
+	page := filepath.Join(dir, "page.html.slim")
+	s.Open(page, "extend layout\nblock content\n\tp")
+
+	location, ok := s.Definition(page, Position{0, 2})
+	if !ok || location.Filename != layout {
+		t.Fatalf("Expected the extended layout, got %+v", location)
+	}
+
+	location, ok = s.Definition(page, Position{1, 8})
+	if !ok || location.Filename != layout || location.Range.Start.Line != 2 {
+		t.Fatalf("Expected the block of the layout, got %+v", location)
+	}
+
+	// targets resolve by the extensions and include paths of the options
+	shared := filepath.Join(dir, "shared")
+	os.Mkdir(shared, 0755)
+
+	card := filepath.Join(shared, "card.slim")
+	ioutil.WriteFile(card, []byte("mixin card\n\tarticle"), 0644)
+
+	base := filepath.Join(dir, "base.slim")
+	ioutil.WriteFile(base, []byte("html\n\tblock main"), 0644)
+
+	s.Options.IncludePaths = []string{shared}
+	s.Open(page, "extend base\nimport card\nblock main\n\t+card")
+
+	if location, ok := s.Definition(page, Position{0, 2}); !ok || location.Filename != base {
+		t.Fatalf("Expected the extended .slim template, got %+v", location)
+	}
+
+	if location, ok := s.Definition(page, Position{1, 2}); !ok || location.Filename != card {
+		t.Fatalf("Expected the template imported from the include path, got %+v", location)
+	}
+
+	if location, ok := s.Definition(page, Position{2, 8}); !ok || location.Filename != base || location.Range.Start.Line != 1 {
+		t.Fatalf("Expected the block of the .slim template, got %+v", location)
+	}
+}
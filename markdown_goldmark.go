@@ -0,0 +1,21 @@
+//go:build goldmark
+
+package slim
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+)
+
+// Markdown of :markdown and the markdown directive is converted by goldmark.
+func init() {
+	RegisterFilter("markdown", func(content string) (string, error) {
+		var buf bytes.Buffer
+		if err := goldmark.Convert([]byte(content), &buf); err != nil {
+			return "", err
+		}
+
+		return buf.String(), nil
+	})
+}
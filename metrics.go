@@ -0,0 +1,55 @@
+package slim
+
+import (
+	"expvar"
+	"time"
+)
+
+// Metrics receives the measurements of compiling and rendering templates, i.e. to export them to a monitoring system.
+// Implementations must be safe for concurrent use. NewExpvarMetrics publishes them through expvar, building with the
+// prometheus tag provides NewPrometheusMetrics registering Prometheus collectors.
+type Metrics interface {
+	// Called after a template has been compiled, err is nil on success.
+	TemplateCompiled(name string, d time.Duration, err error)
+	// Called on every lookup of a compiled template by a Renderer, a miss compiles the template.
+	TemplateLookup(name string, hit bool)
+	// Called after a template has been rendered, err is nil on success.
+	TemplateRendered(name string, d time.Duration, err error)
+}
+
+// Returns Metrics published as an expvar.Map of given name, i.e. "slim", holding the counters
+// compiles, compile_errors, compile_seconds, cache_hits, cache_misses, renders, render_errors and render_seconds.
+// It panics if a variable of the same name is published already, as expvar.Publish does.
+func NewExpvarMetrics(name string) Metrics {
+	return &expvarMetrics{vars: expvar.NewMap(name)}
+}
+
+type expvarMetrics struct {
+	vars *expvar.Map
+}
+
+func (m *expvarMetrics) TemplateCompiled(name string, d time.Duration, err error) {
+	m.vars.Add("compiles", 1)
+	m.vars.AddFloat("compile_seconds", d.Seconds())
+
+	if err != nil {
+		m.vars.Add("compile_errors", 1)
+	}
+}
+
+func (m *expvarMetrics) TemplateLookup(name string, hit bool) {
+	if hit {
+		m.vars.Add("cache_hits", 1)
+	} else {
+		m.vars.Add("cache_misses", 1)
+	}
+}
+
+func (m *expvarMetrics) TemplateRendered(name string, d time.Duration, err error) {
+	m.vars.Add("renders", 1)
+	m.vars.AddFloat("render_seconds", d.Seconds())
+
+	if err != nil {
+		m.vars.Add("render_errors", 1)
+	}
+}
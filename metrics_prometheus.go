@@ -0,0 +1,84 @@
+//go:build prometheus
+
+package slim
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Returns Metrics registering the following collectors with registerer, i.e. prometheus.DefaultRegisterer:
+//
+//	slim_templates_compiled_total{result}      counter of compiles, result is "ok" or "error"
+//	slim_template_compile_seconds              histogram of compile durations
+//	slim_template_lookups_total{result}        counter of lookups, result is "hit" or "miss"
+//	slim_template_render_seconds{template}     histogram of render durations
+//	slim_template_render_errors_total{template} counter of failing renders
+//
+// It panics if the collectors are registered already.
+func NewPrometheusMetrics(registerer prometheus.Registerer) Metrics {
+	m := &prometheusMetrics{
+		compiles: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "slim_templates_compiled_total",
+			Help: "Number of slim templates compiled.",
+		}, []string{"result"}),
+		compileTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "slim_template_compile_seconds",
+			Help:    "Time taken to compile slim templates.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "slim_template_lookups_total",
+			Help: "Number of lookups of compiled slim templates.",
+		}, []string{"result"}),
+		renderTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "slim_template_render_seconds",
+			Help:    "Time taken to render slim templates.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"template"}),
+		renderErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "slim_template_render_errors_total",
+			Help: "Number of failing renders of slim templates.",
+		}, []string{"template"}),
+	}
+
+	registerer.MustRegister(m.compiles, m.compileTime, m.lookups, m.renderTime, m.renderErrors)
+
+	return m
+}
+
+type prometheusMetrics struct {
+	compiles     *prometheus.CounterVec
+	compileTime  prometheus.Histogram
+	lookups      *prometheus.CounterVec
+	renderTime   *prometheus.HistogramVec
+	renderErrors *prometheus.CounterVec
+}
+
+func (m *prometheusMetrics) TemplateCompiled(name string, d time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+
+	m.compiles.WithLabelValues(result).Inc()
+	m.compileTime.Observe(d.Seconds())
+}
+
+func (m *prometheusMetrics) TemplateLookup(name string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+
+	m.lookups.WithLabelValues(result).Inc()
+}
+
+func (m *prometheusMetrics) TemplateRendered(name string, d time.Duration, err error) {
+	m.renderTime.WithLabelValues(name).Observe(d.Seconds())
+
+	if err != nil {
+		m.renderErrors.WithLabelValues(name).Inc()
+	}
+}
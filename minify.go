@@ -0,0 +1,67 @@
+package slim
+
+import (
+	"regexp"
+	"strings"
+)
+
+var rwhitespace = regexp.MustCompile(`\s+`)
+
+// Attributes whose presence alone carries their meaning, value="value" is shortened to value when minifying.
+var booleanAttributes = map[string]bool{
+	"allowfullscreen": true,
+	"async":           true,
+	"autofocus":       true,
+	"autoplay":        true,
+	"checked":         true,
+	"controls":        true,
+	"default":         true,
+	"defer":           true,
+	"disabled":        true,
+	"formnovalidate":  true,
+	"hidden":          true,
+	"inert":           true,
+	"ismap":           true,
+	"itemscope":       true,
+	"loop":            true,
+	"multiple":        true,
+	"muted":           true,
+	"nomodule":        true,
+	"novalidate":      true,
+	"open":            true,
+	"playsinline":     true,
+	"readonly":        true,
+	"required":        true,
+	"reversed":        true,
+	"selected":        true,
+}
+
+// Elements whose white space is significant, the pretty printer leaves their content alone.
+var whitespaceElements = map[string]bool{
+	"pre":      true,
+	"textarea": true,
+}
+
+// Elements whose content is rendered as is, white space included.
+var preservedElements = map[string]bool{
+	"pre":      true,
+	"textarea": true,
+	"script":   true,
+	"style":    true,
+}
+
+// collapseWhitespace replaces runs of white space in static text with a single space,
+// leaving #{...} interpolations untouched.
+func collapseWhitespace(value string) string {
+	var result strings.Builder
+
+	last := 0
+	for _, loc := range rinterpolate.FindAllStringIndex(value, -1) {
+		result.WriteString(rwhitespace.ReplaceAllString(value[last:loc[0]], " "))
+		result.WriteString(value[loc[0]:loc[1]])
+		last = loc[1]
+	}
+
+	result.WriteString(rwhitespace.ReplaceAllString(value[last:], " "))
+	return result.String()
+}
@@ -0,0 +1,219 @@
+package slim
+
+import (
+	goAst "go/ast"
+	goParser "go/parser"
+	"strconv"
+	"strings"
+
+	"github.com/golib/slim/parser"
+)
+
+// mixinTemplate returns the name of the template a mixin is compiled into, prefixed like fragments so the mixins
+// of templates compiled into one set keep apart.
+func (c *Compiler) mixinTemplate(name string) string {
+	return c.namespace + "__slim_mixin_" + name
+}
+
+// visitMixin compiles a mixin aside into a template of its own, whose dot is the runtime.Component of a call.
+// Its parameters are bound to the arguments of the call before its content, or their defaults if the call omits
+// them, the rest parameter to the list of the remaining arguments.
+func (c *Compiler) visitMixin(mixin *parser.Mixin) {
+	c.mixins++
+	defer func() {
+		c.mixins--
+	}()
+
+	name := c.mixinTemplate(mixin.Name)
+	for _, fragment := range c.fragments {
+		if fragment.name == name {
+			panic("Mixin " + mixin.Name + " is defined more than once.")
+		}
+	}
+
+	c.compileTemplate(name, func() {
+		c.pushScope()
+		defer c.popScope()
+
+		for i, parameter := range mixin.Parameters {
+			if i < len(mixin.Defaults) && mixin.Defaults[i] != "" {
+				c.assign(parameter, `$.ArgOr `+strconv.Itoa(i)+` (`+c.visitRawInterpolation(mixin.Defaults[i])+`)`, true)
+			} else {
+				c.assign(parameter, `$.Arg `+strconv.Itoa(i), true)
+			}
+		}
+
+		if mixin.Rest != "" {
+			c.assign(mixin.Rest, `$.Rest `+strconv.Itoa(len(mixin.Parameters)), true)
+		}
+
+		if mixin.Block != nil {
+			c.visitBlock(mixin.Block)
+		}
+	})
+}
+
+// visitMixinCall executes the template of a mixin with the arguments of the call. The content filling its slots
+// is compiled into fragments, rendered with the data and the variables of the caller.
+func (c *Compiler) visitMixinCall(call *parser.MixinCall) {
+	if c.inline == 0 {
+		c.indent(0, true)
+	}
+
+	var (
+		names  []string
+		blocks []*parser.Block
+	)
+
+	if call.Block != nil {
+		filled := make(map[string]bool)
+		content := new(parser.Block)
+
+		for _, child := range call.Block.Children {
+			slot, ok := child.(*parser.Slot)
+			if !ok {
+				content.Children = append(content.Children, child)
+				continue
+			}
+
+			if slot.Name == "" {
+				if slot.Block != nil {
+					content.Children = append(content.Children, slot.Block.Children...)
+				}
+
+				continue
+			}
+
+			if filled[slot.Name] {
+				panic("Slot " + slot.Name + " of mixin " + call.Name + " is filled more than once.")
+			}
+
+			filled[slot.Name] = true
+
+			block := slot.Block
+			if block == nil {
+				block = new(parser.Block)
+			}
+
+			names, blocks = append(names, slot.Name), append(blocks, block)
+		}
+
+		if len(content.Children) > 0 {
+			names, blocks = append(names, ""), append(blocks, content)
+		}
+	}
+
+	data := "."
+
+	var slots []string
+	if len(blocks) > 0 {
+		var fragments []string
+		fragments, data = c.visitFragments(blocks...)
+
+		for i, fragment := range fragments {
+			slots = append(slots, strconv.Quote(names[i]), strconv.Quote(fragment))
+		}
+	}
+
+	args := []string{data, `(__slim_slots` + prefixEach(" ", slots) + `)`}
+	for _, arg := range splitArguments(call.Arguments) {
+		args = append(args, `(`+c.visitRawInterpolation(arg)+`)`)
+	}
+
+	component := `__slim_component ` + strings.Join(args, " ")
+	if len(call.Attributes) > 0 {
+		component = `__slim_forward (` + component + `)` + prefixEach(" ", c.forwardedAttributes(call))
+	}
+
+	c.write(`{{template ` + strconv.Quote(c.mixinTemplate(call.Name)) + ` (` + component + `)}}`)
+}
+
+// forwardedAttributes returns the attributes of a mixin call as pairs of names and values, passed on to the tags
+// of the mixin marked by &attributes.
+func (c *Compiler) forwardedAttributes(call *parser.MixinCall) []string {
+	pairs := make([]string, 0, 2*len(call.Attributes))
+
+	for _, attr := range call.Attributes {
+		var value string
+		switch {
+		case !attr.IsRaw:
+			value = c.visitRawInterpolation(attr.Value)
+		case attr.Value == "":
+			value = `true`
+		default:
+			value = strconv.Quote(attr.Value)
+		}
+
+		if c.CheckURLs && urlAttributes[attr.Name] && value != `true` {
+			value = c.checkURL(value)
+		}
+
+		value = `(` + value + `)`
+
+		if len(attr.Condition) > 0 {
+			value = `(and (` + c.visitRawInterpolation(attr.Condition) + `) ` + value + `)`
+		}
+
+		pairs = append(pairs, strconv.Quote(attr.Name), value)
+	}
+
+	return pairs
+}
+
+// visitSlot outputs the content filling a slot of the mixin being compiled, or the content of the slot itself
+// if the call does not fill it.
+func (c *Compiler) visitSlot(slot *parser.Slot) {
+	if c.mixins == 0 {
+		panic("Slots must be placed within a mixin or immediately within a mixin call.")
+	}
+
+	name := strconv.Quote(slot.Name)
+
+	if slot.Block == nil {
+		c.write(`{{__slim_slot $ ` + name + `}}`)
+		return
+	}
+
+	c.write(`{{if $.HasSlot ` + name + `}}{{__slim_slot $ ` + name + `}}{{else}}`)
+
+	c.pushScope()
+	c.visitBlock(slot.Block)
+	c.popScope()
+
+	c.write(`{{end}}`)
+}
+
+// splitArguments splits a list of slim expressions separated by commas, i.e. the arguments of a mixin call.
+func splitArguments(list string) []string {
+	if strings.TrimSpace(list) == "" {
+		return nil
+	}
+
+	const call = "__slim_args("
+
+	// only variables and default need renaming to parse the list, keeping the offsets of the arguments,
+	// expressions are taken from the source as written
+	value := renameTokens(list, "_", strings.Repeat("_", len("default")))
+
+	expr, err := goParser.ParseExpr(call + value + ")")
+	if err != nil {
+		panic("Unable to parse arguments.")
+	}
+
+	args := make([]string, 0)
+	for _, arg := range expr.(*goAst.CallExpr).Args {
+		args = append(args, strings.TrimSpace(list[int(arg.Pos())-1-len(call):int(arg.End())-1-len(call)]))
+	}
+
+	return args
+}
+
+// prefixEach returns values joined, each prefixed by prefix.
+func prefixEach(prefix string, values []string) string {
+	var result string
+	for _, value := range values {
+		result += prefix + value
+	}
+
+	return result
+}
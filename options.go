@@ -0,0 +1,213 @@
+package slim
+
+import (
+	"html/template"
+	"io/fs"
+
+	"github.com/golib/slim/parser"
+	"github.com/golib/slim/runtime"
+)
+
+// Option configures a Compiler created by NewWithOptions.
+type Option func(*Compiler)
+
+// Create and initialize a new Compiler configured by opts, which are applied in order over DefaultOptions.
+//
+//	compiler := slim.NewWithOptions(slim.WithPrettyPrint(false), slim.WithFuncs(funcs))
+func NewWithOptions(opts ...Option) *Compiler {
+	compiler := New()
+
+	for _, opt := range opts {
+		opt(compiler)
+	}
+
+	return compiler
+}
+
+// Replaces all options at once, following options override single fields of them.
+func WithOptions(options Options) Option {
+	return func(c *Compiler) {
+		c.Options = options
+	}
+}
+
+// Sets whether the output html is indented and in human readable form.
+func WithPrettyPrint(pretty bool) Option {
+	return func(c *Compiler) {
+		c.Pretty = pretty
+	}
+}
+
+// Sets whether line number comments are emitted in the output template.
+func WithLineNumbers(lineNumbers bool) Option {
+	return func(c *Compiler) {
+		c.LineNumbers = lineNumbers
+	}
+}
+
+// Sets whether the output is minified.
+func WithMinify(minify bool) Option {
+	return func(c *Compiler) {
+		c.Minify = minify
+	}
+}
+
+// Sets whether HTML comments other than conditional comments and those marked by /!keep are dropped.
+func WithStripComments(strip bool) Option {
+	return func(c *Compiler) {
+		c.StripComments = strip
+	}
+}
+
+// Sets whether go template actions written within text are kept as actions rather than output as text.
+func WithPassthroughActions(passthrough bool) Option {
+	return func(c *Compiler) {
+		c.PassthroughActions = passthrough
+	}
+}
+
+// Sets the manifest the image_tag helper resolves the variants of images by.
+func WithImageManifest(manifest runtime.ImageManifest) Option {
+	return func(c *Compiler) {
+		c.ImageManifest = manifest
+	}
+}
+
+// Sets the column beyond which pretty printed tags put each of their attributes on a line of its own.
+func WithAttributeWrapColumn(column int) Option {
+	return func(c *Compiler) {
+		c.AttributeWrapColumn = column
+	}
+}
+
+// Sets whether control actions are emitted with trim markers.
+func WithTrimMarkers(trim bool) Option {
+	return func(c *Compiler) {
+		c.TrimMarkers = trim
+	}
+}
+
+// Sets the markup format of the output, parser.FORMAT_HTML or parser.FORMAT_XHTML.
+func WithDialect(format string) Option {
+	return func(c *Compiler) {
+		c.Format = format
+	}
+}
+
+// Sets the doctype prepended to templates which do not declare one.
+func WithDefaultDoctype(doctype string) Option {
+	return func(c *Compiler) {
+		c.DefaultDoctype = doctype
+	}
+}
+
+// Sets the extensions tried in order for targets of import and extend without one.
+func WithExtensions(extensions ...string) Option {
+	return func(c *Compiler) {
+		c.Extensions = extensions
+	}
+}
+
+// Sets the directories searched in order for targets of import and extend not found relative to the template directory.
+func WithIncludePaths(paths ...string) Option {
+	return func(c *Compiler) {
+		c.IncludePaths = paths
+	}
+}
+
+// Sets the limits of nesting, file size, import depth and line length, zero fields keep parser.DefaultLimits.
+func WithLimits(limits parser.Limits) Option {
+	return func(c *Compiler) {
+		c.Limits = limits
+	}
+}
+
+// Sets the indentation accepted in templates, one of the parser.Indent constants.
+func WithIndentStyle(style int) Option {
+	return func(c *Compiler) {
+		c.IndentStyle = style
+	}
+}
+
+// Sets the policy on mixed indentation, one of the parser.MixedIndent constants, and the width of tab stops
+// when normalizing it.
+func WithMixedIndentation(policy, tabWidth int) Option {
+	return func(c *Compiler) {
+		c.MixedIndentation = policy
+		c.TabWidth = tabWidth
+	}
+}
+
+// Adds a filter converting the content of :name filters to HTML, see Options.Filters.
+func WithFilter(name string, filter Filter) Option {
+	return func(c *Compiler) {
+		// the map of options passed by WithOptions is left alone
+		filters := make(map[string]Filter, len(c.Filters)+1)
+		for key, value := range c.Filters {
+			filters[key] = value
+		}
+
+		filters[name] = filter
+		c.Filters = filters
+	}
+}
+
+// Sets the separator joining the lines of piped text spanning several lines.
+func WithTextSeparator(separator string) Option {
+	return func(c *Compiler) {
+		c.TextSeparator = separator
+	}
+}
+
+// Sets the policy joining consecutive lines of piped text, one of the TextJoin constants.
+func WithTextJoin(policy int) Option {
+	return func(c *Compiler) {
+		c.TextJoin = policy
+	}
+}
+
+// Sets the directory targets of import and extend are resolved against, see Compiler.SetTemplateDir.
+func WithTemplateDir(dir string) Option {
+	return func(c *Compiler) {
+		c.SetTemplateDir(dir)
+	}
+}
+
+// Sets the file system templates are read from, see Compiler.SetLoader.
+func WithLoader(fsys fs.FS) Option {
+	return func(c *Compiler) {
+		c.SetLoader(fsys)
+	}
+}
+
+// Adds functions callable from template expressions, see Compiler.AddFuncs.
+func WithFuncs(funcs template.FuncMap) Option {
+	return func(c *Compiler) {
+		c.AddFuncs(funcs)
+	}
+}
+
+// Sets the cache storing fragments of cache directives.
+func WithCache(cache runtime.Cache) Option {
+	return func(c *Compiler) {
+		c.Cache = cache
+	}
+}
+
+// Sets the file system ParseFile as well as import and extend read templates from, i.e. an embed.FS.
+// Paths within it are slash separated.
+func (c *Compiler) SetLoader(fsys fs.FS) {
+	c.loader = fsys
+}
+
+// Adds functions callable from template expressions like helpers, i.e. price(Amount).
+// They take precedence over runtime helpers of the same name.
+func (c *Compiler) AddFuncs(funcs template.FuncMap) {
+	if c.funcs == nil {
+		c.funcs = make(template.FuncMap)
+	}
+
+	for name, fn := range funcs {
+		c.funcs[name] = fn
+	}
+}
@@ -0,0 +1,53 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error is a failure of parsing or compiling a template, located at the node or token it has been raised for.
+// Parse and Reparse panic with an *Error, the slim package returns it from its API.
+type Error struct {
+	SourcePosition
+	Message string
+	// Underlying error, i.e. of reading an imported file, nil if there is none
+	Err error
+}
+
+func (e *Error) Error() string {
+	if len(e.Filename) > 0 {
+		return fmt.Sprintf("Slim Error in <%s>: %s - Line: %d, Column: %d, Length: %d", e.Filename, e.Message, e.Line, e.Column, e.TokenLength)
+	}
+
+	return fmt.Sprintf("Slim Error: %s - Line: %d, Column: %d, Length: %d", e.Message, e.Line, e.Column, e.TokenLength)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Turns a recovered panic value into an *Error at pos. Errors located already keep their position.
+func NewError(r interface{}, pos SourcePosition) *Error {
+	var located *Error
+	if err, ok := r.(error); ok && errors.As(err, &located) {
+		return located
+	}
+
+	err, _ := r.(error)
+
+	return &Error{SourcePosition: pos, Message: fmt.Sprint(r), Err: err}
+}
+
+// Warning is a problem of a template which does not keep it from being compiled.
+type Warning struct {
+	SourcePosition
+	Message string
+}
+
+func (w Warning) String() string {
+	if len(w.Filename) > 0 {
+		return fmt.Sprintf("Slim Warning in <%s>: %s - Line: %d, Column: %d", w.Filename, w.Message, w.Line, w.Column)
+	}
+
+	return fmt.Sprintf("Slim Warning: %s - Line: %d, Column: %d", w.Message, w.Line, w.Column)
+}
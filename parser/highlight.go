@@ -0,0 +1,360 @@
+package parser
+
+import (
+	goScanner "go/scanner"
+	goToken "go/token"
+	"regexp"
+	"strings"
+)
+
+// TokenKind classifies a region of template source for syntax highlighting.
+// The set of kinds is stable, new kinds are only ever appended.
+type TokenKind int
+
+const (
+	TokenDoctype TokenKind = iota
+	TokenComment
+	TokenKeyword
+	TokenTag
+	TokenId
+	TokenClass
+	TokenAttribute
+	TokenText
+	TokenInterpolation
+	TokenName
+	TokenVariable
+	TokenIdentifier
+	TokenFunction
+	TokenString
+	TokenNumber
+	TokenOperator
+)
+
+var tokenKindNames = [...]string{
+	TokenDoctype:       "doctype",
+	TokenComment:       "comment",
+	TokenKeyword:       "keyword",
+	TokenTag:           "tag",
+	TokenId:            "id",
+	TokenClass:         "class",
+	TokenAttribute:     "attribute",
+	TokenText:          "text",
+	TokenInterpolation: "interpolation",
+	TokenName:          "name",
+	TokenVariable:      "variable",
+	TokenIdentifier:    "identifier",
+	TokenFunction:      "function",
+	TokenString:        "string",
+	TokenNumber:        "number",
+	TokenOperator:      "operator",
+}
+
+func (k TokenKind) String() string {
+	if k >= 0 && int(k) < len(tokenKindNames) {
+		return tokenKindNames[k]
+	}
+
+	return "unknown"
+}
+
+// Token is a classified region of template source. Start and End are byte offsets into the input, End is exclusive.
+type Token struct {
+	Kind  TokenKind
+	Start int
+	End   int
+}
+
+var (
+	hlkeyword    = regexp.MustCompile(`^(if|elsif|each|while|until|let|block|import|extend|markdown|include_raw|cache|content_for|provide|yield|define|embed|mixin|slot)(\s+|$)|^else\b`)
+	hlblock      = regexp.MustCompile(`^(append|prepend)\s+`)
+	hlrange      = regexp.MustCompile(`^(\$[\w\-]*)(?:\s*(,)\s*(\$[\w\-]*))?\s+(in)\s+`)
+	hlcondition  = regexp.MustCompile(`^\s*(\?)\s*`)
+	hlassignment = regexp.MustCompile(`^(\$[\w\-]*(?:\s*,\s*\$[\w\-]*)*)\s*(:?=)\s*`)
+	hlname       = regexp.MustCompile(`^[\w-]+`)
+	hlinterp     = regexp.MustCompile(`#\{(.*?)\}`)
+)
+
+// Tokenize classifies the regions of a slim template for editor highlighting.
+// Unlike the parser it never fails, unrecognized content is left unclassified.
+// Tokens are returned in source order and never overlap.
+func Tokenize(input string) []Token {
+	h := &highlighter{tokens: make([]Token, 0)}
+
+	offset := 0
+	rawIndent := -1
+	rawKind := TokenText
+
+	for _, line := range strings.SplitAfter(input, "\n") {
+		start := offset
+		offset += len(line)
+
+		line = strings.TrimRight(line, "\r\n")
+		content := strings.TrimLeft(line, " \t")
+		indent := len(line) - len(content)
+
+		if len(content) == 0 {
+			continue
+		}
+
+		// lines nested deeper than a text, comment, script or style line are raw
+		if rawIndent >= 0 && indent > rawIndent {
+			if rawKind == TokenText {
+				h.text(start+indent, content)
+			} else {
+				h.add(rawKind, start+indent, start+len(line))
+			}
+
+			continue
+		}
+
+		rawIndent = -1
+
+		if raw, kind := h.line(start+indent, content); raw {
+			rawIndent = indent
+			rawKind = kind
+		}
+	}
+
+	return h.tokens
+}
+
+type highlighter struct {
+	tokens []Token
+}
+
+func (h *highlighter) add(kind TokenKind, start, end int) {
+	if end > start {
+		h.tokens = append(h.tokens, Token{kind, start, end})
+	}
+}
+
+// line classifies a single line starting at offset, reporting whether its nested lines are raw content of given kind.
+func (h *highlighter) line(offset int, content string) (bool, TokenKind) {
+	switch {
+	case rdoctype.MatchString(content) || strings.HasPrefix(content, "!!!"):
+		h.add(TokenDoctype, offset, offset+len(content))
+		return false, 0
+	case strings.HasPrefix(content, "/"):
+		h.add(TokenComment, offset, offset+len(content))
+
+		// lines nested below conditional comments are markup
+		if matches := rcomment.FindStringSubmatch(content); len(matches) != 0 && len(matches[1]) > 0 {
+			return false, 0
+		}
+
+		return true, TokenComment
+	case rfilter.MatchString(content):
+		h.add(TokenKeyword, offset, offset+len(content))
+		return true, TokenText
+	case rpassthrough.MatchString(content):
+		h.add(TokenKeyword, offset, offset+len(content))
+		return true, TokenInterpolation
+	case rmixincall.MatchString(content):
+		m := rmixincall.FindStringSubmatchIndex(content)
+		h.add(TokenOperator, offset, offset+1)
+		h.add(TokenName, offset+m[2], offset+m[3])
+
+		if closing := closingParen(content, m[1]); strings.HasPrefix(content[m[1]:], "(") && closing > 0 {
+			h.expression(offset+m[1]+1, content[m[1]+1:closing])
+		}
+
+		return false, 0
+	case rpassthroughline.MatchString(content):
+		h.add(TokenOperator, offset, offset+1)
+		h.add(TokenInterpolation, offset+1, offset+len(content))
+		return false, 0
+	case rtext.MatchString(content):
+		h.add(TokenOperator, offset, offset+1)
+		h.text(offset+1, content[1:])
+		return true, TokenText
+	}
+
+	if matches := hlkeyword.FindStringSubmatchIndex(content); matches != nil {
+		keyword := strings.TrimSpace(content[:matches[1]])
+		h.add(TokenKeyword, offset, offset+len(keyword))
+
+		rest, at := content[matches[1]:], offset+matches[1]
+
+		switch keyword {
+		case "if", "elsif", "while", "until", "cache", "embed":
+			h.expression(at, rest)
+		case "each":
+			if m := hlrange.FindStringSubmatchIndex(rest); m != nil {
+				h.add(TokenVariable, at+m[2], at+m[3])
+				h.add(TokenOperator, at+m[4], at+m[5])
+				h.add(TokenVariable, at+m[6], at+m[7])
+				h.add(TokenKeyword, at+m[8], at+m[9])
+				h.expression(at+m[1], rest[m[1]:])
+			}
+		case "block":
+			if m := hlblock.FindStringSubmatchIndex(rest); m != nil {
+				h.add(TokenKeyword, at+m[2], at+m[3])
+				rest, at = rest[m[1]:], at+m[1]
+			}
+
+			h.add(TokenName, at, at+len(rest))
+		case "let":
+			if m := hlassignment.FindStringSubmatchIndex(rest); m != nil {
+				h.add(TokenVariable, at+m[2], at+m[3])
+				h.add(TokenOperator, at+m[4], at+m[5])
+				h.expression(at+m[1], rest[m[1]:])
+			}
+		case "import", "extend", "markdown", "include_raw", "content_for", "provide", "yield", "define", "mixin", "slot":
+			h.add(TokenName, at, at+len(rest))
+		case "else":
+			if trimmed := strings.TrimLeft(rest, " \t"); len(trimmed) > 0 {
+				return h.line(at+len(rest)-len(trimmed), trimmed)
+			}
+		}
+
+		return false, 0
+	}
+
+	if m := hlassignment.FindStringSubmatchIndex(content); m != nil {
+		h.add(TokenVariable, offset+m[2], offset+m[3])
+		h.add(TokenOperator, offset+m[4], offset+m[5])
+		h.expression(offset+m[1], content[m[1]:])
+		return false, 0
+	}
+
+	name := rtag.FindString(content)
+	if len(name) > 0 {
+		h.add(TokenTag, offset, offset+len(name))
+	}
+
+	rest, at := content[len(name):], offset+len(name)
+
+	for len(rest) > 0 {
+		var consumed int
+
+		switch {
+		case rid.MatchString(rest) || rclass.MatchString(rest):
+			kind := TokenId
+			if rest[0] == '.' {
+				kind = TokenClass
+			}
+
+			consumed = 1 + len(hlname.FindString(rest[1:]))
+			h.add(kind, at, at+consumed)
+		case isAttribute(rest):
+			m, _ := matchAttribute(rest)
+			h.add(TokenAttribute, at+m.name[0], at+m.name[1])
+
+			if m.operator >= 0 {
+				h.add(TokenOperator, at+m.operator, at+m.operator+1)
+
+				if value := rest[m.value[0]:m.value[1]]; value[0] == '"' || value[0] == '\'' || rbareword.MatchString(value) {
+					h.add(TokenString, at+m.value[0], at+m.value[1])
+				} else {
+					h.expression(at+m.value[0], rest[m.value[0]:m.value[1]])
+				}
+			}
+
+			consumed = m.end
+		case rtrailingcomment.MatchString(rest):
+			h.add(TokenComment, at+len(rest)-len(strings.TrimLeft(rest, " \t")), at+len(rest))
+			consumed = len(rest)
+		case hlcondition.MatchString(rest) && len(name) == 0:
+			m := hlcondition.FindStringSubmatchIndex(rest)
+			h.add(TokenOperator, at+m[2], at+m[3])
+			h.expression(at+m[1], rest[m[1]:])
+			consumed = len(rest)
+		default:
+			// the remainder of a tag line is its text content
+			h.text(at, rest)
+
+			if name == "script" || name == "style" {
+				return true, TokenText
+			}
+
+			return false, 0
+		}
+
+		rest, at = rest[consumed:], at+consumed
+	}
+
+	return name == "script" || name == "style", TokenText
+}
+
+func isAttribute(input string) bool {
+	_, ok := matchAttribute(input)
+	return ok
+}
+
+// text classifies plain text, splitting out #{...} interpolation regions.
+func (h *highlighter) text(offset int, text string) {
+	last := 0
+
+	for _, m := range hlinterp.FindAllStringSubmatchIndex(text, -1) {
+		h.add(TokenText, offset+last, offset+m[0])
+		h.add(TokenInterpolation, offset+m[0], offset+m[2])
+		h.expression(offset+m[2], text[m[2]:m[3]])
+		h.add(TokenInterpolation, offset+m[3], offset+m[1])
+		last = m[1]
+	}
+
+	h.add(TokenText, offset+last, offset+len(text))
+}
+
+// expression classifies a slim expression using the Go scanner, $ prefixed names are variables.
+func (h *highlighter) expression(offset int, expr string) {
+	var s goScanner.Scanner
+
+	fset := goToken.NewFileSet()
+	file := fset.AddFile("", -1, len(expr))
+	s.Init(file, []byte(expr), nil, 0)
+
+	type lexeme struct {
+		pos int
+		tok goToken.Token
+		lit string
+	}
+
+	lexemes := make([]lexeme, 0)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == goToken.EOF {
+			break
+		}
+
+		if tok == goToken.SEMICOLON && lit == "\n" {
+			continue
+		}
+
+		lexemes = append(lexemes, lexeme{file.Offset(pos), tok, lit})
+	}
+
+	for i := 0; i < len(lexemes); i++ {
+		lex := lexemes[i]
+		at := offset + lex.pos
+
+		switch {
+		case lex.tok == goToken.ILLEGAL && expr[lex.pos] == '$':
+			end := at + 1
+			if i+1 < len(lexemes) && lexemes[i+1].tok == goToken.IDENT && lexemes[i+1].pos == lex.pos+1 {
+				i++
+				end = offset + lexemes[i].pos + len(lexemes[i].lit)
+			}
+
+			h.add(TokenVariable, at, end)
+		case lex.tok == goToken.IDENT || lex.tok == goToken.DEFAULT:
+			kind := TokenIdentifier
+			if i+1 < len(lexemes) && lexemes[i+1].tok == goToken.LPAREN {
+				kind = TokenFunction
+			}
+
+			name := lex.lit
+			if len(name) == 0 {
+				name = lex.tok.String()
+			}
+
+			h.add(kind, at, at+len(name))
+		case lex.tok == goToken.STRING || lex.tok == goToken.CHAR:
+			h.add(TokenString, at, at+len(lex.lit))
+		case lex.tok == goToken.INT || lex.tok == goToken.FLOAT:
+			h.add(TokenNumber, at, at+len(lex.lit))
+		case lex.tok.IsOperator():
+			h.add(TokenOperator, at, at+len(lex.tok.String()))
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var rcontinue = regexp.MustCompile(`^(else|elsif)(\s|$)`)
+
+// Edit replaces whole lines of a parsed template. Lines are numbered from 1, like SourcePosition.
+type Edit struct {
+	// First replaced line
+	StartLine int
+	// Line following the last replaced line, equal to StartLine for pure insertions
+	EndLine int
+	// Replacement lines without a trailing newline, empty for pure deletions
+	Text string
+}
+
+// segment is a top level construct of the template, starting at a line without indentation
+// and running up to the next one.
+type segment struct {
+	start  int
+	end    int
+	nodes  []Noder
+	blocks []string
+	// warnings raised while parsing the segment and the templates it imports
+	warnings []Warning
+	// kind of indentation the file is taken to be indented with after the segment, see checkMixedIndentation
+	indentKind int
+}
+
+// Applies edit to the source of the template and returns the updated tree.
+// Only the top level constructs touched by the edit are parsed again, the nodes of
+// following constructs are reused with their positions shifted. The first call, and
+// any call on a template extending another one, parses the whole source.
+func (p *Parser) Reparse(edit Edit) *Block {
+	lines := splitLines(p.source)
+	start, end := edit.StartLine-1, edit.EndLine-1
+
+	if start < 0 || end < start || end > len(lines) {
+		panic(fmt.Sprintf("Invalid edit of lines %d to %d, template has %d lines.", edit.StartLine, edit.EndLine, len(lines)))
+	}
+
+	replacement := splitLines(edit.Text)
+	delta := len(replacement) - (end - start)
+
+	updated := make([]string, 0, len(lines)+delta)
+	updated = append(updated, lines[:start]...)
+	updated = append(updated, replacement...)
+	updated = append(updated, lines[end:]...)
+
+	p.source = strings.Join(updated, "\n")
+
+	if len(p.segments) == 0 || p.parent != nil {
+		return p.reparseAll()
+	}
+
+	// the segment preceding an edit at its boundary is parsed again as well,
+	// the edit might turn into its else branch or nested content
+	first := p.segmentAt(start)
+	if first > 0 && start <= p.segments[first].start {
+		first--
+	}
+
+	last := p.segmentAt(start)
+	if end > start {
+		last = p.segmentAt(end - 1)
+	}
+
+	from, to := p.segments[first].start, p.segments[last].end+delta
+
+	for _, seg := range p.segments[first : last+1] {
+		for _, name := range seg.blocks {
+			delete(p.namedBlocks, name)
+		}
+	}
+
+	seen := IndentAny
+	if first > 0 {
+		seen = p.segments[first-1].indentKind
+	}
+
+	reparsed, ok := p.parseSegments(updated, from, to, seen)
+	if !ok {
+		return p.reparseAll()
+	}
+
+	following := p.segments[last+1:]
+
+	// following segments were checked for mixed indentation against the kind the file was indented with
+	if len(following) > 0 {
+		if len(reparsed) > 0 {
+			seen = reparsed[len(reparsed)-1].indentKind
+		}
+
+		if seen != p.segments[last].indentKind {
+			return p.reparseAll()
+		}
+	}
+
+	for _, seg := range following {
+		seg.start += delta
+		seg.end += delta
+
+		for _, node := range seg.nodes {
+			shiftLines(node, p.filename, delta)
+		}
+
+		for i := range seg.warnings {
+			shift(&seg.warnings[i].SourcePosition, p.filename, delta)
+		}
+	}
+
+	segments := make([]*segment, 0, len(p.segments))
+	segments = append(segments, p.segments[:first]...)
+	segments = append(segments, reparsed...)
+	segments = append(segments, following...)
+
+	p.segments = segments
+	p.result = p.segmentsBlock()
+	return p.result
+}
+
+// reparseAll parses the whole source, split into top level segments to allow further reparses.
+func (p *Parser) reparseAll() *Block {
+	p.parent = nil
+	p.result = nil
+	p.segments = nil
+	p.warnings = nil
+	p.imports = nil
+	p.scanner.warnings = nil
+	p.namedBlocks = make(map[string]*NamedBlock)
+
+	lines := splitLines(p.source)
+
+	segments, ok := p.parseSegments(lines, 0, len(lines), IndentAny)
+	if !ok {
+		p.namedBlocks = make(map[string]*NamedBlock)
+
+		full, _ := NewStringParser(p.source)
+		full.filename = p.filename
+		full.filepath = p.filepath
+		full.fileextensions = p.fileextensions
+		full.loader = p.loader
+		full.mounts = p.mounts
+		full.SetLimits(p.limits)
+		full.SetIndentStyle(p.scanner.indentStyle)
+		full.SetMixedIndentation(p.scanner.mixedIndentation, p.scanner.tabWidth)
+		full.SetTextSeparator(p.scanner.textSeparator)
+		full.SetStrict(p.strict)
+		full.SetIncludePaths(p.includePaths...)
+
+		p.result = full.Parse()
+		p.parent = full.parent
+		p.namedBlocks = full.namedBlocks
+		p.options = full.options
+		p.warnings = full.warnings
+		p.imports = full.imports
+		p.scanner.warnings = full.scanner.warnings
+		return p.result
+	}
+
+	p.segments = segments
+	p.result = p.segmentsBlock()
+	return p.result
+}
+
+// parseSegments parses lines[from:to] one top level construct at a time, seen being the kind of indentation
+// the lines before are indented with. It reports false if a segment extends another template, which requires
+// a full parse.
+func (p *Parser) parseSegments(lines []string, from, to int, seen int) ([]*segment, bool) {
+	segments := make([]*segment, 0)
+
+	for start := from; start < to; {
+		end := start + 1
+		for end < to && !isSegmentStart(lines[end]) {
+			end++
+		}
+
+		sub, _ := NewStringParser(strings.Join(lines[start:end], "\n"))
+		sub.filename = p.filename
+		sub.filepath = p.filepath
+		sub.fileextensions = p.fileextensions
+		sub.loader = p.loader
+		sub.mounts = p.mounts
+		sub.SetLimits(p.limits)
+		sub.SetIndentStyle(p.scanner.indentStyle)
+		sub.SetMixedIndentation(p.scanner.mixedIndentation, p.scanner.tabWidth)
+		sub.SetTextSeparator(p.scanner.textSeparator)
+		sub.SetStrict(p.strict)
+		sub.SetIncludePaths(p.includePaths...)
+		sub.scanner.line = start - 1
+		sub.scanner.indentKindSeen = seen
+
+		block := sub.Parse()
+		if sub.parent != nil {
+			return nil, false
+		}
+
+		// a pragma is only recognized on the first line of the template
+		if start == 0 {
+			p.options = sub.options
+		}
+
+		seen = sub.scanner.indentKindSeen
+		seg := &segment{start: start, end: end, nodes: block.Children, warnings: sub.Warnings(), indentKind: seen}
+
+		for name, named := range sub.namedBlocks {
+			if p.namedBlocks[name] != nil {
+				panic("Multiple definitions of named blocks are not permitted. Block " + name + " has been redefined.")
+			}
+
+			p.namedBlocks[name] = named
+			seg.blocks = append(seg.blocks, name)
+		}
+
+		segments = append(segments, seg)
+		start = end
+	}
+
+	return segments, true
+}
+
+func (p *Parser) segmentAt(line int) int {
+	for i, seg := range p.segments {
+		if line < seg.end {
+			return i
+		}
+	}
+
+	return len(p.segments) - 1
+}
+
+func (p *Parser) segmentsBlock() *Block {
+	block := newBlock()
+	block.Options = p.options
+
+	for _, seg := range p.segments {
+		for _, node := range seg.nodes {
+			block.push(node)
+		}
+	}
+
+	return block
+}
+
+func isSegmentStart(line string) bool {
+	if len(line) == 0 || line[0] == ' ' || line[0] == '\t' {
+		return false
+	}
+
+	return !rcontinue.MatchString(line)
+}
+
+// shift moves pos by delta lines if it is within filename.
+func shift(pos *SourcePosition, filename string, delta int) {
+	if pos.Filename == filename {
+		pos.Line += delta
+		pos.EndLine += delta
+	}
+}
+
+func splitLines(source string) []string {
+	source = strings.TrimSuffix(source, "\n")
+	if len(source) == 0 {
+		return []string{}
+	}
+
+	return strings.Split(source, "\n")
+}
+
+// shiftLines moves the positions of node and its children within filename by delta lines.
+// Nodes imported from other files keep their positions.
+func shiftLines(node Noder, filename string, delta int) {
+	switch node := node.(type) {
+	case *Block:
+		shift(&node.SourcePosition, filename, delta)
+
+		for _, child := range node.Children {
+			shiftLines(child, filename, delta)
+		}
+	case *NamedBlock:
+		shiftLines(&node.Block, filename, delta)
+	case *Doctype:
+		shift(&node.SourcePosition, filename, delta)
+	case *Comment:
+		shift(&node.SourcePosition, filename, delta)
+
+		if node.Block != nil {
+			shiftLines(node.Block, filename, delta)
+		}
+	case *Text:
+		shift(&node.SourcePosition, filename, delta)
+	case *Tag:
+		shift(&node.SourcePosition, filename, delta)
+
+		for i := range node.Attributes {
+			shift(&node.Attributes[i].SourcePosition, filename, delta)
+		}
+
+		if node.Block != nil {
+			shiftLines(node.Block, filename, delta)
+		}
+	case *Statement:
+		shift(&node.SourcePosition, filename, delta)
+	case *Assignment:
+		shift(&node.SourcePosition, filename, delta)
+	case *Condition:
+		shift(&node.SourcePosition, filename, delta)
+
+		if node.Positive != nil {
+			shiftLines(node.Positive, filename, delta)
+		}
+
+		if node.Negative != nil {
+			shiftLines(node.Negative, filename, delta)
+		}
+	case *Range:
+		shift(&node.SourcePosition, filename, delta)
+
+		if node.Block != nil {
+			shiftLines(node.Block, filename, delta)
+		}
+	case *Let:
+		shift(&node.SourcePosition, filename, delta)
+
+		if node.Block != nil {
+			shiftLines(node.Block, filename, delta)
+		}
+	case *While:
+		shift(&node.SourcePosition, filename, delta)
+
+		if node.Block != nil {
+			shiftLines(node.Block, filename, delta)
+		}
+	case *Cache:
+		shift(&node.SourcePosition, filename, delta)
+
+		if node.Block != nil {
+			shiftLines(node.Block, filename, delta)
+		}
+	case *ContentFor:
+		shift(&node.SourcePosition, filename, delta)
+
+		if node.Block != nil {
+			shiftLines(node.Block, filename, delta)
+		}
+	case *Define:
+		shift(&node.SourcePosition, filename, delta)
+
+		if node.Block != nil {
+			shiftLines(node.Block, filename, delta)
+		}
+	case *Embed:
+		shift(&node.SourcePosition, filename, delta)
+	case *Passthrough:
+		shift(&node.SourcePosition, filename, delta)
+	case *Mixin:
+		shift(&node.SourcePosition, filename, delta)
+
+		if node.Block != nil {
+			shiftLines(node.Block, filename, delta)
+		}
+	case *MixinCall:
+		shift(&node.SourcePosition, filename, delta)
+
+		for i := range node.Attributes {
+			shift(&node.Attributes[i].SourcePosition, filename, delta)
+		}
+
+		if node.Block != nil {
+			shiftLines(node.Block, filename, delta)
+		}
+	case *Slot:
+		shift(&node.SourcePosition, filename, delta)
+
+		if node.Block != nil {
+			shiftLines(node.Block, filename, delta)
+		}
+	case *Yield:
+		shift(&node.SourcePosition, filename, delta)
+	}
+}
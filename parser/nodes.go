@@ -2,12 +2,16 @@ package parser
 
 import (
 	"regexp"
+	"strings"
+	"time"
 )
 
 // HTML const
 const (
 	FORMAT_HTML  = "html"
 	FORMAT_XHTML = "xhtml"
+	// plain text, i.e. the text/plain part of emails
+	FORMAT_TEXT = "text"
 
 	WRAPPER_BOTH = iota
 	WRAPPER_COMMENT
@@ -61,11 +65,20 @@ type Noder interface {
 	Pos() SourcePosition
 }
 
+// SourcePosition locates the first token of a node. Lines and columns are numbered from 1,
+// EndLine and EndColumn point right after the last character of the token.
+// Columns and TokenLength count runes, ByteColumn and ByteEndColumn are the byte offsets of the same
+// columns within their lines.
 type SourcePosition struct {
 	Line        int
 	Column      int
 	Filename    string
 	TokenLength int
+	EndLine     int
+	EndColumn   int
+
+	ByteColumn    int
+	ByteEndColumn int
 }
 
 func (s *SourcePosition) Pos() SourcePosition {
@@ -89,8 +102,19 @@ func NewCdataWrapper() *Wrapper {
 	return &Wrapper{"\n//<![CDATA[\n", "\n//]]>\n"}
 }
 
+func NewCssCdataWrapper() *Wrapper {
+	return &Wrapper{"\n/*<![CDATA[*/\n", "\n/*]]>*/\n"}
+}
+
+// Returns the wrapper of a conditional comment hidden from browsers other than those matching condition.
 func NewConditionWrapper(condition string) *Wrapper {
-	return &Wrapper{"<!--[if " + condition + "]>\n", "\n<![endif]>\n"}
+	return &Wrapper{"<!--[if " + condition + "]>\n", "\n<![endif]-->\n"}
+}
+
+// Returns the wrapper of a downlevel-revealed conditional comment, its content is shown to browsers matching
+// condition as well as to all those which do not know conditional comments.
+func NewRevealedConditionWrapper(condition string) *Wrapper {
+	return &Wrapper{"<!--[if " + condition + "]><!-->\n", "\n<!--<![endif]-->\n"}
 }
 
 type Doctype struct {
@@ -155,6 +179,8 @@ type Comment struct {
 	Block   *Block
 	Wrapper *Wrapper
 	Silent  bool
+	// Setting if the comment is kept when comments are stripped, marked by /!keep
+	Keep bool
 }
 
 func newComment(value string) *Comment {
@@ -166,6 +192,11 @@ func newComment(value string) *Comment {
 	return node
 }
 
+// Whether is the comment an Internet Explorer conditional comment?
+func (c *Comment) IsConditional() bool {
+	return c.Wrapper != nil && strings.HasPrefix(c.Wrapper.L, "<!--[if ")
+}
+
 type Text struct {
 	SourcePosition
 	Value string
@@ -194,6 +225,8 @@ type Tag struct {
 	Attributes     []Attribute
 	IsInterpolated bool
 	IsRawHtml      bool
+	// Setting if the attributes passed to the enclosing mixin are placed on the tag, marked by &attributes
+	ForwardAttributes bool
 }
 
 func newTag(name string) *Tag {
@@ -211,6 +244,14 @@ func (t *Tag) IsAutoclose() bool {
 	return _AUTOCLOSES[t.Name]
 }
 
+// Registers additional names of autoclose (void) elements, like custom elements without content.
+// It is not safe for concurrent use and should be called on initialization, before templates are compiled.
+func RegisterVoidElement(names ...string) {
+	for _, name := range names {
+		_AUTOCLOSES[name] = true
+	}
+}
+
 func (t *Tag) IsRawText() bool {
 	return t.IsRawHtml || t.Name == "style" || t.Name == "script"
 }
@@ -218,6 +259,8 @@ func (t *Tag) IsRawText() bool {
 type Block struct {
 	SourcePosition
 	Children []Noder
+	// Compiler options set by the pragma of the file the block has been parsed from, nil without pragma
+	Options map[string]string
 }
 
 func newBlock() *Block {
@@ -260,6 +303,10 @@ type NamedBlock struct {
 	Block
 	Name     string
 	Modifier int
+	// Attributes heading the content, added to the tag enclosing the block once templates extending it are merged
+	Attributes []Attribute
+	// Node whose content the block is placed in, nil at the top level of a template
+	Parent Noder
 }
 
 func newNamedBlock(name string) *NamedBlock {
@@ -283,14 +330,21 @@ func newStatement(expression string) *Statement {
 
 type Assignment struct {
 	SourcePosition
-	Variable   string
+	// First of Variables
+	Variable string
+	// Variables assigned, several ones take the elements of the slice or array Expression gives
+	Variables  []string
 	Expression string
+	// Whether the variables are declared anew by :=, shadowing variables of enclosing scopes
+	Declare bool
 }
 
-func newAssignment(variable, expression string) *Assignment {
+func newAssignment(variables, expression string, declare bool) *Assignment {
 	node := new(Assignment)
-	node.Variable = variable
+	node.Variables = rvariables.Split(variables, -1)
+	node.Variable = node.Variables[0]
 	node.Expression = expression
+	node.Declare = declare
 	return node
 }
 
@@ -322,3 +376,207 @@ func newRange(key, value, expression string) *Range {
 	node.Expression = expression
 	return node
 }
+
+// Let declares variables which only exist within its block.
+type Let struct {
+	SourcePosition
+	Variables  []string
+	Expression string
+	Block      *Block
+}
+
+func newLet(variables, expression string) *Let {
+	node := new(Let)
+	node.Variables = rvariables.Split(variables, -1)
+	node.Expression = expression
+	return node
+}
+
+// While repeats its block as long as Expression holds, or until it holds if Until is set.
+type While struct {
+	SourcePosition
+	Expression string
+	Until      bool
+	Block      *Block
+}
+
+func newWhile(expression string, until bool) *While {
+	node := new(While)
+	node.Expression = expression
+	node.Until = until
+	return node
+}
+
+// Cache is a fragment whose rendered output is cached at runtime under the value of Key.
+type Cache struct {
+	SourcePosition
+	Key string
+	// Lifetime of the cached output, zero keeps it until evicted
+	TTL   time.Duration
+	Block *Block
+}
+
+func newCache(key string, ttl time.Duration) *Cache {
+	node := new(Cache)
+	node.Key = key
+	node.TTL = ttl
+	return node
+}
+
+// ContentFor is content provided by a template to be output at the yield point of the same name,
+// typically within the layout it extends.
+type ContentFor struct {
+	SourcePosition
+	Name  string
+	Block *Block
+}
+
+func newContentFor(name string) *ContentFor {
+	node := new(ContentFor)
+	node.Name = name
+	return node
+}
+
+// Define is a named template of its own defined by a template, i.e. a small partial like an icon,
+// executed by the template action or the embed directive.
+type Define struct {
+	SourcePosition
+	Name  string
+	Block *Block
+}
+
+func newDefine(name string) *Define {
+	node := new(Define)
+	node.Name = name
+	return node
+}
+
+// Embed executes the template of given name defined elsewhere in the set, i.e. by a define directive
+// or by a html/template parsed into the set, passing it the result of its expression.
+type Embed struct {
+	SourcePosition
+	Name string
+	// Expression passed to the template, empty to pass the current dot
+	Expression string
+}
+
+func newEmbed(name, expression string) *Embed {
+	node := new(Embed)
+	node.Name = name
+	node.Expression = expression
+	return node
+}
+
+// Mixin is a component compiled into a template of its own, called by MixinCall with arguments bound to its
+// parameters and content filling its slots.
+type Mixin struct {
+	SourcePosition
+	Name string
+	// Variables bound to the arguments of a call in order, i.e. $title
+	Parameters []string
+	// Expressions of the default values of Parameters by index, empty for parameters without default
+	Defaults []string
+	// Variable collecting the arguments following Parameters into a list, i.e. $rest of ...$rest
+	Rest  string
+	Block *Block
+}
+
+func newMixin(name string, parameters, defaults []string, rest string) *Mixin {
+	node := new(Mixin)
+	node.Name = name
+	node.Parameters = parameters
+	node.Defaults = defaults
+	node.Rest = rest
+	return node
+}
+
+// MixinCall outputs the mixin of given name. Slots nested below it fill the slots of the mixin by name,
+// the rest of its content fills the default slot.
+type MixinCall struct {
+	SourcePosition
+	Name string
+	// Expressions passed to the parameters of the mixin, separated by commas
+	Arguments string
+	// Id, classes and attributes passed to the tags of the mixin marked by &attributes
+	Attributes []Attribute
+	Block      *Block
+}
+
+func newMixinCall(name, arguments string) *MixinCall {
+	node := new(MixinCall)
+	node.Name = name
+	node.Arguments = arguments
+	return node
+}
+
+// Slot is a placeholder of a mixin, outputting the content a call fills it with or its own content otherwise,
+// or the content a call fills it with. The default slot has no name.
+type Slot struct {
+	SourcePosition
+	Name  string
+	Block *Block
+}
+
+func newSlot(name string) *Slot {
+	node := new(Slot)
+	node.Name = name
+	return node
+}
+
+// Yield outputs the content provided by content_for blocks of the same name.
+type Yield struct {
+	SourcePosition
+	Name string
+}
+
+func newYield(name string) *Yield {
+	node := new(Yield)
+	node.Name = name
+	return node
+}
+
+// Filter is content converted to HTML at compile time by the filter of given name, i.e. :markdown.
+type Filter struct {
+	SourcePosition
+	Name    string
+	Content string
+	// File the content has been read from, empty if it is nested below the filter
+	Filename string
+}
+
+func newFilter(name, content string) *Filter {
+	node := new(Filter)
+	node.Name = name
+	node.Content = content
+	return node
+}
+
+// Passthrough is go template code written into the generated template as is, i.e. actions slim has no syntax for.
+type Passthrough struct {
+	SourcePosition
+	Value string
+}
+
+func newPassthrough(value string) *Passthrough {
+	node := new(Passthrough)
+	node.Value = value
+	return node
+}
+
+// RawFile is the content of a file output as is, i.e. an SVG icon or critical CSS.
+type RawFile struct {
+	SourcePosition
+	Filename string
+	Content  string
+	// Whether the content is output by the unescaped helper rather than as part of the template,
+	// so html/template does not take its markup into account
+	Unescaped bool
+}
+
+func newRawFile(filename, content string, unescaped bool) *RawFile {
+	node := new(RawFile)
+	node.Filename = filename
+	node.Content = content
+	node.Unescaped = unescaped
+	return node
+}
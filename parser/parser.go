@@ -4,13 +4,79 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
+	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// Limits bound the resources taken by parsing a template, guarding against hostile input.
+// Zero fields take the value of DefaultLimits.
+type Limits struct {
+	// Levels of indentation
+	MaxDepth int
+	// Bytes of a single template file
+	MaxFileSize int
+	// Levels of templates importing or extending each other
+	MaxImportDepth int
+	// Bytes of a single line
+	MaxLineLength int
+}
+
+var DefaultLimits = Limits{MaxDepth: 256, MaxFileSize: 8 << 20, MaxImportDepth: 32, MaxLineLength: 1 << 20}
+
+// Indent styles enforced on the indentation of templates
+const (
+	// Tabs and spaces may be mixed as long as every level is indented coherently
+	IndentAny = iota
+	// Tabs only
+	IndentTabs
+	// Spaces only
+	IndentSpaces
+	// Either tabs or spaces throughout a file, whichever it is indented with first
+	IndentConsistent
+)
+
+// Policies on templates mixing tabs and spaces in their indentation
+const (
+	// Mixing is left to the indent style
+	MixedIndentAllow = iota
+	// Mixing fails parsing
+	MixedIndentError
+	// Mixing is reported by a warning
+	MixedIndentWarn
+	// Tabs are expanded to spaces at the tab width
+	MixedIndentNormalize
+)
+
+// Width of tab stops when normalizing mixed indentation.
+const DefaultTabWidth = 4
+
+// Extensions tried in order for targets of import and extend without one.
+var DefaultExtensions = []string{".html.slim", ".slim"}
+
 func NewStringParser(input string) (*Parser, error) {
-	return newParser(bytes.NewReader([]byte(input))), nil
+	parser := newParser(bytes.NewReader([]byte(input)))
+	parser.source = input
+	return parser, nil
+}
+
+// Creates a parser of the template in input, which must not be modified while the parser is in use.
+func NewBytesParser(input []byte) (*Parser, error) {
+	parser := newParser(bytes.NewReader(input))
+	parser.source = string(input)
+	return parser, nil
+}
+
+// Creates a parser reading the template from r as it goes, without holding its whole source.
+// Such a parser is unable to Reparse edits.
+func NewReaderParser(r io.Reader) (*Parser, error) {
+	return newParser(r), nil
 }
 
 func NewFileParser(filename string) (*Parser, error) {
@@ -21,24 +87,83 @@ func NewFileParser(filename string) (*Parser, error) {
 
 	parser := newParser(bytes.NewReader(data))
 	parser.filename = filename
+	parser.source = string(data)
+	return parser, nil
+}
+
+// Creates a parser of the template named name within fsys, i.e. an embed.FS.
+// Targets of import and extend are read from fsys as well.
+func NewFSParser(fsys fs.FS, name string) (*Parser, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := newParser(bytes.NewReader(data))
+	parser.filename = name
+	parser.source = string(data)
+	parser.loader = fsys
 	return parser, nil
 }
 
 type Parser struct {
-	scanner       *scanner
-	parent        *Parser
-	token         *token
-	result        *Block
-	filename      string
-	filepath      string
-	fileextension string
-	namedBlocks   map[string]*NamedBlock
+	scanner        *scanner
+	parent         *Parser
+	token          *token
+	tokenPos       SourcePosition
+	result         *Block
+	filename       string
+	filepath       string
+	fileextensions []string
+	loader         fs.FS
+	includePaths   []string
+	limits         Limits
+	namedBlocks    map[string]*NamedBlock
+	options        map[string]string
+	source         string
+	segments       []*segment
+
+	// levels of importing and extending templates above this one
+	depth int
+	// parsers of imported templates
+	imports []*Parser
+	// setting if blocks overriding none of the parent templates fail parsing
+	strict bool
+	// position of the extend directive, if any
+	extendPos SourcePosition
+	// setting if the template is extended by another one, which places the attributes of its blocks
+	extended bool
+	// node whose content is being parsed, recorded as the parent of named blocks
+	enclosing Noder
+	// warnings raised while merging the template into those it extends
+	warnings []Warning
+	// depth of mixins and mixin calls whose content is being parsed, slots are theirs within them
+	mixins int
+	// file systems of component libraries by the prefix of the targets of import and extend read from them
+	mounts map[string]fs.FS
+	// setting if the template is parsed on its own, leaving the targets of import, extend and include unread
+	shallow bool
+	// targets of the import and extend directives of the template
+	targets []Target
+}
+
+// Target is the template an import or extend directive refers to.
+type Target struct {
+	SourcePosition
+	// Directive referring to the template, "import" or "extend"
+	Directive string
+	// Target as written, i.e. "layout"
+	Name string
+	// Path the target resolves to, within the loader if one is set. Empty if it is read from a component
+	// library or the template has no path to resolve it from.
+	Filename string
 }
 
 func newParser(r io.Reader) *Parser {
 	p := new(Parser)
 	p.scanner = newScanner(r)
-	p.fileextension = ".html.slim"
+	p.fileextensions = DefaultExtensions
+	p.limits = DefaultLimits
 	p.namedBlocks = make(map[string]*NamedBlock)
 	return p
 }
@@ -49,29 +174,362 @@ func (p *Parser) SetPath(path string) {
 }
 
 func (p *Parser) SetExtension(extension string) {
-	p.fileextension = extension
+	p.SetExtensions(extension)
+	return
+}
+
+// Sets the extensions tried in order for targets of import and extend without one.
+func (p *Parser) SetExtensions(extensions ...string) {
+	p.fileextensions = extensions
+	return
+}
+
+// Sets the directories searched in order for targets of import and extend not found relative to the path.
+// Default: nil
+func (p *Parser) SetIncludePaths(paths ...string) {
+	p.includePaths = paths
+	return
+}
+
+// Sets if the template is parsed on its own, i.e. to outline it in an editor while the templates it refers to are
+// missing or broken. Imports and included files are parsed as empty, extended templates are not merged and named
+// blocks are kept in place whatever their modifier. See Targets for what the template refers to.
+// Default: false
+func (p *Parser) SetShallow(shallow bool) {
+	p.shallow = shallow
+	return
+}
+
+// Returns the targets of the import and extend directives of the template, in the order of the source.
+func (p *Parser) Targets() []Target {
+	return p.targets
+}
+
+// Sets the file system targets of import and extend are read from, paths are slash separated then.
+// Default: nil (the file system of the operating system)
+func (p *Parser) SetLoader(fsys fs.FS) {
+	p.loader = fsys
+	return
+}
+
+// Mounts fsys under prefix, targets of import and extend starting with prefix, i.e. "ui/button" for "ui", are read
+// from fsys without it. Templates read from fsys resolve their own imports within fsys, or other mounts.
+func (p *Parser) Mount(prefix string, fsys fs.FS) {
+	if p.mounts == nil {
+		p.mounts = make(map[string]fs.FS)
+	}
+
+	p.mounts[strings.Trim(prefix, "/")] = fsys
+	return
+}
+
+// mount returns the file system mounted under the prefix of filename and the name of filename within it.
+func (p *Parser) mount(filename string) (fs.FS, string) {
+	for prefix, fsys := range p.mounts {
+		if name := strings.TrimPrefix(filename, prefix+"/"); name != filename {
+			return fsys, name
+		}
+	}
+
+	return nil, ""
+}
+
+// Sets the limits of the template and those it imports or extends, zero fields keep DefaultLimits.
+func (p *Parser) SetLimits(limits Limits) {
+	if limits.MaxDepth == 0 {
+		limits.MaxDepth = DefaultLimits.MaxDepth
+	}
+
+	if limits.MaxFileSize == 0 {
+		limits.MaxFileSize = DefaultLimits.MaxFileSize
+	}
+
+	if limits.MaxImportDepth == 0 {
+		limits.MaxImportDepth = DefaultLimits.MaxImportDepth
+	}
+
+	if limits.MaxLineLength == 0 {
+		limits.MaxLineLength = DefaultLimits.MaxLineLength
+	}
+
+	p.limits = limits
+	p.scanner.limits = limits
+	return
+}
+
+// Sets the indent style enforced on the template and those it imports or extends, one of the Indent constants.
+func (p *Parser) SetIndentStyle(style int) {
+	p.scanner.indentStyle = style
 	return
 }
 
+// Sets the policy on mixed indentation of the template and those it imports or extends, one of the
+// MixedIndent constants, and the width of tab stops when normalizing. A width of zero keeps DefaultTabWidth.
+func (p *Parser) SetMixedIndentation(policy, tabWidth int) {
+	if tabWidth <= 0 {
+		tabWidth = DefaultTabWidth
+	}
+
+	p.scanner.mixedIndentation = policy
+	p.scanner.tabWidth = tabWidth
+	return
+}
+
+// Sets the separator joining the lines of text spanning several lines, i.e. " " to flow the lines of a
+// paragraph into one. An empty separator keeps the default of a newline.
+func (p *Parser) SetTextSeparator(separator string) {
+	if separator == "" {
+		separator = "\n"
+	}
+
+	p.scanner.textSeparator = separator
+	return
+}
+
+// Sets if extending a template fails on named blocks none of the parent templates defines,
+// or on parent templates defining no named blocks at all.
+func (p *Parser) SetStrict(strict bool) {
+	p.strict = strict
+	return
+}
+
+// Returns the warnings raised while parsing the template and those it imports or extends.
+func (p *Parser) Warnings() []Warning {
+	warnings := make([]Warning, 0)
+
+	for _, warning := range p.scanner.warnings {
+		warning.Filename = p.filename
+		warnings = append(warnings, warning)
+	}
+
+	// a reparsed template keeps the warnings of each of its segments
+	for _, seg := range p.segments {
+		warnings = append(warnings, seg.warnings...)
+	}
+
+	warnings = append(warnings, p.warnings...)
+
+	for _, imported := range p.imports {
+		warnings = append(warnings, imported.Warnings()...)
+	}
+
+	if p.parent != nil {
+		warnings = append(warnings, p.parent.Warnings()...)
+	}
+
+	return warnings
+}
+
 func (p *Parser) newFileParser(filename string) *Parser {
-	if len(p.filepath) == 0 {
+	if fsys, name := p.mount(filename); fsys != nil {
+		return p.newLibraryParser(fsys, name)
+	}
+
+	if len(p.filepath) == 0 && len(p.includePaths) == 0 {
 		panic("Unable to import/extend " + filename + " with empty filepath.")
 	}
 
-	filename = filepath.Join(p.filepath, filename)
+	if p.depth >= p.limits.MaxImportDepth {
+		panic(fmt.Sprintf("Unable to import/extend %s, imports exceed the limit of %d levels.", filename, p.limits.MaxImportDepth))
+	}
 
-	if !strings.HasSuffix(strings.ToLower(filename), p.fileextension) {
-		filename += p.fileextension
+	var (
+		parser *Parser
+		err    error
+	)
+
+	filename = p.locate(filename)
+	if p.loader != nil {
+		parser, err = NewFSParser(p.loader, filename)
+	} else {
+		parser, err = NewFileParser(filename)
 	}
 
-	parser, err := NewFileParser(filename)
 	if err != nil {
 		panic("Failed to import/extend " + filename + " with error " + err.Error())
 	}
 
+	// nested imports and extends are resolved the same way
+	parser.filepath = p.filepath
+	parser.includePaths = p.includePaths
+	p.inherit(parser)
+
 	return parser
 }
 
+// newLibraryParser returns the parser of the template name of the component library fsys, whose nested imports
+// and extends resolve within the library.
+func (p *Parser) newLibraryParser(fsys fs.FS, name string) *Parser {
+	if p.depth >= p.limits.MaxImportDepth {
+		panic(fmt.Sprintf("Unable to import/extend %s, imports exceed the limit of %d levels.", name, p.limits.MaxImportDepth))
+	}
+
+	name = ResolveFS(fsys, name, p.fileextensions...)
+
+	parser, err := NewFSParser(fsys, name)
+	if err != nil {
+		panic("Failed to import/extend " + name + " with error " + err.Error())
+	}
+
+	parser.filepath = "."
+	p.inherit(parser)
+
+	return parser
+}
+
+// inherit applies the settings of p to the parser of a template it imports or extends.
+func (p *Parser) inherit(parser *Parser) {
+	parser.fileextensions = p.fileextensions
+	parser.mounts = p.mounts
+	parser.depth = p.depth + 1
+	parser.SetLimits(p.limits)
+	parser.SetIndentStyle(p.scanner.indentStyle)
+	parser.SetMixedIndentation(p.scanner.mixedIndentation, p.scanner.tabWidth)
+	parser.SetTextSeparator(p.scanner.textSeparator)
+	parser.SetStrict(p.strict)
+}
+
+// locate returns the path the target of an import or extend resolves to, within the loader if one is set.
+func (p *Parser) locate(filename string) string {
+	if p.loader != nil {
+		return p.search(filename, path.Join, func(name string) string {
+			return ResolveFS(p.loader, name, p.fileextensions...)
+		}, func(name string) bool {
+			_, err := fs.Stat(p.loader, name)
+			return err == nil
+		})
+	}
+
+	return p.search(filename, filepath.Join, func(name string) string {
+		return Resolve(name, p.fileextensions...)
+	}, func(name string) bool {
+		_, err := os.Stat(name)
+		return err == nil
+	})
+}
+
+// addTarget records the target of an import or extend directive at pos.
+func (p *Parser) addTarget(pos SourcePosition, directive, name string) {
+	target := Target{SourcePosition: pos, Directive: directive, Name: name}
+
+	if fsys, _ := p.mount(name); fsys == nil && (len(p.filepath) > 0 || len(p.includePaths) > 0) {
+		target.Filename = p.locate(name)
+	}
+
+	p.targets = append(p.targets, target)
+}
+
+// search returns the resolved target of an import or extend within the path or else the first of the include paths
+// holding it. Targets found nowhere resolve within the path, failing to be read there.
+func (p *Parser) search(filename string, join func(...string) string, resolve func(string) string, exists func(string) bool) string {
+	dirs := make([]string, 0, len(p.includePaths)+1)
+	if len(p.filepath) > 0 {
+		dirs = append(dirs, p.filepath)
+	}
+
+	dirs = append(dirs, p.includePaths...)
+
+	for _, dir := range dirs {
+		if name := resolve(join(dir, filename)); exists(name) {
+			return name
+		}
+	}
+
+	return resolve(join(dirs[0], filename))
+}
+
+// isPlainHTML reports whether the target of an import is an HTML file rather than a template, that is
+// it has an extension of HTML and no template resolves from it.
+func (p *Parser) isPlainHTML(filename string) bool {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".html", ".htm":
+	default:
+		return false
+	}
+
+	if len(p.filepath) == 0 {
+		return false
+	}
+
+	var err error
+	if p.loader != nil {
+		_, err = fs.Stat(p.loader, ResolveFS(p.loader, path.Join(p.filepath, filename), p.fileextensions...))
+	} else {
+		_, err = os.Stat(Resolve(filepath.Join(p.filepath, filename), p.fileextensions...))
+	}
+
+	return err != nil
+}
+
+// readFile returns the content of a file included as is, relative to the path of imports.
+func (p *Parser) readFile(filename string) string {
+	if p.shallow {
+		return ""
+	}
+
+	if len(p.filepath) == 0 {
+		panic("Unable to include " + filename + " with empty filepath.")
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+
+	if p.loader != nil {
+		data, err = fs.ReadFile(p.loader, path.Join(p.filepath, filename))
+	} else {
+		data, err = os.ReadFile(filepath.Join(p.filepath, filename))
+	}
+
+	if err != nil {
+		panic("Failed to include " + filename + " with error " + err.Error())
+	}
+
+	if len(data) > p.limits.MaxFileSize {
+		panic(fmt.Sprintf("Unable to include %s, it exceeds the limit of %d bytes.", filename, p.limits.MaxFileSize))
+	}
+
+	return string(data)
+}
+
+// Returns filename if it ends with one of the extensions, otherwise filename with the first of the extensions
+// naming an existing file. If there is none, the first extension is appended.
+func Resolve(filename string, extensions ...string) string {
+	return resolve(filename, extensions, func(name string) bool {
+		_, err := os.Stat(name)
+		return err == nil
+	})
+}
+
+// Same as Resolve but looks files up within fsys.
+func ResolveFS(fsys fs.FS, name string, extensions ...string) string {
+	return resolve(name, extensions, func(name string) bool {
+		_, err := fs.Stat(fsys, name)
+		return err == nil
+	})
+}
+
+func resolve(filename string, extensions []string, exists func(string) bool) string {
+	if len(extensions) == 0 {
+		return filename
+	}
+
+	for _, extension := range extensions {
+		if strings.HasSuffix(strings.ToLower(filename), extension) {
+			return filename
+		}
+	}
+
+	for _, extension := range extensions {
+		if exists(filename + extension) {
+			return filename + extension
+		}
+	}
+
+	return filename + extensions[0]
+}
+
 func (p *Parser) Parse() *Block {
 	if p.result != nil {
 		return p.result
@@ -79,17 +537,7 @@ func (p *Parser) Parse() *Block {
 
 	defer func() {
 		if r := recover(); r != nil {
-			if rs, ok := r.(string); ok && rs[:len("Slim Error")] == "Slim Error" {
-				panic(r)
-			}
-
-			pos := p.pos()
-
-			if len(pos.Filename) > 0 {
-				panic(fmt.Sprintf("Slim Error in <%s>: %v - Line: %d, Column: %d, Length: %d", pos.Filename, r, pos.Line, pos.Column, pos.TokenLength))
-			} else {
-				panic(fmt.Sprintf("Slim Error: %v - Line: %d, Column: %d, Length: %d", r, pos.Line, pos.Column, pos.TokenLength))
-			}
+			panic(NewError(r, p.pos()))
 		}
 	}()
 
@@ -97,6 +545,12 @@ func (p *Parser) Parse() *Block {
 
 	p.scanToken()
 
+	if p.token != nil && p.token.Kind == tokPragma {
+		p.options = parsePragma(p.expectToken(tokPragma).Value)
+	}
+
+	block.Options = p.options
+
 	for {
 		if p.token == nil || p.token.Kind == tokEOF {
 			break
@@ -114,42 +568,254 @@ func (p *Parser) Parse() *Block {
 	if p.parent != nil {
 		p.parent.Parse()
 
-		for _, prev := range p.parent.namedBlocks {
+		if p.strict {
+			p.checkBlocks()
+		}
+
+		p.checkUnmatched()
+
+		for _, prev := range p.parent.outputBlocks() {
 			ours := p.namedBlocks[prev.Name]
 
 			if ours == nil {
 				continue
 			}
 
+			// the content of our blocks keeps the options of our pragma within the parent template
+			children := ours.Children
+			if p.options != nil {
+				wrapper := newBlock()
+				wrapper.Children = children
+				wrapper.Options = p.options
+				children = []Noder{wrapper}
+			}
+
 			switch ours.Modifier {
 			case NamedBlockAppend:
-				for i := 0; i < len(ours.Children); i++ {
-					prev.push(ours.Children[i])
+				for i := 0; i < len(children); i++ {
+					prev.push(children[i])
 				}
+
+				prev.Attributes = append(prev.Attributes, ours.Attributes...)
 			case NamedBlockPrepend:
-				for i := len(ours.Children) - 1; i >= 0; i-- {
-					prev.unshift(ours.Children[i])
+				for i := len(children) - 1; i >= 0; i-- {
+					prev.unshift(children[i])
 				}
+
+				prev.Attributes = append(append([]Attribute(nil), ours.Attributes...), prev.Attributes...)
 			default:
-				prev.Children = ours.Children
+				prev.Children = children
+				prev.Attributes = ours.Attributes
 			}
 		}
 
 		block = p.parent.result
 	}
 
+	if !p.extended {
+		p.placeAttributes()
+	}
+
 	p.result = block
 	return block
 }
 
+// placeAttributes adds the attributes of the named blocks output by the template to the tags enclosing them.
+func (p *Parser) placeAttributes() {
+	for _, block := range sortedBlocks(p.outputBlocks()) {
+		if len(block.Attributes) == 0 {
+			continue
+		}
+
+		tag, ok := block.Parent.(*Tag)
+		if !ok {
+			panic(NewError("Attributes of block "+block.Name+" must be placed immediately within a parent tag.", block.Attributes[0].SourcePosition))
+		}
+
+		tag.Attributes = append(tag.Attributes, block.Attributes...)
+	}
+}
+
+// checkBlocks fails on named blocks which none of the parent templates defines and on parent templates
+// defining no named blocks at all, both are most likely typos.
+func (p *Parser) checkBlocks() {
+	if !p.parent.definesBlocks() {
+		panic(NewError("Template "+p.parent.filename+" defines no blocks to override.", p.extendPos))
+	}
+
+	names := make([]string, 0, len(p.namedBlocks))
+	for name := range p.namedBlocks {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !p.parent.definesBlock(name) {
+			panic(NewError("Block "+name+" is not defined by any parent template.", p.namedBlocks[name].SourcePosition))
+		}
+	}
+}
+
+// checkUnmatched warns on named blocks matching no block output by the parent templates, their content is dropped
+// and the parents render their defaults instead. A parent block of a similar name is pointed out as the likely intent.
+func (p *Parser) checkUnmatched() {
+	output := p.parent.outputBlocks()
+
+	for _, block := range sortedBlocks(p.namedBlocks) {
+		if output[block.Name] != nil || block.Parent != nil {
+			continue
+		}
+
+		message := fmt.Sprintf("Block %s matches no block of %s and is dropped.", block.Name, p.parent.filename)
+
+		for _, candidate := range sortedBlocks(output) {
+			if similarNames(block.Name, candidate.Name) {
+				message += fmt.Sprintf(" Did you mean block %s at %s:%d:%d?", candidate.Name, candidate.Filename, candidate.Line, candidate.Column)
+				break
+			}
+		}
+
+		p.warnings = append(p.warnings, Warning{SourcePosition: block.SourcePosition, Message: message})
+	}
+}
+
+// similarNames reports whether a and b differ by case or by at most two edits, as typos do.
+func similarNames(a, b string) bool {
+	if strings.EqualFold(a, b) {
+		return true
+	}
+
+	// distances of the prefixes of a to the growing prefixes of b
+	prev := make([]int, len(a)+1)
+	for i := range prev {
+		prev[i] = i
+	}
+
+	for j := 1; j <= len(b); j++ {
+		next := make([]int, len(a)+1)
+		next[0] = j
+
+		for i := 1; i <= len(a); i++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			next[i] = prev[i-1] + cost
+			if prev[i]+1 < next[i] {
+				next[i] = prev[i] + 1
+			}
+			if next[i-1]+1 < next[i] {
+				next[i] = next[i-1] + 1
+			}
+		}
+
+		prev = next
+	}
+
+	return prev[len(a)] <= 2
+}
+
+// Returns the named blocks of the template, ordered by position. Those of an extending template fill
+// the blocks of the same name of its parent templates.
+func (p *Parser) NamedBlocks() []*NamedBlock {
+	return sortedBlocks(p.namedBlocks)
+}
+
+// Returns the named blocks the templates extended by this one define, nearest parent first.
+func (p *Parser) InheritedBlocks() []*NamedBlock {
+	blocks := make([]*NamedBlock, 0)
+
+	for parent := p.parent; parent != nil; parent = parent.parent {
+		for _, block := range sortedBlocks(parent.namedBlocks) {
+			if block.Modifier == NamedBlockDefault {
+				blocks = append(blocks, block)
+			}
+		}
+	}
+
+	return blocks
+}
+
+func sortedBlocks(named map[string]*NamedBlock) []*NamedBlock {
+	blocks := make([]*NamedBlock, 0, len(named))
+	for _, block := range named {
+		blocks = append(blocks, block)
+	}
+
+	sort.Slice(blocks, func(i, j int) bool {
+		if blocks[i].Line != blocks[j].Line {
+			return blocks[i].Line < blocks[j].Line
+		}
+
+		return blocks[i].Column < blocks[j].Column
+	})
+
+	return blocks
+}
+
+// outputBlocks returns the named blocks output by the template, by name. Those of an extending template are the
+// blocks of its parent templates already filled by it, so templates extending it in turn override any of them.
+func (p *Parser) outputBlocks() map[string]*NamedBlock {
+	blocks := make(map[string]*NamedBlock)
+
+	if p.parent != nil {
+		for name, block := range p.parent.outputBlocks() {
+			blocks[name] = block
+		}
+	}
+
+	// blocks nested within the content of overriding blocks are output as well
+	for name, block := range p.namedBlocks {
+		if blocks[name] == nil && block.Modifier == NamedBlockDefault {
+			blocks[name] = block
+		}
+	}
+
+	return blocks
+}
+
+func (p *Parser) definesBlock(name string) bool {
+	return p.namedBlocks[name] != nil || p.parent != nil && p.parent.definesBlock(name)
+}
+
+func (p *Parser) definesBlocks() bool {
+	return len(p.namedBlocks) > 0 || p.parent != nil && p.parent.definesBlocks()
+}
+
+// parsePragma splits the options of a pragma like `pretty=false, format=xhtml` into names and values.
+func parsePragma(value string) map[string]string {
+	options := make(map[string]string)
+
+	for _, option := range strings.Split(value, ",") {
+		option = strings.TrimSpace(option)
+		if len(option) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(option, "=", 2)
+		if len(parts) != 2 {
+			panic("Invalid pragma option `" + option + "`, expected name=value.")
+		}
+
+		options[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return options
+}
+
 func (p *Parser) pos() SourcePosition {
 	pos := p.scanner.Pos()
 	pos.Filename = p.filename
 	return pos
 }
 
+// scanToken reads the next token and records where it starts, nodes take their position
+// from the token they are made of rather than from the scanner, which has moved on by then.
 func (p *Parser) scanToken() {
 	p.token = p.scanner.Next()
+	p.tokenPos = p.pos()
 }
 
 func (p *Parser) parseToken() Noder {
@@ -176,6 +842,32 @@ func (p *Parser) parseToken() Noder {
 		return p.parseImport()
 	case tokExtend:
 		return p.parseExtend()
+	case tokWhile:
+		return p.parseWhile()
+	case tokLet:
+		return p.parseLet()
+	case tokCache:
+		return p.parseCache()
+	case tokContentFor:
+		return p.parseContentFor()
+	case tokDefine:
+		return p.parseDefine()
+	case tokEmbed:
+		return p.parseEmbed()
+	case tokYield:
+		return p.parseYield()
+	case tokFilter:
+		return p.parseFilter()
+	case tokPassthrough:
+		return p.parsePassthrough()
+	case tokMixin:
+		return p.parseMixin()
+	case tokMixinCall:
+		return p.parseMixinCall()
+	case tokSlot:
+		return p.parseSlot()
+	case tokIncludeRaw:
+		return p.parseIncludeRaw()
 	}
 
 	panic(fmt.Sprintf("Unexpected token: %d", p.token.Kind))
@@ -194,10 +886,17 @@ func (p *Parser) expectToken(kind rune) *token {
 }
 
 func (p *Parser) parseBlock(parent Noder) *Block {
+	pos := p.tokenPos
 	p.expectToken(tokIndent)
 
+	defer func(enclosing Noder) {
+		p.enclosing = enclosing
+	}(p.enclosing)
+
+	p.enclosing = parent
+
 	block := newBlock()
-	block.SourcePosition = p.pos()
+	block.SourcePosition = pos
 
 	for {
 		if p.token == nil || p.token.Kind == tokEOF || p.token.Kind == tokOutdent {
@@ -209,24 +908,45 @@ func (p *Parser) parseBlock(parent Noder) *Block {
 			continue
 		}
 
+		if p.token.Kind == tokForward {
+			tag, ok := parent.(*Tag)
+			if !ok {
+				panic("&attributes must be placed immediately within a parent tag.")
+			}
+
+			p.expectToken(tokForward)
+			tag.ForwardAttributes = true
+			continue
+		}
+
 		if p.token.Kind == tokId ||
 			p.token.Kind == tokClass ||
 			p.token.Kind == tokAttribute {
-			tag, ok := parent.(*Tag)
-			if !ok {
+			// attributes heading the content of a named block are placed once the block is resolved
+			var attributes *[]Attribute
+
+			switch parent := parent.(type) {
+			case *Tag:
+				attributes = &parent.Attributes
+			case *NamedBlock:
+				attributes = &parent.Attributes
+			case *MixinCall:
+				attributes = &parent.Attributes
+			default:
 				panic("Conditional attributes must be placed immediately within a parent tag.")
 			}
 
+			pos := p.tokenPos
 			attr := p.expectToken(p.token.Kind)
 			cond := attr.Data["Condition"]
 
 			switch attr.Kind {
 			case tokId:
-				tag.Attributes = append(tag.Attributes, Attribute{p.pos(), "id", attr.Value, cond, true})
+				*attributes = append(*attributes, Attribute{pos, "id", attr.Value, cond, true})
 			case tokClass:
-				tag.Attributes = append(tag.Attributes, Attribute{p.pos(), "class", attr.Value, cond, true})
+				*attributes = append(*attributes, Attribute{pos, "class", attr.Value, cond, true})
 			case tokAttribute:
-				tag.Attributes = append(tag.Attributes, Attribute{p.pos(), attr.Value, attr.Data["Content"], cond, attr.Data["Mode"] == rawText})
+				*attributes = append(*attributes, Attribute{pos, attr.Value, attr.Data["Content"], cond, attr.Data["Mode"] == rawText})
 			}
 
 			continue
@@ -241,25 +961,37 @@ func (p *Parser) parseBlock(parent Noder) *Block {
 }
 
 func (p *Parser) parseDoctype() *Doctype {
+	pos := p.tokenPos
 	tok := p.expectToken(tokDoctype)
 
 	node := newDoctype(tok.Value, "xhtml")
-	node.SourcePosition = p.pos()
+	node.SourcePosition = pos
 	return node
 }
 
 func (p *Parser) parseComment() *Comment {
+	pos := p.tokenPos
 	tok := p.expectToken(tokComment)
 
 	node := newComment(tok.Value)
-	node.SourcePosition = p.pos()
+	node.SourcePosition = pos
 	switch tok.Data["Mode"] {
 	case "code":
 		node.Silent = true
 	case "html":
 		node.Wrapper = NewCommentWrapper()
+		node.Keep = tok.Data["Keep"] == "true"
 	case "condition":
-		node.Wrapper = NewConditionWrapper(tok.Data["Condition"])
+		if tok.Data["Revealed"] == "true" {
+			node.Wrapper = NewRevealedConditionWrapper(tok.Data["Condition"])
+		} else {
+			node.Wrapper = NewConditionWrapper(tok.Data["Condition"])
+		}
+	}
+
+	// lines nested below a silent comment are left out with it
+	if node.Silent && p.token.Kind == tokText && p.token.Data["Continuation"] == "true" {
+		p.expectToken(tokText)
 	}
 
 	if p.token.Kind == tokIndent {
@@ -270,10 +1002,11 @@ func (p *Parser) parseComment() *Comment {
 }
 
 func (p *Parser) parseTag() *Tag {
+	pos := p.tokenPos
 	tok := p.expectToken(tokTag)
 
 	tag := newTag(tok.Value)
-	tag.SourcePosition = p.pos()
+	tag.SourcePosition = pos
 
 	ensureBlock := func() {
 		if tag.Block == nil {
@@ -297,30 +1030,29 @@ readmore:
 			}
 		}
 	case tokId:
+		pos := p.tokenPos
 		id := p.expectToken(tokId)
-		if len(id.Data["Condition"]) > 0 {
-			panic("Conditional attributes(id) must be placed in a block within a tag.")
-		}
 
-		tag.Attributes = append(tag.Attributes, Attribute{p.pos(), "id", id.Value, "", true})
+		tag.Attributes = append(tag.Attributes, Attribute{pos, "id", id.Value, id.Data["Condition"], true})
 
 		goto readmore
 	case tokClass:
+		pos := p.tokenPos
 		klass := p.expectToken(tokClass)
-		if len(klass.Data["Condition"]) > 0 {
-			panic("Conditional attributes(class) must be placed in a block within a tag.")
-		}
 
-		tag.Attributes = append(tag.Attributes, Attribute{p.pos(), "class", klass.Value, "", true})
+		tag.Attributes = append(tag.Attributes, Attribute{pos, "class", klass.Value, klass.Data["Condition"], true})
 
 		goto readmore
 	case tokAttribute:
+		pos := p.tokenPos
 		attr := p.expectToken(tokAttribute)
-		if len(attr.Data["Condition"]) > 0 {
-			panic("Conditional attributes must be placed in a block within a tag.")
-		}
 
-		tag.Attributes = append(tag.Attributes, Attribute{p.pos(), attr.Value, attr.Data["Content"], "", attr.Data["Mode"] == rawText})
+		tag.Attributes = append(tag.Attributes, Attribute{pos, attr.Value, attr.Data["Content"], attr.Data["Condition"], attr.Data["Mode"] == rawText})
+
+		goto readmore
+	case tokForward:
+		p.expectToken(tokForward)
+		tag.ForwardAttributes = true
 
 		goto readmore
 	case tokText:
@@ -337,26 +1069,39 @@ readmore:
 }
 
 func (p *Parser) parseText() *Text {
+	pos := p.tokenPos
 	tok := p.expectToken(tokText)
 
 	node := newText(tok.Value, tok.Data["Mode"] == rawText)
-	node.SourcePosition = p.pos()
+	node.SourcePosition = pos
+
+	// lines nested below the text line continue it
+	if p.token.Kind == tokText && p.token.Data["Continuation"] == "true" {
+		if node.Value != "" {
+			node.Value += p.scanner.textSeparator
+		}
+
+		node.Value += p.expectToken(tokText).Value
+	}
+
 	return node
 }
 
 func (p *Parser) parseAssignment() *Assignment {
+	pos := p.tokenPos
 	tok := p.expectToken(tokAssignment)
 
-	node := newAssignment(tok.Data["Variable"], tok.Value)
-	node.SourcePosition = p.pos()
+	node := newAssignment(tok.Data["Variable"], tok.Value, tok.Data["Declare"] == "true")
+	node.SourcePosition = pos
 	return node
 }
 
 func (p *Parser) parseCondition() *Condition {
+	pos := p.tokenPos
 	tok := p.expectToken(tokIf)
 
 	node := newCondition(tok.Value)
-	node.SourcePosition = p.pos()
+	node.SourcePosition = pos
 
 readmore:
 	switch p.token.Kind {
@@ -393,10 +1138,39 @@ readmore:
 }
 
 func (p *Parser) parseRange() *Range {
+	pos := p.tokenPos
 	tok := p.expectToken(tokRange)
 
 	node := newRange(tok.Data["Key"], tok.Data["Value"], tok.Value)
-	node.SourcePosition = p.pos()
+	node.SourcePosition = pos
+
+	if p.token.Kind == tokIndent {
+		node.Block = p.parseBlock(node)
+	}
+
+	return node
+}
+
+func (p *Parser) parseLet() *Let {
+	pos := p.tokenPos
+	tok := p.expectToken(tokLet)
+
+	node := newLet(tok.Data["Variable"], tok.Value)
+	node.SourcePosition = pos
+
+	if p.token.Kind == tokIndent {
+		node.Block = p.parseBlock(node)
+	}
+
+	return node
+}
+
+func (p *Parser) parseWhile() *While {
+	pos := p.tokenPos
+	tok := p.expectToken(tokWhile)
+
+	node := newWhile(tok.Value, tok.Data["Until"] == "true")
+	node.SourcePosition = pos
 
 	if p.token.Kind == tokIndent {
 		node.Block = p.parseBlock(node)
@@ -405,7 +1179,216 @@ func (p *Parser) parseRange() *Range {
 	return node
 }
 
+func (p *Parser) parseCache() *Cache {
+	pos := p.tokenPos
+	tok := p.expectToken(tokCache)
+
+	var ttl time.Duration
+	if value := tok.Data["TTL"]; len(value) > 0 {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			ttl = time.Duration(seconds) * time.Second
+		} else if ttl, err = time.ParseDuration(value); err != nil {
+			panic("Invalid cache lifetime " + value + ".")
+		}
+	}
+
+	node := newCache(tok.Value, ttl)
+	node.SourcePosition = pos
+
+	if p.token.Kind == tokIndent {
+		node.Block = p.parseBlock(node)
+	}
+
+	return node
+}
+
+func (p *Parser) parseContentFor() *ContentFor {
+	pos := p.tokenPos
+	tok := p.expectToken(tokContentFor)
+
+	node := newContentFor(tok.Value)
+	node.SourcePosition = pos
+
+	if p.token.Kind == tokIndent {
+		node.Block = p.parseBlock(node)
+	}
+
+	return node
+}
+
+func (p *Parser) parseDefine() *Define {
+	pos := p.tokenPos
+	tok := p.expectToken(tokDefine)
+
+	node := newDefine(tok.Value)
+	node.SourcePosition = pos
+
+	if p.token.Kind == tokIndent {
+		node.Block = p.parseBlock(node)
+	}
+
+	return node
+}
+
+func (p *Parser) parseMixin() *Mixin {
+	pos := p.tokenPos
+	tok := p.expectToken(tokMixin)
+
+	var (
+		parameters, defaults []string
+		rest                 string
+	)
+
+	if list := strings.TrimSpace(tok.Data["Parameters"]); len(list) > 0 {
+		for _, parameter := range splitList(list) {
+			if rest != "" {
+				panic(fmt.Sprintf("Rest parameter `%s` of mixin %s must be the last one.", rest, tok.Value))
+			}
+
+			matches := rparameter.FindStringSubmatch(parameter)
+			if matches == nil {
+				panic(fmt.Sprintf("Invalid parameter `%s` of mixin %s.", parameter, tok.Value))
+			}
+
+			if matches[1] != "" {
+				if matches[3] != "" {
+					panic(fmt.Sprintf("Rest parameter `%s` of mixin %s cannot have a default value.", matches[2], tok.Value))
+				}
+
+				rest = matches[2]
+				continue
+			}
+
+			parameters = append(parameters, matches[2])
+			defaults = append(defaults, strings.TrimSpace(matches[3]))
+		}
+	}
+
+	node := newMixin(tok.Value, parameters, defaults, rest)
+	node.SourcePosition = pos
+
+	if p.token.Kind == tokIndent {
+		p.mixins++
+		node.Block = p.parseBlock(node)
+		p.mixins--
+	}
+
+	return node
+}
+
+// splitList splits a list separated by commas outside of string literals and brackets, i.e. the parameters of
+// a mixin.
+func splitList(list string) []string {
+	var items []string
+
+	start, depth := 0, 0
+	for i := 0; i < len(list); i++ {
+		switch list[i] {
+		case '"', '\'', '`':
+			if end := closingQuote(list, i); end > 0 {
+				i = end
+			}
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, strings.TrimSpace(list[start:i]))
+				start = i + 1
+			}
+		}
+	}
+
+	return append(items, strings.TrimSpace(list[start:]))
+}
+
+func (p *Parser) parseMixinCall() *MixinCall {
+	pos := p.tokenPos
+	tok := p.expectToken(tokMixinCall)
+
+	node := newMixinCall(tok.Value, tok.Data["Arguments"])
+	node.SourcePosition = pos
+
+	for p.token.Kind == tokId || p.token.Kind == tokClass || p.token.Kind == tokAttribute {
+		pos := p.tokenPos
+		attr := p.expectToken(p.token.Kind)
+
+		switch attr.Kind {
+		case tokId:
+			node.Attributes = append(node.Attributes, Attribute{pos, "id", attr.Value, attr.Data["Condition"], true})
+		case tokClass:
+			node.Attributes = append(node.Attributes, Attribute{pos, "class", attr.Value, attr.Data["Condition"], true})
+		case tokAttribute:
+			node.Attributes = append(node.Attributes, Attribute{pos, attr.Value, attr.Data["Content"], attr.Data["Condition"], attr.Data["Mode"] == rawText})
+		}
+	}
+
+	if p.token.Kind == tokIndent {
+		p.mixins++
+		node.Block = p.parseBlock(node)
+		p.mixins--
+	}
+
+	return node
+}
+
+// parseSlot parses a slot of a mixin or a mixin call. Elsewhere it is the slot element of web components.
+func (p *Parser) parseSlot() Noder {
+	pos := p.tokenPos
+	tok := p.expectToken(tokSlot)
+
+	if p.mixins == 0 {
+		tag := newTag("slot")
+		tag.SourcePosition = pos
+
+		if len(tok.Value) > 0 {
+			tag.Block = newBlock()
+			tag.Block.push(newText(tok.Value, true))
+		}
+
+		if p.token.Kind == tokIndent {
+			block := p.parseBlock(tag)
+			if tag.Block == nil {
+				tag.Block = block
+			} else {
+				tag.Block.Children = append(tag.Block.Children, block.Children...)
+			}
+		}
+
+		return tag
+	}
+
+	node := newSlot(tok.Value)
+	node.SourcePosition = pos
+
+	if p.token.Kind == tokIndent {
+		node.Block = p.parseBlock(node)
+	}
+
+	return node
+}
+
+func (p *Parser) parseEmbed() *Embed {
+	pos := p.tokenPos
+	tok := p.expectToken(tokEmbed)
+
+	node := newEmbed(tok.Value, tok.Data["Expression"])
+	node.SourcePosition = pos
+	return node
+}
+
+func (p *Parser) parseYield() *Yield {
+	pos := p.tokenPos
+	tok := p.expectToken(tokYield)
+
+	node := newYield(tok.Value)
+	node.SourcePosition = pos
+	return node
+}
+
 func (p *Parser) parseNamedBlock() *Block {
+	pos := p.tokenPos
 	tok := p.expectToken(tokNamedBlock)
 
 	if p.namedBlocks[tok.Value] != nil {
@@ -413,7 +1396,8 @@ func (p *Parser) parseNamedBlock() *Block {
 	}
 
 	block := newNamedBlock(tok.Value)
-	block.SourcePosition = p.pos()
+	block.SourcePosition = pos
+	block.Parent = p.enclosing
 
 	if tok.Data["Modifier"] == "append" {
 		block.Modifier = NamedBlockAppend
@@ -422,12 +1406,13 @@ func (p *Parser) parseNamedBlock() *Block {
 	}
 
 	if p.token.Kind == tokIndent {
-		block.Block = *(p.parseBlock(nil))
+		block.Block = *(p.parseBlock(block))
+		block.SourcePosition = pos
 	}
 
 	p.namedBlocks[block.Name] = block
 
-	if block.Modifier == NamedBlockDefault {
+	if block.Modifier == NamedBlockDefault || p.shallow {
 		return &block.Block
 	}
 
@@ -435,10 +1420,72 @@ func (p *Parser) parseNamedBlock() *Block {
 }
 
 func (p *Parser) parseImport() *Block {
+	pos := p.tokenPos
 	tok := p.expectToken(tokImport)
 
-	node := p.newFileParser(tok.Value).Parse()
-	node.SourcePosition = p.pos()
+	p.addTarget(pos, "import", tok.Value)
+	if p.shallow {
+		node := newBlock()
+		node.SourcePosition = pos
+		return node
+	}
+
+	// HTML partials of sites migrating to slim are output as is
+	if p.isPlainHTML(tok.Value) {
+		file := newRawFile(tok.Value, p.readFile(tok.Value), false)
+		file.SourcePosition = pos
+
+		node := newBlock()
+		node.SourcePosition = pos
+		node.push(file)
+		return node
+	}
+
+	parser := p.newFileParser(tok.Value)
+	p.imports = append(p.imports, parser)
+
+	node := parser.Parse()
+	node.SourcePosition = pos
+	return node
+}
+
+func (p *Parser) parseFilter() *Filter {
+	pos := p.tokenPos
+	tok := p.expectToken(tokFilter)
+
+	node := newFilter(tok.Value, "")
+	node.SourcePosition = pos
+
+	if filename := tok.Data["File"]; len(filename) > 0 {
+		node.Content = p.readFile(filename)
+		node.Filename = filename
+	} else if p.token.Kind == tokText && p.token.Data["Continuation"] == "true" {
+		node.Content = p.expectToken(tokText).Value
+	}
+
+	return node
+}
+
+func (p *Parser) parsePassthrough() *Passthrough {
+	pos := p.tokenPos
+	tok := p.expectToken(tokPassthrough)
+
+	node := newPassthrough(tok.Value)
+	node.SourcePosition = pos
+
+	if len(tok.Value) == 0 && p.token.Kind == tokText && p.token.Data["Continuation"] == "true" {
+		node.Value = p.expectToken(tokText).Value
+	}
+
+	return node
+}
+
+func (p *Parser) parseIncludeRaw() *RawFile {
+	pos := p.tokenPos
+	tok := p.expectToken(tokIncludeRaw)
+
+	node := newRawFile(tok.Value, p.readFile(tok.Value), tok.Data["Unescaped"] == "true")
+	node.SourcePosition = pos
 	return node
 }
 
@@ -447,9 +1494,16 @@ func (p *Parser) parseExtend() *Block {
 		panic("Unable to extend multiple parent templates.")
 	}
 
+	p.extendPos = p.tokenPos
 	tok := p.expectToken(tokExtend)
 
+	p.addTarget(p.extendPos, "extend", tok.Value)
+	if p.shallow {
+		return newBlock()
+	}
+
 	parser := p.newFileParser(tok.Value)
+	parser.extended = true
 	parser.Parse()
 	p.parent = parser
 	return newBlock()
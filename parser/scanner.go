@@ -2,12 +2,15 @@ package parser
 
 import (
 	"bufio"
+	"bytes"
 	"container/list"
 	"fmt"
 	"io"
 	"regexp"
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 const (
@@ -30,6 +33,21 @@ const (
 	tokNamedBlock
 	tokImport
 	tokExtend
+	tokCache
+	tokContentFor
+	tokYield
+	tokWhile
+	tokLet
+	tokPragma
+	tokFilter
+	tokIncludeRaw
+	tokDefine
+	tokEmbed
+	tokPassthrough
+	tokMixin
+	tokMixinCall
+	tokSlot
+	tokForward
 )
 
 const (
@@ -47,20 +65,50 @@ const (
 var (
 	rindent     = regexp.MustCompile(`^([ \t]*)`)
 	rdoctype    = regexp.MustCompile(`\A(?i:!|doctype)\s+?(.*)\z`)
+	rpragma     = regexp.MustCompile(`^/!\s*slim:\s*(.*)$`)
+	rkeep       = regexp.MustCompile(`^keep(\s|$)`)
 	rcomment    = regexp.MustCompile(`\A(?i:\/\s*?\[\s*?if\s+?(.+)\s*?\](.*)?|\/(!)?(\s*)(.*)?)\z`)
-	rtext       = regexp.MustCompile(`^(\||')[ \t]+?(.*)$`)
+	rtext       = regexp.MustCompile(`^(\||')(?:[ \t]+?(.*))?$`)
 	rtag        = regexp.MustCompile(`^(\w[-:\w]*)`)
 	rid         = regexp.MustCompile(`^#([\w-]+)(?:\s*\?\s*(.*)$)?`)
 	rclass      = regexp.MustCompile(`^\.([\w-]+)(?:\s*\?\s*(.*)$)?`)
-	rattribute  = regexp.MustCompile(`^\[([\w\-]+)\s*(?:=\s*(\"([^\"\\]*)\"|([^\]]+)))?\](?:\s*\?\s*(.*)$)?`)
-	rassignment = regexp.MustCompile(`^(\$[\w0-9\-_]*)?\s*=\s*(.+)$`)
+	rattribute  = regexp.MustCompile(`^\[([@:]?[\w\-:.]+)\s*`)
+	rassignment = regexp.MustCompile(`^(\$[\w0-9\-_]*(?:\s*,\s*\$[\w0-9\-_]*)*)?\s*(:?=)\s*(.+)$`)
 	rif         = regexp.MustCompile(`^if\s*(.+)$`)
 	relsif      = regexp.MustCompile(`^elsif\s*(.+)$`)
 	relse       = regexp.MustCompile(`^else\s*`)
+	rvariables  = regexp.MustCompile(`\s*,\s*`)
+	rlet        = regexp.MustCompile(`^let\s+(\$[\w0-9\-_]*(?:\s*,\s*\$[\w0-9\-_]*)*)\s*:?=\s*(.+)$`)
+	rwhile      = regexp.MustCompile(`^(while|until)\s+(.+)$`)
 	rrange      = regexp.MustCompile(`^each\s+(\$[\w0-9\-_]*)(?:\s*,\s*(\$[\w0-9\-_]*))?\s+in\s+(.+)$`)
 	rblock      = regexp.MustCompile(`^block\s+(?:(append|prepend)\s+)?([0-9a-zA-Z_\-\. \/]*)$`)
 	rimport     = regexp.MustCompile(`^import\s+([0-9a-zA-Z_\-\. \/]*)$`)
 	rextend     = regexp.MustCompile(`^extend\s+([0-9a-zA-Z_\-\. \/]*)$`)
+	rcontentfor = regexp.MustCompile(`^(?:content_for|provide)\s+([\w\-]+)$`)
+	ryield      = regexp.MustCompile(`^yield\s+([\w\-]+)$`)
+	rdefine     = regexp.MustCompile(`^define\s+([\w\-\.\/]+)$`)
+	rembed      = regexp.MustCompile(`^embed\s+"([^"]+)"(?:\s+(.+?))?\s*$`)
+	rfilter     = regexp.MustCompile(`^:([\w\-]+)$`)
+	rmarkdown   = regexp.MustCompile(`^markdown\s+(\S+)$`)
+	rincluderaw = regexp.MustCompile(`^include_raw\s+(?:(unescaped)\s+)?(\S+)$`)
+	rcache      = regexp.MustCompile(`^cache\s+(.+?)(?:\s+((?:\d+(?:ns|us|µs|ms|s|m|h))+|\d+))?$`)
+	// go template code written into the generated template as is, nested below go: or following =
+	rpassthrough     = regexp.MustCompile(`^go:$`)
+	rpassthroughline = regexp.MustCompile(`^=(\{\{.*)$`)
+	// mixins of components, their calls and the slots filled by calls
+	rmixin     = regexp.MustCompile(`^mixin\s+([\w\-\/]+)(?:\s*\((.*)\))?\s*$`)
+	rmixincall = regexp.MustCompile(`^\+([\w\-\/]+)`)
+	rforward   = regexp.MustCompile(`^&attributes\b`)
+	rslot      = regexp.MustCompile(`^slot(?:\s+([\w\-]+))?\s*$`)
+	rparameter = regexp.MustCompile(`^(\.\.\.)?(\$\w+)(?:\s*=\s*(.+))?$`)
+	// attributes of front end frameworks (Vue, Alpine) holding JavaScript rather than slim expressions
+	rdirective = regexp.MustCompile(`^(?:[@:]|v-|x-)`)
+	// attribute values taken as text rather than expressions
+	rquoted        = regexp.MustCompile(`^(?:"([^"\\]+)"|'([^'\\]+)')$`)
+	rbareword      = regexp.MustCompile(`^[a-z][\w\-]*$`)
+	rattrcondition = regexp.MustCompile(`^\s*\?\s*(.*)$`)
+	// silent comment trailing the tag, id, class and attributes of a line
+	rtrailingcomment = regexp.MustCompile(`^\s+/(?:\s.*)?$`)
 )
 
 type token struct {
@@ -77,15 +125,41 @@ type scanner struct {
 
 	buffer string
 	line   int
-	column int
-	state  int32
-
-	lastTokenLine   int
-	lastTokenColumn int
-	lastTokenSize   int
+	// leading whitespace of the current line
+	lineIndent string
+	// column in runes and in bytes
+	column     int
+	byteColumn int
+	state      int32
+
+	lastTokenLine          int
+	lastTokenColumn        int
+	lastTokenSize          int
+	lastTokenEndLine       int
+	lastTokenEndColumn     int
+	lastTokenByteColumn    int
+	lastTokenByteEndColumn int
 
 	readRaw     bool
 	readRawMode string
+	// raw text continues a text line, its lines are joined by the text separator
+	readRawJoined bool
+	// joins the lines of text spanning several lines
+	textSeparator string
+	// the lines continuing the current one were joined to it already
+	continued bool
+
+	limits Limits
+	// indent style enforced, and the kind of indentation found first for IndentConsistent
+	indentStyle int
+	indentKind  int
+	// mixed indentation policy, tab stops of normalizing and the kind of indentation found first
+	mixedIndentation int
+	tabWidth         int
+	indentKindSeen   int
+	warnings         []Warning
+	// bytes read so far
+	size int
 }
 
 func newScanner(r io.Reader) *scanner {
@@ -97,6 +171,9 @@ func newScanner(r io.Reader) *scanner {
 	s.column = 0
 	s.state = scnNewLine
 	s.readRawMode = rawText
+	s.textSeparator = "\n"
+	s.limits = DefaultLimits
+	s.tabWidth = DefaultTabWidth
 
 	return s
 }
@@ -107,11 +184,22 @@ func (s *scanner) Pos() SourcePosition {
 		Column:      s.lastTokenColumn + 1,
 		Filename:    "",
 		TokenLength: s.lastTokenSize,
+		EndLine:     s.lastTokenEndLine + 1,
+		EndColumn:   s.lastTokenEndColumn + 1,
+
+		ByteColumn:    s.lastTokenByteColumn + 1,
+		ByteEndColumn: s.lastTokenByteEndColumn + 1,
 	}
 }
 
 func (s *scanner) Next() *token {
 	if s.state == scnEOF {
+		// raw text may run up to the end of input, close its enclosing blocks
+		if outdent := s.indents.Back(); outdent != nil {
+			s.indents.Remove(outdent)
+			return &token{tokOutdent, "", nil}
+		}
+
 		return &token{tokEOF, "", nil}
 	}
 
@@ -144,10 +232,20 @@ func (s *scanner) Next() *token {
 
 		return s.Next()
 	case scnLine:
+		s.scanContinuation()
+
+		if s.scanTrailingComment() {
+			return s.Next()
+		}
+
 		if tok := s.scanDoctype(); tok != nil {
 			return tok
 		}
 
+		if tok := s.scanPragma(); tok != nil {
+			return tok
+		}
+
 		if tok := s.scanComment(); tok != nil {
 			return tok
 		}
@@ -164,10 +262,30 @@ func (s *scanner) Next() *token {
 			return tok
 		}
 
+		if tok := s.scanWhile(); tok != nil {
+			return tok
+		}
+
+		if tok := s.scanLet(); tok != nil {
+			return tok
+		}
+
 		if tok := s.scanImport(); tok != nil {
 			return tok
 		}
 
+		if tok := s.scanFilter(); tok != nil {
+			return tok
+		}
+
+		if tok := s.scanPassthrough(); tok != nil {
+			return tok
+		}
+
+		if tok := s.scanIncludeRaw(); tok != nil {
+			return tok
+		}
+
 		if tok := s.scanExtend(); tok != nil {
 			return tok
 		}
@@ -176,6 +294,26 @@ func (s *scanner) Next() *token {
 			return tok
 		}
 
+		if tok := s.scanCache(); tok != nil {
+			return tok
+		}
+
+		if tok := s.scanContentFor(); tok != nil {
+			return tok
+		}
+
+		if tok := s.scanDefine(); tok != nil {
+			return tok
+		}
+
+		if tok := s.scanEmbed(); tok != nil {
+			return tok
+		}
+
+		if tok := s.scanMixin(); tok != nil {
+			return tok
+		}
+
 		if tok := s.scanAssignment(); tok != nil {
 			return tok
 		}
@@ -195,6 +333,10 @@ func (s *scanner) Next() *token {
 		if tok := s.scanAttribute(); tok != nil {
 			return tok
 		}
+
+		if tok := s.scanForward(); tok != nil {
+			return tok
+		}
 	}
 
 	return nil
@@ -205,53 +347,101 @@ func (s *scanner) scanRaw() *token {
 		return &token{tokEOF, "", nil}
 	}
 
-	result := ""
-	level := 0
+	joined := s.readRawJoined
+	s.readRawJoined = false
+
+	// lines nested below a text line continue it, joined by the text separator
+	separator := "\n"
+	if joined {
+		separator = s.textSeparator
+	}
+
+	// the lines indented deeper than the enclosing block belong to the text, the block of a raw text tag
+	// starts on the current line and its indentation is the last one, otherwise the text continues the
+	// line of a text, comment or filter
+	current := len(s.buffer) > 0
+
+	enclosing := ""
+	for e := s.indents.Front(); e != nil; e = e.Next() {
+		if current && e.Next() == nil {
+			break
+		}
+
+		enclosing += e.Value.(*regexp.Regexp).String()
+	}
+
+	var (
+		lines  []string
+		blanks int
+		// indentation of the first line, deeper lines keep their whitespace beyond it as written
+		base                                    string
+		startLine, startColumn, startByteColumn = -1, 0, 0
+	)
 
 	for {
 		s.readline()
 
-		switch s.state {
-		case scnEOF:
-			return &token{tokText, result, map[string]string{"Mode": s.readRawMode}}
-		case scnNewLine:
-			s.state = scnLine
+		if s.state == scnEOF {
+			break
+		}
 
-			if tok := s.scanIndent(); tok != nil {
-				switch tok.Kind {
-				case tokIndent:
-					level++
-				case tokOutdent:
-					level--
-					if level < 0 {
-						s.stash.PushBack(&token{tokOutdent, "", nil})
-					} else {
-						s.stash.Remove(s.stash.Front())
-					}
-
-					return s.Next()
-				case tokBlank:
-					result = result + "\n"
-
-					continue
-				}
-			}
-		case scnLine:
-			if len(result) > 0 {
-				result = result + "\n"
+		if s.state == scnNewLine {
+			if len(s.buffer) == 0 {
+				blanks++
+
+				continue
 			}
 
-			for i := 0; i < level; i++ {
-				result += "\t"
+			// the line is left to close the enclosing blocks
+			if len(s.lineIndent) <= len(enclosing) || !strings.HasPrefix(s.lineIndent, enclosing) {
+				break
 			}
 
-			result = result + s.buffer
+			s.state = scnLine
+			s.consume(len(s.lineIndent))
+		}
+
+		if startLine < 0 {
+			startLine, startColumn, startByteColumn = s.line, s.column, s.byteColumn
+			base = s.lineIndent
+			blanks = 0
+		}
+
+		// blank lines within the text are kept, those ending it are not
+		for ; blanks > 0; blanks-- {
+			if separator == "\n" {
+				lines = append(lines, "")
+			}
+		}
 
-			s.consume(len(s.buffer))
+		indent := ""
+		if separator == "\n" && strings.HasPrefix(s.lineIndent, base) {
+			indent = s.lineIndent[len(base):]
 		}
+
+		lines = append(lines, indent+s.buffer)
+
+		s.consume(len(s.buffer))
 	}
 
-	return nil
+	if startLine < 0 {
+		return s.Next()
+	}
+
+	result := strings.Join(lines, separator)
+
+	// the token starts at its first line and ends with the last one
+	s.lastTokenLine = startLine
+	s.lastTokenColumn = startColumn
+	s.lastTokenByteColumn = startByteColumn
+	s.lastTokenSize = utf8.RuneCountInString(result)
+
+	data := map[string]string{"Mode": s.readRawMode}
+	if !current {
+		data["Continuation"] = "true"
+	}
+
+	return &token{tokText, result, data}
 }
 
 func (s *scanner) scanIndent() *token {
@@ -274,6 +464,12 @@ func (s *scanner) scanIndent() *token {
 	newIndent := rindent.FindString(s.buffer)
 
 	if len(newIndent) != 0 && head == nil {
+		if s.indents.Len() >= s.limits.MaxDepth {
+			panic(fmt.Sprintf("Nesting exceeds the limit of %d levels.", s.limits.MaxDepth))
+		}
+
+		s.checkIndentStyle(newIndent)
+
 		s.indents.PushBack(regexp.MustCompile(regexp.QuoteMeta(newIndent)))
 
 		s.consume(len(newIndent))
@@ -297,12 +493,78 @@ func (s *scanner) scanIndent() *token {
 	}
 
 	if len(newIndent) != 0 && head != nil {
-		panic("Mismatching indentation. Please use a coherent indent schema.")
+		// indents of tabs and spaces are their own pattern
+		expected := head.Value.(*regexp.Regexp).String()
+
+		s.consume(len(newIndent))
+
+		panic(fmt.Sprintf("Mismatching indentation, expected %s or less at this level but found %s. Please use a coherent indent schema.",
+			describeIndent(expected), describeIndent(newIndent)))
 	}
 
 	return nil
 }
 
+// checkIndentStyle enforces the indent style on the indentation of a new level.
+func (s *scanner) checkIndentStyle(indent string) {
+	tabs := strings.Count(indent, "\t")
+
+	style := s.indentStyle
+	if style == IndentConsistent {
+		if s.indentKind == IndentAny {
+			switch {
+			case tabs == len(indent):
+				s.indentKind = IndentTabs
+			case tabs == 0:
+				s.indentKind = IndentSpaces
+			default:
+				s.consume(len(indent))
+				panic(fmt.Sprintf("Indentation must use either tabs or spaces, found %s.", describeIndent(indent)))
+			}
+		}
+
+		style = s.indentKind
+	}
+
+	switch {
+	case style == IndentTabs && tabs != len(indent):
+		s.consume(len(indent))
+		panic(fmt.Sprintf("Indentation must use tabs only, found %s.", describeIndent(indent)))
+	case style == IndentSpaces && tabs != 0:
+		s.consume(len(indent))
+		panic(fmt.Sprintf("Indentation must use spaces only, found %s.", describeIndent(indent)))
+	}
+}
+
+// describeIndent spells out an indent string, i.e. `"\t  " (1 tab, 2 spaces)`.
+func describeIndent(indent string) string {
+	tabs := strings.Count(indent, "\t")
+	spaces := len(indent) - tabs
+
+	parts := make([]string, 0, 2)
+	if tabs > 0 {
+		parts = append(parts, plural(tabs, "tab"))
+	}
+
+	if spaces > 0 {
+		parts = append(parts, plural(spaces, "space"))
+	}
+
+	if len(parts) == 0 {
+		return "no indentation"
+	}
+
+	return strconv.Quote(indent) + " (" + strings.Join(parts, ", ") + ")"
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+
+	return strconv.Itoa(n) + " " + noun + "s"
+}
+
 func (s *scanner) scanDoctype() *token {
 	if matches := rdoctype.FindStringSubmatch(s.buffer); len(matches) != 0 {
 		if len(matches[1]) == 0 {
@@ -317,29 +579,54 @@ func (s *scanner) scanDoctype() *token {
 	return nil
 }
 
+// scanPragma reads the options of a pragma comment, which is only recognized on the first line of a file.
+func (s *scanner) scanPragma() *token {
+	if s.line != 0 || s.column != 0 {
+		return nil
+	}
+
+	if matches := rpragma.FindStringSubmatch(s.buffer); len(matches) != 0 {
+		s.consume(len(matches[0]))
+		return &token{tokPragma, matches[1], nil}
+	}
+
+	return nil
+}
+
 func (s *scanner) scanComment() *token {
 	if matches := rcomment.FindStringSubmatch(s.buffer); len(matches) == 6 {
 		var (
 			mode      string
-			condition = matches[1]
+			condition = strings.TrimSpace(matches[1])
 			content   = matches[5]
+			revealed  string
+			keep      string
 		)
 
-		// ie condition comment
-		if content == "" {
-			s.readRaw = true
-
+		// ie condition comment, nested lines are markup shown to the browsers matching the condition.
+		// A trailing > reveals it to all other browsers as well, i.e. /[if !IE]>
+		if condition != "" {
 			mode = "condition"
-			content = matches[2]
+			content = strings.TrimSpace(matches[2])
+
+			if strings.HasPrefix(content, ">") {
+				revealed = "true"
+				content = strings.TrimSpace(content[1:])
+			}
 		} else {
-			// we expect comment has at least one whitespace
-			if matches[4] == "" {
+			// html comments marked by /!keep survive stripping comments
+			if matches[3] == "!" && matches[4] == "" && rkeep.MatchString(content) {
+				keep = "true"
+				content = strings.TrimSpace(content[len("keep"):])
+			} else if matches[4] == "" && content != "" {
+				// we expect comment has at least one whitespace before its text
 				return nil
 			}
 
 			switch matches[3] {
 			case "":
 				s.readRaw = true
+				s.readRawJoined = true
 
 				mode = "code"
 			case "!":
@@ -349,15 +636,27 @@ func (s *scanner) scanComment() *token {
 
 		s.consume(len(matches[0]))
 
-		return &token{tokComment, content, map[string]string{"Mode": mode, "Condition": condition}}
+		return &token{tokComment, content, map[string]string{"Mode": mode, "Condition": condition, "Revealed": revealed, "Keep": keep}}
 	}
 
 	return nil
 }
 
+// scanTrailingComment drops the silent comment ending a tag line, i.e. div.card  / product card
+func (s *scanner) scanTrailingComment() bool {
+	if s.column == 0 || !rtrailingcomment.MatchString(s.buffer) {
+		return false
+	}
+
+	s.consume(len(s.buffer))
+
+	return true
+}
+
 func (s *scanner) scanText() *token {
 	if matches := rtext.FindStringSubmatch(s.buffer); len(matches) != 0 {
 		s.readRaw = true
+		s.readRawJoined = true
 
 		s.consume(len(matches[0]))
 
@@ -407,10 +706,28 @@ func (s *scanner) scanRange() *token {
 	return nil
 }
 
+func (s *scanner) scanWhile() *token {
+	if matches := rwhile.FindStringSubmatch(s.buffer); len(matches) != 0 {
+		s.consume(len(matches[0]))
+		return &token{tokWhile, matches[2], map[string]string{"Until": strconv.FormatBool(matches[1] == "until")}}
+	}
+
+	return nil
+}
+
+func (s *scanner) scanLet() *token {
+	if matches := rlet.FindStringSubmatch(s.buffer); len(matches) != 0 {
+		s.consume(len(matches[0]))
+		return &token{tokLet, matches[2], map[string]string{"Variable": matches[1]}}
+	}
+
+	return nil
+}
+
 func (s *scanner) scanAssignment() *token {
 	if matches := rassignment.FindStringSubmatch(s.buffer); len(matches) != 0 {
 		s.consume(len(matches[0]))
-		return &token{tokAssignment, matches[2], map[string]string{"Variable": matches[1]}}
+		return &token{tokAssignment, matches[3], map[string]string{"Variable": matches[1], "Declare": strconv.FormatBool(matches[2] == ":=")}}
 	}
 
 	return nil
@@ -435,17 +752,149 @@ func (s *scanner) scanClass() *token {
 }
 
 func (s *scanner) scanAttribute() *token {
-	if matches := rattribute.FindStringSubmatch(s.buffer); len(matches) != 0 {
-		s.consume(len(matches[0]))
+	m, ok := matchAttribute(s.buffer)
+	if !ok {
+		return nil
+	}
+
+	name := s.buffer[m.name[0]:m.name[1]]
+
+	value := ""
+	if m.operator >= 0 {
+		value = s.buffer[m.value[0]:m.value[1]]
+	}
+
+	condition := ""
+	if matches := rattrcondition.FindStringSubmatch(s.buffer[m.end:]); len(matches) != 0 {
+		condition = matches[1]
+		m.end += len(matches[0])
+	}
+
+	s.consume(m.end)
+
+	mode := rawText
+	switch matches := rquoted.FindStringSubmatch(value); {
+	case len(matches) != 0:
+		value = matches[1] + matches[2]
+	case m.operator < 0, rbareword.MatchString(value), rdirective.MatchString(name):
+	default:
+		mode = "expression"
+	}
+
+	return &token{tokAttribute, name, map[string]string{"Content": value, "Mode": mode, "Condition": condition}}
+}
+
+// attributeMatch holds the byte offsets of the parts of an attribute.
+type attributeMatch struct {
+	// end of the closing bracket
+	end  int
+	name [2]int
+	// offset of the equals sign, -1 if the attribute has no value
+	operator int
+	value    [2]int
+}
+
+// matchAttribute matches "[name]" or "[name=value]" at the start of input. The value ends at the first
+// closing bracket outside of string literals and nested brackets, so it can hold any expression.
+func matchAttribute(input string) (m attributeMatch, ok bool) {
+	matches := rattribute.FindStringSubmatchIndex(input)
+	if matches == nil {
+		return m, false
+	}
+
+	m.name = [2]int{matches[2], matches[3]}
+	m.operator = -1
+
+	i := matches[1]
+	if i < len(input) && input[i] == ']' {
+		m.end = i + 1
+		return m, true
+	}
+
+	if i >= len(input) || input[i] != '=' {
+		return m, false
+	}
 
-		if len(matches[3]) != 0 || matches[2] == "" {
-			return &token{tokAttribute, matches[1], map[string]string{"Content": matches[3], "Mode": rawText, "Condition": matches[5]}}
+	m.operator = i
+	for i++; i < len(input) && (input[i] == ' ' || input[i] == '\t'); i++ {
+	}
+
+	start, depth := i, 0
+	for ; i < len(input); i++ {
+		switch input[i] {
+		case '"', '\'', '`':
+			end := closingQuote(input, i)
+			if end < 0 {
+				return m, false
+			}
+
+			i = end
+		case '(', '[', '{':
+			depth++
+		case ')', '}':
+			depth--
+		case ']':
+			if depth > 0 {
+				depth--
+				continue
+			}
+
+			value := strings.TrimRight(input[start:i], " \t")
+			if len(value) == 0 {
+				return m, false
+			}
+
+			m.value = [2]int{start, start + len(value)}
+			m.end = i + 1
+			return m, true
 		}
+	}
+
+	return m, false
+}
+
+// closingParen returns the offset of the parenthesis closing the one at start, skipping string literals and
+// nested parentheses, -1 if there is none.
+func closingParen(input string, start int) int {
+	depth := 0
+
+	for i := start; i < len(input); i++ {
+		switch input[i] {
+		case '"', '\'', '`':
+			end := closingQuote(input, i)
+			if end < 0 {
+				return -1
+			}
 
-		return &token{tokAttribute, matches[1], map[string]string{"Content": matches[4], "Mode": "expression", "Condition": matches[5]}}
+			i = end
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
 	}
 
-	return nil
+	return -1
+}
+
+// closingQuote returns the offset of the quote closing the literal opened at start, or -1 if unterminated.
+func closingQuote(input string, start int) int {
+	quote := input[start]
+
+	for i := start + 1; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case quote:
+			return i
+		}
+	}
+
+	return -1
 }
 
 func (s *scanner) scanImport() *token {
@@ -457,6 +906,50 @@ func (s *scanner) scanImport() *token {
 	return nil
 }
 
+// scanFilter scans a filter with the content nested below it, i.e. :markdown, or the markdown directive
+// converting a file.
+func (s *scanner) scanFilter() *token {
+	if matches := rfilter.FindStringSubmatch(s.buffer); len(matches) != 0 {
+		s.readRaw = true
+
+		s.consume(len(matches[0]))
+		return &token{tokFilter, matches[1], nil}
+	}
+
+	if matches := rmarkdown.FindStringSubmatch(s.buffer); len(matches) != 0 {
+		s.consume(len(matches[0]))
+		return &token{tokFilter, "markdown", map[string]string{"File": matches[1]}}
+	}
+
+	return nil
+}
+
+// scanPassthrough scans go template code written as is, nested below go: or following = on a line of its own.
+func (s *scanner) scanPassthrough() *token {
+	if matches := rpassthrough.FindStringSubmatch(s.buffer); len(matches) != 0 {
+		s.readRaw = true
+
+		s.consume(len(matches[0]))
+		return &token{tokPassthrough, "", nil}
+	}
+
+	if matches := rpassthroughline.FindStringSubmatch(s.buffer); len(matches) != 0 {
+		s.consume(len(matches[0]))
+		return &token{tokPassthrough, matches[1], nil}
+	}
+
+	return nil
+}
+
+func (s *scanner) scanIncludeRaw() *token {
+	if matches := rincluderaw.FindStringSubmatch(s.buffer); len(matches) != 0 {
+		s.consume(len(matches[0]))
+		return &token{tokIncludeRaw, matches[2], map[string]string{"Unescaped": strconv.FormatBool(matches[1] != "")}}
+	}
+
+	return nil
+}
+
 func (s *scanner) scanExtend() *token {
 	if matches := rextend.FindStringSubmatch(s.buffer); len(matches) != 0 {
 		s.consume(len(matches[0]))
@@ -475,6 +968,93 @@ func (s *scanner) scanBlock() *token {
 	return nil
 }
 
+func (s *scanner) scanCache() *token {
+	if matches := rcache.FindStringSubmatch(s.buffer); len(matches) != 0 {
+		s.consume(len(matches[0]))
+		return &token{tokCache, matches[1], map[string]string{"TTL": matches[2]}}
+	}
+
+	return nil
+}
+
+func (s *scanner) scanContentFor() *token {
+	if matches := rcontentfor.FindStringSubmatch(s.buffer); len(matches) != 0 {
+		s.consume(len(matches[0]))
+		return &token{tokContentFor, matches[1], nil}
+	}
+
+	if matches := ryield.FindStringSubmatch(s.buffer); len(matches) != 0 {
+		s.consume(len(matches[0]))
+		return &token{tokYield, matches[1], nil}
+	}
+
+	return nil
+}
+
+func (s *scanner) scanDefine() *token {
+	if matches := rdefine.FindStringSubmatch(s.buffer); len(matches) != 0 {
+		s.consume(len(matches[0]))
+		return &token{tokDefine, matches[1], nil}
+	}
+
+	return nil
+}
+
+func (s *scanner) scanEmbed() *token {
+	if matches := rembed.FindStringSubmatch(s.buffer); len(matches) != 0 {
+		s.consume(len(matches[0]))
+		return &token{tokEmbed, matches[1], map[string]string{"Expression": matches[2]}}
+	}
+
+	return nil
+}
+
+// scanMixin scans the definition of a mixin, i.e. `mixin card($title)`, a call of it, i.e. `+card("News")`,
+// and the slots of either.
+func (s *scanner) scanMixin() *token {
+	if matches := rmixin.FindStringSubmatch(s.buffer); len(matches) != 0 {
+		s.consume(len(matches[0]))
+		return &token{tokMixin, matches[1], map[string]string{"Parameters": matches[2]}}
+	}
+
+	if matches := rmixincall.FindStringSubmatch(s.buffer); len(matches) != 0 {
+		// arguments are followed by the id, classes and attributes the call passes on
+		end, arguments := len(matches[0]), ""
+		if strings.HasPrefix(s.buffer[end:], "(") {
+			closing := closingParen(s.buffer, end)
+			if closing < 0 {
+				return nil
+			}
+
+			end, arguments = closing+1, s.buffer[end+1:closing]
+		}
+
+		if strings.TrimSpace(s.buffer[end:]) == "" {
+			end = len(s.buffer)
+		}
+
+		s.consume(end)
+		return &token{tokMixinCall, matches[1], map[string]string{"Arguments": arguments}}
+	}
+
+	if matches := rslot.FindStringSubmatch(s.buffer); len(matches) != 0 {
+		s.consume(len(matches[0]))
+		return &token{tokSlot, matches[1], nil}
+	}
+
+	return nil
+}
+
+// scanForward scans &attributes, placing the attributes passed to a mixin on a tag of it.
+func (s *scanner) scanForward() *token {
+	if matches := rforward.FindStringSubmatch(s.buffer); len(matches) != 0 {
+		s.consume(len(matches[0]))
+		return &token{tokForward, "", nil}
+	}
+
+	return nil
+}
+
 func (s *scanner) scanTag() *token {
 	if matches := rtag.FindStringSubmatch(s.buffer); len(matches) != 0 {
 		s.consume(len(matches[0]))
@@ -490,7 +1070,7 @@ func (s *scanner) readline() {
 		return
 	}
 
-	buf, err := s.reader.ReadString('\n')
+	buf, err := s.readString()
 	if err != nil {
 		if err != io.EOF {
 			panic(err)
@@ -506,20 +1086,230 @@ func (s *scanner) readline() {
 	}
 
 	s.buffer = strings.TrimRightFunc(buf, unicode.IsSpace)
+	s.continued = false
 	s.line += 1
 	s.column = 0
+	s.byteColumn = 0
+
+	s.checkMixedIndentation()
+	s.lineIndent = rindent.FindString(s.buffer)
 	return
 }
 
-func (s *scanner) consume(runes int) {
-	if len(s.buffer) < runes {
-		panic(fmt.Sprintf("Unable to consume %d runes from buffer `%s`.", runes, s.buffer))
+// scanContinuation joins the physical lines continuing the current one, i.e. following a trailing `\`
+// or a trailing comma inside an open attribute list or expression. The backslash is dropped and the
+// indentation of the next line trimmed, so `/a/\` followed by `b` reads `/a/b`. Text and comments are
+// taken as written.
+func (s *scanner) scanContinuation() {
+	// the rest of a line is not looked at again once its first token is scanned
+	if s.continued {
+		return
+	}
+
+	s.continued = true
+	if !strings.HasSuffix(s.buffer, `\`) && !strings.HasSuffix(s.buffer, ",") {
+		return
+	}
+
+	if rtext.MatchString(s.buffer) || strings.HasPrefix(s.buffer, "/") {
+		return
+	}
+
+	// the line is joined in place and scanned for open brackets as it grows, a long list spread
+	// over many lines stays linear
+	var open brackets
+
+	line := []byte(s.buffer)
+	defer func() {
+		s.buffer = string(line)
+	}()
+
+	for {
+		separator := ""
+		switch {
+		case bytes.HasSuffix(line, []byte(`\`)):
+			line = line[:len(line)-1]
+		case bytes.HasSuffix(line, []byte(",")) && open.unclosed(line):
+			separator = " "
+		default:
+			return
+		}
+
+		buf, err := s.readString()
+		if err != nil && err != io.EOF {
+			panic(err)
+		}
+
+		if len(buf) > 0 {
+			s.line += 1
+		}
+
+		line = append(append(line, separator...), strings.TrimSpace(buf)...)
+
+		if err == io.EOF {
+			return
+		}
+	}
+}
+
+// brackets tracks the brackets, parentheses and braces left open by a line, quoted strings aside.
+// The line may only grow between calls, what was scanned already is not scanned again.
+type brackets struct {
+	depth   int
+	quote   rune
+	scanned int
+}
+
+// unclosed reports whether the line leaves a bracket, a parenthesis or a brace open.
+func (b *brackets) unclosed(line []byte) bool {
+	for i, r := range string(line[b.scanned:]) {
+		i += b.scanned
+
+		switch {
+		case b.quote != 0:
+			if r == b.quote && (i == 0 || line[i-1] != '\\') {
+				b.quote = 0
+			}
+		case r == '"' || r == '\'' || r == '`':
+			b.quote = r
+		case r == '(' || r == '[' || r == '{':
+			b.depth++
+		case r == ')' || r == ']' || r == '}':
+			b.depth--
+		}
+	}
+
+	b.scanned = len(line)
+	return b.depth > 0
+}
+
+// checkMixedIndentation applies the mixed indentation policy to the line just read.
+func (s *scanner) checkMixedIndentation() {
+	indent := rindent.FindString(s.buffer)
+	if len(indent) == 0 || s.mixedIndentation == MixedIndentAllow {
+		return
 	}
 
+	tabs := strings.Count(indent, "\t")
+
+	if s.mixedIndentation == MixedIndentNormalize {
+		if tabs > 0 {
+			s.buffer = expandTabs(indent, s.tabWidth) + s.buffer[len(indent):]
+		}
+
+		return
+	}
+
+	kind := IndentAny
+	switch {
+	case tabs == len(indent):
+		kind = IndentTabs
+	case tabs == 0:
+		kind = IndentSpaces
+	}
+
+	if s.indentKindSeen == IndentAny {
+		s.indentKindSeen = kind
+	}
+
+	if kind != IndentAny && kind == s.indentKindSeen {
+		return
+	}
+
+	message := fmt.Sprintf("Mixed indentation, found %s while the file is indented with %s.", describeIndent(indent), indentKindName(s.indentKindSeen))
+	if s.indentKindSeen == IndentAny {
+		message = fmt.Sprintf("Mixed indentation, found %s.", describeIndent(indent))
+	}
+
+	if s.mixedIndentation == MixedIndentError {
+		s.consume(len(indent))
+		panic(message)
+	}
+
+	runes := utf8.RuneCountInString(indent)
+	s.warnings = append(s.warnings, Warning{
+		SourcePosition: SourcePosition{
+			Line:          s.line + 1,
+			Column:        1,
+			TokenLength:   runes,
+			EndLine:       s.line + 1,
+			EndColumn:     runes + 1,
+			ByteColumn:    1,
+			ByteEndColumn: len(indent) + 1,
+		},
+		Message: message,
+	})
+}
+
+func indentKindName(kind int) string {
+	if kind == IndentTabs {
+		return "tabs"
+	}
+
+	return "spaces"
+}
+
+// expandTabs replaces the tabs of indent with spaces up to the next tab stop.
+func expandTabs(indent string, width int) string {
+	expanded := make([]byte, 0, len(indent)*width)
+
+	for i := 0; i < len(indent); i++ {
+		if indent[i] != '\t' {
+			expanded = append(expanded, indent[i])
+			continue
+		}
+
+		expanded = append(expanded, ' ')
+		for len(expanded)%width != 0 {
+			expanded = append(expanded, ' ')
+		}
+	}
+
+	return string(expanded)
+}
+
+// readString reads up to and including the next newline, enforcing the limits of line length and input size
+// before the line is held in memory as a whole.
+func (s *scanner) readString() (string, error) {
+	var buf []byte
+
+	for {
+		chunk, err := s.reader.ReadSlice('\n')
+		buf = append(buf, chunk...)
+
+		s.size += len(chunk)
+		if s.size > s.limits.MaxFileSize {
+			panic(fmt.Sprintf("Template exceeds the limit of %d bytes.", s.limits.MaxFileSize))
+		}
+
+		if len(bytes.TrimRight(buf, "\r\n")) > s.limits.MaxLineLength {
+			panic(fmt.Sprintf("Line exceeds the limit of %d bytes.", s.limits.MaxLineLength))
+		}
+
+		if err != bufio.ErrBufferFull {
+			return string(buf), err
+		}
+	}
+}
+
+// consume drops size bytes of the current line, positions are counted in runes, so multi-byte
+// characters of localized text take a single column.
+func (s *scanner) consume(size int) {
+	if len(s.buffer) < size {
+		panic(fmt.Sprintf("Unable to consume %d bytes from buffer `%s`.", size, s.buffer))
+	}
+
+	runes := utf8.RuneCountInString(s.buffer[:size])
+
 	s.lastTokenLine = s.line
 	s.lastTokenColumn = s.column
 	s.lastTokenSize = runes
+	s.lastTokenEndLine = s.line
+	s.lastTokenEndColumn = s.column + runes
+	s.lastTokenByteColumn = s.byteColumn
+	s.lastTokenByteEndColumn = s.byteColumn + size
 
-	s.buffer = s.buffer[runes:]
+	s.buffer = s.buffer[size:]
 	s.column += runes
+	s.byteColumn += size
 }
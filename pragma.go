@@ -0,0 +1,83 @@
+package slim
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/golib/slim/parser"
+)
+
+// applyPragma overrides compiler options by the options of a file pragma, i.e. `/! slim: pretty=false, format=xhtml`.
+func (c *Compiler) applyPragma(options map[string]string) {
+	for name, value := range options {
+		switch name {
+		case "pretty":
+			c.Pretty = pragmaBool(name, value)
+		case "minify":
+			c.Minify = pragmaBool(name, value)
+		case "strip_comments":
+			c.StripComments = pragmaBool(name, value)
+		case "line_numbers":
+			c.LineNumbers = pragmaBool(name, value)
+		case "trim_markers":
+			c.TrimMarkers = pragmaBool(name, value)
+		case "omit_empty_attributes":
+			c.OmitEmptyAttributes = pragmaBool(name, value)
+		case "boolean_attributes":
+			c.BooleanAttributes = pragmaBool(name, value)
+		case "passthrough_actions":
+			c.PassthroughActions = pragmaBool(name, value)
+		case "format":
+			if value != parser.FORMAT_HTML && value != parser.FORMAT_XHTML && value != parser.FORMAT_TEXT {
+				panic(fmt.Sprintf("Invalid value `%s` of pragma option %s, expected html, xhtml or text.", value, name))
+			}
+
+			c.Format = value
+		case "attribute_quote":
+			if value != `"` && value != "'" {
+				panic(fmt.Sprintf("Invalid value `%s` of pragma option %s, expected \" or '.", value, name))
+			}
+
+			c.AttributeQuote = value
+		case "max_loop_iterations":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				panic(fmt.Sprintf("Invalid value `%s` of pragma option %s, expected a positive number.", value, name))
+			}
+
+			c.MaxLoopIterations = limit
+		case "text_join":
+			policy, ok := textJoinPolicies[value]
+			if !ok {
+				panic(fmt.Sprintf("Invalid value `%s` of pragma option %s, expected layout, newline, space or none.", value, name))
+			}
+
+			c.TextJoin = policy
+		case "attribute_wrap_column":
+			column, err := strconv.Atoi(value)
+			if err != nil || column < 0 {
+				panic(fmt.Sprintf("Invalid value `%s` of pragma option %s, expected a number.", value, name))
+			}
+
+			c.AttributeWrapColumn = column
+		default:
+			panic(fmt.Sprintf("Unknown pragma option %s.", name))
+		}
+	}
+}
+
+var textJoinPolicies = map[string]int{
+	"layout":  TextJoinLayout,
+	"newline": TextJoinNewline,
+	"space":   TextJoinSpace,
+	"none":    TextJoinNone,
+}
+
+func pragmaBool(name, value string) bool {
+	result, err := strconv.ParseBool(value)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid value `%s` of pragma option %s, expected true or false.", value, name))
+	}
+
+	return result
+}
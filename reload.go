@@ -0,0 +1,142 @@
+package slim
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Path the Middleware serves live reload events at.
+const LiveReloadPath = "/__slim/livereload"
+
+var liveReloadScript = []byte(`<script>new EventSource("` + LiveReloadPath + `").addEventListener("reload", function() { location.reload() })</script>`)
+
+// Polls the files below Root and Roots every interval and drops the compiled templates as soon as any of them
+// changes, so the next render picks up the edits. Pages served with LiveReload reload themselves.
+// Meant for development, calling the returned function stops watching.
+func (r *Renderer) Watch(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	last := r.snapshot()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				current := r.snapshot()
+				if !sameSnapshot(last, current) {
+					last = current
+					r.Reset()
+					r.notifyChange()
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+// Wraps next with the live reload endpoint pages rendered with LiveReload connect to.
+// Changes detected by Watch are pushed to them as server-sent events, other requests are passed on to next.
+func (r *Renderer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != LiveReloadPath {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming unsupported.", http.StatusInternalServerError)
+			return
+		}
+
+		r.mu.RLock()
+		changed := r.changed
+		r.mu.RUnlock()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		select {
+		case <-changed:
+			fmt.Fprint(w, "event: reload\ndata: {}\n\n")
+			flusher.Flush()
+		case <-req.Context().Done():
+		}
+	})
+}
+
+// notifyChange wakes up every connected live reload client.
+func (r *Renderer) notifyChange() {
+	r.mu.Lock()
+	close(r.changed)
+	r.changed = make(chan struct{})
+	r.mu.Unlock()
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// snapshot records size and modification time of every file below Root and Roots.
+func (r *Renderer) snapshot() map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+
+	for _, root := range append([]string{r.Root}, r.Roots...) {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				stamps[path] = fileStamp{info.Size(), info.ModTime()}
+			}
+
+			return nil
+		})
+	}
+
+	return stamps
+}
+
+func sameSnapshot(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for path, stamp := range a {
+		if other, ok := b[path]; !ok || other.size != stamp.size || !other.modTime.Equal(stamp.modTime) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// injectLiveReload adds the live reload script to a rendered page, right before its closing body tag if any.
+func injectLiveReload(buf *bytes.Buffer) {
+	page := buf.Bytes()
+
+	at := bytes.LastIndex(bytes.ToLower(page), []byte("</body>"))
+	if at < 0 {
+		buf.Write(liveReloadScript)
+		return
+	}
+
+	result := make([]byte, 0, len(page)+len(liveReloadScript))
+	result = append(result, page[:at]...)
+	result = append(result, liveReloadScript...)
+	result = append(result, page[at:]...)
+
+	buf.Reset()
+	buf.Write(result)
+}
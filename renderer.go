@@ -0,0 +1,637 @@
+package slim
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golib/slim/parser"
+	"github.com/golib/slim/runtime"
+)
+
+// Renderer compiles the slim templates found below a root directory on first use,
+// caches them and renders them into http responses.
+//
+//	renderer := slim.NewRenderer("./views")
+//	renderer.Development = true
+//
+//	func handler(w http.ResponseWriter, r *http.Request) {
+//		renderer.HTML(w, http.StatusOK, "users/show", user)
+//	}
+//
+// It is safe for concurrent use.
+type Renderer struct {
+	// Compiler options used for every template
+	Options
+	// Directory templates are looked up in, imports and extends are resolved against it as well.
+	Root string
+	// Directories searched in order for templates and the targets of their imports and extends missing in Root,
+	// i.e. those of a theme followed by those of the core. Templates of Root override those of the same name.
+	// Default: nil
+	Roots []string
+	// Extension appended to template names without one, Options.Extensions take precedence if set.
+	// Default: .html.slim
+	Extension string
+	// Setting if development mode is enabled.
+	// In development mode failing renders respond with an error page showing the offending slim source.
+	// Default: false
+	Development bool
+	// Setting if rendered pages reload themselves whenever a template changes.
+	// It requires development mode, Watch and the Middleware to be in place.
+	// Default: false
+	LiveReload bool
+	// Cache storing fragments of cache directives, runtime.DefaultCache if nil
+	Cache runtime.Cache
+	// Capacity in bytes up to which output buffers are kept for reuse, larger ones are left to the garbage collector.
+	// Default: DefaultMaxBufferSize
+	MaxBufferSize int
+	// Setting if pages are executed straight into the response rather than buffered first.
+	// It saves memory on very large pages, but a failing execution leaves the page cut off with the status sent already.
+	// Templates missing or failing to compile still respond with an error.
+	// It has no effect while LiveReload is enabled.
+	// Default: false
+	Streaming bool
+
+	mu        sync.RWMutex
+	templates map[string]*renderTemplate
+	globals   map[string]interface{}
+	changed   chan struct{}
+
+	buffers sync.Pool
+	stats   renderStats
+
+	beforeHooks []BeforeRenderHook
+	afterHooks  []AfterRenderHook
+
+	contextFuncs template.FuncMap
+}
+
+// BeforeRenderHook is called before a template is rendered with the name of the template and its data,
+// i.e. to start a tracing span or to decorate the data.
+type BeforeRenderHook func(name string, data interface{})
+
+// AfterRenderHook is called after a template has been rendered with the name of the template, the time taken
+// and the error of rendering, nil on success. It is called on failures to look up or compile the template as well.
+type AfterRenderHook func(name string, d time.Duration, err error)
+
+const DefaultMaxBufferSize = 1 << 20
+
+// RenderStats are the counters of a Renderer since its creation.
+type RenderStats struct {
+	// Lookups of compiled templates, a miss compiles the template
+	TemplateHits   uint64
+	TemplateMisses uint64
+	// Output buffers reused from the pool or allocated
+	BufferHits   uint64
+	BufferMisses uint64
+	// Responses rendered, failed ones and the time taken by all of them
+	Renders    uint64
+	Errors     uint64
+	RenderTime time.Duration
+}
+
+type renderStats struct {
+	templateHits   atomic.Uint64
+	templateMisses atomic.Uint64
+	bufferHits     atomic.Uint64
+	bufferMisses   atomic.Uint64
+	renders        atomic.Uint64
+	errors         atomic.Uint64
+	renderTime     atomic.Int64
+}
+
+// Create and initialize a new Renderer for templates below root
+func NewRenderer(root string) *Renderer {
+	return &Renderer{
+		Options:   DefaultOptions,
+		Root:      root,
+		Extension: ".html.slim",
+		templates: make(map[string]*renderTemplate),
+		globals:   make(map[string]interface{}),
+		changed:   make(chan struct{}),
+	}
+}
+
+// renderTemplate is a template compiled by the Renderer.
+type renderTemplate struct {
+	// never executed, it is cloned whenever request scoped values or context helpers need to be bound
+	master *template.Template
+	// executed when there are neither request scoped values nor context helpers
+	shared *binding
+	// if context helpers have been registered when compiling the template
+	contextual bool
+	// clones of master bound by previous renders, reused rather than cloning master for every render
+	bindings sync.Pool
+}
+
+// binding is a clone of a master template along with the context and the request scoped values its helpers read.
+// It is used by one render at a time.
+type binding struct {
+	tpl    *template.Template
+	ctx    context.Context
+	values map[string]interface{}
+}
+
+// Registers a hook called before every render of Execute, HTML and Render.
+func (r *Renderer) BeforeRender(hook BeforeRenderHook) {
+	r.mu.Lock()
+	r.beforeHooks = append(r.beforeHooks, hook)
+	r.mu.Unlock()
+}
+
+// Registers a hook called after every render of Execute, HTML and Render.
+func (r *Renderer) AfterRender(hook AfterRenderHook) {
+	r.mu.Lock()
+	r.afterHooks = append(r.afterHooks, hook)
+	r.mu.Unlock()
+}
+
+// hooks calls the before hooks and returns a function calling the after hooks with the error of the render.
+func (r *Renderer) hooks(name string, data interface{}) func(err error) {
+	r.mu.RLock()
+	before, after := r.beforeHooks, r.afterHooks
+	r.mu.RUnlock()
+
+	for _, hook := range before {
+		hook(name, data)
+	}
+
+	start := time.Now()
+
+	return func(err error) {
+		d := time.Since(start)
+
+		for _, hook := range after {
+			hook(name, d, err)
+		}
+	}
+}
+
+// Returns the counters of template lookups, output buffers and renders.
+func (r *Renderer) Stats() RenderStats {
+	return RenderStats{
+		TemplateHits:   r.stats.templateHits.Load(),
+		TemplateMisses: r.stats.templateMisses.Load(),
+		BufferHits:     r.stats.bufferHits.Load(),
+		BufferMisses:   r.stats.bufferMisses.Load(),
+		Renders:        r.stats.renders.Load(),
+		Errors:         r.stats.errors.Load(),
+		RenderTime:     time.Duration(r.stats.renderTime.Load()),
+	}
+}
+
+// Registers a value available to every template through the global helper, i.e. #{global("SiteName")}.
+func (r *Renderer) SetGlobal(name string, value interface{}) {
+	r.mu.Lock()
+	r.globals[name] = value
+	r.mu.Unlock()
+}
+
+// Registers helpers taking the context of the render as their first argument, i.e. a `t` helper translating
+// by the locale attached to the request or a `csrf_field` helper reading its token. Templates call them
+// without it, i.e. #{t("greeting")}. Renders other than RenderContext and Render call them with context.Background().
+// Helpers need to be registered before the templates using them are compiled, it panics if one does not take
+// a context.Context first.
+func (r *Renderer) AddContextFuncs(funcs template.FuncMap) {
+	for name, fn := range funcs {
+		if t := reflect.TypeOf(fn); t == nil || t.Kind() != reflect.Func || t.NumIn() == 0 || t.In(0) != contextType {
+			panic(fmt.Sprintf("Context helper %s does not take a context.Context as its first argument.", name))
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.contextFuncs == nil {
+		r.contextFuncs = make(template.FuncMap)
+	}
+
+	for name, fn := range funcs {
+		r.contextFuncs[name] = fn
+	}
+}
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// contextHelpers returns the helpers of AddContextFuncs with the context *ctx holds at the time of their call
+// bound to their first argument.
+func (r *Renderer) contextHelpers(ctx *context.Context) template.FuncMap {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	helpers := make(template.FuncMap, len(r.contextFuncs))
+
+	for name, fn := range r.contextFuncs {
+		fv := reflect.ValueOf(fn)
+		ft := fv.Type()
+
+		in := make([]reflect.Type, ft.NumIn()-1)
+		for i := range in {
+			in[i] = ft.In(i + 1)
+		}
+
+		out := make([]reflect.Type, ft.NumOut())
+		for i := range out {
+			out[i] = ft.Out(i)
+		}
+
+		helpers[name] = reflect.MakeFunc(reflect.FuncOf(in, out, ft.IsVariadic()), func(args []reflect.Value) []reflect.Value {
+			args = append([]reflect.Value{reflect.ValueOf(ctx).Elem()}, args...)
+			if ft.IsVariadic() {
+				return fv.CallSlice(args)
+			}
+
+			return fv.Call(args)
+		}).Interface()
+	}
+
+	return helpers
+}
+
+// Returns the compiled template of given name, i.e. "users/show", compiling it on first use.
+func (r *Renderer) Template(name string) (*template.Template, error) {
+	tpl, _, err := r.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return tpl.shared.tpl, nil
+}
+
+// Executes the template of given name with data and writes the output into given io.Writer instance.
+// Execution errors are translated into slim source positions, see ExplainExecError.
+func (r *Renderer) Execute(w io.Writer, name string, data interface{}) (err error) {
+	done := r.hooks(name, data)
+	defer func() {
+		done(err)
+	}()
+
+	return r.execute(context.Background(), w, name, data, noopSpan{})
+}
+
+// Renders the template of given name with data as the response with given status code.
+// Output is buffered, so a failing render does not emit half a page. On failure the response is
+// a 500 Internal Server Error, in development mode showing an error page with the offending source.
+// With Streaming enabled, the output is written as it is executed instead.
+func (r *Renderer) HTML(w http.ResponseWriter, status int, name string, data interface{}) error {
+	return r.RenderContext(context.Background(), w, status, name, data)
+}
+
+// Same as HTML, but templates can read the values attached to the context of req by WithValue as well.
+func (r *Renderer) Render(w http.ResponseWriter, req *http.Request, status int, name string, data interface{}) error {
+	return r.RenderContext(req.Context(), w, status, name, data)
+}
+
+// Same as HTML, but templates read the values attached to ctx by WithValue and helpers of AddContextFuncs
+// are called with ctx. Once ctx is done, the render stops with its error.
+func (r *Renderer) RenderContext(ctx context.Context, w http.ResponseWriter, status int, name string, data interface{}) (err error) {
+	done := r.hooks(name, data)
+	start := time.Now()
+
+	_, span := startSpan(ctx, r.TracerProvider, "slim.Render", name)
+	written := &countWriter{w: w}
+
+	defer func() {
+		done(err)
+
+		span.SetAttribute(AttributeBytesWritten, written.n)
+		endSpan(span, err)
+
+		r.stats.renders.Add(1)
+		r.stats.renderTime.Add(int64(time.Since(start)))
+
+		if r.Metrics != nil {
+			r.Metrics.TemplateRendered(name, time.Since(start), err)
+		}
+
+		if err != nil {
+			r.stats.errors.Add(1)
+		}
+	}()
+
+	if r.Streaming && !(r.Development && r.LiveReload) {
+		// missing templates and compile errors are reported before the status is sent
+		run, err := r.prepare(ctx, name, span)
+		if err != nil {
+			r.writeError(w, written, name, err)
+			return err
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+
+		// the status has been sent, a failure of the execution is only reported to the caller
+		return run(written, data)
+	}
+
+	buf := r.buffer()
+	defer r.release(buf)
+
+	if err := r.execute(ctx, buf, name, data, span); err != nil {
+		r.writeError(w, written, name, err)
+		return err
+	}
+
+	if r.Development && r.LiveReload {
+		injectLiveReload(buf)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	_, err = buf.WriteTo(written)
+	return err
+}
+
+// writeError responds with a 500 Internal Server Error, in development mode showing an error page with the offending source.
+func (r *Renderer) writeError(w http.ResponseWriter, body io.Writer, name string, err error) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+
+	if r.Development {
+		writeErrorPage(body, name, err)
+	} else {
+		io.WriteString(body, http.StatusText(http.StatusInternalServerError))
+	}
+}
+
+// contextWriter fails writes into w with the error of ctx once it is done, which stops the execution of a template.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (cw *contextWriter) Write(p []byte) (int, error) {
+	if err := cw.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return cw.w.Write(p)
+}
+
+// countWriter counts the bytes written through it into w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+
+	return n, err
+}
+
+// buffer returns an empty output buffer, reused from the pool if there is one.
+func (r *Renderer) buffer() *bytes.Buffer {
+	if buf, ok := r.buffers.Get().(*bytes.Buffer); ok {
+		r.stats.bufferHits.Add(1)
+		return buf
+	}
+
+	r.stats.bufferMisses.Add(1)
+	return new(bytes.Buffer)
+}
+
+// release returns buf to the pool, unless it has grown beyond MaxBufferSize.
+func (r *Renderer) release(buf *bytes.Buffer) {
+	limit := r.MaxBufferSize
+	if limit <= 0 {
+		limit = DefaultMaxBufferSize
+	}
+
+	if buf.Cap() > limit {
+		return
+	}
+
+	buf.Reset()
+	r.buffers.Put(buf)
+}
+
+func (r *Renderer) execute(ctx context.Context, w io.Writer, name string, data interface{}, span Span) error {
+	run, err := r.prepare(ctx, name, span)
+	if err != nil {
+		return err
+	}
+
+	return run(w, data)
+}
+
+// prepare looks up the template of given name, compiling it on first use, and binds it to the values of ctx.
+// It returns the function executing it, which fails with the errors of the execution only.
+func (r *Renderer) prepare(ctx context.Context, name string, span Span) (func(w io.Writer, data interface{}) error, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	compiled, hit, err := r.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttribute(AttributeCacheHit, hit)
+
+	var b *binding
+	if values := requestValues(ctx); len(values) > 0 || compiled.contextual {
+		if b, err = r.acquire(compiled, ctx, values); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(w io.Writer, data interface{}) error {
+		tpl := compiled.shared.tpl
+		if b != nil {
+			defer compiled.release(b)
+			tpl = b.tpl
+		}
+
+		if ctx.Done() != nil {
+			w = &contextWriter{ctx: ctx, w: w}
+		}
+
+		if err := tpl.Execute(w, data); err != nil {
+			return ExplainExecError(err, compiled.master)
+		}
+
+		return nil
+	}, nil
+}
+
+// lookup returns the compiled template of given name and if it has been compiled already.
+func (r *Renderer) lookup(name string) (*renderTemplate, bool, error) {
+	r.mu.RLock()
+	tpl, ok := r.templates[name]
+	r.mu.RUnlock()
+
+	if r.Metrics != nil {
+		r.Metrics.TemplateLookup(name, ok)
+	}
+
+	if ok {
+		r.stats.templateHits.Add(1)
+		return tpl, true, nil
+	}
+
+	r.stats.templateMisses.Add(1)
+
+	tpl, err := r.compile(name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	r.mu.Lock()
+	r.templates[name] = tpl
+	r.mu.Unlock()
+
+	return tpl, false, nil
+}
+
+// bind clones master and installs the helpers reading what is bound to the returned binding by a render,
+// the request scoped values before the globals of the Renderer and the context the helpers of AddContextFuncs
+// are called with.
+func (r *Renderer) bind(master *template.Template) (*binding, error) {
+	tpl, err := master.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	b := &binding{tpl: tpl, ctx: context.Background()}
+
+	global := func(name string) interface{} {
+		if value, ok := b.values[name]; ok {
+			return value
+		}
+
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		return r.globals[name]
+	}
+
+	tpl.Funcs(runtime.Fragments(tpl, r.Cache)).Funcs(template.FuncMap{"global": global}).Funcs(r.contextHelpers(&b.ctx))
+	return b, nil
+}
+
+// acquire returns a binding of compiled to ctx and values, reusing one of a previous render if there is one.
+func (r *Renderer) acquire(compiled *renderTemplate, ctx context.Context, values map[string]interface{}) (*binding, error) {
+	b, ok := compiled.bindings.Get().(*binding)
+	if !ok {
+		var err error
+		if b, err = r.bind(compiled.master); err != nil {
+			return nil, err
+		}
+	}
+
+	b.ctx, b.values = ctx, values
+	return b, nil
+}
+
+// release returns b to the bindings of t once its render is done, not keeping the context and values of the render.
+func (t *renderTemplate) release(b *binding) {
+	b.ctx, b.values = context.Background(), nil
+	t.bindings.Put(b)
+}
+
+// Forgets every compiled template, they are compiled again on next use.
+func (r *Renderer) Reset() {
+	r.mu.Lock()
+	r.templates = make(map[string]*renderTemplate)
+	r.mu.Unlock()
+}
+
+// filename returns the file of the template of given name within Root or else within the first of Roots holding it.
+// Templates found nowhere resolve within Root, failing to be read there.
+func (r *Renderer) filename(name string) string {
+	for _, root := range append([]string{r.Root}, r.Roots...) {
+		filename := parser.Resolve(filepath.Join(root, filepath.FromSlash(name)), r.extensions()...)
+		if _, err := os.Stat(filename); err == nil {
+			return filename
+		}
+	}
+
+	return parser.Resolve(filepath.Join(r.Root, filepath.FromSlash(name)), r.extensions()...)
+}
+
+// extensions returns the extensions tried in order for template names without one.
+func (r *Renderer) extensions() []string {
+	if len(r.Extensions) > 0 {
+		return r.Extensions
+	}
+
+	return []string{r.Extension}
+}
+
+func (r *Renderer) compile(name string) (tpl *renderTemplate, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = recoverError(rec)
+		}
+	}()
+
+	p, err := parser.NewFileParser(r.filename(name))
+	if err != nil {
+		return nil, err
+	}
+
+	p.SetPath(r.Root)
+	p.SetLimits(r.Limits)
+	p.SetIndentStyle(r.IndentStyle)
+	p.SetMixedIndentation(r.MixedIndentation, r.TabWidth)
+	p.SetTextSeparator(r.TextSeparator)
+	p.SetStrict(r.Strict)
+	p.SetExtensions(r.extensions()...)
+	p.SetIncludePaths(append(append([]string(nil), r.Roots...), r.IncludePaths...)...)
+
+	for prefix, fsys := range r.Libraries {
+		p.Mount(prefix, fsys)
+	}
+
+	c := New()
+	c.Options = r.Options
+	c.Cache = r.Cache
+	c.node = p.Parse()
+	c.filename = r.filename(name)
+	background := context.Background()
+	helpers := r.contextHelpers(&background)
+	c.AddFuncs(helpers)
+
+	master, err := c.CompileWithName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := r.bind(master)
+	if err != nil {
+		return nil, err
+	}
+
+	return &renderTemplate{master: master, shared: shared, contextual: len(helpers) > 0}, nil
+}
+
+type valuesKey struct{}
+
+// Returns a copy of ctx carrying a request scoped value for templates rendered by Renderer.Render.
+// Templates read it with the global helper, it takes precedence over a global of the same name.
+func WithValue(ctx context.Context, name string, value interface{}) context.Context {
+	values := make(map[string]interface{})
+	for key, prev := range requestValues(ctx) {
+		values[key] = prev
+	}
+
+	values[name] = value
+	return context.WithValue(ctx, valuesKey{}, values)
+}
+
+func requestValues(ctx context.Context) map[string]interface{} {
+	values, _ := ctx.Value(valuesKey{}).(map[string]interface{})
+	return values
+}
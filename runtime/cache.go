@@ -0,0 +1,137 @@
+package runtime
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"html/template"
+	"sync"
+	"time"
+)
+
+// Cache stores fragments rendered by cache directives. Implementations must be safe for concurrent use.
+type Cache interface {
+	// Returns the fragment stored under key, if present and not expired.
+	Get(key string) (template.HTML, bool)
+	// Stores a fragment under key, a zero ttl keeps it until evicted.
+	Set(key string, value template.HTML, ttl time.Duration)
+}
+
+// Cache used by templates which have not been given one.
+var DefaultCache Cache = NewLRUCache(1024)
+
+// LRUCache is an in-memory Cache holding a limited number of fragments,
+// evicting the least recently used one when full.
+type LRUCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	value   template.HTML
+	expires time.Time
+}
+
+// Create and initialize a new LRUCache holding up to size fragments
+func NewLRUCache(size int) *LRUCache {
+	return &LRUCache{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *LRUCache) Get(key string) (template.HTML, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+
+	entry := elem.Value.(*lruEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.items, key)
+		return "", false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (c *LRUCache) Set(key string, value template.HTML, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &lruEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+
+	if elem, ok := c.items[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(entry)
+
+	for c.size > 0 && c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+var errNoFragments = errors.New("the template has been created without runtime.New or runtime.Fragments")
+
+// Returns the helpers rendering fragments of tpl, for cache directives, content_for blocks and slots of mixins.
+// Cached fragments are stored into cache, or DefaultCache if nil.
+// They need to be installed before tpl is parsed, see New.
+func Fragments(tpl *template.Template, cache Cache) template.FuncMap {
+	funcs := contentFuncs(tpl)
+	for name, fn := range componentFuncs(tpl) {
+		funcs[name] = fn
+	}
+
+	funcs["__slim_cache"] = func(key interface{}, ttl int64, name string, data interface{}) (template.HTML, error) {
+		store := cache
+		if store == nil {
+			store = DefaultCache
+		}
+
+		id := fmt.Sprint(key)
+		if value, ok := store.Get(id); ok {
+			return value, nil
+		}
+
+		var buf bytes.Buffer
+		if err := tpl.ExecuteTemplate(&buf, name, data); err != nil {
+			return "", err
+		}
+
+		value := template.HTML(buf.String())
+		store.Set(id, value, time.Duration(ttl))
+		return value, nil
+	}
+
+	return funcs
+}
+
+// noFragments stands in for the fragment helper of templates created without New.
+func noFragments(key interface{}, ttl int64, name string, data interface{}) (template.HTML, error) {
+	return "", fmt.Errorf("cache %v: %v", key, errNoFragments)
+}
+
+// Creates a template of given name with every runtime function installed.
+// Fragments rendered by cache directives are stored into cache, or DefaultCache if nil.
+func New(name string, cache Cache) *template.Template {
+	tpl := template.New(name)
+	return tpl.Funcs(FuncMap()).Funcs(Fragments(tpl, cache))
+}
@@ -0,0 +1,247 @@
+package runtime
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// Component is the dot of the template of a mixin, holding the arguments of its call and the slots it fills.
+type Component struct {
+	args []interface{}
+	// templates of the content filling the slots by name, the default slot is named ""
+	slots map[string]string
+	// data of the caller, the content of slots is rendered with
+	data interface{}
+	// attributes passed to the tags marked by &attributes, other than class
+	attributes []attribute
+	classes    []string
+}
+
+type attribute struct {
+	name  string
+	value interface{}
+}
+
+// Creates the Component of a mixin call with the data of the caller, slots maps the names of the slots
+// filled by the call to the templates of their content.
+func NewComponent(data interface{}, slots map[string]string, args ...interface{}) *Component {
+	return &Component{args: args, slots: slots, data: data}
+}
+
+// Returns the argument at index i, nil if the call passes fewer arguments.
+func (c *Component) Arg(i int) interface{} {
+	if i < 0 || i >= len(c.args) {
+		return nil
+	}
+
+	return c.args[i]
+}
+
+// Returns the argument at index i, value if the call passes fewer arguments or nil at i.
+func (c *Component) ArgOr(i int, value interface{}) interface{} {
+	if arg := c.Arg(i); arg != nil {
+		return arg
+	}
+
+	return value
+}
+
+// Returns the arguments from index i on, an empty list if the call passes fewer arguments.
+func (c *Component) Rest(i int) []interface{} {
+	if i >= len(c.args) {
+		return []interface{}{}
+	}
+
+	return c.args[i:]
+}
+
+// Reports whether the call fills the slot of given name, the default slot is named "".
+func (c *Component) HasSlot(name string) bool {
+	_, ok := c.slots[name]
+	return ok
+}
+
+// Returns component passing attributes to the tags of its mixin marked by &attributes, given as pairs of names and
+// values. Values of false or nil leave the attribute out, true gives an attribute without value. Classes add up,
+// other attributes passed more than once take the last value.
+func Forward(component *Component, pairs ...interface{}) *Component {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		name, value := fmt.Sprint(pairs[i]), pairs[i+1]
+		if value == nil || value == false {
+			continue
+		}
+
+		if name == "class" {
+			if class := fmt.Sprint(value); class != "" {
+				component.classes = append(component.classes, class)
+			}
+
+			continue
+		}
+
+		replaced := false
+		for j := range component.attributes {
+			if component.attributes[j].name == name {
+				component.attributes[j].value, replaced = value, true
+			}
+		}
+
+		if !replaced {
+			component.attributes = append(component.attributes, attribute{name, value})
+		}
+	}
+
+	return component
+}
+
+// Reports whether the call passes an attribute of given name, other than class, which takes precedence over
+// the attribute of the same name of a tag.
+func (c *Component) HasAttribute(name string) bool {
+	for _, attr := range c.attributes {
+		if attr.name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the class names passed by the call, separated by spaces.
+func (c *Component) Class() string {
+	return strings.Join(c.classes, " ")
+}
+
+// ForwardedAttribute is an attribute passed by a mixin call, output by the tags of the mixin marked by &attributes.
+type ForwardedAttribute struct {
+	Name  template.HTMLAttr
+	Value interface{}
+	// Setting if the attribute is output without value, i.e. disabled
+	Bare bool
+}
+
+// Names of attributes html/template escapes as URLs, srcsets or CSS. Passed by a mixin call, they are output
+// by name with Attribute, so their values are escaped in their context rather than as plain text.
+var ContextAttributes = []string{
+	"action", "background", "cite", "codebase", "formaction", "href", "icon", "longdesc", "manifest", "poster",
+	"src", "srcset", "style", "usemap",
+}
+
+var rattributename = regexp.MustCompile(`^[a-zA-Z_:@][\w\-:.@]*$`)
+
+// Returns the attributes passed by the call whose values are escaped as plain text, leaving out those of given
+// names and those of ContextAttributes. Other attributes holding URLs or scripts, like data-href or onclick, are
+// left out unless their values are typed as safe, i.e. template.JS.
+func (c *Component) Attributes(except ...string) []ForwardedAttribute {
+	attributes := c.attributes
+	if len(c.classes) > 0 {
+		attributes = append([]attribute{{"class", c.Class()}}, attributes...)
+	}
+
+	forwarded := make([]ForwardedAttribute, 0, len(attributes))
+
+next:
+	for _, attr := range attributes {
+		for _, name := range except {
+			if attr.name == name {
+				continue next
+			}
+		}
+
+		if !rattributename.MatchString(attr.name) || !plainAttribute(attr.name) && !safeContent(attr.value) {
+			continue
+		}
+
+		for _, name := range ContextAttributes {
+			if strings.EqualFold(attr.name, name) {
+				continue next
+			}
+		}
+
+		forwarded = append(forwarded, ForwardedAttribute{template.HTMLAttr(attr.name), attr.value, attr.value == true})
+	}
+
+	return forwarded
+}
+
+// Returns the value of the attribute of given name passed by the call, nil if it passes none.
+func (c *Component) Attribute(name string) interface{} {
+	for _, attr := range c.attributes {
+		if attr.name == name {
+			return attr.value
+		}
+	}
+
+	return nil
+}
+
+// plainAttribute reports whether html/template escapes the value of the attribute name as plain text, following
+// its classification of attributes by name.
+func plainAttribute(name string) bool {
+	name = strings.ToLower(name)
+
+	if strings.HasPrefix(name, "data-") {
+		name = name[len("data-"):]
+	} else if i := strings.IndexByte(name, ':'); i >= 0 {
+		if name[:i] == "xmlns" {
+			return false
+		}
+
+		name = name[i+1:]
+	}
+
+	switch name {
+	case "action", "archive", "background", "cite", "classid", "codebase", "data", "formaction", "href", "icon",
+		"longdesc", "manifest", "poster", "profile", "src", "srcset", "style", "usemap", "xmlns":
+		return false
+	}
+
+	return !strings.HasPrefix(name, "on") && !strings.Contains(name, "src") && !strings.Contains(name, "uri") &&
+		!strings.Contains(name, "url")
+}
+
+// safeContent reports whether x is typed as content safe to output in attributes holding scripts, URLs or CSS.
+func safeContent(x interface{}) bool {
+	switch x.(type) {
+	case template.JS, template.URL, template.CSS:
+		return true
+	}
+
+	return false
+}
+
+// Returns the slots of a mixin call from pairs of slot names and template names.
+func Slots(pairs ...string) map[string]string {
+	slots := make(map[string]string, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		slots[pairs[i]] = pairs[i+1]
+	}
+
+	return slots
+}
+
+// componentFuncs returns the helper rendering the content filling slots of mixins with templates of tpl.
+func componentFuncs(tpl *template.Template) template.FuncMap {
+	return template.FuncMap{
+		"__slim_slot": func(component *Component, name string) (template.HTML, error) {
+			fragment, ok := component.slots[name]
+			if !ok {
+				return "", nil
+			}
+
+			var buf bytes.Buffer
+			if err := tpl.ExecuteTemplate(&buf, fragment, component.data); err != nil {
+				return "", err
+			}
+
+			return template.HTML(buf.String()), nil
+		},
+	}
+}
+
+// noSlot stands in for the slot helper of templates created without New.
+func noSlot(component *Component, name string) (template.HTML, error) {
+	return "", errNoFragments
+}
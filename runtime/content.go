@@ -0,0 +1,105 @@
+package runtime
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+)
+
+// Markers delimiting provided content and yield points within the rendered body of a template.
+// NUL characters of data are replaced while escaping, so data is unable to forge them.
+const (
+	contentStart = "\x00slim:content:"
+	contentEnd   = "\x00slim:end\x00"
+	yieldStart   = "\x00slim:yield:"
+	markerEnd    = "\x00"
+)
+
+// Yield marks the point content provided for name is moved to.
+func Yield(name string) template.HTML {
+	return template.HTML(yieldStart + name + markerEnd)
+}
+
+// ResolveContent moves the content provided by content_for blocks within the rendered output
+// to the yield points of the same name, in order of appearance. Content without a yield point is dropped.
+func ResolveContent(output string) string {
+	if !strings.Contains(output, "\x00slim:") {
+		return output
+	}
+
+	provided := make(map[string]string)
+
+	var rest strings.Builder
+	for {
+		start := strings.Index(output, contentStart)
+		if start < 0 {
+			rest.WriteString(output)
+			break
+		}
+
+		rest.WriteString(output[:start])
+		output = output[start+len(contentStart):]
+
+		nameEnd := strings.Index(output, markerEnd)
+		end := strings.Index(output, contentEnd)
+		if nameEnd < 0 || end < nameEnd {
+			rest.WriteString(output)
+			break
+		}
+
+		name := output[:nameEnd]
+		provided[name] += output[nameEnd+len(markerEnd) : end]
+		output = output[end+len(contentEnd):]
+	}
+
+	var result strings.Builder
+	output = rest.String()
+	for {
+		start := strings.Index(output, yieldStart)
+		if start < 0 {
+			result.WriteString(output)
+			break
+		}
+
+		result.WriteString(output[:start])
+		output = output[start+len(yieldStart):]
+
+		nameEnd := strings.Index(output, markerEnd)
+		if nameEnd < 0 {
+			result.WriteString(output)
+			break
+		}
+
+		result.WriteString(provided[output[:nameEnd]])
+		output = output[nameEnd+len(markerEnd):]
+	}
+
+	return result.String()
+}
+
+// contentFuncs returns the helpers rendering content_for fragments and layouts of tpl.
+func contentFuncs(tpl *template.Template) template.FuncMap {
+	return template.FuncMap{
+		"__slim_content_for": func(name, fragment string, data interface{}) (template.HTML, error) {
+			var buf bytes.Buffer
+			if err := tpl.ExecuteTemplate(&buf, fragment, data); err != nil {
+				return "", err
+			}
+
+			return template.HTML(contentStart + name + markerEnd + buf.String() + contentEnd), nil
+		},
+		"__slim_layout": func(body string, data interface{}) (template.HTML, error) {
+			var buf bytes.Buffer
+			if err := tpl.ExecuteTemplate(&buf, body, data); err != nil {
+				return "", err
+			}
+
+			return template.HTML(ResolveContent(buf.String())), nil
+		},
+	}
+}
+
+// noContent stands in for the content_for and layout helpers of templates created without New.
+func noContent(args ...interface{}) (template.HTML, error) {
+	return "", errNoFragments
+}
@@ -0,0 +1,126 @@
+package runtime
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ImageManifest maps the sources of images to the URLs of their variants by width, i.e. as written by an image
+// pipeline fingerprinting its output: {"/img/hero.jpg": {320: "/img/hero-320w.3f2a.jpg", 640: "/img/hero-640w.9c1d.jpg"}}.
+type ImageManifest map[string]map[int]string
+
+// ImageOptions configure the image_tag helper, see NewImageTag.
+type ImageOptions struct {
+	// Manifest the variants of images are resolved by, nil names them by width, see ImageTag.
+	Manifest ImageManifest
+	// Closes the element as XHTML does, i.e. <img ... />
+	XHTML bool
+	// Comma separated schemes the URLs of src and srcset may have, relative URLs always pass. Others are
+	// replaced by #ZgotmplZ, as html/template does with URLs of attributes.
+	// Default: DefaultImageSchemes
+	Schemes string
+}
+
+// Schemes html/template lets URLs of attributes have
+const DefaultImageSchemes = "http,https,mailto"
+
+// Returns an img element of src loaded lazily, offering the variants of given widths in its srcset. Variants are
+// named by appending the width to the name of src, i.e. /img/hero-640w.jpg for /img/hero.jpg. sizes defaults to
+// 100vw.
+func ImageTag(src, alt, sizes string, widths ...int) (template.HTML, error) {
+	return NewImageTag(ImageOptions{})(src, alt, sizes, widths...)
+}
+
+// Returns the image_tag helper resolving the variants of images by manifest instead of their names. Without widths
+// all variants of the manifest are offered, it fails on images and widths the manifest does not list.
+func ManifestImageTag(manifest ImageManifest) func(src, alt, sizes string, widths ...int) (template.HTML, error) {
+	return NewImageTag(ImageOptions{Manifest: manifest})
+}
+
+// Returns the image_tag helper configured by options, see ImageTag and ManifestImageTag.
+func NewImageTag(options ImageOptions) func(src, alt, sizes string, widths ...int) (template.HTML, error) {
+	if options.Schemes == "" {
+		options.Schemes = DefaultImageSchemes
+	}
+
+	return func(src, alt, sizes string, widths ...int) (template.HTML, error) {
+		if options.Manifest == nil {
+			variants := make(map[int]string, len(widths))
+			for _, width := range widths {
+				ext := path.Ext(src)
+				variants[width] = strings.TrimSuffix(src, ext) + "-" + strconv.Itoa(width) + "w" + ext
+			}
+
+			return imageTag(src, alt, sizes, variants, options), nil
+		}
+
+		all, ok := options.Manifest[src]
+		if !ok {
+			return "", fmt.Errorf("image_tag: no image %q in the manifest", src)
+		}
+
+		if len(widths) == 0 {
+			return imageTag(src, alt, sizes, all, options), nil
+		}
+
+		variants := make(map[int]string, len(widths))
+		for _, width := range widths {
+			url, ok := all[width]
+			if !ok {
+				return "", fmt.Errorf("image_tag: no variant of %q %d pixels wide in the manifest", src, width)
+			}
+
+			variants[width] = url
+		}
+
+		return imageTag(src, alt, sizes, variants, options), nil
+	}
+}
+
+// white space and commas separate the candidates of srcset, they are encoded within their URLs
+var srcsetEncoder = strings.NewReplacer(" ", "%20", "\t", "%09", "\n", "%0A", "\r", "%0D", "\f", "%0C", ",", "%2C")
+
+// imageTag writes the img element of src offering variants by width. URLs are checked like html/template does
+// with those of attributes, as it does not see the attributes of the returned element.
+func imageTag(src, alt, sizes string, variants map[int]string, options ImageOptions) template.HTML {
+	var b strings.Builder
+
+	b.WriteString(`<img src="` + html.EscapeString(string(CheckURL(src, options.Schemes))) + `"`)
+
+	if len(variants) > 0 {
+		widths := make([]int, 0, len(variants))
+		for width := range variants {
+			widths = append(widths, width)
+		}
+
+		sort.Ints(widths)
+
+		candidates := make([]string, len(widths))
+		for i, width := range widths {
+			url := string(CheckURL(strings.TrimSpace(variants[width]), options.Schemes))
+			candidates[i] = srcsetEncoder.Replace(url) + " " + strconv.Itoa(width) + "w"
+		}
+
+		if sizes == "" {
+			sizes = "100vw"
+		}
+
+		b.WriteString(` srcset="` + html.EscapeString(strings.Join(candidates, ", ")) + `"`)
+		b.WriteString(` sizes="` + html.EscapeString(sizes) + `"`)
+	}
+
+	b.WriteString(` alt="` + html.EscapeString(alt) + `" loading="lazy"`)
+
+	if options.XHTML {
+		b.WriteString(` />`)
+	} else {
+		b.WriteString(`>`)
+	}
+
+	return template.HTML(b.String())
+}
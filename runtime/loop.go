@@ -0,0 +1,72 @@
+package runtime
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Loop describes the current iteration of an each block, available to it as $loop.
+type Loop struct {
+	// Index of the iteration, counted from 0
+	Index int
+	// Number of the iteration, counted from 1
+	Number int
+	// Number of iterations, 0 for channels
+	Length int
+	First  bool
+	Last   bool
+	// Whether Number is even or odd
+	Even bool
+	Odd  bool
+}
+
+// Creates the Loop of an iteration over collection, positioned before its first iteration.
+func NewLoop(collection interface{}) Loop {
+	length := 0
+
+	vx := reflect.Indirect(reflect.ValueOf(collection))
+	switch vx.Kind() {
+	case reflect.Array, reflect.Slice, reflect.Map, reflect.String:
+		length = vx.Len()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		length = int(vx.Int())
+	}
+
+	return Loop{Index: -1, Length: length}
+}
+
+// Returns the Loop of the next iteration.
+func (l Loop) Next() Loop {
+	index := l.Index + 1
+
+	return Loop{
+		Index:  index,
+		Number: index + 1,
+		Length: l.Length,
+		First:  index == 0,
+		Last:   index == l.Length-1,
+		Even:   (index+1)%2 == 0,
+		Odd:    (index+1)%2 == 1,
+	}
+}
+
+// Returns an iterator yielding dot up to limit+1 times, ranged over by while and until loops
+// so their blocks keep the data of the enclosing scope.
+func While(dot interface{}, limit int) func(yield func(int, interface{}) bool) {
+	return func(yield func(int, interface{}) bool) {
+		for i := 0; i <= limit; i++ {
+			if !yield(i, dot) {
+				return
+			}
+		}
+	}
+}
+
+// Fails the execution once a while or until loop reaches its limit of iterations.
+func LoopLimit(iteration, limit int) (string, error) {
+	if iteration >= limit {
+		return "", fmt.Errorf("loop exceeded the limit of %d iterations", limit)
+	}
+
+	return "", nil
+}
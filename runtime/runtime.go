@@ -0,0 +1,691 @@
+// Package runtime implements the functions every Slim template depends on.
+//
+// Compiled templates call the __slim_* operators for arithmetic and comparison
+// expressions, alongside a set of helpers for everyday formatting. Templates
+// compiled ahead of time, e.g. with slimc, can be parsed elsewhere as long as
+// FuncMap is registered:
+//
+//	tpl := template.Must(template.New("page").Funcs(runtime.FuncMap()).Parse(src))
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+var funcs = template.FuncMap{
+	"__slim_add":   Add,
+	"__slim_sub":   Sub,
+	"__slim_mul":   Mul,
+	"__slim_quo":   Quo,
+	"__slim_rem":   Rem,
+	"__slim_minus": Minus,
+	"__slim_plus":  Plus,
+	"__slim_eql":   Eql,
+	"__slim_gtr":   Gtr,
+	"__slim_lss":   Lss,
+	"__slim_cache": noFragments,
+	"__slim_yield": Yield,
+	"__slim_class": Class,
+	"__slim_seq":   Seq,
+	"__slim_loop":  NewLoop,
+	"__slim_while": While,
+
+	"__slim_loop_limit":  LoopLimit,
+	"__slim_present":     Present,
+	"__slim_content_for": noContent,
+	"__slim_js":          SafeJS,
+	"__slim_css":         SafeCSS,
+	"__slim_url":         CheckURL,
+	"__slim_text":        plainText,
+	"__slim_layout":      noContent,
+	"__slim_component":   NewComponent,
+	"__slim_scope":       NewScope,
+	"__slim_slots":       Slots,
+	"__slim_slot":        noSlot,
+	"__slim_forward":     Forward,
+
+	"json":      JSON,
+	"jsonify":   JSONify,
+	"unescaped": Unescaped,
+	"raw":       Raw,
+	"safeURL":   SafeURL,
+	"safeJS":    SafeJS,
+	"dump":      Dump,
+	"typeOf":    TypeOf,
+	"nbsp":      Nbsp,
+	"entity":    Entity,
+
+	"upper":    Upper,
+	"lower":    Lower,
+	"title":    Title,
+	"trim":     Trim,
+	"truncate": Truncate,
+	"default":  Default,
+	"join":     Join,
+	"keys":     Keys,
+	"split":    Split,
+	"replace":  Replace,
+
+	"timeago":  TimeAgo,
+	"duration": Duration,
+	"datetime": Datetime,
+
+	"image_tag": ImageTag,
+
+	"global": noGlobal,
+}
+
+// Returns a copy of the functions injected into every compiled template,
+// so they can be registered with hand written templates as well.
+func FuncMap() template.FuncMap {
+	funcMap := make(template.FuncMap, len(funcs))
+	for name, fn := range funcs {
+		funcMap[name] = fn
+	}
+
+	return funcMap
+}
+
+const (
+	numInvalid = iota
+	numInt
+	numFloat
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// character reference of HTML, named or numeric
+var rentity = regexp.MustCompile(`^&(?:[a-zA-Z][a-zA-Z0-9]*|#[0-9]+|#[xX][0-9a-fA-F]+);$`)
+
+// numeric is an arithmetic operand normalized from any integer, float or duration value.
+type numeric struct {
+	kind     int
+	i        int64
+	f        float64
+	duration bool
+}
+
+func newNumeric(x interface{}) numeric {
+	var n numeric
+
+	vx := reflect.ValueOf(x)
+	switch vx.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n.kind = numInt
+		n.i = vx.Int()
+		n.duration = vx.Type() == durationType
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := vx.Uint(); u > math.MaxInt64 {
+			n.kind = numFloat
+			n.f = float64(u)
+		} else {
+			n.kind = numInt
+			n.i = int64(u)
+		}
+	case reflect.Float32, reflect.Float64:
+		n.kind = numFloat
+		n.f = vx.Float()
+	}
+
+	return n
+}
+
+func (n numeric) float() float64 {
+	if n.kind == numFloat {
+		return n.f
+	}
+
+	return float64(n.i)
+}
+
+func typeName(x interface{}) string {
+	if x == nil {
+		return "nil"
+	}
+
+	return reflect.TypeOf(x).String()
+}
+
+func arith(op string, x, y interface{}) (interface{}, error) {
+	nx, ny := newNumeric(x), newNumeric(y)
+	if nx.kind == numInvalid || ny.kind == numInvalid {
+		return nil, fmt.Errorf("invalid operation: %s %s %s", typeName(x), op, typeName(y))
+	}
+
+	if nx.kind == numFloat || ny.kind == numFloat {
+		fx, fy := nx.float(), ny.float()
+
+		switch op {
+		case "+":
+			return fx + fy, nil
+		case "-":
+			return fx - fy, nil
+		case "*":
+			return fx * fy, nil
+		case "/":
+			return fx / fy, nil
+		}
+
+		return nil, fmt.Errorf("invalid operation: operator %s not defined on float", op)
+	}
+
+	var result int64
+	var overflow bool
+
+	switch op {
+	case "+":
+		result = nx.i + ny.i
+		overflow = (ny.i > 0 && result < nx.i) || (ny.i < 0 && result > nx.i)
+	case "-":
+		result = nx.i - ny.i
+		overflow = (ny.i < 0 && result < nx.i) || (ny.i > 0 && result > nx.i)
+	case "*":
+		result = nx.i * ny.i
+		overflow = nx.i != 0 && (result/nx.i != ny.i || (nx.i == -1 && ny.i == math.MinInt64))
+	case "/", "%":
+		if ny.i == 0 {
+			return nil, fmt.Errorf("invalid operation: %v %s %v, division by zero", x, op, y)
+		}
+
+		if op == "/" {
+			result = nx.i / ny.i
+			overflow = nx.i == math.MinInt64 && ny.i == -1
+		} else {
+			result = nx.i % ny.i
+		}
+	}
+
+	if overflow {
+		return nil, fmt.Errorf("invalid operation: %v %s %v, integer overflow", x, op, y)
+	}
+
+	if nx.duration || ny.duration {
+		return time.Duration(result), nil
+	}
+
+	return result, nil
+}
+
+// Adds x and y, implementing the + operator. Strings are concatenated with
+// the string form of the other operand.
+func Add(x, y interface{}) (interface{}, error) {
+	_, xstr := x.(string)
+	_, ystr := y.(string)
+
+	if xstr || ystr {
+		return fmt.Sprint(x) + fmt.Sprint(y), nil
+	}
+
+	return arith("+", x, y)
+}
+
+// Subtracts y from x, implementing the - operator.
+func Sub(x, y interface{}) (interface{}, error) {
+	return arith("-", x, y)
+}
+
+// Multiplies x and y, implementing the * operator.
+func Mul(x, y interface{}) (interface{}, error) {
+	return arith("*", x, y)
+}
+
+// Divides x by y, implementing the / operator. Integer division by zero is an error, like integer overflow
+// of any of the operators.
+func Quo(x, y interface{}) (interface{}, error) {
+	return arith("/", x, y)
+}
+
+// Returns the remainder of x divided by y, implementing the % operator for integers.
+func Rem(x, y interface{}) (interface{}, error) {
+	return arith("%", x, y)
+}
+
+// Negates x, implementing the unary - operator.
+func Minus(x interface{}) (interface{}, error) {
+	n := newNumeric(x)
+	switch {
+	case n.kind == numFloat:
+		return -n.f, nil
+	case n.kind == numInt && n.i == math.MinInt64:
+		return nil, fmt.Errorf("invalid operation: -%v, integer overflow", x)
+	case n.duration:
+		return -time.Duration(n.i), nil
+	case n.kind == numInt:
+		return -n.i, nil
+	}
+
+	return nil, fmt.Errorf("invalid operation: -%s", typeName(x))
+}
+
+// Returns x as is, implementing the unary + operator.
+func Plus(x interface{}) (interface{}, error) {
+	n := newNumeric(x)
+	switch {
+	case n.kind == numFloat:
+		return n.f, nil
+	case n.duration:
+		return time.Duration(n.i), nil
+	case n.kind == numInt:
+		return n.i, nil
+	}
+
+	return nil, fmt.Errorf("invalid operation: +%s", typeName(x))
+}
+
+// compares x and y, returning -1, 0 or +1. Numbers of any width are compared by value,
+// strings lexically and a number against a string by its string form.
+func compare(x, y interface{}) (int, bool) {
+	nx, ny := newNumeric(x), newNumeric(y)
+
+	if nx.kind != numInvalid && ny.kind != numInvalid {
+		if nx.kind == numFloat || ny.kind == numFloat {
+			fx, fy := nx.float(), ny.float()
+
+			switch {
+			case fx < fy:
+				return -1, true
+			case fx > fy:
+				return 1, true
+			}
+
+			return 0, true
+		}
+
+		switch {
+		case nx.i < ny.i:
+			return -1, true
+		case nx.i > ny.i:
+			return 1, true
+		}
+
+		return 0, true
+	}
+
+	_, xstr := x.(string)
+	_, ystr := y.(string)
+
+	if (xstr || nx.kind != numInvalid) && (ystr || ny.kind != numInvalid) {
+		return strings.Compare(fmt.Sprint(x), fmt.Sprint(y)), true
+	}
+
+	return 0, false
+}
+
+// Reports whether x and y are equal, implementing the == and != operators.
+func Eql(x, y interface{}) bool {
+	if result, ok := compare(x, y); ok {
+		return result == 0
+	}
+
+	vx, vy := reflect.ValueOf(x), reflect.ValueOf(y)
+	if vx.Kind() == reflect.Bool && newNumeric(y).kind == numInt {
+		return vx.Bool() == (newNumeric(y).i != 0)
+	}
+
+	if vx.Kind() == reflect.Bool && vy.Kind() == reflect.Bool {
+		return vx.Bool() == vy.Bool()
+	}
+
+	return reflect.DeepEqual(x, y)
+}
+
+// Reports whether x is less than y, implementing the < and >= operators.
+func Lss(x, y interface{}) (bool, error) {
+	result, ok := compare(x, y)
+	if !ok {
+		return false, fmt.Errorf("invalid comparison: %s < %s", typeName(x), typeName(y))
+	}
+
+	return result < 0, nil
+}
+
+// Reports whether x is greater than y, implementing the > and <= operators.
+func Gtr(x, y interface{}) (bool, error) {
+	result, ok := compare(x, y)
+	if !ok {
+		return false, fmt.Errorf("invalid comparison: %s > %s", typeName(x), typeName(y))
+	}
+
+	return result > 0, nil
+}
+
+// Encodes x as JSON.
+func JSON(x interface{}) (res string, err error) {
+	bres, err := json.Marshal(x)
+	res = string(bres)
+	return
+}
+
+// Encodes x as JSON for embedding into a script element, i.e. of type application/json. The characters <, > and &
+// are escaped, so the value cannot close the script element nor open a comment within it.
+func JSONify(x interface{}) (template.JS, error) {
+	bres, err := json.Marshal(x)
+	if err != nil {
+		return "", err
+	}
+
+	return template.JS(bres), nil
+}
+
+// Marks x as trusted HTML. Kept for compatibility, see Raw.
+func Unescaped(x string) interface{} {
+	return template.HTML(x)
+}
+
+// marks x as trusted HTML, it will be emitted as is without any escaping.
+// Never use it with user supplied content.
+func Raw(x interface{}) template.HTML {
+	return template.HTML(fmt.Sprint(x))
+}
+
+// marks x as a trusted URL, html/template will not filter its scheme.
+func SafeURL(x interface{}) template.URL {
+	return template.URL(fmt.Sprint(x))
+}
+
+// Returns x as a trusted URL if it is relative or of one of the comma separated schemes, i.e. "http,https,mailto",
+// "#ZgotmplZ" otherwise, the value html/template outputs in place of unsafe URLs.
+// Unlike the filter of html/template, it applies to values marked safe by SafeURL as well.
+func CheckURL(x interface{}, schemes string) template.URL {
+	if x == nil {
+		return ""
+	}
+
+	url := fmt.Sprint(x)
+
+	// browsers ignore leading white space and control characters, i.e. in " javascript:..."
+	trimmed := strings.TrimLeftFunc(url, func(r rune) bool {
+		return r <= ' '
+	})
+
+	colon := strings.IndexByte(trimmed, ':')
+	if colon < 0 || strings.ContainsAny(trimmed[:colon], "/?#") {
+		return template.URL(url)
+	}
+
+	// browsers drop tabs and newlines within the scheme, i.e. in "java	script:..."
+	scheme := strings.ToLower(strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+
+		return r
+	}, trimmed[:colon]))
+
+	for _, allowed := range strings.Split(schemes, ",") {
+		if scheme == strings.TrimSpace(allowed) {
+			return template.URL(url)
+		}
+	}
+
+	return "#ZgotmplZ"
+}
+
+// marks x as a trusted JavaScript expression, it is inserted into script context as is.
+func SafeJS(x interface{}) template.JS {
+	return template.JS(fmt.Sprint(x))
+}
+
+// plainText marks x as trusted, the text format outputs values as they are rather than escaped for HTML.
+func plainText(x interface{}) template.HTML {
+	if x == nil {
+		return ""
+	}
+
+	return template.HTML(fmt.Sprint(x))
+}
+
+// Marks x as a trusted style sheet.
+func SafeCSS(x interface{}) template.CSS {
+	return template.CSS(fmt.Sprint(x))
+}
+
+// Returns s with all letters mapped to upper case.
+func Upper(s string) string {
+	return strings.ToUpper(s)
+}
+
+// Returns s with all letters mapped to lower case.
+func Lower(s string) string {
+	return strings.ToLower(s)
+}
+
+// upper cases the first letter of each word in s.
+func Title(s string) string {
+	runes := []rune(s)
+	prev := ' '
+
+	for i, r := range runes {
+		if unicode.IsSpace(prev) || unicode.IsPunct(prev) {
+			runes[i] = unicode.ToTitle(r)
+		}
+
+		prev = r
+	}
+
+	return string(runes)
+}
+
+// Returns s without leading and trailing white space.
+func Trim(s string) string {
+	return strings.TrimSpace(s)
+}
+
+// cuts s down to at most length runes, appending "..." when anything has been dropped.
+func Truncate(s string, length int) string {
+	if length < 0 || utf8.RuneCountInString(s) <= length {
+		return s
+	}
+
+	runes := []rune(s)
+	return string(runes[:length]) + "..."
+}
+
+// returns fallback if x is nil or the zero value of its type, x otherwise.
+func Default(x, fallback interface{}) interface{} {
+	vx := reflect.ValueOf(x)
+	if !vx.IsValid() {
+		return fallback
+	}
+
+	switch vx.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		if vx.Len() == 0 {
+			return fallback
+		}
+	default:
+		if vx.IsZero() {
+			return fallback
+		}
+	}
+
+	return x
+}
+
+// Joins the elements of the slice or array x with sep.
+func Join(x interface{}, sep string) (string, error) {
+	vx := reflect.ValueOf(x)
+	switch vx.Kind() {
+	case reflect.Array, reflect.Slice:
+		items := make([]string, vx.Len())
+		for i := 0; i < vx.Len(); i++ {
+			items[i] = fmt.Sprint(vx.Index(i).Interface())
+		}
+
+		return strings.Join(items, sep), nil
+	case reflect.Invalid:
+		return "", nil
+	}
+
+	return "", fmt.Errorf("join: unsupported type %s", vx.Type())
+}
+
+// Merges class names into the value of a class attribute. Slices and arrays contribute each of their
+// elements, nil, false and empty values are left out, as are names given more than once.
+func Class(values ...interface{}) string {
+	var (
+		names []string
+		seen  = make(map[string]bool)
+	)
+
+	var add func(x interface{})
+	add = func(x interface{}) {
+		vx := reflect.ValueOf(x)
+		switch vx.Kind() {
+		case reflect.Invalid:
+			return
+		case reflect.Bool:
+			if !vx.Bool() {
+				return
+			}
+		case reflect.Array, reflect.Slice:
+			for i := 0; i < vx.Len(); i++ {
+				add(vx.Index(i).Interface())
+			}
+
+			return
+		}
+
+		for _, name := range strings.Fields(fmt.Sprint(x)) {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	for _, x := range values {
+		add(x)
+	}
+
+	return strings.Join(names, " ")
+}
+
+// Reports whether x is worth outputting as an attribute value, that is neither nil, false nor an empty string.
+func Present(x interface{}) bool {
+	vx := reflect.ValueOf(x)
+	switch vx.Kind() {
+	case reflect.Invalid:
+		return false
+	case reflect.Bool:
+		return vx.Bool()
+	case reflect.String:
+		return vx.Len() > 0
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return !vx.IsNil()
+	}
+
+	return true
+}
+
+// Returns the integers from from to to, both inclusive, implementing from..to ranges.
+// The sequence descends if to is less than from.
+func Seq(from, to interface{}) ([]int, error) {
+	nfrom, nto := newNumeric(from), newNumeric(to)
+	if nfrom.kind != numInt || nto.kind != numInt {
+		return nil, fmt.Errorf("invalid range %s..%s, integers expected", typeName(from), typeName(to))
+	}
+
+	step := 1
+	if nto.i < nfrom.i {
+		step = -1
+	}
+
+	seq := make([]int, 0, (nto.i-nfrom.i)*int64(step)+1)
+	for i := nfrom.i; ; i += int64(step) {
+		seq = append(seq, int(i))
+		if i == nto.i {
+			break
+		}
+	}
+
+	return seq, nil
+}
+
+// Returns the keys of the map x in ascending order.
+func Keys(x interface{}) ([]interface{}, error) {
+	vx := reflect.ValueOf(x)
+	switch vx.Kind() {
+	case reflect.Map:
+	case reflect.Invalid:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("keys: unsupported type %s", vx.Type())
+	}
+
+	keys := make([]interface{}, 0, vx.Len())
+	for _, key := range vx.MapKeys() {
+		keys = append(keys, key.Interface())
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		if result, ok := compare(keys[i], keys[j]); ok {
+			return result < 0
+		}
+
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+
+	return keys, nil
+}
+
+// Splits s into all substrings separated by sep.
+func Split(s, sep string) []string {
+	return strings.Split(s, sep)
+}
+
+// Returns s with all occurrences of old replaced by new.
+func Replace(s, old, new string) string {
+	return strings.Replace(s, old, new, -1)
+}
+
+// pretty prints x as indented JSON within a <pre> element, for inspecting template data.
+func Dump(x interface{}) (template.HTML, error) {
+	bres, err := json.MarshalIndent(x, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return template.HTML("<pre>" + html.EscapeString(string(bres)) + "</pre>"), nil
+}
+
+// returns a non-breaking space, i.e. to keep a number and its unit on the same line.
+func Nbsp() template.HTML {
+	return "&nbsp;"
+}
+
+// returns the character reference of given name, i.e. "rarr" or "#8594", for output as is.
+// It fails on names HTML does not define.
+func Entity(name string) (template.HTML, error) {
+	ref := "&" + strings.TrimSuffix(strings.TrimPrefix(name, "&"), ";") + ";"
+	if !rentity.MatchString(ref) || html.UnescapeString(ref) == ref {
+		return "", fmt.Errorf("unknown HTML entity %q", name)
+	}
+
+	return template.HTML(ref), nil
+}
+
+// returns the Go type name of x.
+func TypeOf(x interface{}) string {
+	if x == nil {
+		return "<nil>"
+	}
+
+	return reflect.TypeOf(x).String()
+}
+
+// noGlobal stands in for the global helper of templates executed outside of a Renderer, which has no values.
+func noGlobal(name string) interface{} {
+	return nil
+}
@@ -0,0 +1,31 @@
+package runtime
+
+// Scope is the data of a fragment referring to variables of the template enclosing it, like the content of a cache
+// block. Fragments are templates of their own, they declare the variables again from the scope.
+type Scope struct {
+	dot       interface{}
+	variables map[string]interface{}
+}
+
+// Creates the Scope of dot and the variables given as pairs of names and values.
+func NewScope(dot interface{}, pairs ...interface{}) *Scope {
+	scope := &Scope{dot: dot, variables: make(map[string]interface{}, len(pairs)/2)}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if name, ok := pairs[i].(string); ok {
+			scope.variables[name] = pairs[i+1]
+		}
+	}
+
+	return scope
+}
+
+// Returns the value of the variable of given name, i.e. "$item".
+func (s *Scope) Var(name string) interface{} {
+	return s.variables[name]
+}
+
+// Returns the dot of the enclosing template as the single item of a list, ranging over it restores the dot
+// whatever its value.
+func (s *Scope) Dot() []interface{} {
+	return []interface{}{s.dot}
+}
@@ -0,0 +1,136 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// returns how long ago x has been, i.e. "3 hours ago", or how far ahead it is, i.e. "in 2 days".
+// x is a time.Time or a *time.Time, nil gives an empty string.
+func TimeAgo(x interface{}) (string, error) {
+	t, ok, err := toTime("timeago", x)
+	if !ok {
+		return "", err
+	}
+
+	d := time.Since(t)
+
+	future := d < 0
+	if future {
+		d = -d
+	}
+
+	var count int
+	var unit string
+
+	switch {
+	case d < time.Minute:
+		return "just now", nil
+	case d < time.Hour:
+		count, unit = int(d/time.Minute), "minute"
+	case d < 24*time.Hour:
+		count, unit = int(d/time.Hour), "hour"
+	case d < 30*24*time.Hour:
+		count, unit = int(d/(24*time.Hour)), "day"
+	case d < 365*24*time.Hour:
+		count, unit = int(d/(30*24*time.Hour)), "month"
+	default:
+		count, unit = int(d/(365*24*time.Hour)), "year"
+	}
+
+	if count != 1 {
+		unit += "s"
+	}
+
+	if future {
+		return fmt.Sprintf("in %d %s", count, unit), nil
+	}
+
+	return fmt.Sprintf("%d %s ago", count, unit), nil
+}
+
+// formats the duration x by its two largest units, i.e. "1h 5m", "2m 30s" or "450ms".
+// x is a time.Duration or a number of seconds.
+func Duration(x interface{}) (string, error) {
+	var d time.Duration
+
+	n := newNumeric(x)
+	switch {
+	case n.kind == numInvalid:
+		return "", fmt.Errorf("duration: unsupported type %s", typeName(x))
+	case n.duration:
+		d = time.Duration(n.i)
+	case n.kind == numInt:
+		d = time.Duration(n.i) * time.Second
+	default:
+		d = time.Duration(n.f * float64(time.Second))
+	}
+
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+
+	if d < time.Second {
+		return sign + d.Round(time.Millisecond).String(), nil
+	}
+
+	units := []struct {
+		size time.Duration
+		name string
+	}{
+		{24 * time.Hour, "d"},
+		{time.Hour, "h"},
+		{time.Minute, "m"},
+		{time.Second, "s"},
+	}
+
+	parts := make([]string, 0, 2)
+	for _, unit := range units {
+		if d < unit.size && len(parts) == 0 {
+			continue
+		}
+
+		if count := d / unit.size; count > 0 {
+			parts = append(parts, fmt.Sprintf("%d%s", count, unit.name))
+		}
+
+		d %= unit.size
+
+		if len(parts) == 2 || len(parts) > 0 && d == 0 {
+			break
+		}
+	}
+
+	return sign + strings.Join(parts, " "), nil
+}
+
+// formats x as RFC 3339, the format of the datetime attribute of time elements, i.e. "2024-03-01T09:30:00Z".
+// x is a time.Time or a *time.Time, nil gives an empty string.
+func Datetime(x interface{}) (string, error) {
+	t, ok, err := toTime("datetime", x)
+	if !ok {
+		return "", err
+	}
+
+	return t.Format(time.RFC3339), nil
+}
+
+// toTime returns the time of x, whether there is one and an error of helper if x is no time at all.
+func toTime(helper string, x interface{}) (time.Time, bool, error) {
+	switch t := x.(type) {
+	case time.Time:
+		return t, true, nil
+	case *time.Time:
+		if t == nil {
+			return time.Time{}, false, nil
+		}
+
+		return *t, true, nil
+	case nil:
+		return time.Time{}, false, nil
+	}
+
+	return time.Time{}, false, fmt.Errorf("%s: unsupported type %s", helper, typeName(x))
+}
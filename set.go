@@ -0,0 +1,124 @@
+package slim
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Set holds templates compiled by name, i.e. for the lifetime of an application, along with the options, the loader
+// and the functions they are compiled with. It is safe for concurrent use, templates may be added or replaced while
+// others are executed.
+//
+//	views := slim.NewSet(slim.WithLoader(viewsFS), slim.WithFuncs(funcs))
+//	if err := views.AddFile("users/show", "users/show.slim"); err != nil {
+//		log.Fatal(err)
+//	}
+//
+//	views.ExecuteTemplate(w, "users/show", user)
+type Set struct {
+	opts      []Option
+	mu        sync.RWMutex
+	funcs     template.FuncMap
+	templates map[string]*template.Template
+}
+
+// Create a new and empty Set compiling templates with a Compiler configured by opts, as NewWithOptions does.
+func NewSet(opts ...Option) *Set {
+	return &Set{
+		opts:      opts,
+		funcs:     make(template.FuncMap),
+		templates: make(map[string]*template.Template),
+	}
+}
+
+// Adds functions callable from the expressions of templates added afterwards, see Compiler.AddFuncs.
+func (s *Set) AddFuncs(funcs template.FuncMap) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, fn := range funcs {
+		s.funcs[name] = fn
+	}
+}
+
+// Compiles the slim template source and adds it by name, replacing a template of the same name.
+func (s *Set) AddString(name, source string) error {
+	compiler := s.compiler()
+
+	if err := compiler.Parse(source); err != nil {
+		return err
+	}
+
+	return s.add(name, compiler)
+}
+
+// Compiles the slim template file, read through the loader if the Set has one, and adds it by name,
+// replacing a template of the same name.
+func (s *Set) AddFile(name, filename string) error {
+	compiler := s.compiler()
+
+	if err := compiler.ParseFile(filename); err != nil {
+		return err
+	}
+
+	return s.add(name, compiler)
+}
+
+// Returns the template of given name, nil if there is none.
+func (s *Set) Lookup(name string) *template.Template {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.templates[name]
+}
+
+// Returns the names of all templates of the Set, sorted.
+func (s *Set) Names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.templates))
+	for name := range s.templates {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Executes the template of given name with data and writes the output into w.
+func (s *Set) ExecuteTemplate(w io.Writer, name string, data interface{}) error {
+	tpl := s.Lookup(name)
+	if tpl == nil {
+		return fmt.Errorf("slim: no template %q", name)
+	}
+
+	return tpl.Execute(w, data)
+}
+
+// compiler returns a Compiler configured by the options and the functions of the Set.
+func (s *Set) compiler() *Compiler {
+	compiler := NewWithOptions(s.opts...)
+
+	s.mu.RLock()
+	compiler.AddFuncs(s.funcs)
+	s.mu.RUnlock()
+
+	return compiler
+}
+
+func (s *Set) add(name string, compiler *Compiler) error {
+	tpl, err := compiler.CompileWithName(name)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.templates[name] = tpl
+	s.mu.Unlock()
+
+	return nil
+}
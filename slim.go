@@ -3,38 +3,34 @@ package slim
 import (
 	"bytes"
 	"container/list"
-	"errors"
+	"context"
 	"fmt"
 	goAst "go/ast"
 	goParser "go/parser"
+	goScanner "go/scanner"
 	goToken "go/token"
+	"html"
 	"html/template"
 	"io"
+	"io/fs"
+	"path"
 	"path/filepath"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golib/slim/parser"
+	"github.com/golib/slim/runtime"
 )
 
-var builtinFunctions = [...]string{
-	"len",
-	"print",
-	"printf",
-	"println",
-	"urlquery",
-	"js",
-	"json",
-	"index",
-	"html",
-	"unescaped",
-}
-
 var (
 	rdelimiter   = regexp.MustCompile(`\{\{(.*?)\}\}`)
 	rinterpolate = regexp.MustCompile(`#\{(.*?)\}`)
+	rloopvar     = regexp.MustCompile(`\$loop\b`)
+	rvariable    = regexp.MustCompile(`\$\w+`)
+	rintrange    = regexp.MustCompile(`^\s*(.+?)\s*\.\.\s*(.+?)\s*$`)
 )
 
 type Options struct {
@@ -47,9 +43,176 @@ type Options struct {
 	// In this form, Slim emits line number comments in the output template. It is usable in debugging environments.
 	// Default: false
 	LineNumbers bool
+	// Setting if the output is minified.
+	// Minified output is compact, collapses white space of text, drops comments other than conditional
+	// comments and shortens boolean attributes. Contents of pre, textarea, script and style are preserved.
+	// Comments marked by /!keep, i.e. license notices, are kept as well.
+	// Default: false
+	Minify bool
+	// Setting if HTML comments are dropped from the output, i.e. in production builds. Conditional comments and
+	// comments marked by /!keep are kept.
+	// Default: false
+	StripComments bool
+	// Elements the pretty printer keeps on the same line as surrounding text. Breaking lines around them
+	// would introduce visible spaces between words and punctuation.
+	// Default: DefaultInlineElements
+	InlineElements []string
+	// Setting if control actions are emitted with trim markers.
+	// In compact mode, {{- and -}} markers around if, range and assignment actions drop the white space
+	// of the template surrounding them, so conditions and iterations leave no blank lines behind.
+	// Default: false
+	TrimMarkers bool
+	// Doctype prepended to templates which do not declare one, i.e. "5" or "transitional".
+	// Default: "" (none)
+	DefaultDoctype string
+	// Elements rendered as void elements, closed within their start tag, replacing the built-in list of HTML.
+	// Useful for XML vocabularies, use parser.RegisterVoidElement to extend the built-in list instead.
+	// Default: nil (built-in list)
+	VoidElements []string
+	// Setting if go template actions written within text are kept as actions rather than output as text,
+	// i.e. `| {{template "footer" .}}`.
+	// Default: false
+	PassthroughActions bool
+	// Manifest the image_tag helper resolves the variants of images by, i.e. as written by an image pipeline.
+	// Default: nil (variants named by width, i.e. /img/hero-640w.jpg)
+	ImageManifest runtime.ImageManifest
+	// Column beyond which pretty printed tags put each of their attributes on a line of its own, indented below the tag.
+	// Attributes are measured as written into the generated template, tabs of the indentation count as one column.
+	// Default: 0 (never wrap)
+	AttributeWrapColumn int
+	// Quote character enclosing attribute values in the output, either `"` or `'`.
+	// Default: `"`
+	AttributeQuote string
+	// Setting if attributes other than class are left out when their expression gives nil, false or an empty string,
+	// instead of being output with an empty value.
+	// Default: false
+	OmitEmptyAttributes bool
+	// Setting if the expression of a boolean attribute like checked, disabled or required decides whether the attribute
+	// is present rather than giving its value, i.e. `[checked=Done]` outputs `checked` or nothing.
+	// Default: false (the value is output, i.e. checked="true")
+	BooleanAttributes bool
+	// Policy for attributes other than class declared more than once on a tag, one of the DuplicateAttribute constants.
+	// Default: DuplicateAttributeLast
+	DuplicateAttributes int
+	// Number of iterations a while or until loop may take before its execution fails.
+	// Default: DefaultMaxLoopIterations
+	MaxLoopIterations int
+	// Markup format of the output, parser.FORMAT_HTML, parser.FORMAT_XHTML or parser.FORMAT_TEXT.
+	// HTML leaves the closing slash out of void elements and picks HTML 4 rather than XHTML doctypes.
+	// XHTML, and the default with an xml or xhtml doctype, expand attributes without value, i.e. checked="checked".
+	// Text outputs the plain text of the template, i.e. for the text/plain part of an email, see visitPlainTag.
+	// Default: "" (xhtml)
+	Format string
+	// Wrapper enclosing the content of script tags in xhtml format, so it is not taken for markup.
+	// Default: parser.NewCdataWrapper()
+	ScriptWrapper *parser.Wrapper
+	// Wrapper enclosing the content of style tags in xhtml format.
+	// Default: parser.NewCssCdataWrapper()
+	StyleWrapper *parser.Wrapper
+	// Filters converting the content of :name filters to HTML, taking precedence over those of RegisterFilter.
+	// Default: nil
+	Filters map[string]Filter
+	// Extensions tried in order for targets of import and extend without one, i.e. []string{".slim", ".html.slim"}.
+	// Default: parser.DefaultExtensions
+	Extensions []string
+	// Directories searched in order for targets of import and extend not found relative to the template directory,
+	// i.e. []string{"shared/components", "vendor/themes/base"}. They are slash separated within a loader.
+	// Default: nil
+	IncludePaths []string
+	// File systems of component libraries by the prefix they are mounted under, targets of import and extend
+	// starting with the prefix, i.e. "ui/button" for "ui", are read from them. See Library.
+	// Default: nil
+	Libraries map[string]fs.FS
+	// Limits of nesting, file size, import depth and line length, exceeding them fails parsing.
+	// Default: parser.DefaultLimits
+	Limits parser.Limits
+	// Indentation accepted in templates, one of the parser.Indent constants.
+	// Default: parser.IndentAny
+	IndentStyle int
+	// Policy on templates mixing tabs and spaces in their indentation, one of the parser.MixedIndent constants.
+	// Default: parser.MixedIndentAllow
+	MixedIndentation int
+	// Width of tab stops when mixed indentation is normalized.
+	// Default: parser.DefaultTabWidth
+	TabWidth int
+	// Separator joining the lines of piped text spanning several lines, i.e. " " to flow them into one line.
+	// Default: "\n"
+	TextSeparator string
+	// Policy joining consecutive lines of piped text, one of the TextJoin constants.
+	// Default: TextJoinLayout
+	TextJoin int
+	// Provider of the tracer spans of ParseFile, Compile and Renderer renders are started with.
+	// Default: nil (not traced)
+	TracerProvider TracerProvider
+	// Receiver of the measurements of compiles, template lookups and renders.
+	// Default: nil (not measured)
+	Metrics Metrics
+	// Setting if compilation fails on calls of unknown helpers, on blocks overriding none of the parent templates
+	// and on extending templates which define no blocks, rather than leaving the typo to show up at runtime.
+	// Default: false
+	Strict bool
+	// Setting if compilation fails on markup browsers would repair, i.e. a div nested inside a p or span, a second
+	// title, unknown elements or void elements given content.
+	// Default: false
+	ValidateHTML bool
+	// Setting if the URLs of href, src, action and formaction attributes given by expressions are checked at
+	// render time, those of schemes other than URLSchemes are replaced by #ZgotmplZ. Unlike the filter of
+	// html/template, the check applies to URLs marked safe by the safeURL helper as well.
+	// Default: false
+	CheckURLs bool
+	// Schemes of URLs passing the check of CheckURLs, relative URLs always pass.
+	// Default: DefaultURLSchemes
+	URLSchemes []string
+	// Setting if compilation fails on expressions whose output html/template cannot escape reliably: those within
+	// tag or attribute names, within unquoted attribute values or within attribute values quoted by several
+	// nodes, as inline HTML spanning several lines may do.
+	// Default: false
+	AuditEscaping bool
+}
+
+const DefaultMaxLoopIterations = 10000
+
+var DefaultURLSchemes = []string{"http", "https", "mailto", "tel"}
+
+// attributes holding URLs checked by Options.CheckURLs
+var urlAttributes = map[string]bool{
+	"href":       true,
+	"src":        true,
+	"action":     true,
+	"formaction": true,
+}
+
+// Policies for attributes declared more than once on a tag
+const (
+	// The last declaration is output, earlier ones are ignored
+	DuplicateAttributeLast = iota
+	// The first declaration is output, later ones are ignored
+	DuplicateAttributeFirst
+	// Values of all declarations are output, separated by spaces
+	DuplicateAttributeMerge
+	// Compilation fails
+	DuplicateAttributeError
+)
+
+// Policies joining consecutive lines of piped text
+const (
+	// Lines are joined as the layout puts them, directly in compact output and on lines of their own when
+	// pretty printed, unless their parent is kept on a single line
+	TextJoinLayout = iota
+	// Lines are joined by a newline
+	TextJoinNewline
+	// Lines are joined by a single space
+	TextJoinSpace
+	// Lines are joined directly
+	TextJoinNone
+)
+
+var DefaultInlineElements = []string{
+	"a", "abbr", "b", "bdi", "bdo", "br", "cite", "code", "data", "del", "dfn", "em", "i", "img", "ins",
+	"kbd", "label", "mark", "q", "s", "samp", "small", "span", "strong", "sub", "sup", "time", "u", "var", "wbr",
 }
 
-var DefaultOptions = Options{true, false}
+var DefaultOptions = Options{Pretty: true}
 
 // Parses and compiles the supplied slim template string.
 // Returns corresponding Go Template (html/templates) instance.
@@ -81,6 +244,124 @@ func CompileFile(filename string, options Options) (*template.Template, error) {
 	return compiler.CompileWithFile()
 }
 
+// Parses and compiles the slim template read from r, i.e. a network stream or a file within an archive.
+// Returns corresponding Go Template (html/templates) instance.
+// Necessary runtime functions will be injected and the template will be ready to be executed.
+func CompileReader(r io.Reader, options Options) (*template.Template, error) {
+	compiler := New()
+	compiler.Options = options
+
+	err := compiler.ParseReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return compiler.CompileWithFile()
+}
+
+// Parses and compiles every slim template below dir into a single Go Template (html/templates) instance.
+// Each template is defined by its path relative to dir without extension, i.e. "users/show", and executed
+// by ExecuteTemplate. Files without one of Options.Extensions are skipped, imports and extends are resolved against dir.
+func CompileDir(dir string, options Options) (*template.Template, error) {
+	return CompileDirContext(context.Background(), dir, options)
+}
+
+// Same as CompileDir, but stops with the error of ctx once it is done.
+func CompileDirContext(ctx context.Context, dir string, options Options) (*template.Template, error) {
+	extensions := options.Extensions
+	if extensions == nil {
+		extensions = parser.DefaultExtensions
+	}
+
+	set := template.New(filepath.Base(dir))
+
+	err := walkTemplates(ctx, dir, extensions, func(name, filename string) error {
+		compiler := New()
+		compiler.Options = options
+		compiler.SetTemplateDir(dir)
+		compiler.namespace = name + ":"
+
+		if err := compiler.ParseFile(filename); err != nil {
+			return err
+		}
+
+		_, err := compiler.CompileWithTemplate(set.New(name))
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return set, nil
+}
+
+// walkTemplates calls fn with the name and the path of every template below dir, stopping once ctx is done.
+func walkTemplates(ctx context.Context, dir string, extensions []string, fn func(name, filename string) error) error {
+	return filepath.WalkDir(dir, func(filename string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		name, ok := TemplateName(dir, filename, extensions)
+		if entry.IsDir() || !ok {
+			return nil
+		}
+
+		return fn(name, filename)
+	})
+}
+
+// Returns the name CompileDir defines the template of filename by, its path relative to dir without its extension,
+// i.e. "users/show" for users/show.html.slim. Reports false if filename has none of extensions, nil for
+// parser.DefaultExtensions.
+func TemplateName(dir, filename string, extensions []string) (string, bool) {
+	if extensions == nil {
+		extensions = parser.DefaultExtensions
+	}
+
+	rel, err := filepath.Rel(dir, filename)
+	if err != nil {
+		return "", false
+	}
+
+	rel = filepath.ToSlash(rel)
+
+	for _, extension := range extensions {
+		if strings.HasSuffix(strings.ToLower(rel), extension) {
+			return rel[:len(rel)-len(extension)], true
+		}
+	}
+
+	return "", false
+}
+
+// Returns the template if err is nil and panics otherwise.
+// It is intended for variable initializations such as
+//
+//	var page = slim.Must(slim.CompileFile("page.slim", slim.DefaultOptions))
+func Must(tpl *template.Template, err error) *template.Template {
+	if err != nil {
+		panic(err)
+	}
+
+	return tpl
+}
+
+// Same as Compile but panics if the template fails to compile.
+func MustCompile(input string, options Options) *template.Template {
+	return Must(Compile(input, options))
+}
+
+// Same as CompileFile but panics if the template fails to compile.
+func MustCompileFile(filename string, options Options) *template.Template {
+	return Must(CompileFile(filename, options))
+}
+
 // Compiler is the main interface of Slim Template Engine.
 // In order to use an Slim template, it is required to create a Compiler and
 // compile an Slim source to native Go template.
@@ -98,12 +379,43 @@ func CompileFile(filename string, options Options) (*template.Template, error) {
 type Compiler struct {
 	// Compiler options
 	Options
+	// Cache storing fragments of cache directives, runtime.DefaultCache if nil
+	Cache        runtime.Cache
 	node         parser.Noder
 	buffer       *bytes.Buffer
 	filename     string
+	templateDir  string
+	loader       fs.FS
+	funcs        template.FuncMap
+	warnings     []parser.Warning
 	newline      bool
 	level        int
 	tempvarIndex int
+	dataType     reflect.Type
+	typeScopes   []*typeScope
+	sourceMap    *SourceMap
+	fragments    []fragment
+	layout       bool
+	preserve     int
+	verbatim     int
+	inline       int
+	inlines      map[string]bool
+	voids        map[string]bool
+	// variables declared by assignments, per scope of the generated template
+	scopes []map[string]bool
+	// depth of mixins being compiled, slots are placeholders within them
+	mixins int
+	// the template declares an xml or xhtml doctype, attributes without value are expanded in the default format
+	xmlDoctype bool
+	// prefix of the names of templates defined by the compiler, keeping those of templates compiled into one set apart
+	namespace string
+	// name of the template the output is defined as by CompileDefine, empty to output it as is
+	define string
+	// digest of the output of the last Compile call
+	digest string
+	// named blocks of the template and those of the templates it extends
+	blocks    []*parser.NamedBlock
+	inherited []*parser.NamedBlock
 }
 
 // Create and initialize a new Compiler
@@ -117,11 +429,18 @@ func New() *Compiler {
 	return compiler
 }
 
+// Sets the directory targets of import and extend are resolved against.
+// Without it, templates parsed from strings are unable to import or extend others,
+// those parsed from files resolve them against their own directory.
+func (c *Compiler) SetTemplateDir(dir string) {
+	c.templateDir = dir
+}
+
 // Parse given raw slim template string.
 func (c *Compiler) Parse(input string) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = errors.New(r.(string))
+			err = recoverError(r)
 		}
 	}()
 
@@ -130,49 +449,251 @@ func (c *Compiler) Parse(input string) (err error) {
 		return
 	}
 
+	c.configure(parser)
+
+	c.node = parser.Parse()
+	c.warnings = parser.Warnings()
+	c.blocks, c.inherited = parser.NamedBlocks(), parser.InheritedBlocks()
+	return
+}
+
+// Parse the slim template in input, i.e. one edited by the users of a CMS.
+// It is safe to use on input of untrusted sources: whatever input holds, parsing fails with a *parser.Error
+// rather than panicking, and memory use is bounded by Options.Limits, input exceeding their MaxFileSize
+// is rejected before it is scanned.
+func (c *Compiler) ParseBytes(input []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = parser.NewError(r, parser.SourcePosition{Line: 1, Column: 1})
+		}
+	}()
+
+	limit := c.Limits.MaxFileSize
+	if limit == 0 {
+		limit = parser.DefaultLimits.MaxFileSize
+	}
+
+	if len(input) > limit {
+		panic(fmt.Sprintf("Template exceeds the limit of %d bytes.", limit))
+	}
+
+	p, err := parser.NewBytesParser(input)
+	if err != nil {
+		return
+	}
+
+	c.configure(p)
+
+	c.node = p.Parse()
+	c.warnings = p.Warnings()
+	c.blocks, c.inherited = p.NamedBlocks(), p.InheritedBlocks()
+	return
+}
+
+// configure applies the options of parsing to p and sets how it resolves import and extend.
+// Applies the options of the compiler concerning parsing to p, i.e. its extensions, include paths and libraries,
+// so tools like editors parse templates as the compiler does.
+func (c *Compiler) Configure(p *parser.Parser) {
+	c.configure(p)
+}
+
+func (c *Compiler) configure(p *parser.Parser) {
+	p.SetLimits(c.Limits)
+	p.SetIndentStyle(c.IndentStyle)
+	p.SetMixedIndentation(c.MixedIndentation, c.TabWidth)
+	p.SetTextSeparator(c.TextSeparator)
+	p.SetStrict(c.Strict)
+
+	if c.Extensions != nil {
+		p.SetExtensions(c.Extensions...)
+	}
+
+	p.SetIncludePaths(c.IncludePaths...)
+	p.SetPath(c.templateDir)
+
+	for prefix, fsys := range c.Libraries {
+		p.Mount(prefix, fsys)
+	}
+
+	if c.loader != nil {
+		p.SetLoader(c.loader)
+
+		if len(c.templateDir) == 0 {
+			p.SetPath(".")
+		}
+	}
+}
+
+// Parse the slim template read from r.
+func (c *Compiler) ParseReader(r io.Reader) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverError(r)
+		}
+	}()
+
+	parser, err := parser.NewReaderParser(r)
+	if err != nil {
+		return
+	}
+
+	c.configure(parser)
+
 	c.node = parser.Parse()
+	c.warnings = parser.Warnings()
+	c.blocks, c.inherited = parser.NamedBlocks(), parser.InheritedBlocks()
 	return
 }
 
 // Parse the slim template file in given path
 func (c *Compiler) ParseFile(filename string) (err error) {
+	_, span := startSpan(context.Background(), c.TracerProvider, "slim.ParseFile", filename)
+	defer func() {
+		endSpan(span, err)
+	}()
+
 	defer func() {
 		if r := recover(); r != nil {
-			err = errors.New(r.(string))
+			err = recoverError(r)
 		}
 	}()
 
-	parser, err := parser.NewFileParser(filename)
+	var p *parser.Parser
+	if c.loader != nil {
+		p, err = parser.NewFSParser(c.loader, filename)
+	} else {
+		p, err = parser.NewFileParser(filename)
+	}
+
 	if err != nil {
 		return
 	}
 
-	c.node = parser.Parse()
+	c.configure(p)
+
+	// imports and extends are resolved against the directory of the file unless a template dir is set
+	if len(c.templateDir) == 0 {
+		if c.loader != nil {
+			p.SetPath(path.Dir(filename))
+		} else {
+			p.SetPath(filepath.Dir(filename))
+		}
+	}
+
+	c.node = p.Parse()
+	c.warnings = p.Warnings()
+	c.blocks, c.inherited = p.NamedBlocks(), p.InheritedBlocks()
 	c.filename = filename
 	return
 }
 
+// Returns the warnings raised by parsing the template, i.e. on mixed indentation,
+// and those on variables which are assigned but never used.
+func (c *Compiler) Warnings() []parser.Warning {
+	if c.node == nil {
+		return c.warnings
+	}
+
+	return append(append([]parser.Warning(nil), c.warnings...), unusedVariables(c.node, c.PassthroughActions)...)
+}
+
 // Compile slim and write the Go Template source into given io.Writer instance
 // You would not be using this unless debugging / checking the output.
 // Please use Compile method to obtain a template instance directly.
 func (c *Compiler) Compile(out io.Writer) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = errors.New(r.(string))
+			err = recoverError(r)
 		}
 	}()
 
 	c.buffer = new(bytes.Buffer)
+	c.typeScopes = nil
+	c.scopes = nil
+	c.sourceMap = newSourceMap()
+	c.fragments = nil
+	c.layout = false
+	c.inlines = make(map[string]bool)
+
+	// known before any tag is visited, so the doctype applies to the whole template wherever it is declared
+	c.xmlDoctype = false
+	if doctype := c.topDoctype(); doctype != nil {
+		c.xmlDoctype = isXMLDoctype(doctype)
+	} else if len(c.DefaultDoctype) > 0 {
+		c.xmlDoctype = isXMLDoctype(&parser.Doctype{Value: c.DefaultDoctype})
+	}
+
+	inlines := c.InlineElements
+	if inlines == nil {
+		inlines = DefaultInlineElements
+	}
+
+	for _, name := range inlines {
+		c.inlines[name] = true
+	}
+
+	c.voids = nil
+	if c.VoidElements != nil {
+		c.voids = make(map[string]bool)
+		for _, name := range c.VoidElements {
+			c.voids[name] = true
+		}
+	}
+
+	if c.ValidateHTML && c.Format != parser.FORMAT_TEXT {
+		c.validateHTML(c.node)
+	}
+
+	if len(c.DefaultDoctype) > 0 && !c.hasDoctype() {
+		c.visitDoctype(&parser.Doctype{Value: c.DefaultDoctype})
+	}
+
 	c.visit(c.node)
 
+	if c.layout {
+		c.wrapLayout()
+	}
+
+	if len(c.define) > 0 {
+		c.wrap(`{{define `+strconv.Quote(c.define)+`}}`, `{{end}}`)
+	}
+
+	c.writeFragments()
+
 	if c.buffer.Len() > 0 {
 		c.write("\n")
 	}
 
+	c.sourceMap.finish(c.buffer.String())
+
+	if c.AuditEscaping && c.Format != parser.FORMAT_TEXT {
+		c.auditEscaping(c.buffer.String())
+	}
+
+	c.digest = digest(c.buffer.String())
+
+	// the digest is the prefix of cache keys, of the same length as its placeholder so source maps hold
+	if output := c.buffer.Bytes(); bytes.Contains(output, []byte(cacheKeyPrefix)) {
+		c.buffer = bytes.NewBuffer(bytes.Replace(output, []byte(cacheKeyPrefix), []byte(c.digest[:len(cacheKeyPrefix)]), -1))
+	}
+
 	_, err = c.buffer.WriteTo(out)
 	return
 }
 
+// Same as Compile but defines the output as a template of given name, followed by the templates defined within it.
+// Names of the templates defined by the compiler are prefixed by name as CompileDir does, so the output of several
+// files can be concatenated into one template set.
+func (c *Compiler) CompileDefine(out io.Writer, name string) error {
+	c.namespace, c.define = name+":", name
+
+	defer func() {
+		c.namespace, c.define = "", ""
+	}()
+
+	return c.Compile(out)
+}
+
 // Compile template and return the Go Template source
 // You would not be using this unless debugging / checking the output.
 // Please use Compile method to obtain a template instance directly.
@@ -183,284 +704,1256 @@ func (c *Compiler) String() (string, error) {
 		return "", err
 	}
 
-	result := buf.String()
+	result := buf.String()
+
+	return result, nil
+}
+
+// Compile slim and create a Go Template (html/templates) instance.
+// Necessary runtime functions will be injected and the template will be ready to be executed.
+func (c *Compiler) CompileWithFile() (*template.Template, error) {
+	return c.CompileWithName(filepath.Base(c.filename))
+}
+
+// Same as Compile but allows to specify a name for the template
+func (c *Compiler) CompileWithName(name string) (*template.Template, error) {
+	return c.CompileWithTemplate(template.New(name))
+}
+
+// Same as Compile but allows to specify a template
+func (c *Compiler) CompileWithTemplate(t *template.Template) (_ *template.Template, err error) {
+	_, span := startSpan(context.Background(), c.TracerProvider, "slim.Compile", t.Name())
+	start := time.Now()
+
+	defer func() {
+		endSpan(span, err)
+
+		if c.Metrics != nil {
+			c.Metrics.TemplateCompiled(t.Name(), time.Since(start), err)
+		}
+	}()
+
+	data, err := c.String()
+	if err != nil {
+		return nil, err
+	}
+
+	tpl, err := t.Funcs(runtime.FuncMap()).Funcs(runtime.Fragments(t, c.Cache)).Funcs(c.imageFuncs()).Funcs(c.funcs).Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the source map for ExplainExecError and the digest along with the template
+	if err = attachSourceMap(tpl, c.sourceMap); err != nil {
+		return nil, err
+	}
+
+	if err = attach(tpl, "digest", c.digest); err != nil {
+		return nil, err
+	}
+
+	return tpl, nil
+}
+
+// imageFuncs returns the image_tag helper bound to Options.ImageManifest, closing the element as void tags of
+// the format and checking its URLs by URLSchemes if CheckURLs is set.
+func (c *Compiler) imageFuncs() template.FuncMap {
+	options := runtime.ImageOptions{Manifest: c.ImageManifest, XHTML: c.Format != parser.FORMAT_HTML}
+
+	if c.CheckURLs {
+		schemes := c.URLSchemes
+		if schemes == nil {
+			schemes = DefaultURLSchemes
+		}
+
+		options.Schemes = strings.Join(schemes, ",")
+	}
+
+	return template.FuncMap{"image_tag": runtime.NewImageTag(options)}
+}
+
+// Returns the runtime functions compiled templates depend on.
+// It is a shorthand for runtime.FuncMap.
+func FuncMap() template.FuncMap {
+	return runtime.FuncMap()
+}
+
+func (c *Compiler) visit(node parser.Noder) {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(parser.NewError(r, node.Pos()))
+		}
+	}()
+
+	start := c.buffer.Len()
+	defer c.mapNode(node, start)
+
+	switch node.(type) {
+	case *parser.Doctype:
+		c.visitDoctype(node.(*parser.Doctype))
+	case *parser.Comment:
+		c.visitComment(node.(*parser.Comment))
+	case *parser.Tag:
+		c.visitTag(node.(*parser.Tag))
+	case *parser.Text:
+		c.visitText(node.(*parser.Text))
+	case *parser.Block:
+		c.visitBlock(node.(*parser.Block))
+	case *parser.Condition:
+		c.visitCondition(node.(*parser.Condition))
+	case *parser.Assignment:
+		c.visitAssignment(node.(*parser.Assignment))
+	case *parser.Range:
+		c.visitRange(node.(*parser.Range))
+	case *parser.Let:
+		c.visitLet(node.(*parser.Let))
+	case *parser.While:
+		c.visitWhile(node.(*parser.While))
+	case *parser.Cache:
+		c.visitCache(node.(*parser.Cache))
+	case *parser.ContentFor:
+		c.visitContentFor(node.(*parser.ContentFor))
+	case *parser.Define:
+		c.visitDefine(node.(*parser.Define))
+	case *parser.Embed:
+		c.visitEmbed(node.(*parser.Embed))
+	case *parser.Yield:
+		c.visitYield(node.(*parser.Yield))
+	case *parser.Filter:
+		c.visitFilter(node.(*parser.Filter))
+	case *parser.Passthrough:
+		c.visitPassthrough(node.(*parser.Passthrough))
+	case *parser.Mixin:
+		c.visitMixin(node.(*parser.Mixin))
+	case *parser.MixinCall:
+		c.visitMixinCall(node.(*parser.MixinCall))
+	case *parser.Slot:
+		c.visitSlot(node.(*parser.Slot))
+	case *parser.RawFile:
+		c.visitRawFile(node.(*parser.RawFile))
+	}
+}
+
+// mapNode records the output written for node since start in the source map.
+func (c *Compiler) mapNode(node parser.Noder, start int) {
+	pos := node.Pos()
+	end := c.buffer.Len()
+
+	if c.sourceMap == nil || pos.Line == 0 || end == start {
+		return
+	}
+
+	c.sourceMap.Mappings = append(c.sourceMap.Mappings, Mapping{start, end, pos.Filename, pos.Line, pos.Column})
+}
+
+func (c *Compiler) write(value string) {
+	c.buffer.WriteString(value)
+}
+
+func (c *Compiler) indent(offset int, newline bool) {
+	if !c.Pretty || c.Minify || c.verbatim > 0 || c.Format == parser.FORMAT_TEXT {
+		return
+	}
+
+	if newline && c.buffer.Len() > 0 {
+		c.write("\n")
+	}
+
+	for i := 0; i < c.level+offset; i++ {
+		c.write("\t")
+	}
+}
+
+func (c *Compiler) tempvar() string {
+	c.tempvarIndex++
+	return "$__slim_" + strconv.Itoa(c.tempvarIndex)
+}
+
+// action wraps the body of a control action into delimiters, with trim markers if enabled in compact mode.
+func (c *Compiler) action(body string) string {
+	if c.TrimMarkers && (!c.Pretty || c.Minify) && c.verbatim == 0 {
+		return `{{- ` + body + ` -}}`
+	}
+
+	return `{{` + body + `}}`
+}
+
+func (c *Compiler) escape(input string) string {
+	return strings.Replace(strings.Replace(input, `\`, `\\`, -1), `"`, `\"`, -1)
+}
+
+// hasDoctype reports whether the template declares a doctype at its top level.
+func (c *Compiler) hasDoctype() bool {
+	return c.topDoctype() != nil
+}
+
+// topDoctype returns the doctype the template declares at its top level, nil if there is none.
+func (c *Compiler) topDoctype() *parser.Doctype {
+	block, ok := c.node.(*parser.Block)
+	if !ok {
+		doctype, _ := c.node.(*parser.Doctype)
+		return doctype
+	}
+
+	for _, child := range block.Children {
+		if doctype, ok := child.(*parser.Doctype); ok {
+			return doctype
+		}
+	}
+
+	return nil
+}
+
+func (c *Compiler) visitDoctype(doctype *parser.Doctype) {
+	if c.Format == parser.FORMAT_TEXT {
+		return
+	}
+
+	if len(c.Format) > 0 {
+		formatted := *doctype
+		formatted.Format = c.Format
+		doctype = &formatted
+	}
+
+	c.write(doctype.String())
+}
+
+// isXMLDoctype reports whether the doctype is output as an xml directive or as one of xhtml in the default format.
+func isXMLDoctype(doctype *parser.Doctype) bool {
+	output := doctype.String()
+	return strings.HasPrefix(output, "<?xml") || strings.Contains(output, "XHTML")
+}
+
+// expandsAttributes reports whether attributes without value are expanded as xhtml requires, i.e. checked="checked":
+// in xhtml format, and in the default format with an xml or xhtml doctype. Minified output keeps them short.
+func (c *Compiler) expandsAttributes() bool {
+	if c.Minify {
+		return false
+	}
+
+	switch c.Format {
+	case parser.FORMAT_XHTML:
+		return true
+	case "":
+		return c.xmlDoctype
+	}
+
+	return false
+}
+
+func (c *Compiler) visitComment(comment *parser.Comment) {
+	// code comments are for template authors only, plain text has no comments at all
+	if comment.Silent || c.Format == parser.FORMAT_TEXT {
+		return
+	}
+
+	if !comment.IsConditional() && !comment.Keep && (c.Minify || c.StripComments) {
+		return
+	}
+
+	c.indent(0, true)
+
+	// conditional comments take effect in browsers, minifying and stripping keeps them
+	if comment.IsConditional() {
+		c.visitConditionalComment(comment)
+	} else {
+		c.visitHTMLComment(comment)
+	}
+}
+
+// visitHTMLComment writes a comment holding its text and the markup nested below it.
+func (c *Compiler) visitHTMLComment(comment *parser.Comment) {
+	open := "<!--"
+	if len(comment.Value) > 0 {
+		open += " " + comment.Value
+	}
+
+	if comment.Block == nil {
+		c.write(`{{unescaped ` + strconv.Quote(open+" -->") + `}}`)
+		return
+	}
+
+	c.writeComment(open, "-->", " ", comment.Block)
+}
+
+// visitConditionalComment writes a conditional comment holding the text following its condition and the markup
+// nested below it.
+func (c *Compiler) visitConditionalComment(comment *parser.Comment) {
+	block := comment.Block
+
+	if len(comment.Value) > 0 {
+		block = new(parser.Block)
+		block.Children = []parser.Noder{&parser.Text{SourcePosition: comment.SourcePosition, Value: comment.Value}}
+
+		if comment.Block != nil {
+			block.Children = append(block.Children, comment.Block.Children...)
+		}
+	}
+
+	c.writeComment(strings.TrimSpace(comment.Wrapper.L), strings.TrimSpace(comment.Wrapper.R), "", block)
+}
+
+// writeComment writes the markers of a comment as trusted code around its content, html/template drops comments
+// of the template itself. The content spans lines of its own when pretty printing, unless it is inline, and is
+// separated from the markers by space otherwise.
+func (c *Compiler) writeComment(open, close, space string, block *parser.Block) {
+	if block == nil {
+		c.write(`{{unescaped ` + strconv.Quote(open+close) + `}}`)
+		return
+	}
+
+	if !c.Pretty || c.canInline(block) {
+		c.write(`{{unescaped ` + strconv.Quote(open+space) + `}}`)
+		c.inline++
+		c.visitBlock(block)
+		c.inline--
+		c.write(`{{unescaped ` + strconv.Quote(space+close) + `}}`)
+		return
+	}
+
+	c.write(`{{unescaped ` + strconv.Quote(open) + `}}`)
+	c.level++
+	c.visitBlock(block)
+	c.level--
+	c.indent(0, true)
+	c.write(`{{unescaped ` + strconv.Quote(close) + `}}`)
+}
+
+func (c *Compiler) visitTag(tag *parser.Tag) {
+	if c.Format == parser.FORMAT_TEXT {
+		c.visitPlainTag(tag)
+		return
+	}
+
+	type attrib struct {
+		name      string
+		value     string
+		condition string
+		// expression whose value is checked for presence before the attribute is output
+		expr string
+	}
+
+	attribs := make(map[string]*attrib)
+	// names of attribs in the order of their declaration
+	var names []string
+
+	// class names of shorthands and class attributes are merged into a single attribute,
+	// literal names at compile time, expressions at render time
+	var (
+		classes []string
+		dynamic bool
+	)
+
+	for _, item := range tag.Attributes {
+		attr := new(attrib)
+		attr.name = item.Name
+
+		var expr string
+		if !item.IsRaw {
+			expr = c.visitRawInterpolation(item.Value)
+			if c.CheckURLs && urlAttributes[item.Name] {
+				expr = c.checkURL(expr)
+			}
+
+			attr.value = `{{` + expr + `}}`
+		} else if item.Value == "" || c.Minify && booleanAttributes[item.Name] && item.Value == item.Name {
+			attr.value = ""
+		} else {
+			expr = c.staticValue(item.Name, item.Value)
+			attr.value = `{{` + expr + `}}`
+		}
+
+		if len(item.Condition) != 0 {
+			attr.condition = c.visitRawInterpolation(item.Condition)
+		}
+
+		// the expression of a boolean attribute may decide whether it is present rather than giving its value
+		if c.BooleanAttributes && !item.IsRaw && booleanAttributes[item.Name] {
+			if len(attr.condition) > 0 {
+				attr.condition = `(and (` + attr.condition + `) (` + expr + `))`
+			} else {
+				attr.condition = expr
+			}
+
+			attr.value, expr = "", ""
+		}
+
+		if attr.name != "class" {
+			if c.OmitEmptyAttributes && !item.IsRaw {
+				attr.expr = expr
+			}
+
+			if prev := attribs[item.Name]; prev != nil {
+				switch c.DuplicateAttributes {
+				case DuplicateAttributeFirst:
+					continue
+				case DuplicateAttributeMerge:
+					prev.value = c.conditional(prev.condition, prev.value) + c.conditional(attr.condition, ` `+attr.value)
+					prev.condition, prev.expr = "", ""
+					continue
+				case DuplicateAttributeError:
+					panic(fmt.Sprintf("Duplicate attribute %s.", item.Name))
+				}
+			}
+
+			if attribs[item.Name] == nil {
+				names = append(names, item.Name)
+			}
+
+			attribs[item.Name] = attr
+			continue
+		}
+
+		if len(attr.condition) > 0 {
+			expr = `(and ` + attr.condition + ` ` + expr + `)`
+		}
+
+		classes = append(classes, expr)
+		dynamic = dynamic || !item.IsRaw || len(attr.condition) > 0
+
+		if prevclass := attribs["class"]; prevclass == nil {
+			names = append(names, "class")
+			attribs["class"] = attr
+		} else if dynamic {
+			prevclass.value = `{{__slim_class ` + strings.Join(classes, " ") + `}}`
+			prevclass.condition = ""
+		} else {
+			prevclass.value += ` ` + attr.value
+		}
+	}
+
+	if tag.ForwardAttributes {
+		if c.mixins == 0 {
+			panic("&attributes must be placed within a mixin.")
+		}
+
+		// classes passed by the call add to those of the tag
+		if class := attribs["class"]; class != nil {
+			class.value = `{{__slim_class ` + strings.Join(append(classes, `$.Class`), " ") + `}}`
+			class.condition = ""
+		}
+	}
+
+	if c.inline == 0 {
+		c.indent(0, true)
+	}
+
+	c.write("<" + tag.Name)
+
+	serialized := make([]string, 0, len(names))
+	for _, name := range names {
+		attr := c.serializeAttribute(name, attribs[name].value, attribs[name].condition, attribs[name].expr)
+		if tag.ForwardAttributes && name != "class" {
+			// attributes passed by the call take precedence
+			attr = `{{if not ($.HasAttribute ` + strconv.Quote(name) + `)}}` + attr + `{{end}}`
+		}
+
+		serialized = append(serialized, attr)
+	}
+
+	wrap := c.wrapAttributes(tag, serialized)
+	for _, attr := range serialized {
+		if wrap {
+			c.indent(1, true)
+			attr = strings.TrimPrefix(attr, " ")
+		}
+
+		c.write(attr)
+	}
+
+	if tag.ForwardAttributes {
+		c.writeForwardedAttributes(attribs["class"] != nil)
+	}
+
+	if c.isVoid(tag) {
+		if c.Format == parser.FORMAT_HTML {
+			c.write(`>`)
+		} else {
+			c.write(` />`)
+		}
+	} else {
+		c.write(`>`)
+
+		if tag.Block != nil {
+			inline := c.canInline(tag.Block)
+			if inline {
+				c.inline++
+			} else {
+				c.level++
+			}
+
+			// content of whitespace sensitive elements is neither indented nor broken into lines
+			verbatim := whitespaceElements[tag.Name]
+			if verbatim {
+				c.verbatim++
+			}
+
+			wrapper := c.wrapper(tag)
+			if wrapper != nil {
+				c.writeWrapper(tag, wrapper.L)
+			}
+
+			if preservedElements[tag.Name] {
+				c.preserve++
+				c.visitBlock(tag.Block)
+				c.preserve--
+			} else {
+				c.visitBlock(tag.Block)
+			}
+
+			if wrapper != nil {
+				c.writeWrapper(tag, wrapper.R)
+			}
+
+			if inline {
+				c.inline--
+			} else {
+				c.level--
+				c.indent(0, true)
+			}
+
+			if verbatim {
+				c.verbatim--
+			}
+		}
+
+		c.write(`</` + tag.Name + `>`)
+	}
+}
+
+// serializeAttribute returns an attribute of a tag as written into the generated template, with a leading space.
+func (c *Compiler) serializeAttribute(name, value, condition, expr string) string {
+	var attr string
+
+	if len(condition) > 0 {
+		attr += `{{if ` + condition + `}}`
+	}
+
+	if len(expr) > 0 {
+		present := c.tempvar()
+		attr += `{{` + present + ` := ` + expr + `}}{{if __slim_present ` + present + `}}`
+		value = `{{` + present + `}}`
+	}
+
+	if value == "" && c.expandsAttributes() {
+		// xhtml has no attributes without value, boolean ones are expanded as checked="checked"
+		attr += ` ` + name + `=` + c.quote() + name + c.quote()
+	} else if value == "" {
+		attr += ` ` + name
+	} else {
+		attr += ` ` + name + `=` + c.quote() + value + c.quote()
+	}
+
+	if len(expr) > 0 {
+		attr += `{{end}}`
+	}
+
+	if len(condition) > 0 {
+		attr += `{{end}}`
+	}
+
+	return attr
+}
+
+// writeForwardedAttributes outputs the attributes passed by the call of the mixin being compiled as attributes of
+// the template, so html/template escapes each of them in its context. Those escaped as URLs or CSS are output by
+// name, the others by ranging over them.
+func (c *Compiler) writeForwardedAttributes(class bool) {
+	except := ""
+	if class {
+		except = ` "class"`
+	}
+
+	bare := ` {{.Name}}`
+	if c.expandsAttributes() {
+		bare = ` {{.Name}}=` + c.quote() + `{{.Name}}` + c.quote()
+	}
+
+	c.write(`{{range $.Attributes` + except + `}}{{if .Bare}}` + bare + `{{else}} {{.Name}}=` + c.quote() + `{{.Value}}` + c.quote() + `{{end}}{{end}}`)
+
+	for _, name := range runtime.ContextAttributes {
+		c.write(`{{with $.Attribute ` + strconv.Quote(name) + `}} ` + name + `=` + c.quote() + `{{.}}` + c.quote() + `{{end}}`)
+	}
+}
+
+// wrapAttributes reports whether the attributes of a tag are put on lines of their own, as its line would exceed
+// Options.AttributeWrapColumn otherwise.
+func (c *Compiler) wrapAttributes(tag *parser.Tag, attributes []string) bool {
+	if c.AttributeWrapColumn <= 0 || len(attributes) < 2 || !c.Pretty || c.Minify || c.inline > 0 || c.verbatim > 0 {
+		return false
+	}
+
+	width := c.level + len("<"+tag.Name+">")
+	for _, attr := range attributes {
+		width += len(attr)
+	}
+
+	return width > c.AttributeWrapColumn
+}
+
+// checkURL wraps the expression of a URL attribute into the check of Options.CheckURLs.
+func (c *Compiler) checkURL(expr string) string {
+	schemes := c.URLSchemes
+	if schemes == nil {
+		schemes = DefaultURLSchemes
+	}
+
+	return `__slim_url (` + expr + `) ` + strconv.Quote(strings.Join(schemes, ","))
+}
+
+// Types of script and style content enclosed in a wrapper, others like JSON or client side templates are left alone.
+var wrappedTypes = map[string]bool{
+	"text/javascript":        true,
+	"application/javascript": true,
+	"module":                 true,
+	"text/css":               true,
+}
+
+// wrapper returns the wrapper enclosing the content of a script or style tag in xhtml format, nil if there is none.
+func (c *Compiler) wrapper(tag *parser.Tag) *parser.Wrapper {
+	if c.Format != parser.FORMAT_XHTML {
+		return nil
+	}
+
+	for _, attr := range tag.Attributes {
+		if attr.Name == "type" && !(attr.IsRaw && wrappedTypes[strings.ToLower(attr.Value)]) {
+			return nil
+		}
+	}
+
+	switch tag.Name {
+	case "script":
+		if c.ScriptWrapper != nil {
+			return c.ScriptWrapper
+		}
+
+		return parser.NewCdataWrapper()
+	case "style":
+		if c.StyleWrapper != nil {
+			return c.StyleWrapper
+		}
+
+		return parser.NewCssCdataWrapper()
+	}
+
+	return nil
+}
+
+// writeWrapper writes a part of the wrapper of script or style content as trusted code, the escaper of
+// html/template drops it as a comment otherwise.
+func (c *Compiler) writeWrapper(tag *parser.Tag, value string) {
+	// the pretty printer breaks the line after it
+	if c.Pretty && !c.Minify {
+		value = strings.TrimSuffix(value, "\n")
+	}
+
+	helper := "__slim_js"
+	if tag.Name == "style" {
+		helper = "__slim_css"
+	}
+
+	c.write(`{{` + helper + ` ` + strconv.Quote(value) + `}}`)
+}
+
+func (c *Compiler) visitText(text *parser.Text) {
+	value := text.Value
+	if c.Format == parser.FORMAT_TEXT {
+		value = html.UnescapeString(value)
+	}
+	if c.Minify && c.preserve == 0 {
+		value = collapseWhitespace(value)
+	}
+
+	if !c.PassthroughActions {
+		value = rdelimiter.ReplaceAllStringFunc(value, func(value string) string {
+			return `{{"{{"}}` + value[2:len(value)-2] + `{{"}}"}}`
+		})
+	}
+
+	if c.Format == parser.FORMAT_TEXT {
+		value = c.plainLiterals(value)
+	}
+
+	value = rinterpolate.ReplaceAllStringFunc(value, func(value string) string {
+		return c.visitInterpolation(value[2 : len(value)-1])
+	})
+
+	lines := strings.Split(value, "\n")
+	for i := 0; i < len(lines); i++ {
+		c.write(lines[i])
+
+		if i < len(lines)-1 {
+			c.write("\n")
+			c.indent(0, false)
+		}
+	}
+}
+
+func (c *Compiler) visitBlock(block *parser.Block) {
+	// options of a file pragma apply to the nodes of that file only
+	if block.Options != nil {
+		defer func(options Options) {
+			c.Options = options
+		}(c.Options)
+
+		c.applyPragma(block.Options)
+	}
+
+	// whether the block is inline is worked out on its first text, not again for each of them
+	var inline, checked bool
+
+	for i, node := range block.Children {
+		if _, ok := node.(*parser.Text); ok {
+			if !checked {
+				inline, checked = c.inline > 0 || c.canInline(block), true
+			}
+
+			if _, joined := previous(block, i).(*parser.Text); joined && c.TextJoin != TextJoinLayout {
+				c.joinText()
+			} else if !inline {
+				c.indent(0, true)
+			}
+		}
+
+		c.visit(node)
+	}
+}
+
+// previous returns the child of block preceding the one at index i, nil if there is none.
+func previous(block *parser.Block, i int) parser.Noder {
+	if i == 0 {
+		return nil
+	}
 
-	return result, nil
+	return block.Children[i-1]
 }
 
-// Compile slim and create a Go Template (html/templates) instance.
-// Necessary runtime functions will be injected and the template will be ready to be executed.
-func (c *Compiler) CompileWithFile() (*template.Template, error) {
-	return c.CompileWithName(filepath.Base(c.filename))
+// joinText writes the separator of consecutive lines of piped text by Options.TextJoin.
+func (c *Compiler) joinText() {
+	switch c.TextJoin {
+	case TextJoinNewline:
+		c.write("\n")
+		c.indent(0, false)
+	case TextJoinSpace:
+		c.write(" ")
+	}
 }
 
-// Same as Compile but allows to specify a name for the template
-func (c *Compiler) CompileWithName(name string) (*template.Template, error) {
-	return c.CompileWithTemplate(template.New(name))
+// conditional wraps value into an if action when condition is given.
+func (c *Compiler) conditional(condition, value string) string {
+	if len(condition) == 0 {
+		return value
+	}
+
+	return `{{if ` + condition + `}}` + value + `{{end}}`
 }
 
-// Same as Compile but allows to specify a template
-func (c *Compiler) CompileWithTemplate(t *template.Template) (*template.Template, error) {
-	data, err := c.String()
-	if err != nil {
-		return nil, err
+// quote returns the quote character of attribute values.
+func (c *Compiler) quote() string {
+	if c.AttributeQuote == "'" {
+		return "'"
 	}
 
-	tpl, err := t.Funcs(funcMap).Parse(data)
-	if err != nil {
-		return nil, err
+	return `"`
+}
+
+// isVoid reports whether tag is closed within its start tag.
+func (c *Compiler) isVoid(tag *parser.Tag) bool {
+	if c.voids != nil {
+		return c.voids[tag.Name]
 	}
 
-	return tpl, nil
+	return tag.IsAutoclose()
 }
 
-func (c *Compiler) visit(node parser.Noder) {
-	defer func() {
-		if r := recover(); r != nil {
-			if rs, ok := r.(string); ok && rs[:len("Slim Error")] == "Slim Error" {
-				panic(r)
+// canInline reports whether the content of block stays on one line when pretty printing,
+// which is the case for text mixed with inline elements only.
+func (c *Compiler) canInline(block *parser.Block) bool {
+	for _, child := range block.Children {
+		switch child := child.(type) {
+		case *parser.Text:
+			if child.IsRaw {
+				return false
 			}
-
-			pos := node.Pos()
-
-			if len(pos.Filename) > 0 {
-				panic(fmt.Sprintf("Slim Error in <%s>: %v - Line: %d, Column: %d, Length: %d", pos.Filename, r, pos.Line, pos.Column, pos.TokenLength))
-			} else {
-				panic(fmt.Sprintf("Slim Error: %v - Line: %d, Column: %d, Length: %d", r, pos.Line, pos.Column, pos.TokenLength))
+		case *parser.Tag:
+			if !c.inlines[child.Name] || child.Block != nil && !c.canInline(child.Block) {
+				return false
+			}
+		case *parser.Block:
+			// named blocks and pragma scopes are transparent
+			if !c.canInline(child) {
+				return false
 			}
+		default:
+			return false
 		}
-	}()
-
-	switch node.(type) {
-	case *parser.Doctype:
-		c.visitDoctype(node.(*parser.Doctype))
-	case *parser.Comment:
-		c.visitComment(node.(*parser.Comment))
-	case *parser.Tag:
-		c.visitTag(node.(*parser.Tag))
-	case *parser.Text:
-		c.visitText(node.(*parser.Text))
-	case *parser.Block:
-		c.visitBlock(node.(*parser.Block))
-	case *parser.Condition:
-		c.visitCondition(node.(*parser.Condition))
-	case *parser.Assignment:
-		c.visitAssignment(node.(*parser.Assignment))
-	case *parser.Range:
-		c.visitRange(node.(*parser.Range))
 	}
+
+	return true
 }
 
-func (c *Compiler) write(value string) {
-	c.buffer.WriteString(value)
+func (c *Compiler) visitCondition(condition *parser.Condition) {
+	c.write(c.action(`if ` + c.visitRawInterpolation(condition.Expression)))
+
+	c.pushScope()
+	c.visitBlock(condition.Positive)
+	c.popScope()
+
+	if condition.Negative != nil {
+		c.write(c.action(`else`))
+
+		c.pushScope()
+		c.visitBlock(condition.Negative)
+		c.popScope()
+	}
+
+	c.write(c.action(`end`))
 }
 
-func (c *Compiler) indent(offset int, newline bool) {
-	if !c.Pretty {
+func (c *Compiler) visitAssignment(assignment *parser.Assignment) {
+	if c.dataType != nil {
+		t := c.expressionType(assignment.Expression)
+		if len(assignment.Variables) > 1 {
+			_, t = rangeTypes(t)
+		}
+
+		for _, name := range assignment.Variables {
+			c.typeScope().vars[name] = t
+		}
+	}
+
+	value := c.visitRawInterpolation(assignment.Expression)
+	if len(assignment.Variables) == 1 {
+		c.assign(assignment.Variable, value, assignment.Declare)
 		return
 	}
 
-	if newline && c.buffer.Len() > 0 {
-		c.write("\n")
+	elements := c.tempvar()
+	c.write(c.action(elements + ` := ` + value))
+
+	for i, name := range assignment.Variables {
+		c.assign(name, `index `+elements+` `+strconv.Itoa(i), assignment.Declare)
 	}
+}
 
-	for i := 0; i < c.level+offset; i++ {
-		c.write("\t")
+// assign assigns value to the variable name if declared in an enclosing scope, unless declare is set,
+// or declares it in the current scope otherwise.
+func (c *Compiler) assign(name, value string, declare bool) {
+	if !declare && c.declared(name) {
+		c.write(c.action(name + ` = ` + value))
+		return
 	}
+
+	c.declare(name)
+	c.write(c.action(name + ` := ` + value))
 }
 
-func (c *Compiler) tempvar() string {
-	c.tempvarIndex++
-	return "$__slim_" + strconv.Itoa(c.tempvarIndex)
+// pushScope opens a scope for variables, as the blocks of if and range actions do.
+func (c *Compiler) pushScope() {
+	c.scopes = append(c.scopes, make(map[string]bool))
 }
 
-func (c *Compiler) escape(input string) string {
-	return strings.Replace(strings.Replace(input, `\`, `\\`, -1), `"`, `\"`, -1)
+func (c *Compiler) popScope() {
+	c.scopes = c.scopes[:len(c.scopes)-1]
 }
 
-func (c *Compiler) visitDoctype(doctype *parser.Doctype) {
-	c.write(doctype.String())
+func (c *Compiler) declare(name string) {
+	if len(c.scopes) == 0 {
+		c.pushScope()
+	}
+
+	c.scopes[len(c.scopes)-1][name] = true
 }
 
-func (c *Compiler) visitComment(comment *parser.Comment) {
-	if comment.Silent {
+func (c *Compiler) declared(name string) bool {
+	for _, scope := range c.scopes {
+		if scope[name] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Compiler) visitRange(iter *parser.Range) {
+	if iter.Block == nil {
 		return
 	}
 
-	c.indent(0, false)
+	collection := c.visitRawInterpolation(iter.Expression)
 
-	if comment.Block == nil {
-		c.write(`{{unescaped "<!-- ` + c.escape(comment.Value) + ` -->"}}`)
+	// $loop is only provided to blocks referring to it, counting iterations in a variable of its own
+	loop := usesLoop(iter.Block)
+	counter := ""
+	if loop {
+		value := collection
+		collection, counter = c.tempvar(), c.tempvar()
+
+		c.write(c.action(collection + ` := ` + value))
+		c.write(c.action(counter + ` := __slim_loop ` + collection))
+	}
+
+	if len(iter.Value) == 0 {
+		c.write(c.action(`range ` + iter.Key + ` := ` + collection))
 	} else {
-		c.write(`<!-- ` + comment.Value)
-		c.visitBlock(comment.Block)
-		c.write(` -->`)
+		c.write(c.action(`range ` + iter.Key + `, ` + iter.Value + ` := ` + collection))
 	}
-}
 
-func (c *Compiler) visitTag(tag *parser.Tag) {
-	type attrib struct {
-		name      string
-		value     string
-		condition string
+	if loop {
+		c.write(c.action(counter + ` = ` + counter + `.Next`))
+		c.write(c.action(`$loop := ` + counter))
 	}
 
-	attribs := make(map[string]*attrib)
+	if c.dataType != nil {
+		t := c.expressionType(iter.Expression)
+		if t.t != nil && t.t.Kind() == reflect.Chan && len(iter.Value) > 0 {
+			panic("Unable to iterate over a channel with an index variable.")
+		}
 
-	for _, item := range tag.Attributes {
-		attr := new(attrib)
-		attr.name = item.Name
+		key, elem := rangeTypes(t)
 
-		if !item.IsRaw {
-			attr.value = c.visitInterpolation(item.Value)
-		} else if item.Value == "" {
-			attr.value = ""
+		c.pushTypeScope(elem)
+		defer c.popTypeScope()
+
+		if len(iter.Value) == 0 {
+			c.typeScope().vars[iter.Key] = elem
 		} else {
-			attr.value = `{{"` + item.Value + `"}}`
+			c.typeScope().vars[iter.Key] = key
+			c.typeScope().vars[iter.Value] = elem
 		}
 
-		if len(item.Condition) != 0 {
-			attr.condition = c.visitRawInterpolation(item.Condition)
+		if loop {
+			c.typeScope().vars["$loop"] = valueType{t: reflect.TypeOf(runtime.Loop{})}
 		}
+	}
 
-		if attr.name == "class" && attribs["class"] != nil {
-			prevclass := attribs["class"]
-			attr.value = ` ` + attr.value
+	c.pushScope()
+	defer c.popScope()
 
-			if len(attr.condition) > 0 {
-				attr.value = `{{if ` + attr.condition + `}}` + attr.value + `{{end}}`
-				attr.condition = ""
-			}
+	c.declare(iter.Key)
+	if len(iter.Value) > 0 {
+		c.declare(iter.Value)
+	}
 
-			if len(prevclass.condition) > 0 {
-				prevclass.value = `{{if ` + prevclass.condition + `}}` + prevclass.value + `{{end}}`
-				prevclass.condition = ""
-			}
+	if loop {
+		c.declare("$loop")
+	}
 
-			prevclass.value = prevclass.value + attr.value
-		} else {
-			attribs[item.Name] = attr
-		}
+	c.visitBlock(iter.Block)
+
+	c.write(c.action(`end`))
+}
+
+// Let blocks are compiled into an if action which always holds, scoping the variables declared within.
+func (c *Compiler) visitLet(let *parser.Let) {
+	if let.Block == nil {
+		return
 	}
 
-	c.indent(0, true)
-	c.write("<" + tag.Name)
+	c.write(c.action(`if true`))
+	c.pushScope()
 
-	for name, value := range attribs {
-		if len(value.condition) > 0 {
-			c.write(`{{if ` + value.condition + `}}`)
-		}
+	if c.dataType != nil {
+		c.pushTypeScope(c.typeScope().dot)
+		defer c.popTypeScope()
+	}
 
-		if value.value == "" {
-			c.write(` ` + name)
-		} else {
-			c.write(` ` + name + `="` + value.value + `"`)
-		}
+	c.visitAssignment(&parser.Assignment{
+		SourcePosition: let.SourcePosition,
+		Variable:       let.Variables[0],
+		Variables:      let.Variables,
+		Expression:     let.Expression,
+		Declare:        true,
+	})
 
-		if len(value.condition) > 0 {
-			c.write(`{{end}}`)
-		}
+	c.visitBlock(let.Block)
+
+	c.popScope()
+	c.write(c.action(`end`))
+}
+
+// While loops range over a runtime iterator repeating the data, breaking out as soon as the condition
+// is done, so variables assigned within the block carry over to the next check of the condition.
+func (c *Compiler) visitWhile(loop *parser.While) {
+	if loop.Block == nil {
+		return
+	}
+
+	limit := c.MaxLoopIterations
+	if limit <= 0 {
+		limit = DefaultMaxLoopIterations
 	}
 
-	if tag.IsAutoclose() {
-		c.write(` />`)
+	iteration, dot := c.tempvar(), c.tempvar()
+	c.write(c.action(`range ` + iteration + `, ` + dot + ` := __slim_while . ` + strconv.Itoa(limit)))
+
+	condition := c.visitRawInterpolation(loop.Expression)
+	if loop.Until {
+		c.write(c.action(`if ` + condition) + c.action(`break`) + c.action(`end`))
 	} else {
-		c.write(`>`)
+		c.write(c.action(`if not ` + condition) + c.action(`break`) + c.action(`end`))
+	}
 
-		if tag.Block != nil {
-			if !tag.Block.CanInline() {
-				c.level++
-			}
+	c.write(c.action(`__slim_loop_limit ` + iteration + ` ` + strconv.Itoa(limit)))
 
-			c.visitBlock(tag.Block)
+	c.pushScope()
+	c.visitBlock(loop.Block)
+	c.popScope()
 
-			if !tag.Block.CanInline() {
-				c.level--
-				c.indent(0, true)
-			}
-		}
+	c.write(c.action(`end`))
+}
 
-		c.write(`</` + tag.Name + `>`)
+// usesLoop reports whether any expression of block refers to the $loop variable.
+func usesLoop(block *parser.Block) bool {
+	used := false
+	walkExpressions(block, func(node parser.Noder, expr string) {
+		used = used || rloopvar.MatchString(expr)
+	})
+
+	return used
+}
+
+// placeholder of the prefix of cache keys, replaced by the digest of the template once compiled
+const cacheKeyPrefix = "__slim_cache_key"
+
+// Fragments of cache directives are compiled into templates of their own, rendered and cached
+// by the __slim_cache runtime function. They get the data of the enclosing scope.
+func (c *Compiler) visitCache(cache *parser.Cache) {
+	if cache.Block == nil {
+		return
 	}
+
+	ttl := strconv.FormatInt(int64(cache.TTL), 10)
+	name, data := c.visitFragment(cache.Block)
+
+	// keys are prefixed by the template, so blocks of different templates using the same key keep apart
+	key := `(print ` + strconv.Quote(c.namespace+cacheKeyPrefix+":") + ` (` + c.visitRawInterpolation(cache.Key) + `))`
+
+	c.write(`{{__slim_cache ` + key + ` ` + ttl + ` "` + name + `" ` + data + `}}`)
 }
 
-func (c *Compiler) visitText(text *parser.Text) {
-	value := rdelimiter.ReplaceAllStringFunc(text.Value, func(value string) string {
-		return `{{"{{"}}` + value[2:len(value)-2] + `{{"}}"}}`
-	})
+// content_for blocks are compiled into templates of their own as well. Their output is moved
+// to the matching yield points once the whole template has been rendered.
+func (c *Compiler) visitContentFor(content *parser.ContentFor) {
+	c.layout = true
 
-	value = rinterpolate.ReplaceAllStringFunc(value, func(value string) string {
-		return c.visitInterpolation(value[2 : len(value)-1])
-	})
+	if content.Block == nil {
+		return
+	}
 
-	lines := strings.Split(value, "\n")
-	for i := 0; i < len(lines); i++ {
-		c.write(lines[i])
+	name, data := c.visitFragment(content.Block)
+	c.write(`{{__slim_content_for "` + content.Name + `" "` + name + `" ` + data + `}}`)
+}
 
-		if i < len(lines)-1 {
-			c.write("\n")
-			c.indent(0, false)
-		}
+func (c *Compiler) visitYield(yield *parser.Yield) {
+	c.layout = true
+	c.write(`{{__slim_yield "` + yield.Name + `"}}`)
+}
+
+// visitDefine compiles the block of a define directive aside into a template of the given name.
+func (c *Compiler) visitDefine(define *parser.Define) {
+	block := define.Block
+	if block == nil {
+		block = new(parser.Block)
 	}
+
+	c.compileFragment(define.Name, block)
 }
 
-func (c *Compiler) visitBlock(block *parser.Block) {
-	for _, node := range block.Children {
-		if _, ok := node.(*parser.Text); !block.CanInline() && ok {
+// visitEmbed executes the template of given name, passing it the result of the expression or the current dot.
+func (c *Compiler) visitEmbed(embed *parser.Embed) {
+	value := "."
+	if len(embed.Expression) > 0 {
+		value = c.visitRawInterpolation(embed.Expression)
+	}
+
+	c.write(`{{template ` + strconv.Quote(embed.Name) + ` ` + value + `}}`)
+}
+
+// visitPassthrough writes go template code as is, its actions are not escaped like those of text.
+func (c *Compiler) visitPassthrough(passthrough *parser.Passthrough) {
+	if c.inline == 0 {
+		c.indent(0, true)
+	}
+
+	lines := strings.Split(passthrough.Value, "\n")
+	for i, line := range lines {
+		if i > 0 {
 			c.indent(0, true)
 		}
 
-		c.visit(node)
+		c.write(line)
 	}
 }
 
-func (c *Compiler) visitCondition(condition *parser.Condition) {
-	c.write(`{{if ` + c.visitRawInterpolation(condition.Expression) + `}}`)
+// visitFragment compiles block aside into a fragment and returns the name of its template along with the data
+// to execute it with, see visitFragments.
+func (c *Compiler) visitFragment(block *parser.Block) (string, string) {
+	names, data := c.visitFragments(block)
+	return names[0], data
+}
 
-	c.visitBlock(condition.Positive)
+// visitFragments compiles blocks aside into fragments and returns the names of their templates along with the data
+// to execute them with: the dot, or a runtime.Scope of the dot and the variables of the enclosing template the
+// blocks refer to. Fragments are templates of their own, so they declare those variables again.
+func (c *Compiler) visitFragments(blocks ...*parser.Block) ([]string, string) {
+	var variables []string
+
+	seen := make(map[string]bool)
+	for _, block := range blocks {
+		walkExpressions(block, func(node parser.Noder, expr string) {
+			for _, name := range rvariable.FindAllString(expr, -1) {
+				if !seen[name] && c.declared(name) {
+					variables = append(variables, name)
+				}
 
-	if condition.Negative != nil {
-		c.write(`{{else}}`)
+				seen[name] = true
+			}
+		})
+	}
 
-		c.visitBlock(condition.Negative)
+	data := "."
+	if len(variables) > 0 {
+		data = `(__slim_scope .`
+		for _, name := range variables {
+			data += ` ` + strconv.Quote(name) + ` ` + name
+		}
+
+		data += `)`
+	}
+
+	names := make([]string, len(blocks))
+	for i, block := range blocks {
+		names[i] = c.namespace + "__slim_fragment_" + strconv.Itoa(len(c.fragments)+1)
+
+		c.compileTemplate(names[i], func() {
+			if len(variables) == 0 {
+				c.visitBlock(block)
+				return
+			}
+
+			c.pushScope()
+			defer c.popScope()
+
+			for _, name := range variables {
+				c.assign(name, `$.Var `+strconv.Quote(name), true)
+			}
+
+			// ranging over the single dot of the scope restores it, whatever its value
+			c.write(`{{range $.Dot}}`)
+			c.visitBlock(block)
+			c.write(`{{end}}`)
+		})
 	}
 
-	c.write(`{{end}}`)
+	return names, data
 }
 
-func (c *Compiler) visitAssignment(assignment *parser.Assignment) {
-	c.write(`{{` + assignment.Variable + ` := ` + c.visitRawInterpolation(assignment.Expression) + `}}`)
+// compileFragment compiles block aside into a template of given name, written out after the template.
+func (c *Compiler) compileFragment(name string, block *parser.Block) {
+	c.compileTemplate(name, func() {
+		c.visitBlock(block)
+	})
 }
 
-func (c *Compiler) visitRange(iter *parser.Range) {
-	if iter.Block == nil {
-		return
-	}
+// compileTemplate compiles the output of body aside into a template of given name, written out after the template.
+// Source mappings are kept for when the template is written out.
+func (c *Compiler) compileTemplate(name string, body func()) {
+	index := len(c.fragments)
+	c.fragments = append(c.fragments, fragment{name: name})
 
-	if len(iter.Value) == 0 {
-		c.write(`{{range ` + iter.Key + ` := ` + c.visitRawInterpolation(iter.Expression) + `}}`)
-	} else {
-		c.write(`{{range ` + iter.Key + `, ` + iter.Value + ` := ` + c.visitRawInterpolation(iter.Expression) + `}}`)
-	}
+	buffer, mappings := c.buffer, len(c.sourceMap.Mappings)
+	c.buffer = new(bytes.Buffer)
 
-	c.visitBlock(iter.Block)
+	// fragments are templates of their own, variables of the enclosing template are out of their scope
+	scopes := c.scopes
+	c.scopes = nil
+
+	body()
 
-	c.write(`{{end}}`)
+	c.scopes = scopes
+
+	c.fragments[index].body = c.buffer.String()
+	c.fragments[index].mappings = append([]Mapping(nil), c.sourceMap.Mappings[mappings:]...)
+	c.sourceMap.Mappings = c.sourceMap.Mappings[:mappings]
+	c.buffer = buffer
 }
 
 func (c *Compiler) visitInterpolation(value string) string {
+	if c.Format == parser.FORMAT_TEXT {
+		return `{{__slim_text (` + c.visitRawInterpolation(value) + `)}}`
+	}
+
 	return `{{` + c.visitRawInterpolation(value) + `}}`
 }
 
 func (c *Compiler) visitRawInterpolation(value string) string {
-	value = strings.Replace(value, "$", "__DOLLAR__", -1)
+	expr := parseExpression(value)
+
+	if c.dataType != nil {
+		c.checkExpression(expr)
+	}
+
+	return c.visitExpression(expr)
+}
+
+// parseExpression parses a slim expression into its Go AST.
+// Variables are renamed from $name to __DOLLAR__name in order to form valid Go identifiers.
+func parseExpression(value string) goAst.Expr {
+	// default is a reserved word for go/parser, calls are renamed until they are resolved
+	value = renameTokens(value, "__DOLLAR__", "__DEFAULT__")
+
+	// integer ranges, from..to, turn into a sequence
+	if matches := rintrange.FindStringSubmatch(value); matches != nil && !strings.ContainsAny(value, "\"'`") {
+		value = "__slim_seq(" + matches[1] + ", " + matches[2] + ")"
+	}
 
 	expr, err := goParser.ParseExpr(value)
 	if err != nil {
 		panic("Unable to parse expression.")
 	}
 
-	return strings.Replace(c.visitExpression(expr), "__DOLLAR__", "$", -1)
+	return expr
+}
+
+// renameTokens replaces the $ of variables by dollar and the default keyword of calls by def, so value parses
+// as a Go expression. Value is scanned into Go tokens, so string literals are left as written.
+func renameTokens(value, dollar, def string) string {
+	fset := goToken.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(value))
+
+	var scanner goScanner.Scanner
+	scanner.Init(file, []byte(value), func(goToken.Position, string) {}, 0)
+
+	var result strings.Builder
+
+	last, keyword := 0, -1
+	for {
+		pos, tok, lit := scanner.Scan()
+		if tok == goToken.EOF {
+			break
+		}
+
+		offset := file.Offset(pos)
+
+		if keyword >= 0 && tok == goToken.LPAREN {
+			result.WriteString(value[last:keyword] + def)
+			last = keyword + len("default")
+		}
+
+		keyword = -1
+
+		switch {
+		case tok == goToken.ILLEGAL && lit == "$":
+			result.WriteString(value[last:offset] + dollar)
+			last = offset + 1
+		case tok == goToken.DEFAULT:
+			keyword = offset
+		}
+	}
+
+	result.WriteString(value[last:])
+	return result.String()
 }
 
 func (c *Compiler) visitExpression(outerexpr goAst.Expr) string {
@@ -596,12 +2089,24 @@ func (c *Compiler) visitExpression(outerexpr goAst.Expr) string {
 			builtin := false
 
 			if ident, ok := ce.Fun.(*goAst.Ident); ok {
+				if ident.Name == "__DEFAULT__" {
+					ident.Name = "default"
+				}
+
 				for _, fname := range builtinFunctions {
 					if fname == ident.Name {
 						builtin = true
 						break
 					}
 				}
+
+				if _, ok := c.funcs[ident.Name]; ok {
+					builtin = true
+				}
+
+				if !builtin && c.Strict && !strings.HasPrefix(ident.Name, "__DOLLAR__") {
+					panic(fmt.Sprintf("Unknown helper %s.", ident.Name))
+				}
 			}
 
 			if builtin {
@@ -2,8 +2,28 @@ package slim
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/golib/slim/parser"
+	"github.com/golib/slim/runtime"
 )
 
 func Test_Doctype(t *testing.T) {
@@ -73,6 +93,13 @@ func Test_EmptyAttribute(t *testing.T) {
 	} else {
 		expect(res, `<div name></div>`, t)
 	}
+
+	res, err = run("doctype 5\ndiv[name]", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<!DOCTYPE html><div name></div>", t)
 }
 
 func Test_RawText(t *testing.T) {
@@ -189,7 +216,7 @@ func expect(cur, expected string, t *testing.T) {
 }
 
 func run(tpl string, data interface{}) (string, error) {
-	t, err := Compile(tpl, Options{false, false})
+	t, err := Compile(tpl, Options{})
 	if err != nil {
 		return "", err
 	}
@@ -199,3 +226,3000 @@ func run(tpl string, data interface{}) (string, error) {
 	}
 	return strings.TrimSpace(buf.String()), nil
 }
+
+func Test_Helpers(t *testing.T) {
+	helpers := Helpers()
+
+	for _, name := range builtinFunctions {
+		if _, ok := helpers[name]; !ok && name != "__slim_seq" {
+			t.Errorf("Expected documentation of helper %s.", name)
+		}
+
+		delete(helpers, name)
+	}
+
+	for name := range helpers {
+		t.Errorf("Expected documented helper %s to be available to templates.", name)
+	}
+
+	if doc := Helpers()["upper"]; !strings.HasPrefix(doc, "upper(s) string\n\n") {
+		t.Fatalf("Expected the signature of upper, got %s", doc)
+	}
+}
+
+func Test_StringHelpers(t *testing.T) {
+	res, err := run(`| #{upper(A)} #{lower(B)} #{title(C)} #{trim(D)}`, map[string]string{"A": "up", "B": "LOW", "C": "hello big world", "D": "  x  "})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `UP low Hello Big World x`, t)
+	}
+}
+
+func Test_StringListHelpers(t *testing.T) {
+	res, err := run(`| #{truncate(A, 5)} #{default(B, "none")} #{join(split(C, ","), "-")} #{replace(A, "o", "0")}`, map[string]string{"A": "foo bar baz", "C": "a,b,c"})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `foo b... none a-b-c f00 bar baz`, t)
+	}
+
+	// default and $ are renamed in code only, string literals are output as written
+	res, err = run("$x = default(B, \"$y\")\n| #{printf(\"default(%s) $x __DOLLAR__ %s\", $x, default (A, `default(`))}\nmixin m($a)\n\t| #{$a}\n+m(printf(\"%s default( $\", default(B, \"b\")))", map[string]string{"A": "a"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, `default($y) $x __DOLLAR__ ab default( $`, t)
+}
+
+func Test_SafeHelpers(t *testing.T) {
+	res, err := run(`a[href=safeURL(A)]
+| #{raw(B)}`, map[string]string{"A": "javascript:void(0)", "B": "<b>bold</b>"})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<a href="javascript:void%280%29"></a><b>bold</b>`, t)
+	}
+}
+
+func Test_DumpHelpers(t *testing.T) {
+	res, err := run(`| #{dump($)} #{typeOf(A)}`, map[string]string{"A": "<a>"})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, "<pre>{\n  &#34;A&#34;: &#34;\\u003ca\\u003e&#34;\n}</pre> string", t)
+	}
+}
+
+func Test_NumericWidths(t *testing.T) {
+	res, err := run(`| #{A + B} #{B * C} #{C - A} #{D * 2} #{C < A}`, map[string]interface{}{"A": int8(2), "B": uint16(3), "C": 1.5, "D": time.Second})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `5 4.5 -0.5 2s true`, t)
+	}
+}
+
+func Test_NumericErrors(t *testing.T) {
+	if _, err := run(`| #{A / 0}`, map[string]int{"A": 2}); err == nil {
+		t.Fatal("Expected division by zero error.")
+	}
+
+	if _, err := run(`| #{A - B}`, map[string]interface{}{"A": 2, "B": []int{}}); err == nil {
+		t.Fatal("Expected invalid operation error.")
+	}
+
+	data := map[string]int64{"Max": math.MaxInt64, "Min": math.MinInt64}
+	for _, tpl := range []string{`| #{Max + 1}`, `| #{Min - 1}`, `| #{Max * 2}`, `| #{Min / -1}`, `| #{-Min}`} {
+		if _, err := run(tpl, data); err == nil || !strings.Contains(err.Error(), "integer overflow") {
+			t.Fatalf("Expected integer overflow error on %s, got %v", tpl, err)
+		}
+	}
+
+	if res, err := run(`| #{Max - 1 + 1} #{Min + 1 - 1}`, data); err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, fmt.Sprint(int64(math.MaxInt64), " ", int64(math.MinInt64)), t)
+	}
+}
+
+type typedPage struct {
+	Title string
+	Items []typedItem
+	Meta  map[string]string
+	Extra interface{}
+}
+
+type typedItem struct {
+	Name string
+}
+
+func (i typedItem) Label() string {
+	return i.Name
+}
+
+func Test_DataTypeCheck(t *testing.T) {
+	cmp := New()
+	cmp.SetData(typedPage{})
+
+	err := cmp.Parse(`div[title=Title + Meta.description + Extra.Anything]
+each $item in Items
+	| #{$item.Name} #{Label}
+br`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err := cmp.String(); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func Test_DataTypeCheckUnknownField(t *testing.T) {
+	cmp := New()
+	cmp.SetData(typedPage{})
+
+	err := cmp.Parse(`div[title=Titel]`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = cmp.String()
+	if err == nil || !strings.Contains(err.Error(), "Unknown field or method Titel in type slim.typedPage - Line: 1") {
+		t.Fatalf("Expected unknown field error, got %v", err)
+	}
+}
+
+type typedCounter struct {
+	N int
+}
+
+func (c *typedCounter) Count() int {
+	return c.N
+}
+
+type typedCounters struct {
+	Counter  typedCounter
+	Pointer  *typedCounter
+	List     []typedCounter
+	Counters map[string]typedCounter
+}
+
+func Test_DataTypeCheckPointerMethods(t *testing.T) {
+	data := &typedCounters{Counter: typedCounter{1}, Pointer: &typedCounter{2}, List: []typedCounter{{3}}}
+
+	cmp := New()
+	cmp.SetData(data)
+
+	if err := cmp.Parse("p\n\t| #{Counter.Count} #{Pointer.Count}\neach $c in List\n\t| #{$c.Count}"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tpl, err := cmp.CompileWithName("counters")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<p>1 2</p>3", t)
+
+	for _, tpl := range []string{"p\n\t| #{Counter.Count}", "p\n\t| #{Counters.a.Count}"} {
+		cmp := New()
+		cmp.SetData(typedCounters{})
+
+		if err := cmp.Parse(tpl); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if _, err := cmp.String(); err == nil || !strings.Contains(err.Error(), "Method Count of type slim.typedCounter has a pointer receiver and the value is not addressable") {
+			t.Fatalf("Expected pointer receiver error, got %v", err)
+		}
+	}
+}
+
+func Test_References(t *testing.T) {
+	cmp := New()
+
+	err := cmp.Parse(`div[title=upper(User.Name)]
+	.active ? Active
+each $item in Items
+	| #{$item.Title} #{len($item.Tags)}
+br`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	refs, err := cmp.References()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	names := make([]string, 0)
+	for _, ref := range refs {
+		names = append(names, ref.Kind+":"+ref.Name)
+	}
+
+	expect(strings.Join(names, " "), `helper:upper field:User.Name field:Active field:Items field:$item.Title helper:len field:$item.Tags`, t)
+}
+
+func Test_Reparse(t *testing.T) {
+	p, _ := parser.NewStringParser(`!!! 5
+div#a
+	p#first
+if A
+	p.yes
+div#b
+	| #{B}`)
+	p.Parse()
+
+	edits := []parser.Edit{
+		{StartLine: 3, EndLine: 4, Text: "\tp#changed\n\tp#added"},
+		{StartLine: 7, EndLine: 7, Text: "else\n\tp.no"},
+		{StartLine: 1, EndLine: 2, Text: ""},
+		{StartLine: 9, EndLine: 10, Text: "\t| #{A}"},
+	}
+
+	for _, edit := range edits {
+		p.Reparse(edit)
+	}
+
+	cmp := New()
+	cmp.Pretty = false
+	cmp.node = p.Reparse(parser.Edit{StartLine: 1, EndLine: 1, Text: "span"})
+
+	res, err := cmp.String()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, `<span></span><div id="{{"a"}}"><p id="{{"changed"}}"></p><p id="{{"added"}}"></p></div>{{if .A}}<p class="{{"yes"}}"></p>{{else}}<p class="{{"no"}}"></p>{{end}}<div id="{{"b"}}">{{.A}}</div>`+"\n", t)
+
+	last := p.Reparse(parser.Edit{StartLine: 1, EndLine: 1, Text: "br"}).Children
+	if line := last[len(last)-1].Pos().Line; line < 9 {
+		t.Fatalf("Expected positions of following nodes to be shifted, got line %d", line)
+	}
+}
+
+func Test_ReparseWarnings(t *testing.T) {
+	p, _ := parser.NewStringParser("div\n\tp\nul\n    li\nspan\n\tb")
+	p.SetMixedIndentation(parser.MixedIndentWarn, 0)
+	p.Parse()
+
+	lines := func() string {
+		var lines []string
+		for _, warning := range p.Warnings() {
+			lines = append(lines, fmt.Sprint(warning.Line))
+		}
+
+		return strings.Join(lines, " ")
+	}
+
+	expect(lines(), "4", t)
+
+	// warnings of following segments move with them
+	p.Reparse(parser.Edit{StartLine: 1, EndLine: 1, Text: "em\n\ti"})
+	expect(lines(), "6", t)
+
+	// fixed indentation drops its warning, new mixed indentation raises one
+	p.Reparse(parser.Edit{StartLine: 6, EndLine: 7, Text: "\tli"})
+	expect(lines(), "", t)
+
+	p.Reparse(parser.Edit{StartLine: 8, EndLine: 9, Text: "    b"})
+	expect(lines(), "8", t)
+
+	// the kind of indentation of the file changes with its first indented line
+	p.Reparse(parser.Edit{StartLine: 2, EndLine: 3, Text: "    i"})
+	expect(lines(), "4 6", t)
+}
+
+func Test_Tokenize(t *testing.T) {
+	src := `div#main.big[title=upper($name)] Hi #{Name}!
+	if A > 1
+		| text`
+
+	kinds := make([]string, 0)
+	for _, tok := range parser.Tokenize(src) {
+		kinds = append(kinds, tok.Kind.String()+"("+src[tok.Start:tok.End]+")")
+	}
+
+	expect(strings.Join(kinds, " "), `tag(div) id(#main) class(.big) attribute(title) operator(=) function(upper) operator(() variable($name) operator()) text( Hi ) interpolation(#{) identifier(Name) interpolation(}) text(!) keyword(if) identifier(A) operator(>) number(1) operator(|) text( text)`, t)
+}
+
+func Test_Positions(t *testing.T) {
+	p, _ := parser.NewStringParser(`doctype 5
+div#a.b[title="x"]
+	p
+	if A
+		| #{B}
+	each $i in C
+		span
+block title
+	| t`)
+
+	positions := make([]string, 0)
+	pos := func(name string, node parser.Noder) {
+		at := node.Pos()
+		positions = append(positions, fmt.Sprintf("%s@%d:%d-%d:%d", name, at.Line, at.Column, at.EndLine, at.EndColumn))
+	}
+
+	root := p.Parse().Children
+
+	div := root[1].(*parser.Tag)
+	cond := div.Block.Children[1].(*parser.Condition)
+	each := div.Block.Children[2].(*parser.Range)
+
+	pos("doctype", root[0])
+	pos("div", div)
+	for i := range div.Attributes {
+		pos(div.Attributes[i].Name, &div.Attributes[i].SourcePosition)
+	}
+	pos("block", div.Block)
+	pos("p", div.Block.Children[0])
+	pos("if", cond)
+	pos("text", cond.Positive.Children[0])
+	pos("each", each)
+	pos("span", each.Block.Children[0])
+	pos("title", root[2])
+
+	expect(strings.Join(positions, " "), `doctype@1:1-1:10 div@2:1-2:4 id@2:4-2:6 class@2:6-2:8 title@2:8-2:19 block@3:1-3:2 p@3:2-3:3 if@4:2-4:6 text@5:3-5:9 each@6:2-6:14 span@7:3-7:7 title@8:1-8:12`, t)
+}
+
+func Test_SourceMap(t *testing.T) {
+	cmp := New()
+	cmp.Pretty = false
+
+	if err := cmp.Parse("div\n\tif A\n\t\tspan#x\n\t| #{B}"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err := cmp.String()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	m := cmp.SourceMap()
+
+	at := func(needle string) string {
+		mapping, ok := m.Lookup(strings.Index(res, needle))
+		if !ok {
+			return needle + "@none"
+		}
+
+		return fmt.Sprintf("%s@%d:%d", needle, mapping.Line, mapping.Column)
+	}
+
+	expect(strings.Join([]string{at("<div"), at("{{if .A}}"), at("<span"), at("{{.B}}")}, " "), `<div@1:1 {{if .A}}@2:2 <span@3:3 {{.B}}@4:2`, t)
+
+	mapping, ok := m.LookupLine(1, strings.Index(res, "{{.B}}")+1)
+	if !ok || mapping.Line != 4 {
+		t.Fatalf("Expected line based lookup to find line 4, got %+v", mapping)
+	}
+
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil || !strings.HasPrefix(buf.String(), `{"mappings":[{"start":0,`) {
+		t.Fatalf("Unexpected source map JSON %s", buf.String())
+	}
+}
+
+type brokenData struct{}
+
+func (brokenData) Broken() (string, error) {
+	return "", errors.New("broken")
+}
+
+func Test_ExplainExecError(t *testing.T) {
+	tpl, err := Compile("div\n\tif A\n\t\t| #{B.Broken}", Options{Pretty: true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = tpl.Execute(new(bytes.Buffer), map[string]interface{}{"A": true, "B": brokenData{}})
+	if err == nil {
+		t.Fatal("Expected execution to fail")
+	}
+
+	err = ExplainExecError(err, tpl)
+	if _, ok := err.(*ExecError); !ok {
+		t.Fatalf("Expected an *ExecError, got %v", err)
+	}
+
+	expect(err.Error(), `Slim Error: at <.B.Broken>: error calling Broken: broken - Line: 3, Column: 3`, t)
+}
+
+func Test_RendererErrorPage(t *testing.T) {
+	root, err := ioutil.TempDir("", "slim")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(root)
+
+	ioutil.WriteFile(filepath.Join(root, "ok.html.slim"), []byte("div\n\t| #{A}"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "broken.html.slim"), []byte("div\n\tif A\n\t\t| #{B.Broken}"), 0644)
+
+	renderer := NewRenderer(root)
+	renderer.Pretty = false
+
+	rec := httptest.NewRecorder()
+	if err := renderer.HTML(rec, http.StatusOK, "ok", map[string]string{"A": "x"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(rec.Body.String(), "<div>x</div>\n", t)
+
+	rec = httptest.NewRecorder()
+	renderer.HTML(rec, http.StatusOK, "broken", map[string]interface{}{"A": true, "B": brokenData{}})
+
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "Broken") {
+		t.Fatalf("Expected a plain 500 response, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	renderer.Development = true
+
+	rec = httptest.NewRecorder()
+	renderer.HTML(rec, http.StatusOK, "broken", map[string]interface{}{"A": true, "B": brokenData{}})
+
+	body := rec.Body.String()
+	if !strings.Contains(body, `<span class="line current"><span class="number">3</span>		| #{B.Broken}</span>`) || !strings.Contains(body, "error calling Broken: broken") {
+		t.Fatalf("Expected error page highlighting line 3, got %s", body)
+	}
+
+	// the file shrinks after the template has been compiled
+	ioutil.WriteFile(filepath.Join(root, "broken.html.slim"), []byte("div"), 0644)
+
+	rec = httptest.NewRecorder()
+	renderer.HTML(rec, http.StatusOK, "broken", map[string]interface{}{"A": true, "B": brokenData{}})
+
+	if body := rec.Body.String(); rec.Code != http.StatusInternalServerError || !strings.Contains(body, "error calling Broken: broken") || strings.Contains(body, `class="line`) {
+		t.Fatalf("Expected error page without source lines, got %d %s", rec.Code, body)
+	}
+}
+
+func Test_RendererWatch(t *testing.T) {
+	root, err := ioutil.TempDir("", "slim")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(root)
+
+	filename := filepath.Join(root, "page.html.slim")
+	ioutil.WriteFile(filename, []byte("body\n\t| v1"), 0644)
+
+	renderer := NewRenderer(root)
+	renderer.Pretty = false
+	renderer.Development = true
+	renderer.LiveReload = true
+
+	render := func() string {
+		rec := httptest.NewRecorder()
+		renderer.HTML(rec, http.StatusOK, "page", nil)
+		return rec.Body.String()
+	}
+
+	expect(render(), `<body>v1`+string(liveReloadScript)+"</body>\n", t)
+
+	stop := renderer.Watch(5 * time.Millisecond)
+	defer stop()
+
+	server := httptest.NewServer(renderer.Middleware(http.NotFoundHandler()))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + LiveReloadPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer res.Body.Close()
+
+	ioutil.WriteFile(filename, []byte("body\n\t| version 2"), 0644)
+
+	event := make([]byte, len("event: reload"))
+	if _, err := io.ReadFull(res.Body, event); err != nil || string(event) != "event: reload" {
+		t.Fatalf("Expected a reload event, got %q %v", event, err)
+	}
+
+	expect(render(), `<body>version 2`+string(liveReloadScript)+"</body>\n", t)
+}
+
+type counter struct {
+	n int
+}
+
+func (c *counter) Next() int {
+	c.n++
+	return c.n
+}
+
+func Test_CacheDirective(t *testing.T) {
+	cmp := New()
+	cmp.Pretty = false
+	cmp.Cache = runtime.NewLRUCache(10)
+
+	if err := cmp.Parse("cache \"nav\"\n\tp\n\t\t| #{C.Next}\ncache \"nav\" 1h\n\tp\n\t\t| #{C.Next}\ncache Key 30\n\tp\n\t\t| #{C.Next}"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tpl, err := cmp.CompileWithName("cached")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	data := map[string]interface{}{"C": new(counter), "Key": "other"}
+
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		if err := tpl.Execute(&buf, data); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		expect(buf.String(), "<p>1</p><p>1</p><p>2</p>\n", t)
+	}
+
+	// blocks refer to the variables around them, keys of different templates sharing a cache keep apart
+	cache := runtime.NewLRUCache(10)
+	for i, source := range []string{"each $i, $x in Items\n\tcache $x\n\t\tp\n\t\t\t| #{$i} #{$x}", "each $x in Items\n\tcache $x\n\t\tb\n\t\t\t| #{$x}"} {
+		cmp := New()
+		cmp.Pretty = false
+		cmp.Cache = cache
+
+		if err := cmp.Parse(source); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		tpl, err := cmp.CompileWithName("cached")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		var buf bytes.Buffer
+		if err := tpl.Execute(&buf, map[string][]string{"Items": {"a", "b"}}); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		expect(buf.String(), []string{"<p>0 a</p><p>1 b</p>\n", "<b>a</b><b>b</b>\n"}[i], t)
+	}
+}
+
+func Test_ContentForVariables(t *testing.T) {
+	res, err := run("$y = \"one\"\nhtml\n\thead\n\t\tyield head\n\tbody\n\t\tcontent_for head\n\t\t\ttitle\n\t\t\t\t| #{$y} #{Name}", map[string]string{"Name": "two"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<html><head><title>one two</title></head><body></body></html>", t)
+}
+
+func Test_ContentFor(t *testing.T) {
+	res, err := run(`html
+	head
+		yield head
+	body
+		content_for head
+			meta[name="a"]
+		p
+			| body
+		provide head
+			meta[name="b"]`, nil)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, `<html><head><meta name="a" /><meta name="b" /></head><body><p>body</p></body></html>`, t)
+
+	tpl, err := Compile("yield head\ncontent_for head\n\tif A\n\t\t| #{B.Broken}", Options{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = ExplainExecError(tpl.Execute(new(bytes.Buffer), map[string]interface{}{"A": true, "B": brokenData{}}), tpl)
+	expect(fmt.Sprint(err), `Slim Error: at <.B.Broken>: error calling Broken: broken - Line: 4, Column: 3`, t)
+}
+
+func Test_RendererGlobals(t *testing.T) {
+	root, err := ioutil.TempDir("", "slim")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(root)
+
+	ioutil.WriteFile(filepath.Join(root, "page.html.slim"), []byte("p\n\t| #{global(\"Site\")} #{default(global(\"User\"), \"guest\")} #{Title}"), 0644)
+
+	renderer := NewRenderer(root)
+	renderer.Pretty = false
+	renderer.SetGlobal("Site", "Slim")
+
+	rec := httptest.NewRecorder()
+	renderer.HTML(rec, http.StatusOK, "page", map[string]string{"Title": "Home"})
+	expect(rec.Body.String(), "<p>Slim guest Home</p>\n", t)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req = req.WithContext(WithValue(req.Context(), "User", "ada"))
+
+	rec = httptest.NewRecorder()
+	renderer.Render(rec, req, http.StatusOK, "page", map[string]string{"Title": "Home"})
+	expect(rec.Body.String(), "<p>Slim ada Home</p>\n", t)
+
+	rec = httptest.NewRecorder()
+	renderer.HTML(rec, http.StatusOK, "page", map[string]string{"Title": "Home"})
+	expect(rec.Body.String(), "<p>Slim guest Home</p>\n", t)
+}
+
+func Test_Minify(t *testing.T) {
+	cmp := New()
+	cmp.Minify = true
+
+	if err := cmp.Parse("div\n\t/! note\n\tinput[checked=\"checked\"]\n\tinput[value=\"checked\"]\n\tp\n\t\t|  a \t  b #{C}  d\n\tpre\n\t\t|  a   b"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err := cmp.String()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, `<div><input checked /><input value="{{"checked"}}" /><p> a b {{.C}} d</p><pre> a   b</pre></div>`+"\n", t)
+}
+
+func Test_PreservedWhitespace(t *testing.T) {
+	cmp := New()
+
+	if err := cmp.Parse("div\n\tpre\n\t\tspan\n\t\t| a   b\n\ttextarea\n\t\t| x"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err := cmp.String()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<div>\n\t<pre><span></span>a   b</pre>\n\t<textarea>x</textarea>\n</div>\n", t)
+}
+
+func Test_InlineElements(t *testing.T) {
+	cmp := New()
+
+	if err := cmp.Parse("div\n\tp\n\t\tdiv\n\tp\n\t\tstrong\n\t\t\tem\n\t\tbr"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err := cmp.String()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<div>\n\t<p>\n\t\t<div></div>\n\t</p>\n\t<p><strong><em></em></strong><br /></p>\n</div>\n", t)
+
+	cmp.InlineElements = []string{}
+
+	res, err = cmp.String()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<div>\n\t<p>\n\t\t<div></div>\n\t</p>\n\t<p>\n\t\t<strong>\n\t\t\t<em></em>\n\t\t</strong>\n\t\t<br />\n\t</p>\n</div>\n", t)
+}
+
+func Test_TrimMarkers(t *testing.T) {
+	cmp := New()
+	cmp.Options = Options{TrimMarkers: true}
+
+	if err := cmp.Parse("ul\n\teach $i in Items\n\t\tif Show\n\t\t\tli\n\t\t\t\t|  #{$i}"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err := cmp.String()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<ul>{{- range $i := .Items -}}{{- if .Show -}}<li> {{$i}}</li>{{- end -}}{{- end -}}</ul>\n", t)
+
+	cmp.Pretty = true
+
+	res, err = cmp.String()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if strings.Contains(res, "{{-") {
+		t.Errorf("Trim markers emitted in pretty mode: %q", res)
+	}
+}
+
+func Test_DefaultDoctype(t *testing.T) {
+	cmp := New()
+	cmp.Options = Options{DefaultDoctype: "5"}
+
+	if err := cmp.Parse("html"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err := cmp.String()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<!DOCTYPE html><html></html>\n", t)
+
+	if err := cmp.Parse("doctype xml\nfeed"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err = cmp.String()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<?xml version=\"1.0\" encoding=\"utf-8\" ?><feed></feed>\n", t)
+}
+
+func Test_VoidElements(t *testing.T) {
+	parser.RegisterVoidElement("x-spacer")
+
+	res, err := run("div\n\tx-spacer\n\tbr", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<div><x-spacer /><br /></div>", t)
+
+	cmp := New()
+	cmp.Options = Options{VoidElements: []string{"item"}}
+
+	if err := cmp.Parse("feed\n\titem\n\tbr"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err = cmp.String()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<feed><item /><br></br></feed>\n", t)
+}
+
+func Test_Namespaces(t *testing.T) {
+	res, err := run("svg[xmlns:xlink=\"http://www.w3.org/1999/xlink\"]\n\tsvg:use[xlink:href=Icon]", map[string]interface{}{"Icon": "#logo"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<svg xmlns:xlink=\"http://www.w3.org/1999/xlink\"><svg:use xlink:href=\"#logo\"></svg:use></svg>", t)
+
+	res, err = run("svg\n\tuse[xlink:href=Icon]", map[string]interface{}{"Icon": "javascript:alert(1)"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<svg><use xlink:href=\"#ZgotmplZ\"></use></svg>", t)
+}
+
+func Test_FrameworkAttributes(t *testing.T) {
+	res, err := run("button[@click=open = !open]", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<button @click=\"open = !open\"></button>", t)
+
+	res, err = run("a[:href=item.url]", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<a :href=\"item.url\"></a>", t)
+
+	res, err = run("form[x-on:submit.prevent=send(\"now\")]", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<form x-on:submit.prevent=\"send(&#34;now&#34;)\"></form>", t)
+
+	res, err = run("button[hx-post=Url]", map[string]interface{}{"Url": "/save"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<button hx-post=\"/save\"></button>", t)
+}
+
+func Test_NestedBracketAttributes(t *testing.T) {
+	data := map[string]interface{}{"Items": []string{"a", "b"}, "Name": "x"}
+
+	res, err := run("input[value=index(Items, 1)]", data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<input value=\"b\" />", t)
+
+	res, err = run("a[title=\"[\" + Name + \"]\"]", data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<a title=\"[x]\"></a>", t)
+}
+
+func Test_AttributeLiterals(t *testing.T) {
+	res, err := run("input[placeholder='Say \"hi\"']", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<input placeholder=\"Say &#34;hi&#34;\" />", t)
+
+	res, err = run("form[method=post]", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<form method=\"post\"></form>", t)
+
+	cmp := New()
+	cmp.Options = Options{AttributeQuote: "'"}
+
+	if err := cmp.Parse("a[title=Name]"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	res, err = cmp.String()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<a title='{{.Name}}'></a>\n", t)
+}
+
+func Test_ClassMerging(t *testing.T) {
+	data := map[string]interface{}{"Extra": []string{"big", "btn"}, "Active": false, "Empty": ""}
+
+	res, err := run("a.btn.primary[class=Extra]", data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<a class=\"btn primary big\"></a>", t)
+
+	res, err = run("a\n\t.active ? Active\n\t.btn\n\t[class=Empty]", data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<a class=\"btn\"></a>", t)
+
+	res, err = run("a.btn.primary", data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<a class=\"btn primary\"></a>", t)
+}
+
+func Test_OmitEmptyAttributes(t *testing.T) {
+	cmp := New()
+	cmp.Options = Options{OmitEmptyAttributes: true}
+
+	if err := cmp.Parse("a[title=Title]"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tpl, err := cmp.CompileWithName("omit")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for _, c := range []struct {
+		title interface{}
+		want  string
+	}{
+		{nil, "<a></a>\n"},
+		{"", "<a></a>\n"},
+		{false, "<a></a>\n"},
+		{0, "<a title=\"0\"></a>\n"},
+		{"Home", "<a title=\"Home\"></a>\n"},
+	} {
+		var buf bytes.Buffer
+		if err := tpl.Execute(&buf, map[string]interface{}{"Title": c.title}); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		expect(buf.String(), c.want, t)
+	}
+}
+
+func Test_DuplicateAttributes(t *testing.T) {
+	cmp := New()
+	cmp.Options = Options{DuplicateAttributes: DuplicateAttributeError}
+
+	if err := cmp.Parse("a[title=\"a\"][title=\"b\"]"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err := cmp.String(); err == nil || !strings.Contains(err.Error(), "Duplicate attribute title.") {
+		t.Fatalf("Expected duplicate attribute error, got %v", err)
+	}
+
+	for policy, want := range map[int]string{
+		DuplicateAttributeFirst: "<a title=\"a\"></a>",
+		DuplicateAttributeLast:  "<a title=\"b\"></a>",
+		DuplicateAttributeMerge: "<a title=\"a b\"></a>",
+	} {
+		cmp.DuplicateAttributes = policy
+
+		tpl, err := cmp.CompileWithName("duplicate")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		var buf bytes.Buffer
+		if err := tpl.Execute(&buf, nil); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		expect(strings.TrimSpace(buf.String()), want, t)
+	}
+}
+
+func Test_EachMap(t *testing.T) {
+	data := map[string]interface{}{"M": map[string]int{"b": 2, "c": 3, "a": 1}, "N": map[int]string{10: "x", 2: "y"}}
+
+	res, err := run("each $k, $v in M\n\t| #{$k}=#{$v}", data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "a=1b=2c=3", t)
+
+	res, err = run("| #{join(keys(N), \",\")}", data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "2,10", t)
+}
+
+func Test_EachIntegerRange(t *testing.T) {
+	res, err := run("each $i in 1..Pages\n\t| #{$i}", map[string]int{"Pages": 4})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "1234", t)
+
+	res, err = run("each $i, $n in 3..1\n\t| #{$i}:#{$n};", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "0:3;1:2;2:1;", t)
+
+	if _, err := run("each $i in 1..X\n\t| #{$i}", map[string]string{"X": "x"}); err == nil {
+		t.Fatal("Expected invalid range error.")
+	}
+}
+
+func Test_LoopVariable(t *testing.T) {
+	res, err := run("each $name in Names\n\tspan\n\t\t.first ? $loop.First\n\t\t| #{$loop.Number}/#{$loop.Length} #{$name}\n\tif !$loop.Last\n\t\t| ,", map[string][]string{"Names": {"a", "b", "c"}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<span class=\"first\">1/3 a</span>,<span>2/3 b</span>,<span>3/3 c</span>", t)
+
+	cmp := New()
+	cmp.SetData(typedPage{})
+
+	if err := cmp.Parse("each $item in Items\n\t| #{$loop.Index} #{$item.Name}"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err := cmp.String(); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func Test_EachIndexValue(t *testing.T) {
+	res, err := run("each $i, $x in Items\n\t| #{$i}=#{$x};", map[string]interface{}{"Items": []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "0=a;1=b;", t)
+
+	res, err = run("each $k, $v in Items\n\t| #{$k}=#{$v};", map[string]interface{}{"Items": map[string]int{"y": 2, "x": 1}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "x=1;y=2;", t)
+
+	cmp := New()
+	cmp.SetData(struct{ Events chan string }{})
+
+	if err := cmp.Parse("each $i, $e in Events\n\t| #{$e}"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err := cmp.String(); err == nil || !strings.Contains(err.Error(), "Unable to iterate over a channel with an index variable.") {
+		t.Fatalf("Expected channel iteration error, got %v", err)
+	}
+}
+
+type cursor struct {
+	pages []string
+	at    int
+}
+
+func (c *cursor) Next() bool {
+	c.at++
+	return c.at <= len(c.pages)
+}
+
+func (c *cursor) Done() bool {
+	return !c.Next()
+}
+
+func (c *cursor) Page() string {
+	return c.pages[c.at-1]
+}
+
+func Test_While(t *testing.T) {
+	res, err := run("while Cursor.Next\n\t| #{Cursor.Page};", map[string]interface{}{"Cursor": &cursor{pages: []string{"a", "b"}}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "a;b;", t)
+
+	res, err = run("until Cursor.Done\n\t| #{Cursor.Page};", map[string]interface{}{"Cursor": &cursor{pages: []string{"c"}}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "c;", t)
+
+	tpl, err := Compile("while 1 == 1\n\t| x", Options{MaxLoopIterations: 5})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := tpl.Execute(ioutil.Discard, nil); err == nil || !strings.Contains(err.Error(), "loop exceeded the limit of 5 iterations") {
+		t.Fatalf("Expected loop limit error, got %v", err)
+	}
+}
+
+func Test_Reassignment(t *testing.T) {
+	res, err := run("$n = 0\nuntil $n == 3\n\t$n = $n + 1\n\t| #{$n}", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "123", t)
+
+	res, err = run("$x = 1\nif 1 == 1\n\t$x := 2\n\t| #{$x}\n| #{$x}", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "21", t)
+
+	res, err = run("$first, $second = split(Pair, \":\")\n| #{$second}-#{$first}", map[string]string{"Pair": "a:b"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "b-a", t)
+}
+
+func Test_Let(t *testing.T) {
+	res, err := run("$name = \"outer\"\nlet $name, $n = split(Pair, \":\")\n\t| #{$name}#{$n}\n| #{$name}", map[string]string{"Pair": "a:1"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "a1outer", t)
+
+	if _, err := run("let $x = 1\n\t| #{$x}\n| #{$x}", nil); err == nil {
+		t.Fatal("Expected undefined variable error.")
+	}
+}
+
+func Test_InlineConditionalAttribute(t *testing.T) {
+	res, err := run("ul\n\tli.active ? Active\n\tli.item.first ? !Active", map[string]bool{"Active": true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<ul><li class=\"active\"></li><li class=\"item\"></li></ul>", t)
+}
+
+func Test_HTMLComment(t *testing.T) {
+	res, err := run("div\n\t/! hello\n\t\tdiv\n\t\t\tp\n\t\t\t\t| #{Name}\n\t/!\n\t\t| note\n\t/ silent\n\t\tp\n\t/! end", map[string]string{"Name": "-->"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<div><!-- hello <div><p>--&gt;</p></div> --><!-- note --><!-- end --></div>", t)
+
+	tpl, err := Compile("div\n\t/! hello\n\t\tdiv\n\t\t\tp\n\t/! end", DefaultOptions)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<div>\n\t<!-- hello\n\t\t<div>\n\t\t\t<p></p>\n\t\t</div>\n\t-->\n\t<!-- end -->\n</div>", t)
+}
+
+func Test_BooleanAttributes(t *testing.T) {
+	source := "input[checked][disabled=On][readonly=Off][value=On][data-x]\nmixin m\n\tinput&attributes\n+m[required][hidden=On][open=Off]"
+	data := map[string]bool{"On": true, "Off": false}
+
+	for format, want := range map[string]string{
+		parser.FORMAT_HTML:  `<input checked disabled value="true" data-x><input required hidden>`,
+		parser.FORMAT_XHTML: `<input checked="checked" disabled="disabled" value="true" data-x="data-x" /><input required="required" hidden="hidden" />`,
+		"":                  `<input checked disabled value="true" data-x /><input required hidden />`,
+	} {
+		tpl, err := Compile(source, Options{Format: format, BooleanAttributes: true})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		var buf bytes.Buffer
+		if err := tpl.Execute(&buf, data); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		expect(strings.TrimSpace(buf.String()), want, t)
+	}
+
+	// without BooleanAttributes the value is output as is
+	res, err := run("input[checked=On][disabled=Off]", data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, `<input checked="true" disabled="false" />`, t)
+
+	// the default format follows the doctype
+	for source, want := range map[string]string{
+		"doctype 5\ninput[checked]":            "<!DOCTYPE html><input checked />",
+		"doctype transitional\ninput[checked]": "<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.0 Transitional//EN\" \"http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd\"><input checked=\"checked\" />",
+		"doctype xml\ninput[checked]":          "&lt;?xml version=\"1.0\" encoding=\"utf-8\" ?><input checked=\"checked\" />",
+	} {
+		res, err := run(source, nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		expect(res, want, t)
+	}
+
+	// minified output keeps them short in any format
+	tpl, err := Compile("doctype transitional\ninput[checked]", Options{Format: parser.FORMAT_XHTML, Minify: true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !strings.HasSuffix(strings.TrimSpace(buf.String()), "<input checked />") {
+		t.Fatalf("Expected a short boolean attribute, got %s", buf.String())
+	}
+}
+
+func Test_AttributeWrapColumn(t *testing.T) {
+	source := "div\n\tinput[type=\"email\"][name=\"user[email]\"][placeholder=\"you@example.com\"][required]\n\ta#home[href=\"/\"]"
+
+	tpl, err := Compile(source, Options{Pretty: true, Format: parser.FORMAT_HTML, AttributeWrapColumn: 40})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(buf.String(), "<div>\n\t<input\n\t\ttype=\"email\"\n\t\tname=\"user[email]\"\n\t\tplaceholder=\"you@example.com\"\n\t\trequired>\n\t<a id=\"home\" href=\"/\"></a>\n</div>\n", t)
+}
+
+func Test_ImageTag(t *testing.T) {
+	source := "figure\n\t| #{image_tag(\"/img/hero.jpg\", Alt, \"\", 640, 320)}"
+
+	res, err := run(source, map[string]string{"Alt": "A \"hero\""})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, `<figure><img src="/img/hero.jpg" srcset="/img/hero-320w.jpg 320w, /img/hero-640w.jpg 640w" sizes="100vw" alt="A &#34;hero&#34;" loading="lazy" /></figure>`, t)
+
+	// html/template does not see the URLs of the element, they are checked like its own
+	res, err = run("| #{image_tag(Src, \"\", \"\", 320)}", map[string]string{"Src": "javascript:alert(1)//x.jpg"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, `<img src="#ZgotmplZ" srcset="#ZgotmplZ 320w" sizes="100vw" alt="" loading="lazy" />`, t)
+
+	tpl, err := Compile("| #{image_tag(Src, \"\", \"\", 320)}", Options{Format: parser.FORMAT_HTML, CheckURLs: true, URLSchemes: []string{"data"}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, map[string]string{"Src": "data:image/png,x y"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(buf.String(), "<img src=\"data:image/png,x y\" srcset=\"data:image/png%2Cx%20y-320w 320w\" sizes=\"100vw\" alt=\"\" loading=\"lazy\">\n", t)
+
+	manifest := runtime.ImageManifest{"/img/hero.jpg": {320: "/img/hero-320w.3f2a.jpg", 640: "/img/hero-640w.9c1d.jpg"}}
+
+	tpl, err = Compile("| #{image_tag(\"/img/hero.jpg\", \"Hero\", \"50vw\")}", Options{ImageManifest: manifest})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf.Reset()
+	if err := tpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(buf.String(), "<img src=\"/img/hero.jpg\" srcset=\"/img/hero-320w.3f2a.jpg 320w, /img/hero-640w.9c1d.jpg 640w\" sizes=\"50vw\" alt=\"Hero\" loading=\"lazy\" />\n", t)
+
+	tpl, err = Compile("| #{image_tag(\"/img/hero.jpg\", \"Hero\", \"\", 1280)}", Options{ImageManifest: manifest})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := tpl.Execute(&buf, nil); err == nil {
+		t.Fatal("Expected an error on a width missing from the manifest.")
+	}
+}
+
+func Test_Jsonify(t *testing.T) {
+	data := map[string]interface{}{"Props": map[string]interface{}{"id": 7, "title": "</script><!-- & more"}}
+
+	res, err := run("script#props[type=\"application/json\"]\n\t#{jsonify(Props)}", data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, `<script id="props" type="application/json">{"id":7,"title":"\u003c/script\u003e\u003c!-- \u0026 more"}</script>`, t)
+}
+
+func Test_TimeHelpers(t *testing.T) {
+	data := map[string]interface{}{
+		"Published": time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC),
+		"Past":      time.Now().Add(-3*time.Hour - time.Minute),
+		"Future":    time.Now().Add(49 * time.Hour),
+		"Length":    time.Hour + 5*time.Minute + 3*time.Second,
+	}
+
+	res, err := run("time[datetime=datetime(Published)]\n\t| #{timeago(Past)}, #{timeago(Future)}, #{duration(Length)}, #{duration(150)}", data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<time datetime=\"2024-03-01T09:30:00Z\">3 hours ago, in 2 days, 1h 5m, 2m 30s</time>", t)
+}
+
+func Test_TextJoin(t *testing.T) {
+	source := "div\n\tp\n\t\t| Read the\n\t\t| manual\n\t\tstrong\n\t\t\t| now"
+
+	for policy, want := range map[int]string{
+		TextJoinLayout:  "<div>\n\t<p>Read themanual<strong>now</strong></p>\n</div>\n",
+		TextJoinNewline: "<div>\n\t<p>Read the\n\tmanual<strong>now</strong></p>\n</div>\n",
+		TextJoinSpace:   "<div>\n\t<p>Read the manual<strong>now</strong></p>\n</div>\n",
+		TextJoinNone:    "<div>\n\t<p>Read themanual<strong>now</strong></p>\n</div>\n",
+	} {
+		tpl, err := Compile(source, Options{Pretty: true, TextJoin: policy})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		var buf bytes.Buffer
+		if err := tpl.Execute(&buf, nil); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		expect(buf.String(), want, t)
+	}
+
+	res, err := run("/! slim: text_join=space\nul\n\t| one\n\t| two", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<ul>one two</ul>", t)
+}
+
+func Test_Entities(t *testing.T) {
+	res, err := run("p\n\ta[title=\"a&nbsp;b &amp; c & d\"]\n\t| 10#{nbsp()}km #{entity(\"rarr\")} #{entity(\"#8594\")} &copy; #{Name}", map[string]string{"Name": "&nbsp;"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<p><a title=\"a\u00a0b &amp; c &amp; d\"></a>10&nbsp;km &rarr; &#8594; &copy; &amp;nbsp;</p>", t)
+
+	// character references of static values are resolved and escaped once, in the context of the attribute
+	res, err = run("a[href=\"/a?x=1&amp;y=2\"][onclick=\"f(&quot;x&quot;)\"][data-x=\"&lt;b&gt;\"]\nbutton[onclick=\"go('x', 1 < 2)\"]", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<a href=\"/a?x=1&amp;y=2\" onclick=\"f(&#34;x&#34;)\" data-x=\"&lt;b&gt;\"></a><button onclick=\"go(&#39;x&#39;, 1 &lt; 2)\"></button>", t)
+
+	if _, err := run("| #{entity(\"rarr; <b>\")}", nil); err == nil {
+		t.Fatal("Expected an error on an unknown entity.")
+	}
+}
+
+func Test_Passthrough(t *testing.T) {
+	res, err := run("ul\n\tgo:\n\t\t{{range $i, $n := .Names}}\n\t\t<li>{{$i}}: {{$n}}</li>\n\t\t{{end}}\n\t={{with .Title}}<li>{{.}}</li>{{end}}\n\tli\n\t\t| {{x}}", map[string]interface{}{"Names": []string{"a", "<b>"}, "Title": "T"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<ul><li>0: a</li><li>1: &lt;b&gt;</li><li>T</li><li>{{x}}</li></ul>", t)
+}
+
+func Test_PassthroughActions(t *testing.T) {
+	data := map[string]string{"Name": "<Ekin>"}
+
+	res, err := run("/! slim: passthrough_actions=true\np\n\t| Hi {{.Name}}, #{Name}", data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<p>Hi &lt;Ekin&gt;, &lt;Ekin&gt;</p>", t)
+
+	res, err = run("p\n\t| Hi {{.Name}}", data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<p>Hi {{.Name}}</p>", t)
+}
+
+func Test_StripComments(t *testing.T) {
+	source := "div\n\t/!keep License MIT\n\t/! sidebar\n\t\taside\n\t/[if IE] old\n\tp"
+
+	tpl, err := Compile(source, Options{StripComments: true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(buf.String(), "<div><!-- License MIT --><!--[if IE]>old<![endif]--><p></p></div>\n", t)
+
+	res, err := run(source, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<div><!-- License MIT --><!-- sidebar <aside></aside> --><!--[if IE]>old<![endif]--><p></p></div>", t)
+}
+
+func Test_ConditionalComment(t *testing.T) {
+	res, err := run("div\n\t/[if mso]\n\t\ttable\n\t\t\ttr\n\t/[if !mso]>\n\t\tp\n\t\t\t| #{Name}\n\t/[if IE] old", map[string]string{"Name": "<modern>"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<div><!--[if mso]><table><tr></tr></table><![endif]--><!--[if !mso]><!--><p>&lt;modern&gt;</p><!--<![endif]--><!--[if IE]>old<![endif]--></div>", t)
+}
+
+func Test_TrailingComment(t *testing.T) {
+	res, err := run("div.card  / renders the product card\n\ta[title=\"a / b\"] /\n\tspan#total / sum", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<div class=\"card\"><a title=\"a / b\"></a><span id=\"total\"></span></div>", t)
+}
+
+func Test_Pragma(t *testing.T) {
+	res, err := run("/! slim: format=html\ndiv\n\tbr", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<div><br></div>", t)
+
+	if _, err := run("/! slim: colors=true\ndiv", nil); err == nil || !strings.Contains(err.Error(), "Unknown pragma option colors.") {
+		t.Fatalf("Expected unknown pragma option error, got %v", err)
+	}
+
+	root, err := ioutil.TempDir("", "slim")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(root)
+
+	ioutil.WriteFile(filepath.Join(root, "page.html.slim"), []byte("div\n\timport mail\n\tbr"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "mail.html.slim"), []byte("/! slim: pretty=false, format=html\np\n\tbr"), 0644)
+
+	renderer := NewRenderer(root)
+	renderer.Pretty = true
+
+	rec := httptest.NewRecorder()
+	if err := renderer.HTML(rec, http.StatusOK, "page", nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(rec.Body.String(), "<div><p><br></p>\n\t<br />\n</div>\n", t)
+}
+
+func Test_Extensions(t *testing.T) {
+	root, err := ioutil.TempDir("", "slim")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(root)
+
+	ioutil.WriteFile(filepath.Join(root, "page.slim"), []byte("div\n\timport card"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "card.html.slim"), []byte("p"), 0644)
+
+	renderer := NewRenderer(root)
+	renderer.Pretty = false
+	renderer.Extensions = []string{".slim", ".html.slim"}
+
+	rec := httptest.NewRecorder()
+	if err := renderer.HTML(rec, http.StatusOK, "page", nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(rec.Body.String(), "<div><p></p></div>\n", t)
+}
+
+func Test_SetTemplateDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "slim")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(root)
+
+	ioutil.WriteFile(filepath.Join(root, "card.slim"), []byte("p\n\t| #{Title}"), 0644)
+
+	compiler := New()
+	compiler.Pretty = false
+	compiler.Extensions = []string{".slim"}
+	compiler.SetTemplateDir(root)
+
+	if err := compiler.Parse("div\n\timport card"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tpl, err := compiler.CompileWithName("page")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, map[string]string{"Title": "x"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<div><p>x</p></div>", t)
+}
+
+func Test_Must(t *testing.T) {
+	var buf bytes.Buffer
+	if err := MustCompile("p", Options{}).Execute(&buf, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<p></p>", t)
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("Expected a panic for a missing file.")
+		}
+	}()
+
+	MustCompileFile(filepath.Join(os.TempDir(), "missing.slim"), Options{})
+}
+
+func Test_NewWithOptions(t *testing.T) {
+	fsys := fstest.MapFS{
+		"views/page.slim":       {Data: []byte("div\n\timport card\n\tbr")},
+		"views/card.html.slim":  {Data: []byte("p\n\t| #{price(Amount)}")},
+		"views/other.html.slim": {Data: []byte("p")},
+	}
+
+	compiler := NewWithOptions(
+		WithPrettyPrint(false),
+		WithDialect(parser.FORMAT_HTML),
+		WithLoader(fsys),
+		WithFuncs(template.FuncMap{"price": func(cents int) string { return fmt.Sprintf("$%d.%02d", cents/100, cents%100) }}),
+	)
+
+	if err := compiler.ParseFile("views/page.slim"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tpl, err := compiler.CompileWithFile()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, map[string]int{"Amount": 1250}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<div><p>$12.50</p><br></div>", t)
+}
+
+func Test_Library(t *testing.T) {
+	components := fstest.MapFS{
+		"components/button.slim": {Data: []byte("import icon\nmixin button($label)\n\tbutton.btn&attributes\n\t\t+icon\n\t\t| #{shout($label)}")},
+		"components/icon.slim":   {Data: []byte("mixin icon\n\ti.icon")},
+	}
+
+	compiler := NewWithOptions(
+		WithPrettyPrint(false),
+		WithLibrary("ui/", Library{FS: components, Dir: "components", Funcs: template.FuncMap{"shout": strings.ToUpper}}),
+	)
+
+	if err := compiler.Parse("import ui/button\n+button(\"save\").primary"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tpl, err := compiler.CompileWithName("page")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), `<button class="btn primary"><i class="icon"></i>SAVE</button>`, t)
+
+	if DefaultOptions.Libraries != nil {
+		t.Fatal("Expected DefaultOptions to be left alone.")
+	}
+}
+
+func Test_CompileReader(t *testing.T) {
+	tpl, err := CompileReader(strings.NewReader("ul\n\teach $item in Items\n\t\tli\n\t\t\t| #{$item}"), Options{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, map[string][]string{"Items": {"a", "b"}}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<ul><li>a</li><li>b</li></ul>", t)
+}
+
+func Test_Limits(t *testing.T) {
+	limits := parser.Limits{MaxDepth: 2, MaxLineLength: 10, MaxFileSize: 40}
+
+	if _, err := Compile("div\n\tdiv\n\t\tp", Options{Limits: limits}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err := Compile("div\n\tdiv\n\t\tdiv\n\t\t\tp", Options{Limits: limits}); err == nil || !strings.Contains(err.Error(), "Nesting exceeds the limit of 2 levels.") {
+		t.Fatalf("Expected nesting limit error, got %v", err)
+	}
+
+	if _, err := Compile("div.a.b.c.d.e", Options{Limits: limits}); err == nil || !strings.Contains(err.Error(), "Line exceeds the limit of 10 bytes.") {
+		t.Fatalf("Expected line length limit error, got %v", err)
+	}
+
+	if _, err := Compile(strings.Repeat("br\n", 20), Options{Limits: limits}); err == nil || !strings.Contains(err.Error(), "Template exceeds the limit of 40 bytes.") {
+		t.Fatalf("Expected file size limit error, got %v", err)
+	}
+
+	compiler := NewWithOptions(WithLoader(fstest.MapFS{"loop.slim": {Data: []byte("div\n\timport loop")}}))
+	if err := compiler.ParseFile("loop.slim"); err == nil || !strings.Contains(err.Error(), "imports exceed the limit of 32 levels") {
+		t.Fatalf("Expected import depth limit error, got %v", err)
+	}
+}
+
+func Test_NeverPanics(t *testing.T) {
+	inputs := []string{"", "!", "[", "a[", "a[href=", "div\n\t\tp\n\tp", "p #{", "= (", "= 1 +", "each $x in", "if", "/[if", "$x = ", "\xff\xfe", "div\n  p\n\tp", "import", "extend x"}
+
+	for _, input := range inputs {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("Compiling %q panicked with %v", input, r)
+				}
+			}()
+
+			Compile(input, Options{})
+		}()
+	}
+
+	_, err := Compile("div\n\tp\n\t\t= (", Options{})
+
+	var slimErr *parser.Error
+	if !errors.As(err, &slimErr) {
+		t.Fatalf("Expected a *parser.Error, got %v", err)
+	}
+
+	if slimErr.Line != 3 {
+		t.Fatalf("Expected the error on line 3, got %d", slimErr.Line)
+	}
+}
+
+func Test_RuneColumns(t *testing.T) {
+	p, _ := parser.NewStringParser("p[title=\"日本\"][id=Name]")
+	tag := p.Parse().Children[0].(*parser.Tag)
+
+	pos := tag.Attributes[1].SourcePosition
+	if pos.Column != 14 || pos.ByteColumn != 18 || pos.EndColumn != 23 || pos.ByteEndColumn != 27 {
+		t.Fatalf("Expected columns 14-23 and byte columns 18-27, got %d-%d and %d-%d", pos.Column, pos.EndColumn, pos.ByteColumn, pos.ByteEndColumn)
+	}
+}
+
+func Test_IndentationDiagnostics(t *testing.T) {
+	_, err := Compile("div\n\t\tp\n\t p", Options{})
+	if err == nil || !strings.Contains(err.Error(), `Mismatching indentation, expected "\t\t" (2 tabs) or less at this level but found "\t " (1 tab, 1 space).`) {
+		t.Fatalf("Expected mismatching indentation error, got %v", err)
+	}
+
+	if _, err := Compile("div\n  p", Options{IndentStyle: parser.IndentTabs}); err == nil || !strings.Contains(err.Error(), `Indentation must use tabs only, found "  " (2 spaces). - Line: 2`) {
+		t.Fatalf("Expected tabs only error, got %v", err)
+	}
+
+	if _, err := Compile("div\n\tp\nul\n  li", Options{IndentStyle: parser.IndentConsistent}); err == nil || !strings.Contains(err.Error(), "Indentation must use tabs only") {
+		t.Fatalf("Expected consistent indentation error, got %v", err)
+	}
+
+	if _, err := Compile("div\n  p\nul\n    li", Options{IndentStyle: parser.IndentSpaces}); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func Test_MixedIndentation(t *testing.T) {
+	input := "div\n\tp\nul\n    li"
+
+	if _, err := Compile(input, Options{MixedIndentation: parser.MixedIndentError}); err == nil || !strings.Contains(err.Error(), `Mixed indentation, found "    " (4 spaces) while the file is indented with tabs. - Line: 4`) {
+		t.Fatalf("Expected mixed indentation error, got %v", err)
+	}
+
+	compiler := NewWithOptions(WithMixedIndentation(parser.MixedIndentWarn, 0))
+	if err := compiler.Parse(input); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if warnings := compiler.Warnings(); len(warnings) != 1 || warnings[0].Line != 4 {
+		t.Fatalf("Expected a warning on line 4, got %v", warnings)
+	}
+
+	// tabs expanded at the tab width make both lines a single level
+	res, err := Compile("div\n\tp\n    p\n    \tbr", Options{MixedIndentation: parser.MixedIndentNormalize})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	res.Execute(&buf, nil)
+	expect(strings.TrimSpace(buf.String()), "<div><p></p><p><br /></p></div>", t)
+}
+
+func Test_MultiLevelOutdent(t *testing.T) {
+	res, err := run("div\n\tdiv\n\t\tp\n\t\t\tbr\nspan", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, "<div><div><p><br /></p></div></div><span></span>", t)
+	}
+
+	// nested text lines are closed together with their enclosing tags
+	res, err = run("div\n\tdiv\n\t\tp\n\t\t\t| x\n\t\t\t\ty\nspan", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, "<div><div><p>x\ny</p></div></div><span></span>", t)
+	}
+
+	res, err = run("div\n\tdiv\n\t\tp\n\t\t\t| x\n\t\t\t\ty\n\tspan", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, "<div><div><p>x\ny</p></div><span></span></div>", t)
+	}
+
+	res, err = run("div\n\tscript\n\t\tvar a\n\t\t\tb\n\t\t\t\tc\n\t\t\td\nspan", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, "<div><script>var a\n\tb\n\t\tc\n\td</script></div><span></span>", t)
+	}
+}
+
+func Test_LineContinuation(t *testing.T) {
+	res, err := run("a[title=\"/very/long/\\\n    path\"]\\\n  [id=\"link\"]\np\n\tbr", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<a title="/very/long/path" id="link"></a><p><br /></p>`, t)
+	}
+
+	res, err = run("a[title=\"/very/\\\n    long/\\\n  path\"]\np\n\tbr", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<a title="/very/long/path"></a><p><br /></p>`, t)
+	}
+
+	res, err = run("div\n\tp[title=printf(\"%s-%s\",\n\t\t\"a\", \"b\")]\n\t\tbr\n\tspan", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, `<div><p title="a-b"><br /></p><span></span></div>`, t)
+	}
+
+	// lines following a continuation keep their line numbers
+	if _, err := run("p[title=\"a\"]\\\n  [id=\"b\"]\n\tdiv\n  span", nil); err == nil || !strings.Contains(err.Error(), "Line: 4") {
+		t.Fatalf("Expected an error on line 4, got %v", err)
+	}
+}
+
+func Test_MultiLinePipedText(t *testing.T) {
+	input := "p\n\t| Lorem ipsum\n\t\tdolor sit\n\n\t\tamet\n\tbr\n\t| a\n\t| b"
+
+	res, err := run(input, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, "<p>Lorem ipsum\ndolor sit\n\namet<br />ab</p>", t)
+	}
+
+	tpl, err := Compile(input, Options{TextSeparator: " "})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	tpl.Execute(&buf, nil)
+	expect(strings.TrimSpace(buf.String()), "<p>Lorem ipsum dolor sit amet<br />ab</p>", t)
+
+	// lines nested below a silent comment are left out with it
+	res, err = run("div\n\t/ silent\n\t\tnested\n\tp", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, "<div><p></p></div>", t)
+	}
+}
+
+func Test_RawIndentation(t *testing.T) {
+	res, err := run("div\n\tscript\n\t\tif (a) {\n\t\t  b();\n\n\t\t    c();\n\t\t}\n\n\tp", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, "<div><script>if (a) {\n  b();\n\n    c();\n}</script><p></p></div>", t)
+	}
+
+	res, err = run("style\n    a {\n        color: red;\n    }\nspan", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, "<style>a {\n    color: red;\n}</style><span></span>", t)
+	}
+
+	// lines less indented than the first one are taken from the margin
+	res, err = run("p\n\t| a\n\t\t  b\n\t\tc", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, "<p>a\nb\nc</p>", t)
+	}
+}
+
+func Test_ScriptWrapper(t *testing.T) {
+	input := "head\n\tscript\n\t\tvar a = 1;\n\tstyle\n\t\tp { color: red; }\n\tscript[type=\"application/json\"]\n\t\t{}"
+
+	tpl, err := Compile(input, Options{Format: parser.FORMAT_XHTML})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	tpl.Execute(&buf, nil)
+	expect(strings.TrimSpace(buf.String()), "<head><script>\n//<![CDATA[\nvar a = 1;\n//]]>\n</script><style>\n/*<![CDATA[*/\np { color: red; }\n/*]]>*/\n</style><script type=\"application/json\">{}</script></head>", t)
+
+	tpl, err = Compile("script\n\tvar a = 1;", Options{Format: parser.FORMAT_XHTML, ScriptWrapper: parser.NewCommentWrapper()})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf.Reset()
+	tpl.Execute(&buf, nil)
+	expect(strings.TrimSpace(buf.String()), "<script><!--\nvar a = 1;\n//--></script>", t)
+
+	// only templates explicitly in xhtml format are wrapped
+	res, err := run("script\n\tvar a = 1;", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else {
+		expect(res, "<script>var a = 1;</script>", t)
+	}
+}
+
+func Test_MarkdownFilter(t *testing.T) {
+	// a stand-in renderer turning lines into paragraphs
+	markdown := func(content string) (string, error) {
+		return "<p>" + strings.Join(strings.Split(strings.TrimSpace(content), "\n"), "</p>\n<p>") + "</p>\n", nil
+	}
+
+	fsys := fstest.MapFS{
+		"views/page.slim":     {Data: []byte("div\n\tmarkdown docs/intro.md\n\t:markdown\n\t\tfirst\n\t\tsecond {{x}}\n\tbr")},
+		"views/docs/intro.md": {Data: []byte("intro\n")},
+	}
+
+	compiler := NewWithOptions(WithPrettyPrint(false), WithLoader(fsys), WithFilter("markdown", markdown))
+	if err := compiler.ParseFile("views/page.slim"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tpl, err := compiler.CompileWithFile()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<div><p>intro</p><p>first</p>\n<p>second {{x}}</p><br /></div>", t)
+
+	if _, err := run("div\n\t:textile\n\t\tx", nil); err == nil || !strings.Contains(err.Error(), "Unknown filter textile. - Line: 2") {
+		t.Fatalf("Expected unknown filter error, got %v", err)
+	}
+}
+
+func Test_IncludeRaw(t *testing.T) {
+	fsys := fstest.MapFS{
+		"views/page.slim":           {Data: []byte("head\n\tinclude_raw assets/critical.css\nbody\n\tinclude_raw assets/icon.svg\n\tinclude_raw unescaped assets/icon.svg")},
+		"views/assets/critical.css": {Data: []byte("<style>p > a { color: red; }</style>\n")},
+		"views/assets/icon.svg":     {Data: []byte("<svg title=\"{{x}}\"></svg>\n")},
+	}
+
+	compiler := NewWithOptions(WithPrettyPrint(false), WithLoader(fsys))
+	if err := compiler.ParseFile("views/page.slim"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tpl, err := compiler.CompileWithFile()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), `<head><style>p > a { color: red; }</style></head><body><svg title="{{x}}"></svg><svg title="{{x}}"></svg></body>`, t)
+}
+
+func Test_ImportHTML(t *testing.T) {
+	fsys := fstest.MapFS{
+		"views/page.slim":   {Data: []byte("body\n\timport header.html\n\timport footer.html\n\tp")},
+		"views/header.html": {Data: []byte("<header><a href=\"/\">Home</a></header>\n")},
+		// templates of that name take precedence
+		"views/footer.html":      {Data: []byte("<footer></footer>\n")},
+		"views/footer.html.slim": {Data: []byte("footer\n\t| slim")},
+	}
+
+	compiler := NewWithOptions(WithPrettyPrint(false), WithLoader(fsys))
+	if err := compiler.ParseFile("views/page.slim"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tpl, err := compiler.CompileWithFile()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), `<body><header><a href="/">Home</a></header><footer>slim</footer><p></p></body>`, t)
+}
+
+func Test_CommandFilter(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	counter := filepath.Join(t.TempDir(), "runs")
+	upper := CommandFilter("sh", "-c", "echo >> "+counter+"; tr a-z A-Z")
+
+	for i := 0; i < 2; i++ {
+		tpl, err := Compile("div\n\t:upper\n\t\t<b>bold</b>", Options{Filters: map[string]Filter{"upper": upper}})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		var buf bytes.Buffer
+		tpl.Execute(&buf, nil)
+		expect(strings.TrimSpace(buf.String()), "<div><B>BOLD</B></div>", t)
+	}
+
+	// the second compilation takes the cached result
+	if runs, _ := os.ReadFile(counter); len(runs) != 1 {
+		t.Fatalf("Expected a single run of the command, got %d", len(runs))
+	}
+
+	failing := CommandFilter("sh", "-c", "echo broken >&2; exit 3")
+	if _, err := Compile(":broken\n\tx", Options{Filters: map[string]Filter{"broken": failing}}); err == nil || !strings.Contains(err.Error(), "Filter broken failed: sh: exit status 3: broken") {
+		t.Fatalf("Expected a failing filter, got %v", err)
+	}
+
+	start := time.Now()
+	slow := FilterCommand{Name: "sh", Args: []string{"-c", "sleep 5"}, Timeout: 100 * time.Millisecond}.Filter()
+	if _, err := slow("x"); err == nil || !strings.Contains(err.Error(), "sh: timed out after 100ms") || time.Since(start) > 3*time.Second {
+		t.Fatalf("Expected the command to time out, got %v after %s", err, time.Since(start))
+	}
+
+	// the least recently used result is dropped beyond CacheSize
+	os.Remove(counter)
+	bounded := FilterCommand{Name: "sh", Args: []string{"-c", "echo >> " + counter + "; cat"}, CacheSize: 1}.Filter()
+	for _, content := range []string{"a", "a", "b", "a"} {
+		if result, err := bounded(content); err != nil || result != content {
+			t.Fatalf("Expected {%s} got {%s} %v", content, result, err)
+		}
+	}
+
+	if runs, _ := os.ReadFile(counter); len(runs) != 3 {
+		t.Fatalf("Expected three runs of the command, got %d", len(runs))
+	}
+}
+
+func Test_TextFormat(t *testing.T) {
+	input := "doctype html\nhtml\n\thead\n\t\ttitle\n\t\t\t| Welcome\n\tbody\n\t\th1\n\t\t\t| Hello #{Name}\n\t\tp\n\t\t\t| Tom &amp; Jerry\n\t\t\tbr\n\t\t\t| second\n\t\tul\n\t\t\tli\n\t\t\t\t| one\n\t\t\tli\n\t\t\t\t| two\n\t\tp\n\t\t\ta[href=URL]\n\t\t\t\t| Confirm\n\t\t/! note\n\t\thr\n\t\tp\n\t\t\t| Bye"
+
+	tpl, err := Compile(input, Options{Pretty: true, Format: parser.FORMAT_TEXT})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, map[string]string{"Name": "<Ann>", "URL": "https://example.org/?a=1&b=2"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "Hello <Ann>\n\nTom & Jerry\nsecond\n\n- one\n- two\n\nConfirm (https://example.org/?a=1&b=2)\n\n---\n\nBye", t)
+
+	// static text holding markup characters is output as is, not escaped by the context it would open
+	tpl, err = Compile("p\n\t| 1 < 2 & \"q\" 'a' #{Name}\np\n\t| <a href=\"#{URL}\"> #{Name < \"b\"}\na[href=\"/x?a=1&b=<2>\"]\n\t| go", Options{Format: parser.FORMAT_TEXT})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf.Reset()
+	if err := tpl.Execute(&buf, map[string]string{"Name": "<Ann>", "URL": "https://example.org/?a=1&b=2"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "1 < 2 & \"q\" 'a' <Ann>\n\n<a href=\"https://example.org/?a=1&b=2\"> true\n\ngo (/x?a=1&b=<2>)", t)
+}
+
+func Test_RendererBuffers(t *testing.T) {
+	root := t.TempDir()
+
+	ioutil.WriteFile(filepath.Join(root, "ok.html.slim"), []byte("div\n\t| #{A}"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "broken.html.slim"), []byte("div\n\t| before\n\tp\n\t\t| #{B.Broken}"), 0644)
+
+	renderer := NewRenderer(root)
+	renderer.Pretty = false
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		if err := renderer.HTML(rec, http.StatusOK, "ok", map[string]string{"A": "x"}); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		expect(rec.Body.String(), "<div>x</div>\n", t)
+	}
+
+	rec := httptest.NewRecorder()
+	renderer.HTML(rec, http.StatusOK, "broken", map[string]interface{}{"B": brokenData{}})
+
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "before") {
+		t.Fatalf("Expected a plain 500 response, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	stats := renderer.Stats()
+	if stats.TemplateHits != 1 || stats.TemplateMisses != 2 || stats.Renders != 3 || stats.Errors != 1 || stats.BufferHits+stats.BufferMisses != 3 || stats.RenderTime <= 0 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+
+	// streamed pages are cut off where they fail
+	renderer.Streaming = true
+
+	rec = httptest.NewRecorder()
+	if err := renderer.HTML(rec, http.StatusOK, "broken", map[string]interface{}{"B": brokenData{}}); err == nil {
+		t.Fatal("Expected a failing render")
+	}
+
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "<div>before<p>") {
+		t.Fatalf("Expected a partial page, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	// templates failing to be found or compiled are reported before the status is sent
+	ioutil.WriteFile(filepath.Join(root, "invalid.html.slim"), []byte("div\n\tp[title=\"open]"), 0644)
+
+	for _, name := range []string{"missing", "invalid"} {
+		rec = httptest.NewRecorder()
+		if err := renderer.HTML(rec, http.StatusOK, name, nil); err == nil {
+			t.Fatalf("Expected template %s to fail", name)
+		}
+
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected a 500 response on template %s, got %d %s", name, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func Test_RendererHooks(t *testing.T) {
+	root := t.TempDir()
+
+	ioutil.WriteFile(filepath.Join(root, "page.html.slim"), []byte("div\n\t| #{Title}"), 0644)
+
+	renderer := NewRenderer(root)
+	renderer.Pretty = false
+
+	var calls []string
+
+	renderer.BeforeRender(func(name string, data interface{}) {
+		calls = append(calls, "before "+name)
+
+		// data can be decorated before it is rendered
+		data.(map[string]string)["Title"] = "decorated"
+	})
+
+	renderer.AfterRender(func(name string, d time.Duration, err error) {
+		calls = append(calls, fmt.Sprintf("after %s %v %v", name, d > 0, err != nil))
+	})
+
+	rec := httptest.NewRecorder()
+	if err := renderer.HTML(rec, http.StatusOK, "page", map[string]string{}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(rec.Body.String(), "<div>decorated</div>\n", t)
+
+	renderer.Execute(ioutil.Discard, "missing", map[string]string{})
+
+	expect(strings.Join(calls, ", "), "before page, after page true false, before missing, after missing true true", t)
+}
+
+func Test_Tracing(t *testing.T) {
+	root := t.TempDir()
+
+	ioutil.WriteFile(filepath.Join(root, "page.html.slim"), []byte("div\n\t| #{Title}"), 0644)
+
+	tracer := &recordTracer{}
+
+	renderer := NewRenderer(root)
+	renderer.Pretty = false
+	renderer.TracerProvider = tracer
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		if err := renderer.HTML(rec, http.StatusOK, "page", map[string]string{"Title": "traced"}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	expect(strings.Join(tracer.spans, "\n"), strings.Join([]string{
+		"slim.Compile slim.template=page",
+		"slim.Render slim.template=page slim.cache_hit=false slim.bytes_written=18",
+		"slim.Render slim.template=page slim.cache_hit=true slim.bytes_written=18",
+	}, "\n"), t)
+
+	tracer.spans = nil
+
+	compiler := New()
+	compiler.TracerProvider = tracer
+
+	if err := compiler.ParseFile(filepath.Join(root, "missing.slim")); err == nil {
+		t.Fatal("parsing a missing file must fail")
+	}
+
+	expect(strings.Join(tracer.spans, "\n"), "slim.ParseFile slim.template="+filepath.Join(root, "missing.slim")+" error", t)
+}
+
+type recordTracer struct {
+	spans []string
+}
+
+func (tracer *recordTracer) Tracer(name string) Tracer {
+	return tracer
+}
+
+func (tracer *recordTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, &recordSpan{tracer: tracer, value: name}
+}
+
+type recordSpan struct {
+	tracer *recordTracer
+	value  string
+}
+
+func (span *recordSpan) SetAttribute(key string, value interface{}) {
+	span.value += fmt.Sprintf(" %s=%v", key, value)
+}
+
+func (span *recordSpan) RecordError(err error) {
+	span.value += " error"
+}
+
+func (span *recordSpan) End() {
+	span.tracer.spans = append(span.tracer.spans, span.value)
+}
+
+func Test_Metrics(t *testing.T) {
+	root := t.TempDir()
+
+	ioutil.WriteFile(filepath.Join(root, "page.html.slim"), []byte("div\n\t| #{Title}"), 0644)
+
+	metrics := &recordMetrics{}
+
+	renderer := NewRenderer(root)
+	renderer.Pretty = false
+	renderer.Metrics = metrics
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		renderer.HTML(rec, http.StatusOK, "page", map[string]string{"Title": "measured"})
+	}
+
+	renderer.HTML(httptest.NewRecorder(), http.StatusOK, "missing", nil)
+
+	expect(strings.Join(metrics.calls, ", "), strings.Join([]string{
+		"lookup page false", "compiled page true", "rendered page true",
+		"lookup page true", "rendered page true",
+		"lookup missing false", "rendered missing false",
+	}, ", "), t)
+
+	vars := &expvarMetrics{vars: new(expvar.Map)}
+	vars.TemplateLookup("page", true)
+	vars.TemplateRendered("page", time.Millisecond, errors.New("failed"))
+
+	expect(vars.vars.Get("cache_hits").String()+" "+vars.vars.Get("render_errors").String(), "1 1", t)
+}
+
+type recordMetrics struct {
+	calls []string
+}
+
+func (m *recordMetrics) TemplateCompiled(name string, d time.Duration, err error) {
+	m.calls = append(m.calls, fmt.Sprintf("compiled %s %v", name, err == nil))
+}
+
+func (m *recordMetrics) TemplateLookup(name string, hit bool) {
+	m.calls = append(m.calls, fmt.Sprintf("lookup %s %v", name, hit))
+}
+
+func (m *recordMetrics) TemplateRendered(name string, d time.Duration, err error) {
+	m.calls = append(m.calls, fmt.Sprintf("rendered %s %v", name, err == nil))
+}
+
+func Test_RenderContext(t *testing.T) {
+	root := t.TempDir()
+
+	ioutil.WriteFile(filepath.Join(root, "page.html.slim"), []byte("div[title=t(\"greeting\")]\n\t| #{global(\"User\")}"), 0644)
+
+	renderer := NewRenderer(root)
+	renderer.Pretty = false
+
+	type localeKey struct{}
+
+	renderer.AddContextFuncs(template.FuncMap{
+		"t": func(ctx context.Context, key string) string {
+			if locale, ok := ctx.Value(localeKey{}).(string); ok {
+				return locale + "." + key
+			}
+
+			return key
+		},
+	})
+
+	ctx := context.WithValue(WithValue(context.Background(), "User", "ada"), localeKey{}, "de")
+
+	rec := httptest.NewRecorder()
+	if err := renderer.RenderContext(ctx, rec, http.StatusOK, "page", nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(rec.Body.String(), "<div title=\"de.greeting\">ada</div>\n", t)
+
+	rec = httptest.NewRecorder()
+	renderer.HTML(rec, http.StatusOK, "page", nil)
+
+	expect(rec.Body.String(), "<div title=\"greeting\"></div>\n", t)
+
+	// renders reuse bound clones of the template, each one sees its own context and values only,
+	// while helpers may still be registered for templates compiled later
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(locale string) {
+			defer wg.Done()
+
+			renderer.AddContextFuncs(template.FuncMap{"locale": func(ctx context.Context) string { return locale }})
+
+			rec := httptest.NewRecorder()
+			ctx := context.WithValue(WithValue(context.Background(), "User", locale), localeKey{}, locale)
+			if err := renderer.RenderContext(ctx, rec, http.StatusOK, "page", nil); err != nil {
+				t.Error(err.Error())
+				return
+			}
+
+			if want := "<div title=\"" + locale + ".greeting\">" + locale + "</div>\n"; rec.Body.String() != want {
+				t.Errorf("Expected {%s} got {%s}.", want, rec.Body.String())
+			}
+		}(fmt.Sprint("l", i))
+	}
+
+	wg.Wait()
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if err := renderer.RenderContext(canceled, httptest.NewRecorder(), http.StatusOK, "page", nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the render to be canceled, got %v", err)
+	}
+}
+
+func Test_CompileDir(t *testing.T) {
+	root := t.TempDir()
+
+	os.MkdirAll(filepath.Join(root, "users"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "users", "show.slim"), []byte("div\n\t| #{Name}"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "index.slim"), []byte("ul\n\t| index"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "notes.txt"), []byte("not a template"), 0644)
+
+	tpl, err := CompileDir(root, Options{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.ExecuteTemplate(&buf, "users/show", map[string]string{"Name": "ada"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tpl.ExecuteTemplate(&buf, "index", nil)
+
+	expect(strings.TrimSpace(buf.String()), "<div>ada</div>\n<ul>index</ul>", t)
+
+	if tpl.Lookup("notes") != nil {
+		t.Fatal("files without a slim extension must be skipped")
+	}
+
+	// mixins of the same name defined by different templates keep apart
+	ioutil.WriteFile(filepath.Join(root, "a.slim"), []byte("mixin card($x)\n\tb\n\t\t| A #{$x}\n+card(\"a\")"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "b.slim"), []byte("mixin card($x)\n\tspan\n\t\t| B #{$x}\n+card(\"b\")"), 0644)
+
+	tpl, err = CompileDir(root, Options{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf.Reset()
+	tpl.ExecuteTemplate(&buf, "a", nil)
+	tpl.ExecuteTemplate(&buf, "b", nil)
+
+	expect(strings.TrimSpace(buf.String()), "<b>A a</b>\n<span>B b</span>", t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := CompileDirContext(ctx, root, Options{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected compiling to be canceled, got %v", err)
+	}
+}
+
+func Test_Strict(t *testing.T) {
+	if _, err := Compile("div[title=titel(Name)]", Options{Strict: true}); err == nil || !strings.Contains(err.Error(), "Unknown helper titel.") {
+		t.Fatalf("Expected unknown helper error, got %v", err)
+	}
+
+	if _, err := Compile("div[title=title(Name)]", Options{Strict: true}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	compiler := NewWithOptions(WithOptions(Options{Strict: true}), WithFuncs(template.FuncMap{"titel": strings.ToTitle}))
+	if err := compiler.Parse("div[title=titel(Name)]"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err := compiler.CompileWithName("page"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	loader := fstest.MapFS{
+		"layout.slim":  {Data: []byte("div\n\tblock content")},
+		"plain.slim":   {Data: []byte("div")},
+		"page.slim":    {Data: []byte("extend layout\nblock content\n\t| page")},
+		"typo.slim":    {Data: []byte("extend layout\nblock contnet\n\t| page")},
+		"noblock.slim": {Data: []byte("extend plain\nblock content\n\t| page")},
+	}
+
+	strict := NewWithOptions(WithOptions(Options{Strict: true}), WithLoader(loader))
+	if err := strict.ParseFile("page.slim"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := strict.ParseFile("typo.slim"); err == nil || !strings.Contains(err.Error(), "Block contnet is not defined by any parent template.") {
+		t.Fatalf("Expected undefined block error, got %v", err)
+	}
+
+	if err := strict.ParseFile("noblock.slim"); err == nil || !strings.Contains(err.Error(), "defines no blocks to override.") {
+		t.Fatalf("Expected no blocks error, got %v", err)
+	}
+
+	if err := NewWithOptions(WithLoader(loader)).ParseFile("typo.slim"); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func Test_ChainedExtend(t *testing.T) {
+	loader := fstest.MapFS{
+		"base.slim":    {Data: []byte("html\n\thead\n\t\tblock head\n\t\t\tmeta\n\tbody\n\t\tblock content\n\t\tblock footer\n\t\t\t| base")},
+		"section.slim": {Data: []byte("extend base\nblock append head\n\tlink\nblock content\n\tmain\n\t\tblock main\n\t\t\t| section")},
+		"page.slim":    {Data: []byte("extend section\nblock prepend head\n\ttitle\nblock main\n\t| page\nblock footer\n\t| page footer")},
+		"append.slim":  {Data: []byte("extend section\nblock append main\n\t| !\nblock append footer\n\t| !")},
+	}
+
+	render := func(filename string) string {
+		compiler := NewWithOptions(WithOptions(Options{}), WithLoader(loader))
+		if err := compiler.ParseFile(filename); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		tpl, err := compiler.CompileWithName(filename)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		var buf bytes.Buffer
+		if err := tpl.Execute(&buf, nil); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		return strings.TrimSpace(buf.String())
+	}
+
+	expect(render("section.slim"), "<html><head><meta /><link /></head><body><main>section</main>base</body></html>", t)
+	expect(render("page.slim"), "<html><head><title></title><meta /><link /></head><body><main>page</main>page footer</body></html>", t)
+	expect(render("append.slim"), "<html><head><meta /><link /></head><body><main>section!</main>base!</body></html>", t)
+}
+
+func Test_NestedNamedBlocks(t *testing.T) {
+	loader := fstest.MapFS{
+		"layout.slim": {Data: []byte("div\n\tp\n\t\tblock label\n\t\t\t| default\n\tnav\n\t\tblock nav\n\tif Show\n\t\tblock content\n\tul\n\t\teach $x in Items\n\t\t\tli\n\t\t\t\tblock item\n\t\t\t\t\t| #{$x}")},
+		"page.slim":   {Data: []byte("extend layout\nblock label\n\t| page\nblock nav\n\t.active\n\ta\n\t\t| home\nblock content\n\tb\n\t\t| shown\nblock item\n\ti\n\t\t| #{$x}")},
+		"broken.slim": {Data: []byte("extend layout\nblock content\n\t.active")},
+	}
+
+	compiler := NewWithOptions(WithOptions(DefaultOptions), WithLoader(loader))
+	if err := compiler.ParseFile("page.slim"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tpl, err := compiler.CompileWithName("page")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, map[string]interface{}{"Show": true, "Items": []string{"a"}}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<div>\n\t<p>page</p>\n\t<nav class=\"active\"><a>home</a></nav>\n\t<b>shown</b>\n\t<ul>\n\t\t<li><i>a</i></li>\n\t</ul>\n</div>", t)
+
+	err = NewWithOptions(WithLoader(loader)).ParseFile("broken.slim")
+	if err == nil || !strings.Contains(err.Error(), "Attributes of block content must be placed immediately within a parent tag.") {
+		t.Fatalf("Expected misplaced attributes error, got %v", err)
+	}
+}
+
+func Test_UnmatchedBlockWarnings(t *testing.T) {
+	loader := fstest.MapFS{
+		"layout.slim": {Data: []byte("div\n\tblock content\n\t\t| default")},
+		"page.slim":   {Data: []byte("extend layout\nblock contnet\n\t| page\nblock sidebar\n\t| aside")},
+	}
+
+	compiler := NewWithOptions(WithLoader(loader))
+	if err := compiler.ParseFile("page.slim"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	warnings := compiler.Warnings()
+	if len(warnings) != 2 {
+		t.Fatalf("Expected 2 warnings, got %v", warnings)
+	}
+
+	expect(warnings[0].String(), "Slim Warning in <page.slim>: Block contnet matches no block of layout.slim and is dropped. Did you mean block content at layout.slim:2:2? - Line: 2, Column: 1", t)
+	expect(warnings[1].String(), "Slim Warning in <page.slim>: Block sidebar matches no block of layout.slim and is dropped. - Line: 4, Column: 1", t)
+}
+
+func Test_IncludePaths(t *testing.T) {
+	loader := fstest.MapFS{
+		"views/page.slim":             {Data: []byte("extend layout\nblock content\n\timport button\n\timport card")},
+		"views/card.slim":             {Data: []byte("p\n\t| project card")},
+		"shared/button.slim":          {Data: []byte("button\n\t| shared button")},
+		"shared/card.slim":            {Data: []byte("p\n\t| shared card")},
+		"themes/base/layout.slim":     {Data: []byte("main\n\tblock content")},
+		"themes/base/button.slim":     {Data: []byte("button\n\t| theme button")},
+		"themes/base/unreadable.slim": {Data: []byte("import nowhere")},
+	}
+
+	compiler := NewWithOptions(WithOptions(Options{}), WithLoader(loader), WithIncludePaths("shared", "themes/base"))
+	compiler.SetTemplateDir("views")
+
+	if err := compiler.ParseFile("views/page.slim"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tpl, err := compiler.CompileWithName("page")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<main><button>shared button</button><p>project card</p></main>", t)
+
+	err = compiler.ParseFile("themes/base/unreadable.slim")
+	if err == nil || !strings.Contains(err.Error(), "views/nowhere") {
+		t.Fatalf("Expected import error within the template directory, got %v", err)
+	}
+}
+
+func Test_LayeredTemplates(t *testing.T) {
+	site := fstest.MapFS{
+		"header.slim": {Data: []byte("header\n\t| site header")},
+	}
+	theme := fstest.MapFS{
+		"page.slim": {Data: []byte("main\n\timport header\n\timport footer")},
+	}
+	core := fstest.MapFS{
+		"header.slim": {Data: []byte("header\n\t| core header")},
+		"footer.slim": {Data: []byte("footer\n\t| core footer")},
+		"page.slim":   {Data: []byte("main")},
+	}
+
+	layers := NewLayeredFS(site, theme, core)
+
+	compiler := NewWithOptions(WithOptions(Options{}), WithLoader(layers))
+	if err := compiler.ParseFile("page.slim"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tpl, err := compiler.CompileWithName("page")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<main><header>site header</header><footer>core footer</footer></main>", t)
+
+	entries, err := fs.ReadDir(layers, ".")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	expect(strings.Join(names, ","), "footer.slim,header.slim,page.slim", t)
+
+	root, themeDir := t.TempDir(), t.TempDir()
+
+	ioutil.WriteFile(filepath.Join(root, "header.html.slim"), []byte("header\n\t| site header"), 0644)
+	ioutil.WriteFile(filepath.Join(themeDir, "header.html.slim"), []byte("header\n\t| theme header"), 0644)
+	ioutil.WriteFile(filepath.Join(themeDir, "page.html.slim"), []byte("main\n\timport header"), 0644)
+
+	renderer := NewRenderer(root)
+	renderer.Pretty = false
+	renderer.Roots = []string{themeDir}
+
+	rec := httptest.NewRecorder()
+	if err := renderer.HTML(rec, http.StatusOK, "page", nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(rec.Body.String()), "<main><header>site header</header></main>", t)
+}
+
+func Test_Set(t *testing.T) {
+	loader := fstest.MapFS{
+		"users/show.slim": {Data: []byte("h1\n\t| #{shout(Name)}")},
+	}
+
+	set := NewSet(WithOptions(Options{}), WithLoader(loader))
+	set.AddFuncs(template.FuncMap{"shout": strings.ToUpper})
+
+	if err := set.AddFile("users/show", "users/show.slim"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := set.AddString("home", "p\n\t| home"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := set.AddString("broken", "each $x in"); err == nil {
+		t.Fatal("Expected a compile error")
+	}
+
+	expect(strings.Join(set.Names(), ","), "home,users/show", t)
+
+	var buf bytes.Buffer
+	if err := set.ExecuteTemplate(&buf, "users/show", map[string]string{"Name": "ada"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := set.Lookup("home").Execute(&buf, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<h1>ADA</h1>\n<p>home</p>", t)
+
+	if set.Lookup("missing") != nil {
+		t.Fatal("Expected no template")
+	}
+
+	if err := set.ExecuteTemplate(&buf, "missing", nil); err == nil || err.Error() != `slim: no template "missing"` {
+		t.Fatalf("Expected missing template error, got %v", err)
+	}
+}
+
+func Test_Digest(t *testing.T) {
+	loader := fstest.MapFS{
+		"layout.slim": {Data: []byte("main\n\tblock content")},
+		"page.slim":   {Data: []byte("extend layout\nblock content\n\tp\n\t\t| #{Name}")},
+	}
+
+	compile := func() *template.Template {
+		compiler := NewWithOptions(WithLoader(loader))
+		if err := compiler.ParseFile("page.slim"); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		tpl, err := compiler.CompileWithName("page")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		expect(Digest(tpl), compiler.Digest(), t)
+		return tpl
+	}
+
+	first := Digest(compile())
+	expect(fmt.Sprint(len(first)), "64", t)
+	expect(Digest(compile()), first, t)
+
+	loader["layout.slim"] = &fstest.MapFile{Data: []byte("main.wide\n\tblock content")}
+
+	if Digest(compile()) == first {
+		t.Fatal("Expected the digest to change along with the layout")
+	}
+
+	expect(Digest(template.Must(template.New("plain").Parse("plain"))), "", t)
+
+	// the digest and the source map go along with the template and its clones rather than a global registry
+	tpl := compile()
+	clone, err := tpl.Clone()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(Digest(clone), Digest(tpl), t)
+
+	var compiler *Compiler
+	for _, source := range []string{"p\n\t| first", "p\n\t| second"} {
+		compiler = New()
+		if err := compiler.Parse(source); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if _, err := compiler.CompileWithTemplate(tpl); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	expect(fmt.Sprint(len(tpl.Templates())), fmt.Sprint(len(clone.Templates())), t)
+	expect(Digest(tpl), compiler.Digest(), t)
+}
+
+func Test_UnusedWarnings(t *testing.T) {
+	compiler := New()
+	if err := compiler.Parse("$used = 1\n$unused = 2\ndiv\n\t| #{$used}"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if warnings := compiler.Warnings(); len(warnings) != 1 || warnings[0].Line != 2 || !strings.Contains(warnings[0].Message, "Variable $unused is assigned but never used.") {
+		t.Fatalf("Expected a warning on $unused, got %v", warnings)
+	}
+
+	compiler = New()
+	if err := compiler.Parse("$a = 1\n$b = 2\n$c = 3\ngo:\n\t{{$a}}\n={{$b}}\np\n\t| {{$c}}"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if warnings := compiler.Warnings(); len(warnings) != 1 || !strings.Contains(warnings[0].Message, "Variable $c is assigned but never used.") {
+		t.Fatalf("Expected a warning on $c only, got %v", warnings)
+	}
+
+	compiler = New()
+	compiler.PassthroughActions = true
+	if err := compiler.Parse("$c = 3\np\n\t| {{$c}}"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if warnings := compiler.Warnings(); len(warnings) != 0 {
+		t.Fatalf("Expected no warnings with actions passed through, got %v", warnings)
+	}
+
+	compiler = New()
+	if err := compiler.Parse("/! slim: passthrough_actions=true\n$c = 3\np\n\t| {{$c}}"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if warnings := compiler.Warnings(); len(warnings) != 0 {
+		t.Fatalf("Expected no warnings with actions passed through by a pragma, got %v", warnings)
+	}
+
+	root := t.TempDir()
+
+	ioutil.WriteFile(filepath.Join(root, "layout.slim"), []byte("div\n\tblock content\n\tblock sidebar"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "page.slim"), []byte("extend layout\nblock content\n\t| page"), 0644)
+
+	warnings, err := LintDir(root, Options{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(warnings) != 1 || warnings[0].Line != 3 || !strings.Contains(warnings[0].Message, "Block sidebar is never filled") {
+		t.Fatalf("Expected a warning on block sidebar, got %v", warnings)
+	}
+}
+
+func Test_ParseBytes(t *testing.T) {
+	compiler := New()
+	if err := compiler.ParseBytes([]byte("div\n\t| #{Name}")); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	compiler = NewWithOptions(WithLimits(parser.Limits{MaxFileSize: 8}))
+
+	var slimErr *parser.Error
+	if err := compiler.ParseBytes([]byte("div\n\tp\n\tp")); !errors.As(err, &slimErr) || !strings.Contains(err.Error(), "Template exceeds the limit of 8 bytes.") {
+		t.Fatalf("Expected file size limit error, got %v", err)
+	}
+}
+
+// Long argument lists and runs of text used to take quadratic time, which stalled the fuzzer.
+func Test_LongInputs(t *testing.T) {
+	const n = 20000
+
+	res, err := run("p[title=print("+strings.Repeat("A,\n  ", n)+"B)]", map[string]string{"A": "x", "B": "y"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, `<p title="`+strings.Repeat("x", n)+`y"></p>`, t)
+
+	res, err = run("p\n"+strings.Repeat("\t| a\n", n), nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<p>"+strings.Repeat("a", n)+"</p>", t)
+}
+
+// Inputs found by fuzzing are kept in testdata/fuzz/FuzzParseBytes, run with `go test -fuzz FuzzParseBytes`.
+func FuzzParseBytes(f *testing.F) {
+	seeds := []string{
+		"div\n\tp", "a[title=\"x\"]\n\t| #{y}", "!!! 5\nhtml\n\tbody\n\t\t| hi #{Name}", "each $x in Items\n\t| #{$x}",
+		"$a = 1 + 2\n| #{$a}", "script\n\tvar x = 1;", "/[if IE]\n\tp", "div\n\t| a\\\n  b", "p[title=printf(\"%s\",\n  Name)]",
+		":markdown\n\t# x", "if a\n\tp\nelse\n\tp", "while $i < 3\n\tbr",
+	}
+
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		compiler := New()
+
+		err := compiler.ParseBytes(input)
+		if err == nil {
+			_, err = compiler.String()
+		}
+
+		if err != nil {
+			var slimErr *parser.Error
+			if !errors.As(err, &slimErr) {
+				t.Fatalf("Expected a *parser.Error compiling %q, got %T: %v", input, err, err)
+			}
+		}
+	})
+}
+
+func Test_ValidateHTML(t *testing.T) {
+	valid := "html\n\thead\n\t\ttitle\n\t\t\t| Page\n\tbody\n\t\tp\n\t\t\tspan\n\t\t\t\tem\n\t\t\tbr\n\t\ta\n\t\t\tdiv\n\t\tmy-widget\n\t\tsvg\n\t\t\ttitle"
+	if _, err := Compile(valid, Options{ValidateHTML: true}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	invalid := map[string]string{
+		"div\n\tp\n\t\tp":              "Element p cannot be nested inside p. - Line: 3",
+		"span\n\tdiv":                  "Element div cannot be nested inside span. - Line: 2",
+		"head\n\ttitle\nbody\n\ttitle": "more than one title element. - Line: 4",
+		"div\n\tblink":                 "Unknown element blink. - Line: 2",
+		"br\n\tspan":                   "Void element br cannot have content. - Line: 1",
+		"p\n\tif Show\n\t\tul":         "Element ul cannot be nested inside p. - Line: 3",
+	}
+
+	for input, message := range invalid {
+		if _, err := Compile(input, Options{ValidateHTML: true}); err == nil || !strings.Contains(err.Error(), message) {
+			t.Fatalf("Expected %q compiling %q, got %v", message, input, err)
+		}
+
+		if _, err := Compile(input, Options{}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+}
+
+func Test_CheckURLs(t *testing.T) {
+	links := map[string]string{
+		"https://example.com/a": `<a href="https://example.com/a"></a>`,
+		"/relative?q=1":         `<a href="/relative?q=1"></a>`,
+		"tel:123":               `<a href="tel:123"></a>`,
+		"javascript:alert(1)":   `<a href="#ZgotmplZ"></a>`,
+		" JavaScript:alert(1)":  `<a href="#ZgotmplZ"></a>`,
+		"data:text/html,hi":     `<a href="#ZgotmplZ"></a>`,
+	}
+
+	tpl, err := Compile("a[href=safeURL(Link)]", Options{CheckURLs: true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for link, want := range links {
+		var buf bytes.Buffer
+		if err := tpl.Execute(&buf, map[string]string{"Link": link}); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		expect(strings.TrimSpace(buf.String()), want, t)
+	}
+
+	tpl, err = Compile("img[src=Link]", Options{CheckURLs: true, URLSchemes: []string{"https"}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	tpl.Execute(&buf, map[string]string{"Link": "http://example.com/a.png"})
+
+	expect(strings.TrimSpace(buf.String()), `<img src="#ZgotmplZ" />`, t)
+}
+
+func Test_AuditEscaping(t *testing.T) {
+	valid := "div[title=Name]\n\t| <a title=\"#{Name}\">#{Name}</a>\nscript\n\t| var name = #{Name};"
+	if _, err := Compile(valid, Options{AuditEscaping: true}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	invalid := map[string]string{
+		"div\n\t| <a title=#{Name}>x</a>":           "unquoted attribute value of tag a. - Line: 2",
+		"div\n\t| <a #{Attrs}>x</a>":                "within tag a outside of an attribute value. - Line: 2",
+		"div\n\t| <h#{Level}>x</h1>":                "within a tag name. - Line: 2",
+		"div\n\t| <a title=\"\n\t| #{Name}\">x</a>": "quoted by several nodes. - Line: 3",
+	}
+
+	for input, message := range invalid {
+		if _, err := Compile(input, Options{AuditEscaping: true}); err == nil || !strings.Contains(err.Error(), message) {
+			t.Fatalf("Expected %q compiling %q, got %v", message, input, err)
+		}
+	}
+}
+
+func Test_Define(t *testing.T) {
+	tpl, err := Compile("define badge\n\tspan.badge\n\t\t| #{Label}\ndefine icons/star\n\ti.star\ndiv\n\t| page", Options{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<div>page</div>", t)
+
+	buf.Reset()
+	if err := tpl.ExecuteTemplate(&buf, "badge", map[string]string{"Label": "new"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tpl.ExecuteTemplate(&buf, "icons/star", nil)
+
+	expect(strings.TrimSpace(buf.String()), "<span class=\"badge\">new</span><i class=\"star\"></i>", t)
+}
+
+func Test_CompileDefine(t *testing.T) {
+	cmp := New()
+	cmp.Options = Options{}
+
+	if err := cmp.Parse("define icon\n\ti\np\n\tembed \"icon\"\n\tembed \"pages/footer\""); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := cmp.CompileDefine(&buf, "pages/index"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), `{{define "pages/index"}}<p>{{template "icon" .}}{{template "pages/footer" .}}</p>{{end}}{{define "icon"}}<i></i>{{end}}`, t)
+
+	res, err := cmp.String()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(res), `<p>{{template "icon" .}}{{template "pages/footer" .}}</p>{{define "icon"}}<i></i>{{end}}`, t)
+}
+
+func Test_Mixin(t *testing.T) {
+	source := "mixin card($title)\n\tdiv.card\n\t\theader\n\t\t\tslot header\n\t\t\t\th3\n\t\t\t\t\t| #{$title}\n\t\tdiv.body\n\t\t\tslot\n\t\tfooter\n\t\t\tslot footer\n\t\t\t\t| none\n" +
+		"+card(\"News\")\n\tp\n\t\t| Hi #{Name}\n\tslot footer\n\t\ta\n\t\t\t| More\n" +
+		"+card(upper(Name))\n\tslot header\n\t\th2\n\t\t\t| Custom\n" +
+		"section\n\tslot\n\t\t| web"
+
+	res, err := run(source, map[string]string{"Name": "<Ekin>"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, `<div class="card"><header><h3>News</h3></header><div class="body"><p>Hi &lt;Ekin&gt;</p></div><footer><a>More</a></footer></div>`+
+		`<div class="card"><header><h2>Custom</h2></header><div class="body"></div><footer>none</footer></div><section><slot>web</slot></section>`, t)
+
+	if _, err := run("+card\n\tslot a\n\tslot a", nil); err == nil {
+		t.Fatal("Expected an error on a slot filled twice.")
+	}
+
+	res, err = run("mixin box\n\tdiv\n\t\tslot\n$z = \"zz\"\neach $x in Items\n\t+box\n\t\tb\n\t\t\t| #{$z} #{$x}", map[string][]string{"Items": {"a"}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<div><b>zz a</b></div>", t)
+
+	if _, err := run("mixin m\n\tp\nmixin m\n\tb", nil); err == nil {
+		t.Fatal("Expected an error on a mixin defined twice.")
+	}
+}
+
+func Test_MixinParameters(t *testing.T) {
+	source := "mixin btn($label, $kind=\"primary\", ...$rest)\n\tbutton[class=$kind]\n\t\t| #{$label}\n\t\teach $r in $rest\n\t\t\tspan\n\t\t\t\t| #{$r}\n" +
+		"mixin pair($a, $b=$a, $c=upper(\"x,y\"))\n\tp\n\t\t| #{$a}#{$b}#{$c}\n" +
+		"+btn(\"A\")\n+btn(\"B\", \"danger\", 1, 2)\n+btn(\"C\", nil)\n+pair(\"q\")"
+
+	res, err := run(source, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, `<button class="primary">A</button><button class="danger">B<span>1</span><span>2</span></button>`+
+		`<button class="primary">C</button><p>qqX,Y</p>`, t)
+
+	if _, err := run("mixin m(...$a, $b)\n\tp", nil); err == nil {
+		t.Fatal("Expected an error on a parameter following the rest parameter.")
+	}
+}
+
+func Test_ForwardAttributes(t *testing.T) {
+	source := "mixin btn($label)\n\tbutton.btn[type=\"button\"]&attributes\n\t\t| #{$label}\n" +
+		"mixin box\n\tdiv\n\t\t&attributes\n\t\tslot\n" +
+		"+btn(\"Save\").primary#save[type=\"submit\"][data-id=Id][hidden=Hide]\n+btn(\"Plain\")[title=\"<t>\"]\n" +
+		"+box.wide[role=\"note\"]\n\t| hi"
+
+	res, err := run(source, map[string]interface{}{"Id": 7, "Hide": false})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, `<button class="btn primary" id="save" type="submit" data-id="7">Save</button>`+
+		`<button class="btn" type="button" title="&lt;t&gt;">Plain</button><div class="wide" role="note">hi</div>`, t)
+
+	res, err = run("mixin link\n\ta&attributes\n+link[href=Link][style=Style][title=Title][onclick=Script][data-href=Link][onmouseover=Safe]", map[string]interface{}{
+		"Link":   "javascript:alert(1)",
+		"Style":  "x;background:url(javascript:1)",
+		"Title":  `"><script>`,
+		"Script": "alert(2)",
+		"Safe":   template.JS(`go("x")`),
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, `<a title="&#34;&gt;&lt;script&gt;" onmouseover="go(&#34;x&#34;)" href="#ZgotmplZ" style="ZgotmplZ"></a>`, t)
+
+	if _, err := run("div&attributes", nil); err == nil {
+		t.Fatal("Expected an error on &attributes outside of a mixin.")
+	}
+}
+
+func Test_Embed(t *testing.T) {
+	res, err := run("define badge\n\tspan.badge\n\t\t| #{Label}\ndiv\n\tembed \"badge\" Item\n\tembed \"badge\"", map[string]interface{}{
+		"Label": "page",
+		"Item":  map[string]string{"Label": "new"},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(res, "<div><span class=\"badge\">new</span><span class=\"badge\">page</span></div>", t)
+
+	tpl, err := Compile("p\n\tembed \"legacy\" upper(Name)", Options{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err := tpl.New("legacy").Parse(`<b>{{.}}</b>`); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, map[string]string{"Name": "<x>"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expect(strings.TrimSpace(buf.String()), "<p><b>&lt;X&gt;</b></p>", t)
+}
@@ -1,49 +1,256 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 
 	"github.com/golib/slim"
+	"github.com/golib/slim/gen"
 )
 
 var prettyPrint bool
 var lineNumbers bool
+var minify bool
+var stripComments bool
+var trimMarkers bool
+var goPackage string
+var dataType string
+var output string
+var fields bool
+var sourceMap string
+var baseDir string
 
-func init() {
-	flag.BoolVar(&prettyPrint, "prettyprint", true, "Use pretty indentation in output html.")
-	flag.BoolVar(&prettyPrint, "pp", true, "Use pretty indentation in output html.")
+// flags returns the flag set of the command line, bound to the variables above and reset to their defaults.
+func flags(stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("slimc", flag.ContinueOnError)
+	fs.SetOutput(stderr)
 
-	flag.BoolVar(&lineNumbers, "linenos", true, "Enable debugging information in output html.")
-	flag.BoolVar(&lineNumbers, "ln", true, "Enable debugging information in output html.")
+	fs.BoolVar(&prettyPrint, "prettyprint", true, "Use pretty indentation in output html.")
+	fs.BoolVar(&prettyPrint, "pp", true, "Use pretty indentation in output html.")
 
-	flag.Parse()
+	fs.BoolVar(&lineNumbers, "linenos", true, "Enable debugging information in output html.")
+	fs.BoolVar(&lineNumbers, "ln", true, "Enable debugging information in output html.")
+
+	fs.BoolVar(&minify, "minify", false, "Minify output html for production builds.")
+	fs.BoolVar(&stripComments, "strip-comments", false, "Strip html comments other than conditional and /!keep comments.")
+	fs.BoolVar(&trimMarkers, "trim", false, "Emit trim markers around control actions when not pretty printing.")
+
+	fs.StringVar(&goPackage, "pkg", "", "Generate Go source of the given package with a typed Render function per input file.")
+	fs.StringVar(&dataType, "type", "", "Data type of generated Render functions, i.e. PageData or example.com/app/models.PageData.")
+	fs.BoolVar(&fields, "fields", false, "List data fields, methods and helpers referenced by the input templates, grouped by file.")
+
+	fs.StringVar(&sourceMap, "map", "", "Write a JSON source map relating the generated template to slim lines to the given file.")
+
+	fs.StringVar(&baseDir, "dir", ".", "Directory the names of templates are relative to when compiling several inputs into one template set, "+
+		"or when naming generated Render functions, which default to the directory common to all inputs.")
+
+	fs.StringVar(&output, "o", "", "Write generated Go source to the given file. Package name defaults to its directory name.")
+
+	return fs
 }
 
 func main() {
-	input := flag.Arg(0)
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command line args, writing output to stdout and errors to stderr, and returns the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flags(stderr)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	input := fs.Arg(0)
 
 	if len(input) == 0 {
-		fmt.Fprintln(os.Stderr, "Please provide an input file. (slimc input.slim)")
-		os.Exit(1)
+		fmt.Fprintln(stderr, "Please provide an input file. (slimc input.slim)")
+		return 1
+	}
+
+	options := slim.Options{Pretty: prettyPrint, LineNumbers: lineNumbers, Minify: minify, StripComments: stripComments, TrimMarkers: trimMarkers}
+
+	if len(output) > 0 || len(goPackage) > 0 || len(dataType) > 0 {
+		config := gen.Config{
+			Inputs:  fs.Args(),
+			Output:  output,
+			Package: goPackage,
+			Type:    dataType,
+			Options: options,
+		}
+
+		// names of Render functions are relative to the directory common to all inputs, unless -dir is given
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "dir" {
+				config.Dir = baseDir
+			}
+		})
+
+		var err error
+		if len(output) > 0 {
+			err = gen.Generate(config)
+		} else {
+			if len(config.Package) == 0 {
+				config.Package = "views"
+			}
+
+			err = gen.Write(stdout, config)
+		}
+
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+
+		return 0
+	}
+
+	if fields {
+		for _, filename := range fs.Args() {
+			if err := printReferences(stdout, filename); err != nil {
+				fmt.Fprintln(stderr, err)
+				return 1
+			}
+		}
+
+		return 0
+	}
+
+	if fs.NArg() > 1 {
+		m, err := compileSet(stdout, fs.Args(), options)
+
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+
+		if len(sourceMap) > 0 {
+			if err := writeSourceMap(m, sourceMap); err != nil {
+				fmt.Fprintln(stderr, err)
+				return 1
+			}
+		}
+
+		return 0
 	}
 
 	cmp := slim.New()
-	cmp.PrettyPrint = prettyPrint
-	cmp.LineNumbers = lineNumbers
+	cmp.Options = options
 
 	err := cmp.ParseFile(input)
 
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		fmt.Fprintln(stderr, err)
+		return 1
 	}
 
-	err = cmp.CompileWriter(os.Stdout)
+	err = cmp.Compile(stdout)
 
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if len(sourceMap) > 0 {
+		if err := writeSourceMap(cmp.SourceMap(), sourceMap); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
 	}
+
+	return 0
+}
+
+// compileSet writes the inputs as one template set, each defined by its path relative to baseDir
+// without extension as slim.CompileDir names them, and returns the source map of the whole output.
+func compileSet(out io.Writer, inputs []string, options slim.Options) (*slim.SourceMap, error) {
+	m := new(slim.SourceMap)
+	offset := 0
+
+	for _, input := range inputs {
+		name, ok := slim.TemplateName(baseDir, input, options.Extensions)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a template within %s", input, baseDir)
+		}
+
+		cmp := slim.New()
+		cmp.Options = options
+
+		if err := cmp.ParseFile(input); err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		if err := cmp.CompileDefine(&buf, name); err != nil {
+			return nil, err
+		}
+
+		for _, mapping := range cmp.SourceMap().Mappings {
+			mapping.Start += offset
+			mapping.End += offset
+			m.Mappings = append(m.Mappings, mapping)
+		}
+
+		offset += buf.Len()
+
+		if _, err := buf.WriteTo(out); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func writeSourceMap(m *slim.SourceMap, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if _, err := m.WriteTo(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func printReferences(out io.Writer, filename string) error {
+	cmp := slim.New()
+
+	if err := cmp.ParseFile(filename); err != nil {
+		return err
+	}
+
+	refs, err := cmp.References()
+	if err != nil {
+		return err
+	}
+
+	groups := slim.GroupReferences(refs)
+
+	files := make([]string, 0, len(groups))
+	for file := range groups {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		fmt.Fprintln(out, file+":")
+
+		seen := make(map[string]bool)
+		for _, ref := range groups[file] {
+			if seen[ref.Kind+ref.Name] {
+				continue
+			}
+			seen[ref.Kind+ref.Name] = true
+
+			fmt.Fprintf(out, "\t%-8s %s\t(line %d)\n", ref.Kind, ref.Name, ref.Line)
+		}
+	}
+
+	return nil
 }
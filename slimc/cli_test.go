@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// views writes the templates by their paths below a temporary directory and returns it.
+func views(t *testing.T, templates map[string]string) string {
+	root := t.TempDir()
+
+	for name, source := range templates {
+		filename := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(filename), 0755)
+
+		if err := ioutil.WriteFile(filename, []byte(source), 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	return root
+}
+
+// slimc runs the command line args and returns its exit code, output and errors.
+func slimc(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func expect(cur, expected string, t *testing.T) {
+	if cur != expected {
+		t.Fatalf("Expected {%s} got {%s}.", expected, cur)
+	}
+}
+
+func Test_Compile(t *testing.T) {
+	root := views(t, map[string]string{"index.slim": "p\n\t| #{Title}"})
+
+	code, out, _ := slimc("-pp=false", "-ln=false", filepath.Join(root, "index.slim"))
+	expect(fmt.Sprint(code), "0", t)
+	expect(out, "<p>{{.Title}}</p>\n", t)
+
+	code, _, errs := slimc()
+	expect(fmt.Sprint(code), "1", t)
+	expect(errs, "Please provide an input file. (slimc input.slim)\n", t)
+
+	code, _, errs = slimc("-unknown", "index.slim")
+	expect(fmt.Sprint(code), "2", t)
+
+	if !strings.Contains(errs, "flag provided but not defined: -unknown") {
+		t.Fatalf("Expected the unknown flag to be reported, got %s", errs)
+	}
+}
+
+func Test_CompileSet(t *testing.T) {
+	root := views(t, map[string]string{
+		"index.slim":           "p\n\t| index",
+		"admin/index.slim":     "p\n\t| admin",
+		"users/show.html.slim": "p\n\t| user",
+		"notes.txt":            "p",
+	})
+
+	code, out, errs := slimc("-pp=false", "-ln=false", "-dir", root, filepath.Join(root, "admin", "index.slim"), filepath.Join(root, "index.slim"), filepath.Join(root, "users", "show.html.slim"))
+	if code != 0 {
+		t.Fatal(errs)
+	}
+
+	// named as slim.CompileDir names them
+	expect(out, "{{define \"admin/index\"}}<p>admin</p>{{end}}\n{{define \"index\"}}<p>index</p>{{end}}\n{{define \"users/show\"}}<p>user</p>{{end}}\n", t)
+
+	code, _, errs = slimc("-dir", root, filepath.Join(root, "index.slim"), filepath.Join(root, "notes.txt"))
+	expect(fmt.Sprint(code), "1", t)
+
+	if !strings.Contains(errs, "notes.txt is not a template within") {
+		t.Fatalf("Expected an error on an input without template extension, got %s", errs)
+	}
+}
+
+func Test_Generate(t *testing.T) {
+	root := views(t, map[string]string{
+		"index.slim":             "p\n\t| #{Title}",
+		"admin/index.slim":       "p\n\t| #{Title}",
+		"admin/users/index.slim": "p\n\t| #{Title}",
+	})
+
+	inputs := []string{
+		filepath.Join(root, "index.slim"),
+		filepath.Join(root, "admin", "index.slim"),
+		filepath.Join(root, "admin", "users", "index.slim"),
+	}
+
+	// templates of the same base name are told apart by their paths relative to the directory common to all inputs
+	code, out, errs := slimc(append([]string{"-pkg", "views"}, inputs...)...)
+	if code != 0 {
+		t.Fatal(errs)
+	}
+
+	for _, fn := range []string{"RenderIndex(", "RenderAdminIndex(", "RenderAdminUsersIndex("} {
+		if strings.Count(out, "func "+fn) != 1 {
+			t.Fatalf("Expected a single %s in\n%s", fn, out)
+		}
+	}
+
+	code, out, errs = slimc(append([]string{"-pkg", "views", "-dir", filepath.Join(root, "admin")}, inputs[1:]...)...)
+	if code != 0 {
+		t.Fatal(errs)
+	}
+
+	if !strings.Contains(out, "func RenderIndex(") || !strings.Contains(out, "func RenderUsersIndex(") {
+		t.Fatalf("Expected names relative to -dir in\n%s", out)
+	}
+
+	code, _, errs = slimc("-pkg", "views", "-type", "mypkg.PageData", inputs[0])
+	expect(fmt.Sprint(code), "1", t)
+
+	if !strings.Contains(errs, "full import path") {
+		t.Fatalf("Expected an error on a type without its full import path, got %s", errs)
+	}
+
+	output := filepath.Join(root, "gen", "views_gen.go")
+	os.MkdirAll(filepath.Dir(output), 0755)
+
+	if code, _, errs = slimc("-o", output, "-type", "example.com/app/models.PageData", inputs[0]); code != 0 {
+		t.Fatal(errs)
+	}
+
+	source, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !strings.HasPrefix(string(source), "// Code generated by github.com/golib/slim/gen. DO NOT EDIT.\n\npackage gen\n") ||
+		!strings.Contains(string(source), "func RenderIndex(w io.Writer, data models.PageData) error {") {
+		t.Fatalf("Unexpected generated source\n%s", source)
+	}
+}
+
+func Test_Fields(t *testing.T) {
+	root := views(t, map[string]string{"index.slim": "p\n\t| #{upper(User.Name)}"})
+
+	code, out, errs := slimc("-fields", filepath.Join(root, "index.slim"))
+	if code != 0 {
+		t.Fatal(errs)
+	}
+
+	if !strings.Contains(out, "User.Name") || !strings.Contains(out, "upper") {
+		t.Fatalf("Expected the field and the helper to be listed, got\n%s", out)
+	}
+}
@@ -0,0 +1,211 @@
+package slim
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"text/template/parse"
+)
+
+// Mapping relates a byte range of the generated Go template to the slim node it has been compiled from.
+type Mapping struct {
+	// Byte offsets into the generated template, End is exclusive
+	Start int `json:"start"`
+	End   int `json:"end"`
+	// Origin of the range, Filename is empty for templates parsed from strings
+	Filename string `json:"file,omitempty"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+}
+
+// SourceMap maps the generated Go template back to slim source positions.
+// Ranges of nested nodes are contained within the ranges of their parents.
+type SourceMap struct {
+	Mappings []Mapping `json:"mappings"`
+
+	// generated template, needed to resolve line and column based positions
+	source string
+}
+
+// Returns the source map of the last Compile call, or nil if nothing has been compiled yet.
+func (c *Compiler) SourceMap() *SourceMap {
+	return c.sourceMap
+}
+
+// Looks up the innermost slim node the generated byte offset belongs to.
+func (m *SourceMap) Lookup(offset int) (Mapping, bool) {
+	var (
+		found Mapping
+		ok    bool
+	)
+
+	for _, mapping := range m.Mappings {
+		if offset < mapping.Start || offset >= mapping.End {
+			continue
+		}
+
+		if !ok || mapping.End-mapping.Start < found.End-found.Start {
+			found, ok = mapping, true
+		}
+	}
+
+	return found, ok
+}
+
+// Same as Lookup but takes a line and column of the generated template, both numbered from 1,
+// as reported by html/template errors.
+func (m *SourceMap) LookupLine(line, column int) (Mapping, bool) {
+	if line < 1 || column < 1 {
+		return Mapping{}, false
+	}
+
+	offset := 0
+	for i := 1; i < line; i++ {
+		next := strings.IndexByte(m.source[offset:], '\n')
+		if next < 0 {
+			return Mapping{}, false
+		}
+
+		offset += next + 1
+	}
+
+	return m.Lookup(offset + column - 1)
+}
+
+// Writes the source map as JSON into given io.Writer instance.
+func (m *SourceMap) WriteTo(out io.Writer) (int64, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := out.Write(data)
+	return int64(n), err
+}
+
+// attachedSourceMap is the form a source map is attached to its template in, along with the generated template.
+type attachedSourceMap struct {
+	Mappings []Mapping `json:"mappings"`
+	Source   string    `json:"source"`
+}
+
+// attachSourceMap keeps the source map for ExplainExecError with the template it has been compiled into, see attach.
+func attachSourceMap(tpl *template.Template, m *SourceMap) error {
+	data, err := json.Marshal(attachedSourceMap{m.Mappings, m.source})
+	if err != nil {
+		return err
+	}
+
+	return attach(tpl, "sourcemap", string(data))
+}
+
+// attachedSourceMapOf returns the source map attached to the template of given name in the set of tpl, if any.
+func attachedSourceMapOf(tpl *template.Template, name string) *SourceMap {
+	data, ok := attachment(tpl, "sourcemap", name)
+	if !ok {
+		return nil
+	}
+
+	var attached attachedSourceMap
+	if json.Unmarshal([]byte(data), &attached) != nil {
+		return nil
+	}
+
+	return &SourceMap{Mappings: attached.Mappings, source: attached.Source}
+}
+
+// attach adds data to the set of tpl as a template named after kind and the name of tpl, which is never executed.
+// Unlike a global map keyed by templates, the set holds the data as long as tpl or its clones are in use only,
+// and a template compiled again under the same name replaces it.
+func attach(tpl *template.Template, kind, data string) error {
+	name := "__slim_" + kind + " " + tpl.Name()
+	tree := &parse.Tree{
+		Name: name,
+		Root: &parse.ListNode{NodeType: parse.NodeList, Nodes: []parse.Node{
+			&parse.TextNode{NodeType: parse.NodeText, Text: []byte(data)},
+		}},
+	}
+
+	_, err := tpl.AddParseTree(name, tree)
+	return err
+}
+
+// attachment returns the data of kind attached to the template of given name in the set of tpl, see attach.
+func attachment(tpl *template.Template, kind, name string) (string, bool) {
+	attached := tpl.Lookup("__slim_" + kind + " " + name)
+	if attached == nil || attached.Tree == nil || len(attached.Tree.Root.Nodes) != 1 {
+		return "", false
+	}
+
+	text, ok := attached.Tree.Root.Nodes[0].(*parse.TextNode)
+	if !ok {
+		return "", false
+	}
+
+	return string(text.Text), true
+}
+
+func newSourceMap() *SourceMap {
+	return &SourceMap{Mappings: make([]Mapping, 0)}
+}
+
+// finish sorts the mappings by start offset, outer ranges first.
+func (m *SourceMap) finish(source string) {
+	m.source = source
+
+	sort.SliceStable(m.Mappings, func(i, j int) bool {
+		if m.Mappings[i].Start != m.Mappings[j].Start {
+			return m.Mappings[i].Start < m.Mappings[j].Start
+		}
+
+		return m.Mappings[i].End > m.Mappings[j].End
+	})
+}
+
+// fragment is a part of the template compiled into a definition of its own.
+type fragment struct {
+	name     string
+	body     string
+	mappings []Mapping
+}
+
+// writeFragments appends the definitions of compiled fragments to the output.
+func (c *Compiler) writeFragments() {
+	for _, f := range c.fragments {
+		c.write(`{{define "` + f.name + `"}}`)
+
+		offset := c.buffer.Len()
+		for _, mapping := range f.mappings {
+			mapping.Start += offset
+			mapping.End += offset
+			c.sourceMap.Mappings = append(c.sourceMap.Mappings, mapping)
+		}
+
+		c.write(f.body + `{{end}}`)
+	}
+}
+
+// wrapLayout moves the output compiled so far into a template of its own, rendered by the
+// __slim_layout runtime function which resolves content_for and yield.
+func (c *Compiler) wrapLayout() {
+	c.wrap(`{{__slim_layout "`+c.namespace+`__slim_body" .}}{{define "`+c.namespace+`__slim_body"}}`, `{{end}}`)
+}
+
+// wrap encloses the output compiled so far by prefix and suffix, shifting its mappings accordingly.
+func (c *Compiler) wrap(prefix, suffix string) {
+	body := c.buffer.String()
+
+	c.buffer = new(bytes.Buffer)
+	c.write(prefix)
+
+	offset := c.buffer.Len()
+	for i := range c.sourceMap.Mappings {
+		c.sourceMap.Mappings[i].Start += offset
+		c.sourceMap.Mappings[i].End += offset
+	}
+
+	c.write(body + suffix)
+}
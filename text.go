@@ -0,0 +1,158 @@
+package slim
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/golib/slim/parser"
+)
+
+// Elements output as blocks of plain text on lines of their own, those set are set apart by a blank line.
+var plainBlocks = map[string]bool{
+	"p": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "dl": true, "table": true, "blockquote": true, "pre": true,
+
+	"html": false, "body": false, "div": false, "section": false, "article": false, "header": false,
+	"footer": false, "main": false, "nav": false, "aside": false, "address": false, "figure": false,
+	"figcaption": false, "li": false, "dt": false, "dd": false, "tr": false, "form": false, "fieldset": false,
+	"details": false, "summary": false, "center": false,
+}
+
+// Elements without plain text
+var plainHidden = map[string]bool{
+	"head":     true,
+	"script":   true,
+	"style":    true,
+	"template": true,
+	"svg":      true,
+	"iframe":   true,
+	"object":   true,
+}
+
+// visitPlainTag outputs the plain text of a tag in text format. Markup is left out, blocks are put on lines of
+// their own, list items are marked with a dash, links are followed by their URL and images give their alt text.
+func (c *Compiler) visitPlainTag(tag *parser.Tag) {
+	if plainHidden[tag.Name] {
+		return
+	}
+
+	switch tag.Name {
+	case "br":
+		c.write("\n")
+		return
+	case "hr":
+		c.breakLines(2)
+		c.write("---")
+		c.breakLines(2)
+		return
+	case "img":
+		if alt, ok := c.plainAttribute(tag, "alt"); ok {
+			c.write(alt)
+		}
+
+		return
+	}
+
+	paragraph, block := plainBlocks[tag.Name]
+
+	lines := 1
+	if paragraph {
+		lines = 2
+	}
+
+	if block {
+		c.breakLines(lines)
+	}
+
+	switch tag.Name {
+	case "li":
+		c.write("- ")
+	case "td", "th":
+		c.write(" ")
+	}
+
+	if tag.Block != nil {
+		c.visitBlock(tag.Block)
+	}
+
+	if tag.Name == "a" {
+		if href, ok := c.plainAttribute(tag, "href"); ok {
+			c.write(" (" + href + ")")
+		}
+	}
+
+	if block {
+		c.breakLines(lines)
+	}
+}
+
+// plainAttribute returns the output of the attribute of given name of a tag in text format.
+func (c *Compiler) plainAttribute(tag *parser.Tag, name string) (string, bool) {
+	for _, attr := range tag.Attributes {
+		if attr.Name != name {
+			continue
+		}
+
+		if attr.IsRaw {
+			return plainLiteral(attr.Value), true
+		}
+
+		return c.visitInterpolation(attr.Value), true
+	}
+
+	return "", false
+}
+
+// plainLiteral returns static text of the text format as html/template, which compiles it still, leaves it: a <
+// would open a tag for it, escaping what follows by the context of the tag, or be escaped as &lt; itself.
+func plainLiteral(value string) string {
+	return strings.Replace(value, "<", `{{__slim_text "<"}}`, -1)
+}
+
+// plainLiterals applies plainLiteral to text outside of interpolations and, with Options.PassthroughActions,
+// outside of actions.
+func (c *Compiler) plainLiterals(value string) string {
+	matches := rinterpolate.FindAllStringIndex(value, -1)
+	if c.PassthroughActions {
+		matches = append(matches, rdelimiter.FindAllStringIndex(value, -1)...)
+		sort.Slice(matches, func(i, j int) bool {
+			return matches[i][0] < matches[j][0]
+		})
+	}
+
+	var b strings.Builder
+
+	last := 0
+	for _, m := range matches {
+		if m[0] < last {
+			continue
+		}
+
+		b.WriteString(plainLiteral(value[last:m[0]]))
+		b.WriteString(value[m[0]:m[1]])
+		last = m[1]
+	}
+
+	b.WriteString(plainLiteral(value[last:]))
+	return b.String()
+}
+
+// breakLines ends the output so far with count line breaks, unless there is no output yet.
+func (c *Compiler) breakLines(count int) {
+	output := c.buffer.Bytes()
+
+	newlines := 0
+	for i := len(output) - 1; i >= 0; i-- {
+		switch output[i] {
+		case '\n':
+			newlines++
+		case ' ', '\t':
+		default:
+			for ; newlines < count; newlines++ {
+				c.write("\n")
+			}
+
+			return
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package slim
+
+import (
+	"context"
+)
+
+// TracerProvider creates the tracers spans of parsing, compiling and rendering are started with.
+// It follows the shape of the OpenTelemetry API, building with the otel tag provides OtelTracerProvider
+// adapting a trace.TracerProvider of go.opentelemetry.io/otel.
+type TracerProvider interface {
+	Tracer(name string) Tracer
+}
+
+// Tracer starts spans, see TracerProvider.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a traced operation, ended once the operation has finished.
+type Span interface {
+	// Sets an attribute of the span, value is a string, bool, int or int64.
+	SetAttribute(key string, value interface{})
+	// Records err as the failure of the span.
+	RecordError(err error)
+	End()
+}
+
+// Name of the tracer spans are started with.
+const TracerName = "github.com/golib/slim"
+
+// Attributes set on spans
+const (
+	// Name of the template parsed, compiled or rendered
+	AttributeTemplate = "slim.template"
+	// Number of bytes written by a render
+	AttributeBytesWritten = "slim.bytes_written"
+	// Setting if a render found its template compiled already
+	AttributeCacheHit = "slim.cache_hit"
+)
+
+// startSpan starts a span of given name on the tracer of provider, a span doing nothing if provider is nil.
+func startSpan(ctx context.Context, provider TracerProvider, name, template string) (context.Context, Span) {
+	if provider == nil {
+		return ctx, noopSpan{}
+	}
+
+	ctx, span := provider.Tracer(TracerName).Start(ctx, name)
+	span.SetAttribute(AttributeTemplate, template)
+
+	return ctx, span
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	span.End()
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+
+func (noopSpan) RecordError(err error) {}
+
+func (noopSpan) End() {}
@@ -0,0 +1,65 @@
+//go:build otel
+
+package slim
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Returns a TracerProvider starting the spans of slim on provider of OpenTelemetry, i.e.
+//
+//	slim.Options{TracerProvider: slim.OtelTracerProvider(otel.GetTracerProvider())}
+func OtelTracerProvider(provider trace.TracerProvider) TracerProvider {
+	return otelProvider{provider}
+}
+
+type otelProvider struct {
+	provider trace.TracerProvider
+}
+
+func (p otelProvider) Tracer(name string) Tracer {
+	return otelTracer{p.provider.Tracer(name)}
+}
+
+type otelTracer struct {
+	tracer trace.Tracer
+}
+
+func (t otelTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	ctx, span := t.tracer.Start(ctx, name)
+
+	return ctx, otelSpan{span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) SetAttribute(key string, value interface{}) {
+	switch value := value.(type) {
+	case string:
+		s.span.SetAttributes(attribute.String(key, value))
+	case bool:
+		s.span.SetAttributes(attribute.Bool(key, value))
+	case int:
+		s.span.SetAttributes(attribute.Int(key, value))
+	case int64:
+		s.span.SetAttributes(attribute.Int64(key, value))
+	default:
+		s.span.SetAttributes(attribute.String(key, fmt.Sprint(value)))
+	}
+}
+
+func (s otelSpan) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s otelSpan) End() {
+	s.span.End()
+}
@@ -0,0 +1,219 @@
+package slim
+
+import (
+	"fmt"
+	goAst "go/ast"
+	goToken "go/token"
+	"reflect"
+	"strings"
+)
+
+// Enables compile time type checking of template expressions against the given data type.
+// Every field and method reference is resolved on t (or on the element type within each blocks),
+// unknown names are reported as compile errors with their source position.
+// Values of interface type cannot be checked and are accepted as is. Methods with pointer receivers are accepted
+// only on pointers and on values text/template can take the address of, i.e. not on map elements.
+func (c *Compiler) SetDataType(t reflect.Type) {
+	c.dataType = t
+}
+
+// Same as SetDataType but takes the type from a sample value, i.e. c.SetData(PageData{})
+func (c *Compiler) SetData(data interface{}) {
+	c.SetDataType(reflect.TypeOf(data))
+}
+
+// valueType is the type of a value along with whether text/template can take the address of that value,
+// as calling the methods of the type with pointer receivers requires.
+type valueType struct {
+	t           reflect.Type
+	addressable bool
+}
+
+// typeScope keeps the type of dot and of every declared variable while visiting the tree.
+type typeScope struct {
+	dot  valueType
+	vars map[string]valueType
+}
+
+func (c *Compiler) pushTypeScope(dot valueType) {
+	scope := &typeScope{dot: dot, vars: make(map[string]valueType)}
+
+	if len(c.typeScopes) > 0 {
+		for name, t := range c.typeScopes[len(c.typeScopes)-1].vars {
+			scope.vars[name] = t
+		}
+	}
+
+	c.typeScopes = append(c.typeScopes, scope)
+}
+
+func (c *Compiler) popTypeScope() {
+	c.typeScopes = c.typeScopes[:len(c.typeScopes)-1]
+}
+
+func (c *Compiler) typeScope() *typeScope {
+	if len(c.typeScopes) == 0 {
+		c.pushTypeScope(valueType{t: c.dataType})
+	}
+
+	return c.typeScopes[len(c.typeScopes)-1]
+}
+
+// expressionType parses and checks the raw slim expression value, returning its type.
+func (c *Compiler) expressionType(value string) valueType {
+	if c.dataType == nil {
+		return valueType{}
+	}
+
+	return c.checkExpression(parseExpression(value))
+}
+
+// checkExpression resolves the type of expr, panicking on references that do not exist.
+// A nil type means the type is unknown and further lookups cannot be checked.
+func (c *Compiler) checkExpression(expr goAst.Expr) valueType {
+	switch expr := expr.(type) {
+	case *goAst.BinaryExpr:
+		x, y := c.checkExpression(expr.X), c.checkExpression(expr.Y)
+
+		switch expr.Op {
+		case goToken.LAND, goToken.LOR, goToken.EQL, goToken.NEQ, goToken.LSS, goToken.GTR, goToken.LEQ, goToken.GEQ:
+			return valueType{t: reflect.TypeOf(true)}
+		}
+
+		if x.t == y.t {
+			return valueType{t: x.t}
+		}
+	case *goAst.UnaryExpr:
+		x := c.checkExpression(expr.X)
+		if expr.Op == goToken.NOT {
+			return valueType{t: reflect.TypeOf(true)}
+		}
+
+		return valueType{t: x.t}
+	case *goAst.ParenExpr:
+		return c.checkExpression(expr.X)
+	case *goAst.Ident:
+		scope := c.typeScope()
+
+		// the data passed to Execute is held in an interface, so it is not addressable
+		if expr.Name == "__DOLLAR__" {
+			return valueType{t: c.dataType}
+		}
+
+		if strings.HasPrefix(expr.Name, "__DOLLAR__") {
+			return scope.vars["$"+expr.Name[len("__DOLLAR__"):]]
+		}
+
+		return c.lookupType(scope.dot, expr.Name)
+	case *goAst.SelectorExpr:
+		return c.lookupType(c.checkExpression(expr.X), expr.Sel.Name)
+	case *goAst.CallExpr:
+		for _, arg := range expr.Args {
+			c.checkExpression(arg)
+		}
+
+		if ident, ok := expr.Fun.(*goAst.Ident); ok && isBuiltinFunction(ident.Name) {
+			switch ident.Name {
+			case "len":
+				return valueType{t: reflect.TypeOf(0)}
+			case "__slim_seq":
+				return valueType{t: reflect.TypeOf([]int(nil))}
+			}
+
+			return valueType{}
+		}
+
+		if fn := c.checkExpression(expr.Fun).t; fn != nil && fn.Kind() == reflect.Func && fn.NumOut() > 0 {
+			return valueType{t: fn.Out(0)}
+		}
+	}
+
+	return valueType{}
+}
+
+// lookupType resolves the field, method or map element name of v the same way
+// text/template evaluates .Name against a value of that type. Methods with pointer receivers
+// are only found on values text/template can take the address of.
+func (c *Compiler) lookupType(v valueType, name string) valueType {
+	t := v.t
+	if t == nil {
+		return valueType{}
+	}
+
+	if method, ok := t.MethodByName(name); ok && t.Kind() != reflect.Interface {
+		return valueType{t: methodType(method)}
+	}
+
+	if method, ok := reflect.PtrTo(t).MethodByName(name); ok && t.Kind() != reflect.Ptr && t.Kind() != reflect.Interface {
+		if !v.addressable {
+			panic(fmt.Sprintf("Method %s of type %s has a pointer receiver and the value is not addressable", name, t))
+		}
+
+		return valueType{t: methodType(method)}
+	}
+
+	addressable := v.addressable
+	for t.Kind() == reflect.Ptr {
+		t, addressable = t.Elem(), true
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return valueType{}
+	case reflect.Map:
+		if t.Key().Kind() == reflect.String {
+			return valueType{t: elemType(t.Elem())}
+		}
+	case reflect.Struct:
+		if field, ok := t.FieldByName(name); ok && field.IsExported() {
+			return valueType{t: elemType(field.Type), addressable: addressable}
+		}
+	}
+
+	panic(fmt.Sprintf("Unknown field or method %s in type %s", name, t))
+}
+
+// rangeTypes returns the key and element types produced by ranging over a value of type v.
+// Elements of slices are addressable, those of arrays only when the array is.
+func rangeTypes(v valueType) (key, elem valueType) {
+	t, addressable := v.t, v.addressable
+	for t != nil && t.Kind() == reflect.Ptr {
+		t, addressable = t.Elem(), true
+	}
+
+	if t == nil {
+		return valueType{}, valueType{}
+	}
+
+	switch t.Kind() {
+	case reflect.Array:
+		return valueType{t: reflect.TypeOf(0)}, valueType{t: elemType(t.Elem()), addressable: addressable}
+	case reflect.Slice:
+		return valueType{t: reflect.TypeOf(0)}, valueType{t: elemType(t.Elem()), addressable: true}
+	case reflect.Map:
+		return valueType{t: t.Key()}, valueType{t: elemType(t.Elem())}
+	case reflect.Chan:
+		return valueType{}, valueType{t: elemType(t.Elem())}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return valueType{}, valueType{t: t}
+	}
+
+	return valueType{}, valueType{}
+}
+
+// elemType returns nil for interface types as their dynamic type is unknown at compile time.
+func elemType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Interface {
+		return nil
+	}
+
+	return t
+}
+
+func methodType(method reflect.Method) reflect.Type {
+	if method.Type.NumOut() == 0 {
+		return nil
+	}
+
+	return elemType(method.Type.Out(0))
+}
@@ -0,0 +1,178 @@
+package slim
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golib/slim/parser"
+)
+
+// elements of HTML, custom elements are told apart by the dash of their name
+var knownElements = toSet(
+	"a", "abbr", "address", "area", "article", "aside", "audio", "b", "base", "bdi", "bdo", "blockquote", "body", "br",
+	"button", "canvas", "caption", "cite", "code", "col", "colgroup", "data", "datalist", "dd", "del", "details", "dfn",
+	"dialog", "div", "dl", "dt", "em", "embed", "fieldset", "figcaption", "figure", "footer", "form", "h1", "h2", "h3",
+	"h4", "h5", "h6", "head", "header", "hgroup", "hr", "html", "i", "iframe", "img", "input", "ins", "kbd", "label",
+	"legend", "li", "link", "main", "map", "mark", "menu", "meta", "meter", "nav", "noscript", "object", "ol",
+	"optgroup", "option", "output", "p", "param", "picture", "pre", "progress", "q", "rp", "rt", "ruby", "s", "samp",
+	"script", "search", "section", "select", "slot", "small", "source", "span", "strong", "style", "sub", "summary",
+	"sup", "table", "tbody", "td", "template", "textarea", "tfoot", "th", "thead", "time", "title", "tr", "track", "u",
+	"ul", "var", "video", "wbr", "svg", "math",
+)
+
+// elements which close an open paragraph, a p cannot contain them
+var paragraphClosers = toSet(
+	"address", "article", "aside", "blockquote", "details", "dialog", "div", "dl", "fieldset", "figcaption", "figure",
+	"footer", "form", "h1", "h2", "h3", "h4", "h5", "h6", "header", "hgroup", "hr", "main", "menu", "nav", "ol", "p",
+	"pre", "search", "section", "table", "ul",
+)
+
+// elements which take phrasing content only, a link is transparent and left out
+var phrasingElements = toSet(
+	"abbr", "b", "bdi", "bdo", "cite", "code", "data", "dfn", "em", "h1", "h2", "h3", "h4", "h5", "h6", "i", "kbd",
+	"label", "mark", "p", "pre", "q", "s", "samp", "small", "span", "strong", "sub", "sup", "time", "u", "var",
+)
+
+func toSet(names ...string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+
+	return set
+}
+
+// validateHTML fails on markup browsers would repair in surprising ways: illegal nesting, unknown elements,
+// void elements given content and a second title.
+func (c *Compiler) validateHTML(node parser.Noder) {
+	titles := 0
+
+	var walk func(node parser.Noder, parents []string)
+	walk = func(node parser.Noder, parents []string) {
+		switch node := node.(type) {
+		case *parser.Block:
+			for _, child := range node.Children {
+				walk(child, parents)
+			}
+		case *parser.Tag:
+			// foreign content of SVG and MathML and tags of interpolated names are beyond checking
+			if node.IsInterpolated || node.Name == "svg" || node.Name == "math" {
+				return
+			}
+
+			c.validateTag(node, parents)
+
+			if node.Name == "title" && !contains(parents, "svg") {
+				if titles++; titles > 1 {
+					panic(parser.NewError("A document must not have more than one title element.", node.SourcePosition))
+				}
+			}
+
+			if node.Block != nil {
+				walk(node.Block, append(parents, node.Name))
+			}
+		case *parser.Condition:
+			if node.Positive != nil {
+				walk(node.Positive, parents)
+			}
+
+			if node.Negative != nil {
+				walk(node.Negative, parents)
+			}
+		case *parser.Range:
+			if node.Block != nil {
+				walk(node.Block, parents)
+			}
+		case *parser.Let:
+			if node.Block != nil {
+				walk(node.Block, parents)
+			}
+		case *parser.While:
+			if node.Block != nil {
+				walk(node.Block, parents)
+			}
+		case *parser.Cache:
+			if node.Block != nil {
+				walk(node.Block, parents)
+			}
+		case *parser.ContentFor:
+			if node.Block != nil {
+				walk(node.Block, nil)
+			}
+		case *parser.Define:
+			if node.Block != nil {
+				walk(node.Block, nil)
+			}
+		case *parser.Mixin:
+			if node.Block != nil {
+				walk(node.Block, nil)
+			}
+		case *parser.MixinCall:
+			if node.Block != nil {
+				walk(node.Block, nil)
+			}
+		case *parser.Slot:
+			if node.Block != nil {
+				walk(node.Block, parents)
+			}
+		}
+	}
+
+	walk(node, nil)
+}
+
+func (c *Compiler) validateTag(tag *parser.Tag, parents []string) {
+	fail := func(format string, args ...interface{}) {
+		panic(parser.NewError(fmt.Sprintf(format, args...), tag.SourcePosition))
+	}
+
+	// vocabularies of their own replace the void elements of HTML
+	if c.voids == nil && !knownElements[tag.Name] && !strings.Contains(tag.Name, "-") {
+		fail("Unknown element %s.", tag.Name)
+	}
+
+	if c.isVoid(tag) && tag.Block != nil && len(tag.Block.Children) > 0 {
+		fail("Void element %s cannot have content.", tag.Name)
+	}
+
+	if len(parents) == 0 {
+		return
+	}
+
+	parent := parents[len(parents)-1]
+
+	if paragraphClosers[tag.Name] && contains(parents, "p") {
+		fail("Element %s cannot be nested inside p.", tag.Name)
+	}
+
+	if phrasingElements[parent] && knownElements[tag.Name] && !phrasingContent(tag.Name) {
+		fail("Element %s cannot be nested inside %s.", tag.Name, parent)
+	}
+}
+
+// phrasingContent reports whether elements of name are allowed within phrasing elements.
+func phrasingContent(name string) bool {
+	for _, inline := range DefaultInlineElements {
+		if inline == name {
+			return true
+		}
+	}
+
+	switch name {
+	case "audio", "button", "canvas", "datalist", "embed", "iframe", "input", "map", "meter", "noscript", "object",
+		"output", "picture", "progress", "ruby", "script", "select", "slot", "template", "textarea", "video":
+		return true
+	}
+
+	return false
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}